@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"net/mail"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sessionCookie holds a signed "userID:generation:issued" triple.
+// Bumping a user's SessionGen invalidates every session they hold.
+const sessionCookie = "bw_session"
+
+const (
+	sessionTTL    = 30 * 24 * time.Hour
+	sessionRotate = 24 * time.Hour // reissue cookies older than this
+	minPassword   = 8
+	maxPassword   = 72 // bcrypt ignores anything longer
+)
+
+var errEmailTaken = errors.New("an account with that email already exists")
+
+// User is a registered visitor.
+type User struct {
+	ID           int       `json:"id"`
+	Email        string    `json:"email"`
+	Name         string    `json:"name,omitempty"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Role         string    `json:"role,omitempty"`
+	SessionGen   int       `json:"session_gen,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// EmailVerified is set once an OAuth provider has confirmed the
+	// account's email. Registering with a password doesn't, so anyone
+	// could have typed the address.
+	EmailVerified bool `json:"email_verified,omitempty"`
+
+	// Identities maps OAuth provider names to the account's subject ID
+	// there.
+	Identities map[string]string `json:"identities,omitempty"`
+}
+
+// DisplayName is the user's name, or their email when they gave none.
+func (u User) DisplayName() string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.Email
+}
+
+// userStore is implemented by repositories that keep accounts. PutUser
+// assigns an ID to a new user.
+type userStore interface {
+	Users() ([]User, error)
+	GetUser(id int) (User, error)
+	UserByEmail(email string) (User, error)
+	UserByIdentity(provider, subject string) (User, error)
+	PutUser(u *User) error
+}
+
+func users() (userStore, error) {
+	us, ok := store.(userStore)
+	if !ok {
+		return nil, errors.New("store does not support accounts")
+	}
+	return us, nil
+}
+
+// loginLimiter throttles login and registration attempts per client IP.
+var (
+	loginLimiter = newRateLimiter([]rateRule{loginRule})
+	loginRule    = rateRule{Prefix: "/login", Rate: 0.1, Burst: 5}
+)
+
+// registerAccounts wires the sign-in routes.
+func registerAccounts() {
+	handleFunc("GET /login", loginHandler)
+	handleFunc("POST /login", loginPostHandler)
+	handleFunc("GET /register", registerHandler)
+	handleFunc("POST /register", registerPostHandler)
+	handleFunc("POST /logout", logoutHandler)
+	handleFunc("GET /me", meHandler)
+	handleFunc("GET /auth/{provider}", oauthStartHandler)
+	handleFunc("GET /auth/{provider}/callback", oauthCallbackHandler)
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			loginLimiter.sweep(now)
+		}
+	}()
+}
+
+type userKey struct{}
+
+// sessions resolves the session cookie into the request's user and
+// reissues cookies that are due for rotation.
+func sessions(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, issued, ok := sessionUser(r); ok {
+			if time.Since(issued) > sessionRotate {
+				startSession(w, r, u)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), userKey{}, u))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// currentUser returns the signed-in user, if any.
+func currentUser(r *http.Request) (User, bool) {
+	u, ok := r.Context().Value(userKey{}).(User)
+	return u, ok
+}
+
+func sessionUser(r *http.Request) (User, time.Time, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return User{}, time.Time{}, false
+	}
+	value, err := verifyToken("session", c.Value, time.Now())
+	if err != nil {
+		return User{}, time.Time{}, false
+	}
+	var id, gen int
+	var issued int64
+	if _, err := fmt.Sscanf(value, "%d:%d:%d", &id, &gen, &issued); err != nil {
+		return User{}, time.Time{}, false
+	}
+	us, err := users()
+	if err != nil {
+		return User{}, time.Time{}, false
+	}
+	u, err := us.GetUser(id)
+	if err != nil || u.SessionGen != gen {
+		return User{}, time.Time{}, false
+	}
+	return u, time.Unix(issued, 0), true
+}
+
+// startSession issues a fresh session cookie for u, replacing any the
+// client already had.
+func startSession(w http.ResponseWriter, r *http.Request, u User) {
+	now := time.Now()
+	value := fmt.Sprintf("%d:%d:%d", u.ID, u.SessionGen, now.Unix())
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    signToken("session", value, now.Add(sessionTTL)),
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func endSession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// accountForm is what a visitor posts to /login or /register.
+type accountForm struct {
+	Email string
+	Name  string
+	Next  string
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	renderAccount(w, r, http.StatusOK, "login", accountForm{Next: r.URL.Query().Get("next")}, "")
+}
+
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	renderAccount(w, r, http.StatusOK, "register", accountForm{Next: r.URL.Query().Get("next")}, "")
+}
+
+// accountPost parses and checks the common parts of a login or
+// registration post, rendering the form on failure.
+func accountPost(w http.ResponseWriter, r *http.Request, mode string) (accountForm, string, userStore, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return accountForm{}, "", nil, false
+	}
+	f := accountForm{
+		Email: strings.ToLower(strings.TrimSpace(r.PostForm.Get("email"))),
+		Name:  strings.TrimSpace(r.PostForm.Get("name")),
+		Next:  r.PostForm.Get("next"),
+	}
+	if allowed, wait := loginLimiter.allow(loginRule, clientIP(r), time.Now()); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		renderAccount(w, r, http.StatusTooManyRequests, mode, f, "Too many attempts; please wait a moment.")
+		return f, "", nil, false
+	}
+	us, err := users()
+	if err != nil {
+		serverError(w, err)
+		return f, "", nil, false
+	}
+	return f, r.PostForm.Get("password"), us, true
+}
+
+func loginPostHandler(w http.ResponseWriter, r *http.Request) {
+	f, password, us, ok := accountPost(w, r, "login")
+	if !ok {
+		return
+	}
+	u, err := us.UserByEmail(f.Email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		serverError(w, err)
+		return
+	}
+	if err != nil || u.PasswordHash == "" ||
+		bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		renderAccount(w, r, http.StatusUnauthorized, "login", f, "Wrong email or password.")
+		return
+	}
+	startSession(w, r, u)
+	http.Redirect(w, r, localNext(f.Next, "/me"), http.StatusSeeOther)
+}
+
+func registerPostHandler(w http.ResponseWriter, r *http.Request) {
+	f, password, us, ok := accountPost(w, r, "register")
+	if !ok {
+		return
+	}
+	if _, err := mail.ParseAddress(f.Email); err != nil {
+		renderAccount(w, r, http.StatusBadRequest, "register", f, "Please enter a valid email address.")
+		return
+	}
+	if len(f.Name) > 100 {
+		renderAccount(w, r, http.StatusBadRequest, "register", f, "Please keep your name under 100 characters.")
+		return
+	}
+	if len(password) < minPassword || len(password) > maxPassword {
+		renderAccount(w, r, http.StatusBadRequest, "register", f,
+			fmt.Sprintf("Passwords must be %d to %d characters.", minPassword, maxPassword))
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	u := User{Email: f.Email, Name: f.Name, PasswordHash: string(hash), CreatedAt: time.Now().UTC()}
+	if err := us.PutUser(&u); errors.Is(err, errEmailTaken) {
+		renderAccount(w, r, http.StatusConflict, "register", f, err.Error())
+		return
+	} else if err != nil {
+		serverError(w, err)
+		return
+	}
+	log.Printf("accounts: registered user %d", u.ID)
+	startSession(w, r, u)
+	http.Redirect(w, r, localNext(f.Next, "/me"), http.StatusSeeOther)
+}
+
+// logoutHandler ends the session. With everywhere=1 it also bumps the
+// user's session generation, signing out every other device.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if u, ok := currentUser(r); ok && r.PostFormValue("everywhere") == "1" {
+		if us, err := users(); err == nil {
+			u.SessionGen++
+			if err := us.PutUser(&u); err != nil {
+				serverError(w, err)
+				return
+			}
+		}
+	}
+	endSession(w, r)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login?next=/me", http.StatusSeeOther)
+		return
+	}
+	data := map[string]interface{}{
+		"Title": "Your account | BlendingWaves",
+		"User":  u,
+		"CSRF":  csrfToken(w, r),
+		"OAuth": oauthClients,
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if err := render(w, "me.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+func renderAccount(w http.ResponseWriter, r *http.Request, status int, mode string, f accountForm, formErr string) {
+	title := "Sign in | BlendingWaves"
+	if mode == "register" {
+		title = "Create an account | BlendingWaves"
+	}
+	data := map[string]interface{}{
+		"Title": title,
+		"Mode":  mode,
+		"Form":  f,
+		"Error": formErr,
+		"CSRF":  csrfToken(w, r),
+		"OAuth": oauthClients,
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if err := renderStatus(w, status, "account.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// localNext returns next when it is a path on this site, else def, so
+// login can't be used as an open redirect.
+func localNext(next, def string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return def
+	}
+	return next
+}
+
+// usersPath is where the JSON store keeps accounts.
+func (s *jsonStore) usersPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".users.json"
+}
+
+func (s *jsonStore) readUsers() ([]User, error) {
+	data, err := os.ReadFile(s.usersPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []User
+	return out, json.Unmarshal(data, &out)
+}
+
+func (s *jsonStore) Users() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readUsers()
+}
+
+func (s *jsonStore) findUser(match func(User) bool) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readUsers()
+	if err != nil {
+		return User{}, err
+	}
+	if i := slices.IndexFunc(all, match); i >= 0 {
+		return all[i], nil
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *jsonStore) GetUser(id int) (User, error) {
+	return s.findUser(func(u User) bool { return u.ID == id })
+}
+
+func (s *jsonStore) UserByEmail(email string) (User, error) {
+	return s.findUser(func(u User) bool { return u.Email == email })
+}
+
+func (s *jsonStore) UserByIdentity(provider, subject string) (User, error) {
+	return s.findUser(func(u User) bool { return u.Identities[provider] == subject })
+}
+
+func (s *jsonStore) PutUser(u *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readUsers()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(all, func(x User) bool { return x.Email == u.Email && x.ID != u.ID }) {
+		return errEmailTaken
+	}
+	if i := slices.IndexFunc(all, func(x User) bool { return x.ID == u.ID }); u.ID != 0 && i >= 0 {
+		all[i] = *u
+	} else {
+		for _, x := range all {
+			u.ID = max(u.ID, x.ID)
+		}
+		u.ID++
+		all = append(all, *u)
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.usersPath(), data)
+}
+
+// Users are stored as JSON documents like items, so new User fields need
+// no migration; email is broken out for lookups.
+func (s *sqliteStore) scanUser(row *sql.Row) (User, error) {
+	var data string
+	if err := row.Scan(&data); errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotFound
+	} else if err != nil {
+		return User{}, err
+	}
+	var u User
+	return u, json.Unmarshal([]byte(data), &u)
+}
+
+func (s *sqliteStore) Users() ([]User, error) {
+	rows, err := s.db.Query(`SELECT data FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []User
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var u User
+		if err := json.Unmarshal([]byte(data), &u); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) GetUser(id int) (User, error) {
+	return s.scanUser(s.db.QueryRow(`SELECT data FROM users WHERE id = ?`, id))
+}
+
+func (s *sqliteStore) UserByEmail(email string) (User, error) {
+	return s.scanUser(s.db.QueryRow(`SELECT data FROM users WHERE email = ?`, email))
+}
+
+func (s *sqliteStore) UserByIdentity(provider, subject string) (User, error) {
+	return s.scanUser(s.db.QueryRow(`SELECT data FROM users WHERE json_extract(data, '$.identities.' || ?) = ?`, provider, subject))
+}
+
+func (s *sqliteStore) PutUser(u *User) error {
+	var taken int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM users WHERE email = ? AND id != ?`, u.Email, u.ID).Scan(&taken); err != nil {
+		return err
+	}
+	if taken > 0 {
+		return errEmailTaken
+	}
+	if u.ID == 0 {
+		res, err := s.db.Exec(`INSERT INTO users (email, data) VALUES (?, '{}')`, u.Email)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		u.ID = int(id)
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE users SET email = ?, data = ? WHERE id = ?`, u.Email, string(data), u.ID)
+	return err
+}
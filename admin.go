@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	adminUser     string
+	adminPassword string
+)
+
+// registerAdmin wires the /admin routes, each guarded by the least role
+// that may use it.
+func registerAdmin(cfg Config) {
+	admin := func(pattern, role string, h func(http.ResponseWriter, *http.Request)) {
+		handle(pattern, requireRole(role, http.HandlerFunc(h)))
+	}
+	admin("GET /admin", RoleViewer, adminListHandler)
+	admin("GET /admin/items/new", RoleEditor, adminNewHandler)
+	admin("POST /admin/items", RoleEditor, adminCreateHandler)
+	admin("GET /admin/items/{id}/edit", RoleViewer, adminEditHandler)
+	admin("POST /admin/items/{id}", RoleEditor, adminUpdateHandler)
+	admin("POST /admin/items/{id}/delete", RoleEditor, adminDeleteHandler)
+	admin("PATCH /api/admin/items/order", RoleEditor, adminOrderHandler)
+	admin("GET /admin/items/{id}/history", RoleViewer, adminItemHistoryHandler)
+	admin("POST /admin/items/{id}/preview", RoleEditor, adminPreviewHandler)
+	admin("POST /admin/template/preview", RoleEditor, adminTemplatePreviewHandler)
+	admin("POST /admin/items/{id}/rollback", RoleEditor, adminRollbackItemHandler)
+	admin("GET /admin/history", RoleViewer, adminHistoryHandler)
+	admin("POST /admin/diff", RoleViewer, adminDiffHandler)
+	admin("POST /admin/history/{rev}/rollback", RoleEditor, adminRollbackCatalogHandler)
+	admin("POST /admin/media", RoleEditor, adminUploadHandler)
+	admin("GET /admin/api/views", RoleViewer, adminViewsHandler)
+	admin("GET /admin/comments", RoleViewer, adminCommentsHandler)
+	admin("POST /admin/comments/{id}/status", RoleEditor, adminModerateHandler)
+	admin("GET /admin/subscribers.csv", RoleAdmin, adminSubscribersHandler)
+	admin("GET /admin/digests", RoleViewer, adminDigestsHandler)
+	admin("GET /admin/digests/preview", RoleViewer, adminDigestPreviewHandler)
+	admin("POST /admin/maintenance", RoleAdmin, adminMaintenanceHandler)
+	admin("GET /admin/redirects", RoleViewer, adminRedirectsHandler)
+	admin("POST /admin/redirects", RoleEditor, adminPutRedirectHandler)
+	admin("POST /admin/redirects/delete", RoleEditor, adminDeleteRedirectHandler)
+	admin("GET /admin/collections", RoleViewer, adminCollectionsHandler)
+	admin("POST /admin/collections", RoleEditor, adminPutCollectionHandler)
+	admin("POST /admin/collections/delete", RoleEditor, adminDeleteCollectionHandler)
+	admin("GET /admin/stats", RoleViewer, adminStatsHandler)
+	admin("GET /admin/api/analytics", RoleViewer, adminAnalyticsHandler)
+	admin("GET /admin/api/experiments", RoleViewer, adminExperimentsHandler)
+	admin("GET /admin/api/flags", RoleViewer, adminFlagsHandler)
+	admin("PUT /admin/api/flags/{name}", RoleAdmin, adminPutFlagHandler)
+	admin("DELETE /admin/api/flags/{name}", RoleAdmin, adminDeleteFlagHandler)
+	admin("GET /admin/users", RoleAdmin, adminUsersHandler)
+	admin("POST /admin/users/{id}/role", RoleAdmin, adminRoleHandler)
+	admin("GET /admin/audit", RoleAdmin, adminAuditHandler)
+	admin("GET /admin/api/audit", RoleAdmin, adminAuditAPIHandler)
+	admin("GET /admin/audit.csv", RoleAdmin, adminAuditCSVHandler)
+	admin("GET /admin/backup", RoleAdmin, adminBackupHandler)
+	admin("GET /admin/items.json", RoleAdmin, adminItemsFileHandler)
+	admin("POST /admin/restore", RoleAdmin, adminRestoreHandler)
+	admin("GET /admin/features", RoleAdmin, adminFeaturesHandler(cfg))
+	admin("GET /admin/jobs", RoleViewer, adminJobsHandler)
+	admin("GET /admin/api/jobs", RoleViewer, adminJobsAPIHandler)
+	admin("POST /admin/jobs/{name}/run", RoleAdmin, adminRunJobHandler)
+	admin("GET /admin/api/links", RoleViewer, adminLinksHandler)
+}
+
+// adminListHandler lists the items in the home page's order, which
+// editors change by dragging the rows.
+func adminListHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Title":       "Items | Admin",
+		"Items":       current().items,
+		"Maintenance": maintenance.on.Load(),
+		"CSRF":        csrfToken(w, r),
+	}
+	if err := render(w, "admin_items.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+func adminNewHandler(w http.ResponseWriter, r *http.Request) {
+	renderItemForm(w, r, Item{}, true, "")
+}
+
+func adminEditHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := adminItem(w, r)
+	if !ok {
+		return
+	}
+	renderItemForm(w, r, it, false, "")
+}
+
+func adminCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var it Item
+	if err := itemFromForm(r, &it); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderItemForm(w, r, it, true, err.Error())
+		return
+	}
+	it.ID = nextItemID(current())
+	if msgs := checkItem(&it); len(msgs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		renderItemForm(w, r, it, true, strings.Join(msgs, "; "))
+		return
+	}
+	now := time.Now().UTC()
+	it.CreatedAt = &now
+	saveItem(w, r, RevCreate, it)
+}
+
+func adminUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := adminItem(w, r)
+	if !ok {
+		return
+	}
+	if err := itemFromForm(r, &it); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderItemForm(w, r, it, false, err.Error())
+		return
+	}
+	if msgs := checkItem(&it); len(msgs) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		renderItemForm(w, r, it, false, strings.Join(msgs, "; "))
+		return
+	}
+	saveItem(w, r, RevUpdate, it)
+}
+
+func adminDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	before, err := store.Get(id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	if err := deleteItem(store, actor(r), id); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	audit(r, "item.delete", "item "+strconv.Itoa(id), before, nil)
+	log.Printf("admin: deleted item %d", id)
+	afterWrite(w, r)
+}
+
+// adminItem fetches the item named by {id} from the store, writing a 404
+// when it doesn't exist.
+func adminItem(w http.ResponseWriter, r *http.Request) (Item, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return Item{}, false
+	}
+	it, err := store.Get(id)
+	if err != nil {
+		storeError(w, r, err)
+		return Item{}, false
+	}
+	return it, true
+}
+
+func saveItem(w http.ResponseWriter, r *http.Request, action string, it Item) {
+	var before *Item
+	if prev, err := store.Get(it.ID); err == nil {
+		before = &prev
+	}
+	now := time.Now().UTC()
+	it.UpdatedAt = &now
+	if err := putItem(store, actor(r), action, it); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	audit(r, "item."+action, "item "+strconv.Itoa(it.ID), before, it)
+	log.Printf("admin: saved item %d", it.ID)
+	afterWrite(w, r)
+}
+
+// afterWrite reloads the public catalog and returns to the item list.
+func afterWrite(w http.ResponseWriter, r *http.Request) {
+	if err := reloadItems(r.Context()); err != nil {
+		log.Printf("admin: reload after write: %v", err)
+		http.Error(w, "Saved, but reloading the catalog failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func storeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	log.Printf("admin: store: %v", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func renderItemForm(w http.ResponseWriter, r *http.Request, it Item, isNew bool, formErr string) {
+	data := map[string]interface{}{
+		"Title": "Edit item | Admin",
+		"Item":  it,
+		"New":   isNew,
+		"Error": formErr,
+		"CSRF":  csrfToken(w, r),
+	}
+	if err := render(w, "admin_item_form.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// itemFromForm overwrites the editable fields of it from the posted form,
+// leaving everything else (ID, slug, translations) untouched.
+func itemFromForm(r *http.Request, it *Item) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	it.KeywordTitle = strings.TrimSpace(r.PostForm.Get("keyword_title"))
+	it.Texts = splitParagraphs(r.PostForm.Get("texts"))
+	it.VideoPath = splitLines(r.PostForm.Get("video_path"))
+	it.VideoCredit = splitLines(r.PostForm.Get("video_credit"))
+	captions, err := parseCaptions(r.PostForm.Get("captions"))
+	if err != nil {
+		return err
+	}
+	it.Captions = captions
+	it.ItemLink = strings.TrimSpace(r.PostForm.Get("item_link"))
+	it.ShortCode = strings.ToLower(strings.TrimSpace(r.PostForm.Get("short_code")))
+	if msg := checkShortCode(it.ShortCode); msg != "" {
+		return errors.New(msg)
+	}
+	it.Tags = nil
+	for _, t := range strings.Split(r.PostForm.Get("tags"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			it.Tags = append(it.Tags, t)
+		}
+	}
+	it.Pinned = r.PostForm.Get("pinned") != ""
+	it.Order = 0
+	if v := strings.TrimSpace(r.PostForm.Get("order")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errors.New("order must be a whole number, 0 or more")
+		}
+		it.Order = n
+	}
+	it.Status = r.PostForm.Get("status")
+	it.PublishAt = nil
+	if v := strings.TrimSpace(r.PostForm.Get("publish_at")); v != "" {
+		t, err := time.ParseInLocation(formTimeLayout, v, time.Local)
+		if err != nil {
+			return errors.New("publish time must look like 2006-01-02T15:04")
+		}
+		t = t.UTC()
+		it.PublishAt = &t
+	}
+	if it.KeywordTitle == "" {
+		return errors.New("title is required")
+	}
+	return validStatus(it.Status, it.PublishAt)
+}
+
+// formTimeLayout is the value format of <input type="datetime-local">.
+const formTimeLayout = "2006-01-02T15:04"
+
+// formTime formats t for a datetime-local input in server time.
+func formTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.In(time.Local).Format(formTimeLayout)
+}
+
+// nextItemID returns one more than the highest ID in c.
+func nextItemID(c *catalog) int {
+	max := 0
+	for i := range c.items {
+		if c.items[i].ID > max {
+			max = c.items[i].ID
+		}
+	}
+	return max + 1
+}
+
+// splitLines returns the non-blank lines of s, trimmed.
+func splitLines(s string) []string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// splitParagraphs splits s on blank lines, joining wrapped lines within
+// a paragraph with a space.
+func splitParagraphs(s string) []string {
+	var out []string
+	var cur []string
+	flush := func() {
+		if len(cur) > 0 {
+			out = append(out, strings.Join(cur, " "))
+			cur = nil
+		}
+	}
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			flush()
+			continue
+		}
+		cur = append(cur, line)
+	}
+	flush()
+	return out
+}
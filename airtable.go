@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// airtableSource reads items from an Airtable table, one per record.
+// Record fields named like item fields (or mapped with -airtable-map)
+// fill them; others are ignored, so the table can hold editorial notes.
+// Every record needs a numeric id field, e.g. an autonumber.
+type airtableSource struct {
+	endpoint string // records URL of the table
+	view     string
+	token    string
+	mapping  map[string]string
+	client   *http.Client
+}
+
+func newAirtableSource(cfg Config) (*airtableSource, error) {
+	if cfg.AirtableToken == "" {
+		return nil, errors.New("airtable-base needs airtable-token")
+	}
+	if cfg.AirtableTable == "" {
+		return nil, errors.New("airtable-table must not be empty")
+	}
+	mapping, err := parseFieldMap("airtable-map", cfg.AirtableMap)
+	if err != nil {
+		return nil, err
+	}
+	return &airtableSource{
+		endpoint: strings.TrimSuffix(cfg.AirtableURL, "/") + "/" + url.PathEscape(cfg.AirtableBase) + "/" + url.PathEscape(cfg.AirtableTable),
+		view:     cfg.AirtableView,
+		token:    cfg.AirtableToken,
+		mapping:  mapping,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (a *airtableSource) Name() string { return "airtable" }
+
+// airtablePage is one page of the list records response.
+type airtablePage struct {
+	Records []struct {
+		ID          string         `json:"id"`
+		CreatedTime time.Time      `json:"createdTime"`
+		Fields      map[string]any `json:"fields"`
+	} `json:"records"`
+	Offset string `json:"offset"`
+}
+
+// Fetch reads every page of records. The version is a hash of the items,
+// as Airtable has no revision number for a whole table.
+func (a *airtableSource) Fetch(ctx context.Context) ([]Item, string, error) {
+	var items []Item
+	offset := ""
+	for {
+		page, err := a.page(ctx, offset)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, r := range page.Records {
+			rec := importRecord{where: "airtable record " + r.ID, fields: map[string]any{}}
+			for col, v := range r.Fields {
+				if _, ok := mappedField(col, a.mapping); ok {
+					rec.fields[col] = v
+				}
+			}
+			if err := rec.normalize(a.mapping, false); err != nil {
+				return nil, "", err
+			}
+			data, err := json.Marshal(rec.fields)
+			if err != nil {
+				return nil, "", fmt.Errorf("%s: %w", rec.where, err)
+			}
+			var it Item
+			if err := json.Unmarshal(data, &it); err != nil {
+				return nil, "", fmt.Errorf("%s: %w", rec.where, err)
+			}
+			if it.ID == 0 {
+				return nil, "", fmt.Errorf("%s: no id field", rec.where)
+			}
+			if it.CreatedAt == nil && !r.CreatedTime.IsZero() {
+				created := r.CreatedTime.UTC()
+				it.CreatedAt = &created
+			}
+			items = append(items, it)
+		}
+		if page.Offset == "" {
+			break
+		}
+		offset = page.Offset
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	return items, hex.EncodeToString(sum[:]), nil
+}
+
+// page fetches the records after offset.
+func (a *airtableSource) page(ctx context.Context, offset string) (*airtablePage, error) {
+	q := url.Values{"pageSize": {"100"}}
+	if a.view != "" {
+		q.Set("view", a.view)
+	}
+	if offset != "" {
+		q.Set("offset", offset)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("airtable: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var page airtablePage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("airtable: %w", err)
+	}
+	return &page, nil
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Analytics counters, kept per UTC day. Visitors are estimated by
+// hashing the client's address and user agent with a salt that only
+// lives in memory and changes every day, so no address is stored and
+// a visitor can't be followed from one day to the next.
+const (
+	KindViews        = "views"         // every counted pageview; key ""
+	KindVisitors     = "visitors"      // distinct visitors; key ""
+	KindPage         = "page"          // pageviews by path
+	KindPageVisitors = "page-visitors" // distinct visitors by path
+	KindReferrer     = "referrer"      // pageviews by source: a host, utm_source or (direct)
+	KindEvent        = "event"         // events posted to /collect, by name and detail
+	KindSearchMiss   = "search-miss"   // searches finding nothing, by query
+)
+
+// analyticsKeysPerDay caps the distinct keys of one kind recorded in a
+// day, and analyticsSeenMax the visitor hashes remembered, so junk paths
+// or referrers can't grow the store without bound.
+const (
+	analyticsKeysPerDay = 5000
+	analyticsSeenMax    = 1 << 20
+)
+
+// directSource is the referrer key of visits with no referrer.
+const directSource = "(direct)"
+
+// analyticsEnabled records pageviews; -analytics turns it off.
+var analyticsEnabled = true
+
+// AnalyticsKey names one counter.
+type AnalyticsKey struct {
+	Day  string `json:"day"` // 2006-01-02, UTC
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+}
+
+// AnalyticsRow is a counter and its value.
+type AnalyticsRow struct {
+	AnalyticsKey
+	Count int64 `json:"count"`
+}
+
+// analyticsStore is implemented by repositories that persist analytics.
+// AddAnalytics adds the deltas to the stored counts; Analytics returns
+// every count of the days from through to.
+type analyticsStore interface {
+	AddAnalytics(deltas map[AnalyticsKey]int64) error
+	Analytics(from, to string) ([]AnalyticsRow, error)
+}
+
+// analyticsCollector buffers counts between flushes and holds the day's
+// salt and the visitor hashes already counted.
+type analyticsCollector struct {
+	sync.Mutex
+	day     string
+	salt    []byte
+	seen    map[string]bool
+	keys    map[string]int // distinct keys per kind today
+	pending map[AnalyticsKey]int64
+}
+
+var collector = analyticsCollector{pending: map[AnalyticsKey]int64{}}
+
+// flushAnalytics writes pending counts to the store, keeping them for
+// the next flush on failure.
+func flushAnalytics() {
+	as, ok := store.(analyticsStore)
+	if !ok {
+		return
+	}
+	collector.Lock()
+	pending := collector.pending
+	collector.pending = map[AnalyticsKey]int64{}
+	collector.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	if err := as.AddAnalytics(pending); err != nil {
+		log.Printf("analytics: flush: %v", err)
+		collector.Lock()
+		for k, n := range pending {
+			collector.pending[k] += n
+		}
+		collector.Unlock()
+	}
+}
+
+// collectPageviews records every HTML page served to a visitor: its path,
+// where the visitor came from and whether they are new today. Bots,
+// staff, admin pages and anything but a 200 are left out.
+func collectPageviews(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !analyticsEnabled || r.Method != http.MethodGet || exporting {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		mt, _, _ := strings.Cut(rec.Header().Get("Content-Type"), ";")
+		if rec.Status() != http.StatusOK || mt != "text/html" || !countable(r) {
+			return
+		}
+		now := time.Now().UTC()
+		visitor := visitorHash(r, now)
+		day := now.Format(time.DateOnly)
+		page := truncate(r.URL.Path, 200)
+		collector.Lock()
+		defer collector.Unlock()
+		collector.add(AnalyticsKey{day, KindViews, ""})
+		collector.add(AnalyticsKey{day, KindPage, page})
+		if src := trafficSource(r); src != "" {
+			collector.add(AnalyticsKey{day, KindReferrer, src})
+		}
+		if collector.firstSeen(visitor) {
+			collector.add(AnalyticsKey{day, KindVisitors, ""})
+		}
+		if collector.firstSeen(visitor + " " + page) {
+			collector.add(AnalyticsKey{day, KindPageVisitors, page})
+		}
+	})
+}
+
+// countSearchMiss records that a visitor searched for q and found
+// nothing, so editors can see what people look for in vain.
+func countSearchMiss(r *http.Request, q string) {
+	q = strings.Join(strings.Fields(strings.ToLower(q)), " ")
+	if !analyticsEnabled || exporting || q == "" || !countable(r) {
+		return
+	}
+	now := time.Now().UTC()
+	visitorHash(r, now) // starts a new day's counters if need be
+	collector.Lock()
+	collector.add(AnalyticsKey{now.Format(time.DateOnly), KindSearchMiss, truncate(q, 100)})
+	collector.Unlock()
+}
+
+// countable reports whether r is a visitor's request worth counting.
+func countable(r *http.Request) bool {
+	if isBot(r) || staff(r) || strings.HasPrefix(r.URL.Path, "/admin") || strings.HasPrefix(r.URL.Path, "/preview/") {
+		return false
+	}
+	// Prefetches may never be looked at.
+	return r.Header.Get("Sec-Purpose") == "" && r.Header.Get("Purpose") != "prefetch"
+}
+
+// visitorHash is the day's stand-in for the visitor, rotating the salt
+// when the day changes.
+func visitorHash(r *http.Request, now time.Time) string {
+	day := now.Format(time.DateOnly)
+	collector.Lock()
+	if collector.day != day {
+		collector.day = day
+		collector.salt = make([]byte, 32)
+		rand.Read(collector.salt)
+		collector.seen = map[string]bool{}
+		collector.keys = map[string]int{}
+	}
+	salt := collector.salt
+	collector.Unlock()
+	sum := sha256.Sum256([]byte(string(salt) + clientIP(r) + "\x00" + r.UserAgent()))
+	return hex.EncodeToString(sum[:12])
+}
+
+// add counts one for k unless its kind has hit today's key cap. The
+// caller holds the lock.
+func (c *analyticsCollector) add(k AnalyticsKey) {
+	if _, ok := c.pending[k]; !ok && k.Key != "" {
+		if c.keys[k.Kind] >= analyticsKeysPerDay {
+			return
+		}
+		c.keys[k.Kind]++
+	}
+	c.pending[k]++
+}
+
+// firstSeen reports whether id is new today, remembering it. Past
+// analyticsSeenMax every visitor counts as seen, so the estimate errs
+// low rather than using unbounded memory.
+func (c *analyticsCollector) firstSeen(id string) bool {
+	if c.seen[id] || len(c.seen) >= analyticsSeenMax {
+		return false
+	}
+	c.seen[id] = true
+	return true
+}
+
+// trafficSource names where a pageview came from: the utm_source of a
+// campaign link, the host of another site, or (direct); moving between
+// pages of this site is not a source.
+func trafficSource(r *http.Request) string {
+	if src := r.URL.Query().Get("utm_source"); src != "" {
+		return "utm:" + truncate(strings.ToLower(src), 60)
+	}
+	ref := r.Referer()
+	if ref == "" {
+		return directSource
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	self, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		self = r.Host
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if host == strings.TrimPrefix(strings.ToLower(self), "www.") {
+		return ""
+	}
+	return host
+}
+
+// collectHandler records an event the server doesn't see itself, sent
+// by main.js as {"event": "outbound", "detail": "github.com"}: leaving
+// for another site, or playing a video. Detail is at most a host or a
+// path; anything longer is cut.
+func collectHandler(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Event  string `json:"event"`
+		Detail string `json:"detail"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&in); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if !flagName.MatchString(in.Event) || len(in.Event) > 40 {
+		writeJSONError(w, http.StatusBadRequest, "event must be a short lowercase name")
+		return
+	}
+	if analyticsEnabled && countable(r) {
+		now := time.Now().UTC()
+		visitorHash(r, now) // starts a new day's counters if need be
+		collector.Lock()
+		collector.add(AnalyticsKey{now.Format(time.DateOnly), KindEvent, strings.TrimSpace(in.Event + " " + truncate(in.Detail, 200))})
+		collector.Unlock()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AnalyticsCount is one line of a top list.
+type AnalyticsCount struct {
+	Key      string `json:"key"`
+	Count    int64  `json:"count"`
+	Visitors int64  `json:"visitors,omitempty"`
+}
+
+// AnalyticsDay is one day's totals.
+type AnalyticsDay struct {
+	Day      string `json:"day"`
+	Views    int64  `json:"views"`
+	Visitors int64  `json:"visitors"`
+}
+
+// AnalyticsItem is an item's pageviews in a report, whether reached by
+// ID or slug, beside its all-time count from the view counter.
+type AnalyticsItem struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	Slug     string `json:"slug"`
+	Views    int64  `json:"views"`
+	Visitors int64  `json:"visitors"`
+	AllTime  int64  `json:"all_time"`
+}
+
+// AnalyticsReport aggregates the days from through to. Visitors are
+// summed over days, so someone coming back on two days counts twice.
+type AnalyticsReport struct {
+	From         string           `json:"from"`
+	To           string           `json:"to"`
+	Views        int64            `json:"views"`
+	Visitors     int64            `json:"visitors"`
+	Days         []AnalyticsDay   `json:"days"`
+	Pages        []AnalyticsCount `json:"pages"`
+	Items        []AnalyticsItem  `json:"items"`
+	Referrers    []AnalyticsCount `json:"referrers"`
+	Events       []AnalyticsCount `json:"events"`
+	SearchMisses []AnalyticsCount `json:"search_misses"`
+	Shares       []AnalyticsCount `json:"shares"` // short link visits by item slug and source
+}
+
+// analyticsReport adds up the stored and pending counts of the days from
+// through to, keeping the top limit of each list.
+func analyticsReport(from, to time.Time, limit int) (AnalyticsReport, error) {
+	rep := AnalyticsReport{From: from.Format(time.DateOnly), To: to.Format(time.DateOnly)}
+	as, ok := store.(analyticsStore)
+	if !ok {
+		return rep, errors.New("store does not keep analytics")
+	}
+	rows, err := as.Analytics(rep.From, rep.To)
+	if err != nil {
+		return rep, err
+	}
+	collector.Lock()
+	for k, n := range collector.pending {
+		if k.Day >= rep.From && k.Day <= rep.To {
+			rows = append(rows, AnalyticsRow{k, n})
+		}
+	}
+	collector.Unlock()
+
+	days := map[string]*AnalyticsDay{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		rep.Days = append(rep.Days, AnalyticsDay{Day: d.Format(time.DateOnly)})
+	}
+	for i := range rep.Days {
+		days[rep.Days[i].Day] = &rep.Days[i]
+	}
+	pages, visitors, refs, events, misses, shares := map[string]int64{}, map[string]int64{}, map[string]int64{}, map[string]int64{}, map[string]int64{}, map[string]int64{}
+	for _, row := range rows {
+		switch row.Kind {
+		case KindViews:
+			rep.Views += row.Count
+			if d := days[row.Day]; d != nil {
+				d.Views += row.Count
+			}
+		case KindVisitors:
+			rep.Visitors += row.Count
+			if d := days[row.Day]; d != nil {
+				d.Visitors += row.Count
+			}
+		case KindPage:
+			pages[row.Key] += row.Count
+		case KindPageVisitors:
+			visitors[row.Key] += row.Count
+		case KindReferrer:
+			refs[row.Key] += row.Count
+		case KindEvent:
+			events[row.Key] += row.Count
+		case KindSearchMiss:
+			misses[row.Key] += row.Count
+		case KindShare:
+			shares[row.Key] += row.Count
+		}
+	}
+	rep.Pages = topCounts(pages, visitors, limit)
+	rep.Items = topItems(pages, visitors, limit)
+	rep.Referrers = topCounts(refs, nil, limit)
+	rep.Events = topCounts(events, nil, limit)
+	rep.SearchMisses = topCounts(misses, nil, limit)
+	rep.Shares = topCounts(shares, nil, limit)
+	return rep, nil
+}
+
+// topItems adds up the views of item pages by item, most viewed first.
+// Pages of items that no longer exist are left out.
+func topItems(pages, visitors map[string]int64, limit int) []AnalyticsItem {
+	c, now := current(), time.Now()
+	byID := map[int]*AnalyticsItem{}
+	for page, n := range pages {
+		key, ok := strings.CutPrefix(page, "/items/")
+		if !ok {
+			continue
+		}
+		it, ok := c.lookup(key, now)
+		if !ok {
+			continue
+		}
+		ai := byID[it.ID]
+		if ai == nil {
+			ai = &AnalyticsItem{ID: it.ID, Title: it.KeywordTitle, Slug: it.Slug, AllTime: viewsOf(it.ID).Page}
+			byID[it.ID] = ai
+		}
+		ai.Views += n
+		ai.Visitors += visitors[page]
+	}
+	out := make([]AnalyticsItem, 0, len(byID))
+	for _, ai := range byID {
+		out = append(out, *ai)
+	}
+	slices.SortFunc(out, func(a, b AnalyticsItem) int {
+		return cmp.Or(cmp.Compare(b.Views, a.Views), cmp.Compare(a.ID, b.ID))
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// topCounts lists the limit largest counts, most first.
+func topCounts(counts, visitors map[string]int64, limit int) []AnalyticsCount {
+	out := make([]AnalyticsCount, 0, len(counts))
+	for k, n := range counts {
+		out = append(out, AnalyticsCount{Key: k, Count: n, Visitors: visitors[k]})
+	}
+	slices.SortFunc(out, func(a, b AnalyticsCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Key, b.Key))
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// analyticsRange reads from and to as dates, defaulting to the 30 days
+// up to today.
+func analyticsRange(q url.Values) (from, to time.Time, err error) {
+	to = time.Now().UTC().Truncate(24 * time.Hour)
+	from = to.AddDate(0, 0, -29)
+	if s := q.Get("to"); s != "" {
+		if to, err = time.Parse(time.DateOnly, s); err != nil {
+			return from, to, fmt.Errorf("to must be a date like 2006-01-02")
+		}
+		from = to.AddDate(0, 0, -29)
+	}
+	if s := q.Get("from"); s != "" {
+		if from, err = time.Parse(time.DateOnly, s); err != nil {
+			return from, to, fmt.Errorf("from must be a date like 2006-01-02")
+		}
+	}
+	if from.After(to) {
+		return from, to, fmt.Errorf("from must not be after to")
+	}
+	if to.Sub(from) > 366*24*time.Hour {
+		return from, to, fmt.Errorf("the range can be at most a year")
+	}
+	return from, to, nil
+}
+
+// adminAnalyticsHandler returns the report for ?from= through ?to=.
+func adminAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := analyticsRange(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rep, err := analyticsReport(from, to, 20)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// statsRanges are the preset ranges offered on /admin/stats, in days.
+var statsRanges = []int{7, 30, 90, 365}
+
+// chartBar is one day of the /admin/stats chart in SVG units: the views
+// bar and, inside it, the visitors bar.
+type chartBar struct {
+	AnalyticsDay
+	X, W, Y, H, VY, VH float64
+}
+
+// Chart size in SVG units; the chart scales to its box.
+const (
+	chartWidth  = 900
+	chartHeight = 200
+)
+
+// viewsChart lays the days out as bars scaled to the busiest day.
+func viewsChart(days []AnalyticsDay) []chartBar {
+	if len(days) == 0 {
+		return nil
+	}
+	peak := int64(1)
+	for _, d := range days {
+		peak = max(peak, d.Views)
+	}
+	step := float64(chartWidth) / float64(len(days))
+	out := make([]chartBar, len(days))
+	for i, d := range days {
+		h := chartHeight * float64(d.Views) / float64(peak)
+		vh := chartHeight * float64(d.Visitors) / float64(peak)
+		out[i] = chartBar{
+			AnalyticsDay: d,
+			X:            float64(i)*step + step*0.1,
+			W:            step * 0.8,
+			Y:            chartHeight - h,
+			H:            h,
+			VY:           chartHeight - vh,
+			VH:           vh,
+		}
+	}
+	return out
+}
+
+// adminStatsHandler renders the analytics dashboard for ?from= through
+// ?to=.
+func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	from, to, err := analyticsRange(r.URL.Query())
+	var problem string
+	if err != nil {
+		status, problem = http.StatusBadRequest, err.Error()
+		from, to, _ = analyticsRange(nil)
+	}
+	rep, err := analyticsReport(from, to, 20)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	type preset struct {
+		Label   string
+		URL     string
+		Current bool
+	}
+	var presets []preset
+	for _, n := range statsRanges {
+		start := to.AddDate(0, 0, 1-n).Format(time.DateOnly)
+		presets = append(presets, preset{
+			Label:   fmt.Sprintf("%d days", n),
+			URL:     "/admin/stats?" + url.Values{"from": {start}, "to": {today}}.Encode(),
+			Current: rep.From == start && rep.To == today,
+		})
+	}
+	data := map[string]interface{}{
+		"Title":       "Stats | Admin",
+		"Report":      rep,
+		"Chart":       viewsChart(rep.Days),
+		"ChartWidth":  chartWidth,
+		"ChartHeight": chartHeight,
+		"Presets":     presets,
+		"Today":       today,
+		"Error":       problem,
+		"Enabled":     analyticsEnabled,
+	}
+	if err := renderStatus(w, status, "admin_stats.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// analyticsPath is where the JSON store keeps counts, beside items.json.
+func (s *jsonStore) analyticsPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".analytics.json"
+}
+
+func (s *jsonStore) readAnalytics() ([]AnalyticsRow, error) {
+	var rows []AnalyticsRow
+	if ok, err := readRecoverable(s.analyticsPath(), &rows); !ok {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (s *jsonStore) Analytics(from, to string) ([]AnalyticsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows, err := s.readAnalytics()
+	return slices.DeleteFunc(rows, func(r AnalyticsRow) bool { return r.Day < from || r.Day > to }), err
+}
+
+func (s *jsonStore) AddAnalytics(deltas map[AnalyticsKey]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows, err := s.readAnalytics()
+	if err != nil {
+		return err
+	}
+	index := make(map[AnalyticsKey]int, len(rows))
+	for i, r := range rows {
+		index[r.AnalyticsKey] = i
+	}
+	for k, n := range deltas {
+		if i, ok := index[k]; ok {
+			rows[i].Count += n
+		} else {
+			rows = append(rows, AnalyticsRow{k, n})
+		}
+	}
+	slices.SortFunc(rows, func(a, b AnalyticsRow) int {
+		return cmp.Or(strings.Compare(a.Day, b.Day), strings.Compare(a.Kind, b.Kind), strings.Compare(a.Key, b.Key))
+	})
+	data, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.analyticsPath(), data)
+}
+
+func (s *sqliteStore) Analytics(from, to string) ([]AnalyticsRow, error) {
+	rows, err := s.db.Query(`SELECT day, kind, key, count FROM analytics WHERE day BETWEEN ? AND ?`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AnalyticsRow
+	for rows.Next() {
+		var row AnalyticsRow
+		if err := rows.Scan(&row.Day, &row.Kind, &row.Key, &row.Count); err != nil {
+			return nil, err
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) AddAnalytics(deltas map[AnalyticsKey]int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for k, n := range deltas {
+		if _, err := tx.Exec(`INSERT INTO analytics (day, kind, key, count) VALUES (?, ?, ?, ?)
+			ON CONFLICT(day, kind, key) DO UPDATE SET count = count + excluded.count`,
+			k.Day, k.Kind, k.Key, n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
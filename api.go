@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeJSON encodes v as the response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writeJSON: %v", err)
+	}
+}
+
+// wantsJSON reports whether the client asked for JSON, via ?format=json or
+// an Accept header preferring application/json over HTML.
+func wantsJSON(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "json"
+	}
+	accept := r.Header.Get("Accept")
+	j := strings.Index(accept, "application/json")
+	h := strings.Index(accept, "text/html")
+	return j >= 0 && (h < 0 || j < h)
+}
+
+// isAPI reports whether path is served to API clients rather than
+// browsers: everything under /api/ plus the GraphQL endpoint.
+func isAPI(path string) bool {
+	return strings.HasPrefix(path, "/api/") || path == "/graphql"
+}
+
+// writeJSONError writes {"error": msg} with the given status.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// ndjsonFlushEvery is how many lines are written between flushes.
+const ndjsonFlushEvery = 100
+
+// itemsNDJSONHandler streams every item as one JSON document per line so
+// clients can process large catalogs without buffering the whole array.
+func itemsNDJSONHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i, it := range current().live(time.Now()) {
+		if r.Context().Err() != nil {
+			return
+		}
+		// Encode appends the newline that terminates each record.
+		if err := enc.Encode(&it); err != nil {
+			return
+		}
+		if flusher != nil && (i+1)%ndjsonFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+}
+
+// itemsHandler returns the live items as a JSON array, in the ?sort=
+// order when given. With ?page= or ?per_page= it returns one page, with
+// X-Total-Count and Link headers.
+func itemsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	order := q.Get("sort")
+	if !validSort(order) {
+		writeJSONError(w, http.StatusBadRequest, "sort must be newest, popular or alpha")
+		return
+	}
+	c := current()
+	live := withViews(c.sortItems(c.live(time.Now()), order))
+	if !q.Has("page") && !q.Has("per_page") {
+		writeJSON(w, http.StatusOK, live)
+		return
+	}
+	page, p := paginate(r, live)
+	w.Header().Set("X-Total-Count", strconv.Itoa(p.Total))
+	if link := p.linkHeader(); link != "" {
+		w.Header().Set("Link", link)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// itemHandler returns the item named by the {id} path segment, with an
+// ETag of its content so clients polling it can send If-None-Match.
+func itemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "item id must be an integer")
+		return
+	}
+	c := current()
+	it, ok := c.byID[id]
+	if !ok || !it.visible(time.Now()) {
+		writeJSONError(w, http.StatusNotFound, "item not found")
+		return
+	}
+	setItemHeaders(w, it)
+	// View counts change between reloads, so they'd make the ETag lie.
+	if !showViews && notModified(w, r, c.etags[id]) {
+		return
+	}
+	writeJSON(w, http.StatusOK, withViews([]Item{*it})[0])
+}
+
+// resolveHandler returns the item that owns ?link=.
+func resolveHandler(w http.ResponseWriter, r *http.Request) {
+	link := r.URL.Query().Get("link")
+	if link == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing link parameter")
+		return
+	}
+	it, ok := current().byLink[link]
+	if !ok || !it.visible(time.Now()) {
+		writeJSONError(w, http.StatusNotFound, "no item with that link")
+		return
+	}
+	writeJSON(w, http.StatusOK, it)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestItemsNDJSON(t *testing.T) {
+	var items []Item
+	for id := 1; id <= 2*ndjsonFlushEvery+5; id++ {
+		items = append(items, testItem(id, fmt.Sprintf("Project %03d", id)))
+	}
+	draft := testItem(len(items)+1, "Draft")
+	draft.Status = StatusDraft
+	useItems(t, append(items, draft)...)
+
+	w := get("/api/items.ndjson")
+	if w.Code != 200 {
+		t.Fatalf("status %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	sc := bufio.NewScanner(w.Body)
+	n := 0
+	for sc.Scan() {
+		var it Item
+		if err := json.Unmarshal(sc.Bytes(), &it); err != nil {
+			t.Fatalf("line %d: %v", n+1, err)
+		}
+		if it.ID != items[n].ID {
+			t.Errorf("line %d: item %d, want %d", n+1, it.ID, items[n].ID)
+		}
+		n++
+	}
+	if n != len(items) {
+		t.Errorf("%d lines, want %d published items", n, len(items))
+	}
+}
+
+func TestItemETag(t *testing.T) {
+	useItems(t, testItem(1, "Alpha"), testItem(2, "Beta"))
+	first := get("/api/items/1")
+	tag := first.Header().Get("ETag")
+	if first.Code != 200 || tag == "" {
+		t.Fatalf("status %d, ETag %q", first.Code, tag)
+	}
+	other := get("/api/items/2").Header().Get("ETag")
+
+	changed := testItem(1, "Alpha")
+	changed.Tags = []string{"New"}
+	tests := []struct {
+		name        string
+		items       []Item
+		ifNoneMatch string
+		want        int
+	}{
+		{"unchanged", nil, tag, 304},
+		{"weak form", nil, "W/" + tag, 304},
+		{"one of several", nil, other + ", " + tag, 304},
+		{"another item's", nil, other, 200},
+		{"unconditional", nil, "", 200},
+		{"other item changed", []Item{testItem(1, "Alpha"), testItem(2, "Beta Two")}, tag, 304},
+		{"item changed", []Item{changed, testItem(2, "Beta")}, tag, 200},
+	}
+	for _, tt := range tests {
+		if tt.items != nil {
+			useItems(t, tt.items...)
+		}
+		w := get("/api/items/1", "If-None-Match", tt.ifNoneMatch)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if w.Code == 304 && w.Body.Len() > 0 {
+			t.Errorf("%s: 304 with a body", tt.name)
+		}
+	}
+}
+
+func TestItemPageETag(t *testing.T) {
+	for _, cached := range []bool{false, true} {
+		useItems(t, testItem(1, "Alpha"), testItem(2, "Beta"))
+		if cached {
+			prev := pageTTLs
+			pageTTLs = map[string]time.Duration{"item": time.Hour}
+			t.Cleanup(func() { pageTTLs = prev })
+		}
+		first := get("/items/1", "Accept", "application/json")
+		tag := first.Header().Get("ETag")
+		if first.Code != 200 || tag == "" {
+			t.Fatalf("cached=%v: status %d, ETag %q", cached, first.Code, tag)
+		}
+		w := get("/items/1", "Accept", "application/json", "If-None-Match", tag)
+		if w.Code != 304 || w.Body.Len() > 0 {
+			t.Errorf("cached=%v: unchanged item: status %d, %d bytes", cached, w.Code, w.Body.Len())
+		}
+
+		changed := testItem(1, "Alpha")
+		changed.Tags = []string{"New"}
+		useItems(t, changed, testItem(2, "Beta"))
+		w = get("/items/1", "Accept", "application/json", "If-None-Match", tag)
+		if w.Code != 200 || w.Header().Get("ETag") == tag {
+			t.Errorf("cached=%v: changed item: status %d, ETag %q", cached, w.Code, w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+
+	const link = "https://example.com/demo?a=1&b=2"
+	first, dup, other := testItem(1, "Alpha"), testItem(2, "Beta"), testItem(3, "Gamma")
+	first.ItemLink, dup.ItemLink, other.ItemLink = link, link, "https://example.com/gamma"
+	useItems(t, first, dup, other)
+	if !strings.Contains(logs.String(), "items 1 and 2 share ItemLink") {
+		t.Errorf("no warning about the duplicate link; logged %q", logs.String())
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		id     int
+	}{
+		{"match", "?link=https://example.com/gamma", 200, 3},
+		{"escaped match", "?link=" + url.QueryEscape("https://example.com/gamma"), 200, 3},
+		{"duplicate resolves to the first", "?link=" + url.QueryEscape(link), 200, 1},
+		{"miss", "?link=https://example.com/nowhere", 404, 0},
+		{"missing parameter", "", 400, 0},
+	}
+	for _, tt := range tests {
+		w := get("/api/resolve" + tt.query)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+			continue
+		}
+		if tt.status != 200 {
+			continue
+		}
+		var it Item
+		if err := json.Unmarshal(w.Body.Bytes(), &it); err != nil {
+			t.Fatal(err)
+		}
+		if it.ID != tt.id {
+			t.Errorf("%s: resolved to item %d, want %d", tt.name, it.ID, tt.id)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// embedded holds a copy of the site's templates and assets so the binary
+// runs from any working directory.
+//
+//go:embed templates/*.html styles.css main.js static
+var embedded embed.FS
+
+// The file systems pages and assets are served from. Each is the on-disk
+// directory when present, so edits show up without a rebuild, and the
+// embedded copy otherwise.
+var (
+	templateFS fs.FS
+	staticFS   fs.FS
+	rootFS     fs.FS // styles.css and main.js
+)
+
+// setAssets picks the on-disk or embedded source for each file system.
+func setAssets(cfg Config) {
+	templateFS = assetFS(cfg.TemplateDir, "templates")
+	staticFS = assetFS(cfg.StaticDir, "static")
+	if _, err := os.Stat("styles.css"); err == nil {
+		rootFS = os.DirFS(".")
+	} else {
+		rootFS = embedded
+		log.Printf("Serving styles.css and main.js from the embedded copy")
+	}
+}
+
+// assetFS returns dir when it is a directory, otherwise the embedded
+// subtree sub.
+func assetFS(dir, sub string) fs.FS {
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return os.DirFS(dir)
+	}
+	log.Printf("%s not found; serving the embedded %s/", dir, sub)
+	f, err := fs.Sub(embedded, sub)
+	if err != nil {
+		panic(err) // sub is a constant embedded above
+	}
+	return f
+}
+
+// embeddedItems is the items.json bundled with the binary, read by the
+// JSON store until its own file has been written.
+const embeddedItems = "static/data/items.json"
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditEntry records one change made through the admin: who made it,
+// from where, what it was done to, and the state before and after.
+// Before is absent for something created and After for something
+// removed; Changes names the fields that differ.
+type AuditEntry struct {
+	ID      int64           `json:"id"`
+	At      time.Time       `json:"at"`
+	Actor   string          `json:"actor"`
+	IP      string          `json:"ip"`
+	Action  string          `json:"action"`
+	Target  string          `json:"target"`
+	Changes []string        `json:"changes,omitempty"`
+	Before  json.RawMessage `json:"before,omitempty"`
+	After   json.RawMessage `json:"after,omitempty"`
+}
+
+// auditStore is implemented by repositories that keep the audit log.
+// AddAudit assigns the entry's ID; Audit lists every entry, oldest
+// first.
+type auditStore interface {
+	AddAudit(e *AuditEntry) error
+	Audit() ([]AuditEntry, error)
+}
+
+// auditShown caps the entries the admin page lists; the exports have
+// them all.
+const auditShown = 500
+
+// audit records that the admin making r did action to target, changing
+// it from before to after; either is nil when there was nothing. The
+// change has already happened, so a failure is logged rather than
+// undoing it.
+func audit(r *http.Request, action, target string, before, after any) {
+	as, ok := store.(auditStore)
+	if !ok {
+		return
+	}
+	e := AuditEntry{
+		At:     time.Now().UTC(),
+		Actor:  actor(r),
+		IP:     clientIP(r),
+		Action: action,
+		Target: target,
+		Before: auditJSON(before),
+		After:  auditJSON(after),
+	}
+	e.Changes = auditDiff(e.Before, e.After)
+	if err := as.AddAudit(&e); err != nil {
+		log.Printf("audit: %s %s: %v", action, target, err)
+	}
+}
+
+// auditJSON is v as JSON, or nil for nil.
+func auditJSON(v any) json.RawMessage {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// auditDiff names the top-level fields that differ between before and
+// after when both are objects.
+func auditDiff(before, after json.RawMessage) []string {
+	if before == nil || after == nil {
+		return nil
+	}
+	var b, a map[string]json.RawMessage
+	if json.Unmarshal(before, &b) != nil || json.Unmarshal(after, &a) != nil {
+		return nil
+	}
+	keys := slices.Collect(maps.Keys(b))
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return slices.DeleteFunc(keys, func(k string) bool { return bytes.Equal(b[k], a[k]) })
+}
+
+// auditFilter selects entries by the query of an audit request: actor,
+// action (a prefix, so "item" covers item.update and item.delete),
+// target (a substring), and from and to days as 2006-01-02.
+type auditFilter struct {
+	Actor, Action, Target, From, To string
+}
+
+func parseAuditFilter(r *http.Request) auditFilter {
+	q := r.URL.Query()
+	return auditFilter{
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Action: strings.TrimSpace(q.Get("action")),
+		Target: strings.TrimSpace(q.Get("target")),
+		From:   q.Get("from"),
+		To:     q.Get("to"),
+	}
+}
+
+func (f auditFilter) match(e AuditEntry) bool {
+	day := e.At.Format(time.DateOnly)
+	return (f.Actor == "" || strings.EqualFold(e.Actor, f.Actor)) &&
+		strings.HasPrefix(e.Action, f.Action) &&
+		strings.Contains(strings.ToLower(e.Target), strings.ToLower(f.Target)) &&
+		(f.From == "" || day >= f.From) &&
+		(f.To == "" || day <= f.To)
+}
+
+// auditEntries returns the entries matching r's filter, newest first.
+func auditEntries(r *http.Request) (auditFilter, []AuditEntry, error) {
+	f := parseAuditFilter(r)
+	as, ok := store.(auditStore)
+	if !ok {
+		return f, nil, errors.New("store does not keep an audit log")
+	}
+	all, err := as.Audit()
+	if err != nil {
+		return f, nil, err
+	}
+	all = slices.DeleteFunc(all, func(e AuditEntry) bool { return !f.match(e) })
+	slices.Reverse(all)
+	return f, all, nil
+}
+
+// adminAuditHandler lists the audit log with its filter form.
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	f, all, err := auditEntries(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	data := map[string]interface{}{
+		"Title":   "Audit log | Admin",
+		"Entries": all[:min(len(all), auditShown)],
+		"Total":   len(all),
+		"Filter":  f,
+		"Query":   r.URL.RawQuery,
+	}
+	if err := render(w, "admin_audit.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// adminAuditAPIHandler answers the filtered audit log as JSON.
+func adminAuditAPIHandler(w http.ResponseWriter, r *http.Request) {
+	_, all, err := auditEntries(r)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entries": all})
+}
+
+// adminAuditCSVHandler exports the filtered audit log, one row per entry
+// with the before and after states as JSON.
+func adminAuditCSVHandler(w http.ResponseWriter, r *http.Request) {
+	_, all, err := auditEntries(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "at", "actor", "ip", "action", "target", "changes", "before", "after"})
+	for _, e := range all {
+		cw.Write([]string{
+			strconv.FormatInt(e.ID, 10), e.At.Format(time.RFC3339), e.Actor, e.IP, e.Action, e.Target,
+			strings.Join(e.Changes, " "), string(e.Before), string(e.After),
+		})
+	}
+	cw.Flush()
+}
+
+// auditPath is where the JSON store keeps the audit log, one entry per
+// line, beside items.json.
+func (s *jsonStore) auditPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".audit.ndjson"
+}
+
+func (s *jsonStore) readAudit() ([]AuditEntry, error) {
+	data, err := os.ReadFile(s.auditPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []AuditEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", s.auditPath(), line, err)
+		}
+		out = append(out, e)
+	}
+	return out, sc.Err()
+}
+
+func (s *jsonStore) Audit() ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readAudit()
+}
+
+func (s *jsonStore) AddAudit(e *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readAudit()
+	if err != nil {
+		return err
+	}
+	e.ID = 1
+	if len(all) > 0 {
+		e.ID = all[len(all)-1].ID + 1
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.auditPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *sqliteStore) Audit() ([]AuditEntry, error) {
+	rows, err := s.db.Query(`SELECT data FROM audit ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AuditEntry
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var e AuditEntry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) AddAudit(e *AuditEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO audit (created_at, actor, action, data) VALUES (?, ?, ?, '')`, e.At, e.Actor, e.Action)
+	if err != nil {
+		return err
+	}
+	if e.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE audit SET data = ? WHERE id = ?`, string(data), e.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
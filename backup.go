@@ -0,0 +1,611 @@
+package main
+
+import (
+	"archive/tar"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupVersion is the schema of the archives written here. Restoring
+// refuses archives from a newer schema, which this build can't know how
+// to read; an older one would be migrated as it's read when the schema
+// changes.
+const backupVersion = 1
+
+// backupEntryMax caps one decompressed file of an archive, so a
+// malicious upload can't expand without bound.
+const backupEntryMax = 512 << 20
+
+// backupManifest is the first file of an archive, manifest.json. Sections
+// counts the entries of each file that follows; a section the store
+// couldn't hold is absent and left alone on restore.
+type backupManifest struct {
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Sections  map[string]int `json:"sections"`
+}
+
+// Backup is a complete snapshot of the store: the catalog, view counts
+// and likes, comments, subscribers, redirects, collections and flag
+// overrides. User
+// accounts are left out, so restoring never changes who can sign in.
+type Backup struct {
+	Manifest    backupManifest
+	Items       []Item
+	Views       map[int]Views
+	Likes       map[string][]int
+	Comments    []Comment
+	Subscribers []Subscriber
+	Redirects   []Redirect
+	Collections []Collection
+	Flags       []FeatureFlag
+}
+
+// backupSection is one file of an archive and the field it decodes into.
+type backupSection struct {
+	name string
+	v    any
+}
+
+// sections pairs each section's name, which is also its file name in the
+// archive, with the field holding it, in the order they're written and
+// restored. Items go before redirects, so redirects an item rename adds
+// are replaced by the archive's.
+func (b *Backup) sections() []backupSection {
+	return []backupSection{
+		{"items", &b.Items},
+		{"views", &b.Views},
+		{"likes", &b.Likes},
+		{"comments", &b.Comments},
+		{"subscribers", &b.Subscribers},
+		{"redirects", &b.Redirects},
+		{"collections", &b.Collections},
+		{"flags", &b.Flags},
+	}
+}
+
+// backupStore is implemented by repositories that can replace their
+// comments and subscribers wholesale. Restoring needs it: putting comments
+// back one by one would renumber them and break their replies.
+type backupStore interface {
+	ReplaceComments(all []Comment) error
+	ReplaceSubscribers(all []Subscriber) error
+}
+
+// takeBackup reads everything repo keeps into a Backup.
+func takeBackup(repo ItemRepository) (*Backup, error) {
+	b := &Backup{Manifest: backupManifest{Version: backupVersion, CreatedAt: time.Now().UTC(), Sections: map[string]int{}}}
+	sections := b.Manifest.Sections
+	var err error
+	if b.Items, err = repo.List(); err != nil {
+		return nil, fmt.Errorf("items: %w", err)
+	}
+	sections["items"] = len(b.Items)
+	if vs, ok := repo.(viewStore); ok {
+		if b.Views, err = vs.LoadViews(); err != nil {
+			return nil, fmt.Errorf("views: %w", err)
+		}
+		sections["views"] = len(b.Views)
+	}
+	if ls, ok := repo.(likeStore); ok {
+		if b.Likes, err = ls.LoadLikes(); err != nil {
+			return nil, fmt.Errorf("likes: %w", err)
+		}
+		sections["likes"] = len(b.Likes)
+	}
+	if _, ok := repo.(backupStore); ok {
+		if cs, ok := repo.(commentStore); ok {
+			if b.Comments, err = cs.LoadComments(); err != nil {
+				return nil, fmt.Errorf("comments: %w", err)
+			}
+			sections["comments"] = len(b.Comments)
+		}
+		if ss, ok := repo.(subscriberStore); ok {
+			if b.Subscribers, err = ss.Subscribers(); err != nil {
+				return nil, fmt.Errorf("subscribers: %w", err)
+			}
+			sections["subscribers"] = len(b.Subscribers)
+		}
+	}
+	if rs, ok := repo.(redirectStore); ok {
+		if b.Redirects, err = rs.Redirects(); err != nil {
+			return nil, fmt.Errorf("redirects: %w", err)
+		}
+		sections["redirects"] = len(b.Redirects)
+	}
+	if cs, ok := repo.(collectionStore); ok {
+		if b.Collections, err = cs.Collections(); err != nil {
+			return nil, fmt.Errorf("collections: %w", err)
+		}
+		sections["collections"] = len(b.Collections)
+	}
+	if fs, ok := repo.(flagStore); ok {
+		if b.Flags, err = fs.LoadFlags(); err != nil {
+			return nil, fmt.Errorf("flags: %w", err)
+		}
+		sections["flags"] = len(b.Flags)
+	}
+	return b, nil
+}
+
+// writeBackup writes b to w as a gzipped tar of manifest.json and one
+// JSON file per section.
+func writeBackup(w io.Writer, b *Backup) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	add := func(name string, v any) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: b.Manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+	if err := add("manifest.json", b.Manifest); err != nil {
+		return err
+	}
+	for _, s := range b.sections() {
+		if _, ok := b.Manifest.Sections[s.name]; !ok {
+			continue
+		}
+		if err := add(s.name+".json", s.v); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// readBackup reads an archive writeBackup wrote, checking its schema
+// version, that it holds every section its manifest lists with the
+// counts listed, and that its items are valid.
+func readBackup(r io.Reader) (*Backup, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	next := func() (*tar.Header, io.Reader, error) {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Size > backupEntryMax {
+			return nil, nil, fmt.Errorf("%s: %d bytes is over the limit of %d", hdr.Name, hdr.Size, backupEntryMax)
+		}
+		return hdr, io.LimitReader(tr, backupEntryMax), nil
+	}
+	hdr, body, err := next()
+	if err == io.EOF || err == nil && hdr.Name != "manifest.json" {
+		return nil, errors.New("not a backup archive: manifest.json must come first")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	b := &Backup{}
+	if err := json.NewDecoder(body).Decode(&b.Manifest); err != nil {
+		return nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	switch m := b.Manifest; {
+	case m.Version <= 0:
+		return nil, errors.New("manifest.json: no schema version")
+	case m.Version > backupVersion:
+		return nil, fmt.Errorf("backup has schema version %d; this build restores up to version %d", m.Version, backupVersion)
+	}
+	fields := map[string]any{}
+	for _, s := range b.sections() {
+		fields[s.name+".json"] = s.v
+	}
+	seen := map[string]bool{}
+	for {
+		hdr, body, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		v, ok := fields[hdr.Name]
+		name := strings.TrimSuffix(hdr.Name, ".json")
+		if _, listed := b.Manifest.Sections[name]; !ok || !listed || seen[name] {
+			return nil, fmt.Errorf("%s: not a section the manifest lists", hdr.Name)
+		}
+		if err := json.NewDecoder(body).Decode(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		if n, want := reflect.ValueOf(v).Elem().Len(), b.Manifest.Sections[name]; n != want {
+			return nil, fmt.Errorf("%s: %d entries, but the manifest lists %d", hdr.Name, n, want)
+		}
+		seen[name] = true
+	}
+	for name := range b.Manifest.Sections {
+		if !seen[name] {
+			return nil, fmt.Errorf("%s.json is missing", name)
+		}
+	}
+	if !seen["items"] {
+		return nil, errors.New("items.json is missing")
+	}
+	if err := checkItems(b.Items, nil); err != nil {
+		return nil, fmt.Errorf("items.json: %w", err)
+	}
+	return b, nil
+}
+
+// restoreBackup puts repo back as b records it, by who. The catalog is
+// replaced item by item through the history, so a restore can itself be
+// rolled back; count, like, redirect, collection and flag tables are set
+// to the archive's; comments and subscribers are replaced wholesale. It
+// returns the entries restored per section.
+func restoreBackup(repo ItemRepository, who string, b *Backup) (map[string]int, error) {
+	done := map[string]int{}
+	has := func(name string) bool {
+		_, ok := b.Manifest.Sections[name]
+		return ok
+	}
+	current, err := repo.List()
+	if err != nil {
+		return done, fmt.Errorf("items: %w", err)
+	}
+	keep := map[int]bool{}
+	for _, it := range b.Items {
+		keep[it.ID] = true
+	}
+	for _, it := range current {
+		if !keep[it.ID] {
+			if err := deleteItem(repo, who, it.ID); err != nil {
+				return done, fmt.Errorf("items: %w", err)
+			}
+		}
+	}
+	for _, it := range b.Items {
+		if err := putItem(repo, who, RevRestore, it); err != nil {
+			return done, fmt.Errorf("items: %w", err)
+		}
+	}
+	done["items"] = len(b.Items)
+
+	if vs, ok := repo.(viewStore); ok && has("views") {
+		now, err := vs.LoadViews()
+		if err != nil {
+			return done, fmt.Errorf("views: %w", err)
+		}
+		deltas := map[int]Views{}
+		for id, v := range now {
+			deltas[id] = Views{Page: -v.Page, Video: -v.Video, Download: -v.Download}
+		}
+		for id, v := range b.Views {
+			deltas[id] = deltas[id].plus(v)
+		}
+		if err := vs.AddViews(deltas); err != nil {
+			return done, fmt.Errorf("views: %w", err)
+		}
+		done["views"] = len(b.Views)
+	}
+	if ls, ok := repo.(likeStore); ok && has("likes") {
+		now, err := ls.LoadLikes()
+		if err != nil {
+			return done, fmt.Errorf("likes: %w", err)
+		}
+		for visitor, ids := range now {
+			for _, id := range ids {
+				if !slices.Contains(b.Likes[visitor], id) {
+					if err := ls.SetLike(visitor, id, false); err != nil {
+						return done, fmt.Errorf("likes: %w", err)
+					}
+				}
+			}
+		}
+		for visitor, ids := range b.Likes {
+			for _, id := range ids {
+				if !slices.Contains(now[visitor], id) {
+					if err := ls.SetLike(visitor, id, true); err != nil {
+						return done, fmt.Errorf("likes: %w", err)
+					}
+				}
+			}
+		}
+		done["likes"] = len(b.Likes)
+	}
+	if bs, ok := repo.(backupStore); ok {
+		if has("comments") {
+			if err := bs.ReplaceComments(b.Comments); err != nil {
+				return done, fmt.Errorf("comments: %w", err)
+			}
+			done["comments"] = len(b.Comments)
+		}
+		if has("subscribers") {
+			if err := bs.ReplaceSubscribers(b.Subscribers); err != nil {
+				return done, fmt.Errorf("subscribers: %w", err)
+			}
+			done["subscribers"] = len(b.Subscribers)
+		}
+	}
+	if rs, ok := repo.(redirectStore); ok && has("redirects") {
+		now, err := rs.Redirects()
+		if err != nil {
+			return done, fmt.Errorf("redirects: %w", err)
+		}
+		for _, rd := range now {
+			if !slices.ContainsFunc(b.Redirects, func(x Redirect) bool { return x.From == rd.From }) {
+				if err := rs.DeleteRedirect(rd.From); err != nil && !errors.Is(err, ErrNotFound) {
+					return done, fmt.Errorf("redirects: %w", err)
+				}
+			}
+		}
+		for _, rd := range b.Redirects {
+			if err := rs.PutRedirect(rd); err != nil {
+				return done, fmt.Errorf("redirects: %w", err)
+			}
+		}
+		done["redirects"] = len(b.Redirects)
+	}
+	if cs, ok := repo.(collectionStore); ok && has("collections") {
+		now, err := cs.Collections()
+		if err != nil {
+			return done, fmt.Errorf("collections: %w", err)
+		}
+		for _, col := range now {
+			if !slices.ContainsFunc(b.Collections, func(x Collection) bool { return x.Slug == col.Slug }) {
+				if err := cs.DeleteCollection(col.Slug); err != nil && !errors.Is(err, ErrNotFound) {
+					return done, fmt.Errorf("collections: %w", err)
+				}
+			}
+		}
+		for _, col := range b.Collections {
+			if err := cs.PutCollection(col); err != nil {
+				return done, fmt.Errorf("collections: %w", err)
+			}
+		}
+		done["collections"] = len(b.Collections)
+	}
+	if fs, ok := repo.(flagStore); ok && has("flags") {
+		now, err := fs.LoadFlags()
+		if err != nil {
+			return done, fmt.Errorf("flags: %w", err)
+		}
+		for _, f := range now {
+			if !slices.ContainsFunc(b.Flags, func(x FeatureFlag) bool { return x.Name == f.Name }) {
+				if err := fs.DeleteFlag(f.Name); err != nil && !errors.Is(err, ErrNotFound) {
+					return done, fmt.Errorf("flags: %w", err)
+				}
+			}
+		}
+		for _, f := range b.Flags {
+			if err := fs.PutFlag(f); err != nil {
+				return done, fmt.Errorf("flags: %w", err)
+			}
+		}
+		done["flags"] = len(b.Flags)
+	}
+	return done, nil
+}
+
+// reloadAfterRestore brings everything the server keeps in memory up to
+// date with the restored store.
+func reloadAfterRestore(ctx context.Context) error {
+	if vs, ok := store.(viewStore); ok {
+		if totals, err := vs.LoadViews(); err != nil {
+			log.Printf("views: load: %v", err)
+		} else {
+			views.Lock()
+			views.totals = totals
+			views.Unlock()
+		}
+	}
+	loadLikes()
+	loadComments()
+	loadRedirects()
+	loadFlags()
+	loadCollections()
+	return reloadItems(ctx)
+}
+
+// backupName is the file name of a backup taken at t.
+func backupName(t time.Time) string {
+	return "blendingwaves-" + t.UTC().Format("20060102T150405Z") + ".tar.gz"
+}
+
+// adminBackupHandler downloads a backup of the store.
+func adminBackupHandler(w http.ResponseWriter, r *http.Request) {
+	flushViews()
+	b, err := takeBackup(store)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	name := backupName(b.Manifest.CreatedAt)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := writeBackup(w, b); err != nil {
+		log.Printf("backup: %v", err)
+		return
+	}
+	audit(r, "backup.export", name, nil, b.Manifest.Sections)
+	log.Printf("admin: %s downloaded backup %s", actor(r), name)
+}
+
+// adminItemsFileHandler serves the JSON store's items.json byte for byte
+// with a strong ETag of its content, so backup tools can send
+// If-None-Match and skip a file they already have.
+func adminItemsFileHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := store.(*jsonStore)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "the items aren't kept in a JSON file; use /admin/backup")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	serveFileETag(w, r, os.DirFS(filepath.Dir(s.path)), filepath.Base(s.path))
+}
+
+// adminRestoreHandler restores the store from the archive sent as the
+// request body. With ?dry_run=1 the archive is only checked.
+func adminRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := readBackup(r.Body)
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("backup exceeds %d bytes", tooBig.Limit))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dry, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dry {
+		writeJSON(w, http.StatusOK, map[string]any{"version": b.Manifest.Version, "created_at": b.Manifest.CreatedAt, "sections": b.Manifest.Sections})
+		return
+	}
+	flushViews()
+	done, err := restoreBackup(store, actor(r)+" (restore)", b)
+	audit(r, "backup.restore", backupName(b.Manifest.CreatedAt), nil, done)
+	if err != nil {
+		log.Printf("admin: restore: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "restore failed part way: "+err.Error())
+		reloadAfterRestore(r.Context())
+		return
+	}
+	if err := reloadAfterRestore(r.Context()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "restored, but reloading the catalog failed: "+err.Error())
+		return
+	}
+	log.Printf("admin: %s restored the backup of %s", actor(r), b.Manifest.CreatedAt.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, map[string]any{"version": b.Manifest.Version, "created_at": b.Manifest.CreatedAt, "restored": done})
+}
+
+// backupCmd writes a backup of the store to FILE, or stdout for -.
+func backupCmd(cfg Config) error {
+	if len(cfg.Args) != 1 {
+		return errors.New("usage: backup [flags] FILE")
+	}
+	b, err := takeBackup(store)
+	if err != nil {
+		return err
+	}
+	if cfg.Args[0] == "-" {
+		return writeBackup(os.Stdout, b)
+	}
+	f, err := os.Create(cfg.Args[0])
+	if err != nil {
+		return err
+	}
+	if err := writeBackup(f, b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "backup: wrote %s: %v\n", cfg.Args[0], b.Manifest.Sections)
+	return nil
+}
+
+// restoreCmd restores the store from the archive FILE, or stdin for -.
+// The server should be stopped first: it keeps its state in memory and
+// wouldn't see the restore.
+func restoreCmd(cfg Config) error {
+	if len(cfg.Args) != 1 {
+		return errors.New("usage: restore [flags] FILE")
+	}
+	var in io.Reader = os.Stdin
+	if cfg.Args[0] != "-" {
+		f, err := os.Open(cfg.Args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	b, err := readBackup(in)
+	if err != nil {
+		return err
+	}
+	done, err := restoreBackup(store, cmp.Or(os.Getenv("USER"), "cli")+" (restore)", b)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored the backup of %s: %v\n", b.Manifest.CreatedAt.Format(time.RFC3339), done)
+	return nil
+}
+
+func (s *jsonStore) ReplaceComments(all []Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if all == nil {
+		all = []Comment{}
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.commentsPath(), data)
+}
+
+func (s *jsonStore) ReplaceSubscribers(all []Subscriber) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if all == nil {
+		all = []Subscriber{}
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.subscribersPath(), data)
+}
+
+func (s *sqliteStore) ReplaceComments(all []Comment) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM comments`); err != nil {
+		return err
+	}
+	for _, c := range all {
+		if _, err := tx.Exec(`INSERT INTO comments (id, item_id, parent_id, author, body, status, created_at, ip)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, c.ID, c.ItemID, c.ParentID, c.Author, c.Body, c.Status, c.CreatedAt, c.IP); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) ReplaceSubscribers(all []Subscriber) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM subscribers`); err != nil {
+		return err
+	}
+	for _, sub := range all {
+		if _, err := tx.Exec(`INSERT INTO subscribers (email, status, created_at, confirmed_at) VALUES (?, ?, ?, ?)`,
+			sub.Email, sub.Status, sub.CreatedAt, sub.ConfirmedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAdminItemsFile(t *testing.T) {
+	withAdmin(t)
+	useItems(t, testItem(1, "Alpha"))
+	path := store.(*jsonStore).path
+	// Hand-edited formatting must come back byte for byte.
+	raw := []byte("[\n  {\"id\": 1, \"keyword_title\": \"Alpha\",  \"texts\": [\"x\"],\n   \"video_path\": [\"/static/video/chalk.mp4\"], \"video_credit\": [\"c\"]}\n]\n")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := get("/admin/items.json"); w.Code != 401 {
+		t.Errorf("anonymous: status %d, want 401", w.Code)
+	}
+	w := do(adminRequest("GET", "/admin/items.json", nil))
+	tag := w.Header().Get("ETag")
+	if w.Code != 200 || w.Body.String() != string(raw) {
+		t.Fatalf("status %d, body %q", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if len(tag) < 3 || tag[0] != '"' {
+		t.Fatalf("ETag %q is not strong", tag)
+	}
+
+	r := adminRequest("GET", "/admin/items.json", nil)
+	r.Header.Set("If-None-Match", tag)
+	if w := do(r); w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("unchanged: status %d with %d bytes, want 304", w.Code, w.Body.Len())
+	}
+
+	if err := os.WriteFile(path, append(raw, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r = adminRequest("GET", "/admin/items.json", nil)
+	r.Header.Set("If-None-Match", tag)
+	if w := do(r); w.Code != 200 || w.Header().Get("ETag") == tag {
+		t.Errorf("changed: status %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+// TestUploadExpectContinue checks that large admin uploads are refused
+// on their headers alone: a client that sends Expect: 100-continue with
+// bad credentials or too large a body gets the error instead of being
+// told to send the body.
+func TestUploadExpectContinue(t *testing.T) {
+	withAdmin(t)
+	// serveCmd wraps the site in the body limits.
+	srv := httptest.NewServer(limitRequests(newRequestLimits(testConfig.limitRules, testConfig.UploadMax, testConfig.RequestTimeout, testConfig.MaxBody), testSite))
+	defer srv.Close()
+	good := "Basic " + base64.StdEncoding.EncodeToString([]byte(adminUser+":"+testAdminPassword))
+	bad := "Basic " + base64.StdEncoding.EncodeToString([]byte(adminUser+":wrong"))
+
+	tests := []struct {
+		path   string
+		auth   string
+		length int64
+		status int
+	}{
+		{"/admin/restore", "", uploadMax / 2, http.StatusUnauthorized},
+		{"/admin/restore", bad, uploadMax / 2, http.StatusUnauthorized},
+		{"/admin/media", bad, uploadMax / 2, http.StatusUnauthorized},
+		{"/admin/restore", good, uploadMax + 1, http.StatusRequestEntityTooLarge},
+		{"/admin/media", good, uploadMax + 1, http.StatusRequestEntityTooLarge},
+		{"/admin/restore", good, uploadMax / 2, http.StatusContinue},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/gzip\r\nContent-Length: %d\r\nExpect: 100-continue\r\n", tt.path, tt.length)
+		if tt.auth != "" {
+			fmt.Fprintf(conn, "Authorization: %s\r\n", tt.auth)
+		}
+		fmt.Fprint(conn, "\r\n")
+		// Nothing of the body is sent: the first response must come on
+		// the headers alone.
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		conn.Close()
+		if err != nil {
+			t.Errorf("POST %s: %v", tt.path, err)
+			continue
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("POST %s (auth %q, %d bytes): status %d, want %d", tt.path, tt.auth, tt.length, resp.StatusCode, tt.status)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// basePath is the path -base-url mounts the site under, such as /waves
+// behind a reverse proxy shared with other sites; empty at the root.
+// Routes, handlers and templates all work with paths from the site's
+// root: withBasePath takes the prefix off requests and puts it back on
+// the URLs responses send out.
+var basePath string
+
+// setBasePath takes the base path from -base-url, validated by then.
+func setBasePath(base string) {
+	basePath = ""
+	if u, err := url.Parse(base); err == nil {
+		basePath = strings.TrimSuffix(u.Path, "/")
+	}
+}
+
+// withBasePath serves h under basePath. Requests for a path under it
+// have the prefix removed; others pass as they are, for proxies that
+// strip it themselves. Root-relative Location, Content-Location and Link
+// header URLs get the prefix.
+func withBasePath(h http.Handler) http.Handler {
+	if basePath == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest, ok := trimBase(r.URL.Path); ok {
+			r2 := new(http.Request)
+			*r2 = *r
+			u := *r.URL
+			u.Path, u.RawPath = rest, ""
+			r2.URL = &u
+			r = r2
+		}
+		h.ServeHTTP(&basePathWriter{w}, r)
+	})
+}
+
+// trimBase returns p without the basePath prefix, reporting false when p
+// isn't under it.
+func trimBase(p string) (string, bool) {
+	rest, ok := strings.CutPrefix(p, basePath)
+	if basePath == "" || !ok || rest != "" && rest[0] != '/' {
+		return p, false
+	}
+	return cmp.Or(rest, "/"), true
+}
+
+// basePathWriter prefixes root-relative URLs in redirect and link
+// headers with basePath as the response starts.
+type basePathWriter struct {
+	http.ResponseWriter
+}
+
+func (b *basePathWriter) Unwrap() http.ResponseWriter { return b.ResponseWriter }
+
+func (b *basePathWriter) Flush() {
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (b *basePathWriter) WriteHeader(status int) {
+	hdr := b.Header()
+	for _, k := range []string{"Location", "Content-Location"} {
+		if v := hdr.Get(k); v != "" {
+			hdr.Set(k, withBase(v))
+		}
+	}
+	if links := hdr.Values("Link"); len(links) > 0 {
+		hdr.Del("Link")
+		for _, l := range links {
+			hdr.Add("Link", strings.ReplaceAll(l, "</", "<"+basePath+"/"))
+		}
+	}
+	b.ResponseWriter.WriteHeader(status)
+}
+
+// withBase prefixes the root-relative URL p with basePath, leaving
+// absolute and protocol-relative URLs alone.
+func withBase(p string) string {
+	if basePath == "" || !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") {
+		return p
+	}
+	return basePath + p
+}
+
+// rootURLAttr matches a URL attribute whose value starts at the site's
+// root, capturing the attribute and the URL.
+var rootURLAttr = regexp.MustCompile(`(\s(?:href|src|action|formaction|poster)=")(/(?:[^/"][^"]*)?")`)
+
+// withBaseHTML prefixes the root-relative URL attributes of rendered
+// html with basePath, so templates and the links handlers build needn't
+// know where the site is mounted. Scripts use the page's data-base.
+func withBaseHTML(html []byte) []byte {
+	if basePath == "" {
+		return html
+	}
+	repl := []byte("${1}" + strings.ReplaceAll(basePath, "$", "$$") + "${2}")
+	return rootURLAttr.ReplaceAll(html, repl)
+}
+
+// basePathFunc is the template helper "base": basePath, for scripts
+// building URLs.
+func basePathFunc() string { return basePath }
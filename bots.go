@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultBotAgents lists user-agent substrings of well-known crawlers.
+const defaultBotAgents = "googlebot,bingbot,duckduckbot,baiduspider,yandexbot,slurp,facebookexternalhit,twitterbot,linkedinbot,applebot,discordbot,slackbot"
+
+var botAgents []string
+
+// setBotAgents parses a comma-separated list of user-agent substrings.
+func setBotAgents(list string) {
+	botAgents = botAgents[:0]
+	for _, a := range strings.Split(list, ",") {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			botAgents = append(botAgents, a)
+		}
+	}
+}
+
+// isBot reports whether the request comes from a known crawler.
+func isBot(r *http.Request) bool {
+	ua := strings.ToLower(r.UserAgent())
+	if ua == "" {
+		return false
+	}
+	for _, a := range botAgents {
+		if strings.Contains(ua, a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsBot(t *testing.T) {
+	t.Cleanup(func() { setBotAgents(testConfig.BotAgents) })
+	tests := []struct {
+		agents string
+		ua     string
+		want   bool
+	}{
+		{defaultBotAgents, "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", true},
+		{defaultBotAgents, "Mozilla/5.0 (compatible; bingbot/2.0)", true},
+		{defaultBotAgents, "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36", false},
+		{defaultBotAgents, "", false},
+		{"examplecrawler", "ExampleCrawler/1.0", true},
+		{"examplecrawler", "Googlebot/2.1", false},
+		{" , ", "Googlebot/2.1", false},
+	}
+	for _, tt := range tests {
+		setBotAgents(tt.agents)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", tt.ua)
+		if got := isBot(r); got != tt.want {
+			t.Errorf("agents %q: isBot(%q) = %v, want %v", tt.agents, tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestHomeBotVariant(t *testing.T) {
+	var items []Item
+	for id := 1; id <= 30; id++ {
+		items = append(items, testItem(id, fmt.Sprintf("Project %02d", id)))
+	}
+	useItems(t, items...)
+	tests := []struct {
+		name   string
+		ua     string
+		lite   bool
+		listed int
+	}{
+		{"crawler", "Mozilla/5.0 (compatible; Googlebot/2.1)", true, len(items)},
+		{"browser", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0", false, min(testConfig.PerPage, len(items))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get("/", "User-Agent", tt.ua)
+			if w.Code != 200 {
+				t.Fatalf("status %d", w.Code)
+			}
+			body := w.Body.String()
+			if got := strings.Contains(body, "three.min.js"); got == tt.lite {
+				t.Errorf("loads three.js = %v, want %v", got, !tt.lite)
+			}
+			listed, linked := 0, 0
+			for _, it := range current().items {
+				if strings.Contains(body, it.KeywordTitle) {
+					listed++
+				}
+				if strings.Contains(body, `href="/items/`+it.Slug+`"`) {
+					linked++
+				}
+			}
+			if listed != tt.listed {
+				t.Errorf("lists %d items, want %d", listed, tt.listed)
+			}
+			if tt.lite && linked != listed {
+				t.Errorf("links %d of the %d items listed", linked, listed)
+			}
+			if vary := w.Header().Values("Vary"); !strings.Contains(strings.Join(vary, ","), "User-Agent") {
+				t.Errorf("Vary = %q, want User-Agent", vary)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// cacheRule gives responses matching Match the Cache-Control Directive.
+// Match is a path prefix such as /api/, "fingerprinted" for URLs whose
+// file name carries a content hash (styles.3f2a9c1b.css), or "html" for
+// pages.
+type cacheRule struct {
+	Match     string
+	Directive string
+}
+
+// cacheRules is the policy in effect, first match winning.
+var cacheRules []cacheRule
+
+// defaultCacheRules is the policy when -cache-policy sets none. A hashed
+// URL names one version of a file forever; pages carry the visitor's
+// CSRF token, so only their browser may keep them, briefly, revalidating
+// in the background while it shows the copy it has.
+var defaultCacheRules = []cacheRule{
+	{"fingerprinted", "public, max-age=31536000, immutable"},
+	{"/api/", "no-store"},
+	{"/admin", "no-store"},
+	{"html", "private, max-age=60, stale-while-revalidate=300"},
+}
+
+// fingerprinted matches a file name with a hex content hash before its
+// extension.
+var fingerprinted = regexp.MustCompile(`\.[0-9a-f]{8,64}\.[0-9A-Za-z]+$`)
+
+// parseCacheRule parses match=directive, e.g. /api/=no-store.
+func parseCacheRule(s string) (cacheRule, error) {
+	match, directive, ok := strings.Cut(s, "=")
+	match, directive = strings.TrimSpace(match), strings.TrimSpace(directive)
+	if !ok || directive == "" || match != "fingerprinted" && match != "html" && !strings.HasPrefix(match, "/") {
+		return cacheRule{}, fmt.Errorf("cache policy %q: want /prefix=directive, fingerprinted=directive or html=directive", s)
+	}
+	return cacheRule{Match: match, Directive: directive}, nil
+}
+
+// setCachePolicy puts rules in effect, or the default policy when there
+// are none.
+func setCachePolicy(rules []cacheRule) {
+	cacheRules = rules
+	if len(rules) == 0 {
+		cacheRules = defaultCacheRules
+	}
+}
+
+// cachePolicy sets Cache-Control on responses the handler left without
+// one, from the first of cacheRules they match. Handlers that know
+// better, such as account pages, set their own and keep it. no-store
+// applies to every response; other directives only to successful and
+// not-modified ones, so errors aren't cached.
+func cachePolicy(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(cacheRules) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(&cacheControlWriter{ResponseWriter: w, path: r.URL.Path}, r)
+	})
+}
+
+// cacheControlWriter applies cacheRules as the response starts.
+type cacheControlWriter struct {
+	http.ResponseWriter
+	path        string
+	wroteHeader bool
+}
+
+func (c *cacheControlWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+func (c *cacheControlWriter) Flush() {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *cacheControlWriter) WriteHeader(status int) {
+	if !c.wroteHeader && status >= 200 {
+		c.wroteHeader = true
+		hdr := c.Header()
+		if hdr.Get("Cache-Control") == "" {
+			if d := cacheDirective(c.path, hdr.Get("Content-Type")); d != "" && (strings.Contains(d, "no-store") || status < 300 || status == http.StatusNotModified) {
+				hdr.Set("Cache-Control", d)
+			}
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheControlWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// cacheDirective returns the directive of the first rule matching a
+// response for path with the content type ctype, or "".
+func cacheDirective(path, ctype string) string {
+	mt, _, _ := mime.ParseMediaType(ctype)
+	for _, rule := range cacheRules {
+		switch {
+		case rule.Match == "fingerprinted" && fingerprinted.MatchString(path),
+			rule.Match == "html" && mt == "text/html",
+			strings.HasPrefix(rule.Match, "/") && strings.HasPrefix(path, rule.Match):
+			return rule.Directive
+		}
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// canonical is the origin -canonical-redirect sends requests to: the
+// scheme and host name of -base-url, and the other names of the site
+// that redirect to it. Hosts not listed, such as a load balancer's IP,
+// are served as they are.
+var canonical struct {
+	on      bool
+	https   bool
+	host    string
+	aliases []string
+}
+
+// canonicalQuery are the query parameters that still name a different
+// page in a rel=canonical URL; the rest only reorder or filter it.
+var canonicalQuery = []string{"page"}
+
+// setCanonical checks -canonical-redirect and -canonical-aliases against
+// -base-url. The www or bare form of the base host is always an alias.
+func setCanonical(on bool, base, aliases string) error {
+	canonical.on = on
+	if !on {
+		return nil
+	}
+	if base == "" {
+		return fmt.Errorf("canonical-redirect needs base-url")
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("base-url: %w", err)
+	}
+	canonical.https = u.Scheme == "https"
+	canonical.host = strings.ToLower(u.Hostname())
+	other, ok := strings.CutPrefix(canonical.host, "www.")
+	if !ok {
+		other = "www." + canonical.host
+	}
+	canonical.aliases = []string{other}
+	for _, h := range strings.Split(aliases, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			if strings.ContainsAny(h, ":/") {
+				return fmt.Errorf("canonical-aliases: %q must be a host name, without scheme or port", h)
+			}
+			canonical.aliases = append(canonical.aliases, h)
+		}
+	}
+	return nil
+}
+
+// canonicalWriter carries the page's canonical URL to render.
+type canonicalWriter struct {
+	http.ResponseWriter
+	url string
+}
+
+func (c *canonicalWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+func (c *canonicalWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// canonicalize 301-redirects requests reaching the site under another of
+// its names, or over plain HTTP when -base-url is https, to the same
+// path on -base-url. Other methods get a 308 so they keep their body.
+// Health checks and metrics are answered wherever they arrive.
+func canonicalize(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, ok := canonicalTarget(r); ok {
+			status := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				status = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, target, status)
+			return
+		}
+		h.ServeHTTP(&canonicalWriter{w, siteURL(r, canonicalPath(r.URL))}, r)
+	})
+}
+
+// canonicalTarget returns where canonicalize sends r, if anywhere.
+func canonicalTarget(r *http.Request) (string, bool) {
+	if !canonical.on || exporting {
+		return "", false
+	}
+	switch r.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return "", false
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	host = strings.ToLower(host)
+	switch {
+	case slices.Contains(canonical.aliases, host):
+	case host == canonical.host && canonical.https && !isHTTPS(r):
+	default:
+		return "", false
+	}
+	return baseURL + r.URL.RequestURI(), true
+}
+
+// canonicalPath is u's path with only the canonicalQuery parameters.
+func canonicalPath(u *url.URL) string {
+	q := u.Query()
+	keep := url.Values{}
+	for _, k := range canonicalQuery {
+		if v := q.Get(k); v != "" {
+			keep.Set(k, v)
+		}
+	}
+	if len(keep) == 0 {
+		return u.Path
+	}
+	return u.Path + "?" + keep.Encode()
+}
+
+// canonicalURL returns the canonical URL canonicalize found for the page
+// written to w, or "" outside it.
+func canonicalURL(w http.ResponseWriter) string {
+	if c, ok := findWriter[*canonicalWriter](w); ok {
+		return c.url
+	}
+	return ""
+}
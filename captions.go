@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Caption is a WebVTT track for one of an item's videos: Video is the
+// index into VideoPath, Lang the track's language tag and Path the .vtt
+// file, a /static/ path or an "s3:" object like the videos.
+type Caption struct {
+	Video int    `json:"video"`
+	Lang  string `json:"lang"`
+	Label string `json:"label,omitempty"` // shown in the player's menu; Lang when empty
+	Path  string `json:"path"`
+}
+
+// vttType is the Content-Type WebVTT files are served with. Go's built-in
+// MIME table has no entry for .vtt, and browsers ignore tracks served as
+// anything else.
+const vttType = "text/vtt; charset=utf-8"
+
+func init() {
+	mime.AddExtensionType(".vtt", vttType)
+}
+
+// langTag loosely matches a BCP 47 language tag such as en or pt-BR.
+var langTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// captionTrack is a caption as the item page's <track> element needs it.
+type captionTrack struct {
+	URL     string
+	Lang    string
+	Label   string
+	Default bool
+}
+
+// CaptionURL is the URL of the item's i-th caption.
+func (it Item) CaptionURL(i int) string {
+	return "/captions/" + strconv.Itoa(it.ID) + "/" + strconv.Itoa(i)
+}
+
+// CaptionTracks returns the tracks of the item's video-th video. The one
+// in lang, the page's language, is the default.
+func (it Item) CaptionTracks(video int, lang string) []captionTrack {
+	var out []captionTrack
+	for i, c := range it.Captions {
+		if c.Video != video {
+			continue
+		}
+		out = append(out, captionTrack{
+			URL:     it.CaptionURL(i),
+			Lang:    c.Lang,
+			Label:   cmp.Or(c.Label, c.Lang),
+			Default: strings.EqualFold(c.Lang, lang),
+		})
+	}
+	return out
+}
+
+// checkCaptions describes what's wrong with the item's captions.
+func checkCaptions(it *Item) []string {
+	var msgs []string
+	seen := map[string]bool{}
+	for i, c := range it.Captions {
+		switch {
+		case c.Video < 0 || c.Video >= len(it.VideoPath):
+			msgs = append(msgs, fmt.Sprintf("captions[%d] is for video %d of %d", i, c.Video+1, len(it.VideoPath)))
+		case !langTag.MatchString(c.Lang):
+			msgs = append(msgs, fmt.Sprintf("captions[%d] language %q must be a tag like en or pt-BR", i, c.Lang))
+		case path.Ext(c.Path) != ".vtt":
+			msgs = append(msgs, fmt.Sprintf("captions[%d] %q must be a .vtt file", i, c.Path))
+		default:
+			if msg := checkVideoPath(c.Path); msg != "" {
+				msgs = append(msgs, fmt.Sprintf("captions[%d] %q %s", i, c.Path, msg))
+			}
+			key := strconv.Itoa(c.Video) + " " + strings.ToLower(c.Lang)
+			if seen[key] {
+				msgs = append(msgs, fmt.Sprintf("captions[%d] repeats language %s for video %d", i, c.Lang, c.Video+1))
+			}
+			seen[key] = true
+		}
+	}
+	return msgs
+}
+
+// parseCaptions reads the captions field of the item form: one track per
+// line as "VIDEO LANG PATH [LABEL]", where VIDEO counts from 1.
+func parseCaptions(s string) ([]Caption, error) {
+	var out []Caption
+	for _, line := range splitLines(s) {
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			return nil, fmt.Errorf("caption %q: want VIDEO LANG PATH [LABEL]", line)
+		}
+		n, err := strconv.Atoi(f[0])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("caption %q: the video is counted from 1", line)
+		}
+		out = append(out, Caption{Video: n - 1, Lang: f[1], Path: f[2], Label: strings.Join(f[3:], " ")})
+	}
+	return out, nil
+}
+
+// formatCaptions is parseCaptions in reverse, for the form.
+func formatCaptions(cs []Caption) string {
+	var b strings.Builder
+	for _, c := range cs {
+		fmt.Fprintf(&b, "%d %s %s", c.Video+1, c.Lang, c.Path)
+		if c.Label != "" {
+			b.WriteString(" " + c.Label)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// isVTT reports whether head, the start of an upload, is a WebVTT file,
+// which sniffs as plain text.
+func isVTT(head []byte) bool {
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	rest, ok := bytes.CutPrefix(head, []byte("WEBVTT"))
+	return ok && (len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n' || rest[0] == '\r')
+}
+
+// captionHandler serves /captions/{id}/{index} as WebVTT. Captions in the
+// object store redirect to a signed URL, so the bucket must allow the
+// site's origin for players to load them.
+func captionHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := current().lookup(r.PathValue("id"), time.Now())
+	i, err := strconv.Atoi(r.PathValue("index"))
+	if !ok || err != nil || i < 0 || i >= len(it.Captions) {
+		notFound(w, r)
+		return
+	}
+	ref := it.Captions[i].Path
+	if isRemoteMedia(ref) {
+		u, err := media.URL(ref)
+		if err != nil {
+			serverError(w, fmt.Errorf("caption %s: %w", ref, err))
+			return
+		}
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	name, ok := staticFile(ref)
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	f, err := staticFS.Open(name)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	rs, seekable := f.(io.ReadSeeker)
+	if err != nil || !fi.Mode().IsRegular() || !seekable {
+		notFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", vttType)
+	if tag, ok := fileETag(staticFS, name); ok {
+		w.Header().Set("ETag", tag)
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), rs)
+}
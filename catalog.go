@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// catalog is an immutable snapshot of the items plus their lookup
+// indexes. Reloads build a new catalog and swap it in atomically, so a
+// request always sees one consistent version.
+type catalog struct {
+	items       []Item
+	raw         []Item // items as the store listed them, before slugs are filled in
+	byID        map[int]*Item
+	bySlug      map[string]*Item
+	byShort     map[string]*Item
+	byLink      map[string]*Item
+	byTag       map[string][]int  // tag slug -> item indexes
+	tagNames    map[string]string // tag slug -> display name
+	index       *searchIndex      // set once built, before indexed is closed
+	indexed     chan struct{}     // closed when index is ready
+	suggestions *suggestTrie
+	related     [][]int         // item index -> related item indexes, best first
+	etags       map[int]string  // item ID -> ETag of the item's JSON
+	videoFiles  map[string]bool // videos under static/, by path there
+	loadedAt    time.Time
+	sum         [sha256.Size]byte // of the items as the store listed them
+}
+
+var snapshot atomic.Pointer[catalog]
+
+// current returns the catalog in use.
+func current() *catalog {
+	return snapshot.Load()
+}
+
+// newCatalog orders all, fills in slugs and builds the indexes. all is
+// owned by the returned catalog.
+func newCatalog(all []Item) *catalog {
+	sum := itemsSum(all)
+	raw := slices.Clone(all)
+	editorialOrder(all)
+	c := &catalog{items: all, raw: raw, loadedAt: time.Now(), sum: sum}
+	c.indexSlugs()
+	c.indexShortCodes()
+	c.indexIDs()
+	c.indexETags()
+	c.indexLinks()
+	c.indexVideos()
+	c.indexTags()
+	c.indexed = make(chan struct{})
+	go c.buildIndex()
+	c.suggestions = buildSuggest(c)
+	c.related = buildRelated(c)
+	return c
+}
+
+// buildIndex builds the catalog's search index, which can take a while
+// for a large catalog; until it is ready, searches scan the items.
+func (c *catalog) buildIndex() {
+	c.index = buildSearchIndex(c.items)
+	close(c.indexed)
+}
+
+// searchIndex returns the catalog's search index, or nil while it is
+// still being built.
+func (c *catalog) searchIndex() *searchIndex {
+	select {
+	case <-c.indexed:
+		return c.index
+	default:
+		return nil
+	}
+}
+
+// reloadItems reads the catalog from the store and swaps it in.
+func reloadItems(ctx context.Context) error {
+	_, span := startSpan(ctx, "catalog.reload")
+	defer span.End()
+	all, err := store.List()
+	if err != nil {
+		span.fail(err)
+		return err
+	}
+	span.set("items", len(all))
+	if err := checkItems(all, storeLines()); err != nil {
+		span.fail(err)
+		return err
+	}
+	next := newCatalog(all)
+	catalogChanged(snapshot.Swap(next), next, time.Now())
+	hub.broadcast(next.update())
+	return nil
+}
+
+// loadItems is reloadItems for startup, where failure is fatal.
+func loadItems() {
+	if err := reloadItems(context.Background()); err != nil {
+		log.Fatalf("Failed to load items: %v", err)
+	}
+}
+
+// editorialOrder sorts items the way the home page lists them: pinned
+// items first, then within each group the items given an order, lowest
+// first, then the rest in their original order. Pinned items without an
+// order go by ID.
+func editorialOrder(items []Item) {
+	slices.SortStableFunc(items, func(a, b Item) int {
+		switch {
+		case a.Pinned != b.Pinned:
+			if a.Pinned {
+				return -1
+			}
+			return 1
+		case a.Order != b.Order && (a.Order == 0 || b.Order == 0):
+			return cmp.Compare(b.Order, a.Order)
+		case a.Pinned:
+			return cmp.Or(cmp.Compare(a.Order, b.Order), cmp.Compare(a.ID, b.ID))
+		}
+		return cmp.Compare(a.Order, b.Order)
+	})
+}
+
+// indexSlugs fills in missing slugs and builds bySlug. A slug already
+// taken by an earlier item gets the item ID appended.
+func (c *catalog) indexSlugs() {
+	c.bySlug = make(map[string]*Item, len(c.items))
+	for i := range c.items {
+		it := &c.items[i]
+		if it.Slug == "" {
+			it.Slug = slugify(it.KeywordTitle)
+		}
+		if _, taken := c.bySlug[it.Slug]; taken || it.Slug == "" {
+			fixed := strings.Trim(it.Slug+"-"+strconv.Itoa(it.ID), "-")
+			log.Printf("warning: item %d slug %q is not unique; using %q", it.ID, it.Slug, fixed)
+			it.Slug = fixed
+		}
+		c.bySlug[it.Slug] = it
+	}
+}
+
+func (c *catalog) indexIDs() {
+	c.byID = make(map[int]*Item, len(c.items))
+	for i := range c.items {
+		c.byID[c.items[i].ID] = &c.items[i]
+	}
+}
+
+// indexETags hashes each item's JSON, so API clients polling one item
+// can revalidate it cheaply.
+func (c *catalog) indexETags() {
+	c.etags = make(map[int]string, len(c.items))
+	for i := range c.items {
+		data, _ := json.Marshal(&c.items[i])
+		c.etags[c.items[i].ID] = contentETag(data)
+	}
+}
+
+// indexVideos collects the static files of the items' videos.
+func (c *catalog) indexVideos() {
+	c.videoFiles = map[string]bool{}
+	for i := range c.items {
+		for _, vp := range c.items[i].VideoPath {
+			if name, ok := staticFile(vp); ok {
+				c.videoFiles[name] = true
+			}
+		}
+	}
+}
+
+// indexLinks maps each ItemLink to the first item that declares it,
+// warning about duplicates.
+func (c *catalog) indexLinks() {
+	c.byLink = make(map[string]*Item, len(c.items))
+	for i := range c.items {
+		it := &c.items[i]
+		if it.ItemLink == "" {
+			continue
+		}
+		if prev, ok := c.byLink[it.ItemLink]; ok {
+			log.Printf("warning: items %d and %d share ItemLink %s; resolving to %d", prev.ID, it.ID, it.ItemLink, prev.ID)
+			continue
+		}
+		c.byLink[it.ItemLink] = it
+	}
+}
+
+// live returns the items that are published and unexpired at now.
+func (c *catalog) live(now time.Time) []Item {
+	live := make([]Item, 0, len(c.items))
+	for i := range c.items {
+		if c.items[i].visible(now) {
+			live = append(live, c.items[i])
+		}
+	}
+	return live
+}
+
+// lookup resolves an ID or slug to a live item.
+func (c *catalog) lookup(key string, now time.Time) (*Item, bool) {
+	it, ok := c.bySlug[key]
+	if !ok {
+		if id, err := strconv.Atoi(key); err == nil {
+			it, ok = c.byID[id]
+		}
+	}
+	if !ok || !it.visible(now) {
+		return nil, false
+	}
+	return it, true
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collection is a themed sequence of items an editor curates, shown at
+// /collections/{slug} in the order of ItemIDs.
+type Collection struct {
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	ItemIDs     []int     `json:"item_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// collectionStore is implemented by repositories that keep collections,
+// one per slug.
+type collectionStore interface {
+	Collections() ([]Collection, error)
+	PutCollection(col Collection) error
+	DeleteCollection(slug string) error
+}
+
+// collections are the collections in effect, by title.
+var collections = struct {
+	sync.RWMutex
+	all []Collection
+}{}
+
+// loadCollections reads the collections from the store, dropping the
+// sitemap built from the old ones.
+func loadCollections() {
+	cs, ok := store.(collectionStore)
+	if !ok {
+		return
+	}
+	all, err := cs.Collections()
+	if err != nil {
+		log.Printf("collections: load: %v", err)
+		return
+	}
+	slices.SortFunc(all, func(a, b Collection) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) })
+	collections.Lock()
+	collections.all = all
+	collections.Unlock()
+	sitemapCache.Lock()
+	sitemapCache.body = nil
+	sitemapCache.Unlock()
+}
+
+// allCollections returns the collections in effect, by title.
+func allCollections() []Collection {
+	collections.RLock()
+	defer collections.RUnlock()
+	return slices.Clone(collections.all)
+}
+
+// collectionBySlug returns the collection at slug.
+func collectionBySlug(slug string) (Collection, bool) {
+	collections.RLock()
+	defer collections.RUnlock()
+	i := slices.IndexFunc(collections.all, func(col Collection) bool { return col.Slug == slug })
+	if i < 0 {
+		return Collection{}, false
+	}
+	return collections.all[i], true
+}
+
+// items returns the collection's items live in c at now, in its order.
+func (col Collection) items(c *catalog, now time.Time) []Item {
+	var out []Item
+	for _, id := range col.ItemIDs {
+		if it, ok := c.byID[id]; ok && it.visible(now) {
+			out = append(out, *it)
+		}
+	}
+	return out
+}
+
+// collectionSummary is a collection as /collections lists it.
+type collectionSummary struct {
+	Slug        string `json:"slug"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Count       int    `json:"count"`
+	Cover       *Item  `json:"-"`
+}
+
+// collectionNav places an item within one of its collections, for the
+// item page's previous and next links.
+type collectionNav struct {
+	Slug     string `json:"slug"`
+	Title    string `json:"title"`
+	Position int    `json:"position"`
+	Total    int    `json:"total"`
+	Prev     *Item  `json:"prev,omitempty"`
+	Next     *Item  `json:"next,omitempty"`
+}
+
+// collectionsOf returns where item id sits in each collection that has
+// it live in c at now, its neighbours localized to lang.
+func collectionsOf(c *catalog, id int, now time.Time, lang string) []collectionNav {
+	var out []collectionNav
+	for _, col := range allCollections() {
+		items := col.items(c, now)
+		i := slices.IndexFunc(items, func(it Item) bool { return it.ID == id })
+		if i < 0 {
+			continue
+		}
+		nav := collectionNav{Slug: col.Slug, Title: col.Title, Position: i + 1, Total: len(items)}
+		if i > 0 {
+			prev := items[i-1].localized(lang)
+			nav.Prev = &prev
+		}
+		if i+1 < len(items) {
+			next := items[i+1].localized(lang)
+			nav.Next = &next
+		}
+		out = append(out, nav)
+	}
+	return out
+}
+
+// collectionsHandler lists the collections with anything live in them.
+func collectionsHandler(w http.ResponseWriter, r *http.Request) {
+	c, now, lang := current(), time.Now(), writerLocale(w)
+	var list []collectionSummary
+	for _, col := range allCollections() {
+		items := col.items(c, now)
+		if len(items) == 0 {
+			continue
+		}
+		cover := items[0].localized(lang)
+		list = append(list, collectionSummary{Slug: col.Slug, Title: col.Title, Description: col.Description, Count: len(items), Cover: &cover})
+	}
+	title := translate(lang, "Collections")
+	data := map[string]interface{}{
+		"Title":       title + " | BlendingWaves",
+		"Collections": list,
+		"Meta": PageMeta{
+			Title:       title,
+			Description: "Themed collections of BlendingWaves projects.",
+			URL:         siteURL(r, "/collections"),
+			Type:        "website",
+			Image:       siteURL(r, defaultThumbnail),
+			TwitterCard: "summary",
+		},
+	}
+	renderPage(w, r, "collections.html", data, "Collections")
+}
+
+// collectionPageHandler shows /collections/{slug}: its live items in the
+// collection's order.
+func collectionPageHandler(w http.ResponseWriter, r *http.Request) {
+	col, ok := collectionBySlug(r.PathValue("slug"))
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	items := col.items(current(), time.Now())
+	if len(items) == 0 {
+		notFound(w, r)
+		return
+	}
+	items = localizeAll(items, writerLocale(w))
+	data := map[string]interface{}{
+		"Title":      col.Title + " | BlendingWaves",
+		"Collection": col,
+		"Items":      items,
+		"Meta": PageMeta{
+			Title:       col.Title,
+			Description: truncate(col.Description, 300),
+			URL:         siteURL(r, "/collections/"+col.Slug),
+			Type:        "website",
+			Image:       siteURL(r, cmp.Or(posterFor(&items[0], 0), defaultThumbnail)),
+			TwitterCard: "summary_large_image",
+		},
+	}
+	renderPage(w, r, "collection.html", data, "Collection", "Items")
+}
+
+// checkCollection validates col against the catalog, deriving its slug
+// from the title when it has none. Every item must exist, once.
+func checkCollection(col *Collection, c *catalog) error {
+	col.Title = strings.TrimSpace(col.Title)
+	col.Description = strings.TrimSpace(col.Description)
+	if col.Slug = slugify(col.Slug); col.Slug == "" {
+		col.Slug = slugify(col.Title)
+	}
+	switch {
+	case col.Title == "":
+		return errors.New("the title must not be empty")
+	case col.Slug == "":
+		return errors.New("the slug needs at least one letter or digit")
+	case len(col.ItemIDs) == 0:
+		return errors.New("add at least one item")
+	}
+	seen := map[int]bool{}
+	for _, id := range col.ItemIDs {
+		if _, ok := c.byID[id]; !ok {
+			return fmt.Errorf("there is no item %d", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("item %d is listed twice", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// parseItemIDs reads the item IDs of a collection form, separated by
+// commas, spaces or new lines.
+func parseItemIDs(s string) ([]int, error) {
+	var ids []int
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t' }) {
+		id, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an item ID", f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// purgeCollectionPages drops the cached pages of the items in the given
+// collections, whose collection links have changed.
+func purgeCollectionPages(cols ...Collection) {
+	for _, col := range cols {
+		for _, id := range col.ItemIDs {
+			purgeItemPages(id)
+		}
+	}
+}
+
+// adminCollectionsHandler lists the collections with the form adding
+// one, or editing the one ?edit names.
+func adminCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	form, _ := collectionBySlug(r.URL.Query().Get("edit"))
+	renderCollections(w, r, http.StatusOK, form, form.Slug, "")
+}
+
+func renderCollections(w http.ResponseWriter, r *http.Request, status int, form Collection, original, problem string) {
+	c := current()
+	ids := make([]string, len(form.ItemIDs))
+	for i, id := range form.ItemIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+	titles := map[int]string{}
+	for id, it := range c.byID {
+		titles[id] = it.KeywordTitle
+	}
+	data := map[string]interface{}{
+		"Title":       "Collections | Admin",
+		"Collections": allCollections(),
+		"ItemTitles":  titles,
+		"Items":       c.items,
+		"Form":        form,
+		"FormItems":   strings.Join(ids, ", "),
+		"Original":    original,
+		"Error":       problem,
+		"CSRF":        csrfToken(w, r),
+	}
+	if err := renderStatus(w, status, "admin_collections.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// adminPutCollectionHandler adds or replaces the collection from the
+// posted slug, title, description and items. A changed slug moves the
+// collection named by original.
+func adminPutCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	cs, ok := store.(collectionStore)
+	if !ok {
+		http.Error(w, "store does not keep collections", http.StatusNotImplemented)
+		return
+	}
+	original := r.PostFormValue("original")
+	col := Collection{
+		Slug:        r.PostFormValue("slug"),
+		Title:       r.PostFormValue("title"),
+		Description: r.PostFormValue("description"),
+	}
+	ids, err := parseItemIDs(r.PostFormValue("items"))
+	col.ItemIDs = ids
+	if err == nil {
+		err = checkCollection(&col, current())
+	}
+	if err != nil {
+		renderCollections(w, r, http.StatusBadRequest, col, original, err.Error())
+		return
+	}
+	if _, taken := collectionBySlug(col.Slug); taken && col.Slug != original {
+		renderCollections(w, r, http.StatusBadRequest, col, original, fmt.Sprintf("another collection is at /collections/%s", col.Slug))
+		return
+	}
+	now := time.Now().UTC()
+	col.CreatedAt, col.UpdatedAt = now, now
+	var before any
+	prev, existed := collectionBySlug(original)
+	if existed {
+		before = prev
+		col.CreatedAt = prev.CreatedAt
+	}
+	if err := cs.PutCollection(col); err != nil {
+		serverError(w, err)
+		return
+	}
+	if existed && original != col.Slug {
+		if err := cs.DeleteCollection(original); err != nil && !errors.Is(err, ErrNotFound) {
+			serverError(w, err)
+			return
+		}
+	}
+	loadCollections()
+	purgeCollectionPages(prev, col)
+	audit(r, "collection.put", col.Slug, before, col)
+	log.Printf("admin: %s saved the collection %s (%d items)", actor(r), col.Slug, len(col.ItemIDs))
+	http.Redirect(w, r, "/admin/collections", http.StatusSeeOther)
+}
+
+// adminDeleteCollectionHandler removes the collection from the posted
+// slug.
+func adminDeleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	cs, ok := store.(collectionStore)
+	if !ok {
+		http.Error(w, "store does not keep collections", http.StatusNotImplemented)
+		return
+	}
+	slug := r.PostFormValue("slug")
+	before, _ := collectionBySlug(slug)
+	if err := cs.DeleteCollection(slug); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	loadCollections()
+	purgeCollectionPages(before)
+	audit(r, "collection.delete", slug, before, nil)
+	log.Printf("admin: %s removed the collection %s", actor(r), slug)
+	http.Redirect(w, r, "/admin/collections", http.StatusSeeOther)
+}
+
+// collectionsPath is where the JSON store keeps collections, beside
+// items.json.
+func (s *jsonStore) collectionsPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".collections.json"
+}
+
+func (s *jsonStore) readCollections() ([]Collection, error) {
+	data, err := os.ReadFile(s.collectionsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Collection
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.collectionsPath(), err)
+	}
+	return out, nil
+}
+
+func (s *jsonStore) writeCollections(all []Collection) error {
+	slices.SortFunc(all, func(a, b Collection) int { return strings.Compare(a.Slug, b.Slug) })
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.collectionsPath(), data)
+}
+
+func (s *jsonStore) Collections() ([]Collection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readCollections()
+}
+
+func (s *jsonStore) PutCollection(col Collection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readCollections()
+	if err != nil {
+		return err
+	}
+	if i := slices.IndexFunc(all, func(x Collection) bool { return x.Slug == col.Slug }); i >= 0 {
+		all[i] = col
+	} else {
+		all = append(all, col)
+	}
+	return s.writeCollections(all)
+}
+
+func (s *jsonStore) DeleteCollection(slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readCollections()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(all, func(x Collection) bool { return x.Slug == slug })
+	if i < 0 {
+		return ErrNotFound
+	}
+	return s.writeCollections(slices.Delete(all, i, i+1))
+}
+
+func (s *sqliteStore) Collections() ([]Collection, error) {
+	rows, err := s.db.Query(`SELECT data FROM collections ORDER BY slug`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Collection
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var col Collection
+		if err := json.Unmarshal([]byte(data), &col); err != nil {
+			return nil, err
+		}
+		out = append(out, col)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) PutCollection(col Collection) error {
+	data, err := json.Marshal(col)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO collections (slug, data) VALUES (?, ?)
+		ON CONFLICT(slug) DO UPDATE SET data = excluded.data`, col.Slug, string(data))
+	return err
+}
+
+func (s *sqliteStore) DeleteCollection(slug string) error {
+	res, err := s.db.Exec(`DELETE FROM collections WHERE slug = ?`, slug)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
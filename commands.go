@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// commands are the subcommands picked by the first argument. Every one
+// takes the same flags, which go before any positional arguments; with no
+// command name the server runs, as it always has.
+//
+//	my-go-app [serve] [flags]
+//	my-go-app validate [flags]
+//	my-go-app export [flags] DIR
+//	my-go-app import [flags] FILE...
+//	my-go-app backup [flags] FILE
+//	my-go-app restore [flags] FILE
+var commands = map[string]func(Config) error{
+	"serve":    serveCmd,
+	"validate": validateCmd,
+	"export":   exportCmd,
+	"import":   importCmd,
+	"backup":   backupCmd,
+	"restore":  restoreCmd,
+}
+
+func commandNames() string {
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// validateCmd checks the items in the store and the templates, printing
+// what it finds, and fails if either is broken.
+func validateCmd(cfg Config) error {
+	var problems []string
+	all, err := store.List()
+	if err == nil {
+		err = checkItems(all, storeLines())
+	}
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("items: %v", err))
+	} else {
+		fmt.Printf("items: %d valid in the %s store\n", len(all), cfg.Store)
+	}
+	if set, err := parseTemplates(templateFS); err != nil {
+		problems = append(problems, fmt.Sprintf("templates: %v", err))
+	} else {
+		fmt.Printf("templates: %d pages parsed\n", len(set))
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		return errors.New("validation failed")
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+// exportCmd writes the static build to DIR (or -export).
+func exportCmd(cfg Config) error {
+	dir := cfg.Export
+	if len(cfg.Args) > 0 {
+		dir = cfg.Args[0]
+	}
+	if dir == "" {
+		return errors.New("usage: export [flags] DIR")
+	}
+	loadItems()
+	startViews()
+	loadLikes()
+	loadComments()
+	loadFlags()
+	loadCollections()
+	return exportSite(dir, buildSite(cfg))
+}
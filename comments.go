@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Comment moderation states. New comments wait as pending until an admin
+// approves them.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentSpam     = "spam"
+)
+
+const (
+	maxCommentAuthor = 80
+	maxCommentBody   = 4000
+)
+
+// Comment is one visitor comment; ParentID is 0 for top-level comments.
+type Comment struct {
+	ID        int64     `json:"id"`
+	ItemID    int       `json:"item_id"`
+	ParentID  int64     `json:"parent_id,omitempty"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	IP        string    `json:"ip,omitempty"`
+}
+
+// CommentNode is an approved comment with its approved replies.
+type CommentNode struct {
+	ID        int64          `json:"id"`
+	ItemID    int            `json:"-"`
+	CSRF      string         `json:"-"` // token for the node's reply form
+	Author    string         `json:"author"`
+	Body      string         `json:"body"`
+	CreatedAt time.Time      `json:"created_at"`
+	Replies   []*CommentNode `json:"replies,omitempty"`
+}
+
+// commentStore is implemented by repositories that persist comments.
+// PutComment assigns an ID to comments that have none.
+type commentStore interface {
+	LoadComments() ([]Comment, error)
+	PutComment(c Comment) (Comment, error)
+}
+
+// comments mirrors the stored comments, oldest first.
+var comments = struct {
+	sync.RWMutex
+	all []Comment
+}{}
+
+// commentLimiter throttles comment posts per client IP.
+var (
+	commentLimiter *rateLimiter
+	commentRule    rateRule
+)
+
+// setCommentLimit parses a rate/burst spec for comment posts.
+func setCommentLimit(spec string) error {
+	rule, err := parseRateRule("/comments=" + spec)
+	if err != nil {
+		return fmt.Errorf("comment-limit: want rate/burst, got %q", spec)
+	}
+	commentRule = rule
+	commentLimiter = newRateLimiter([]rateRule{rule})
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			commentLimiter.sweep(now)
+		}
+	}()
+	return nil
+}
+
+// loadComments reads every stored comment into memory.
+func loadComments() {
+	cs, ok := store.(commentStore)
+	if !ok {
+		return
+	}
+	all, err := cs.LoadComments()
+	if err != nil {
+		log.Printf("comments: load: %v", err)
+		return
+	}
+	comments.Lock()
+	comments.all = all
+	comments.Unlock()
+}
+
+// saveComment stores c and updates the in-memory copy.
+func saveComment(c Comment) (Comment, error) {
+	cs, ok := store.(commentStore)
+	if !ok {
+		return c, errors.New("store does not support comments")
+	}
+	comments.Lock()
+	defer comments.Unlock()
+	saved, err := cs.PutComment(c)
+	if err != nil {
+		return c, err
+	}
+	if i := slices.IndexFunc(comments.all, func(x Comment) bool { return x.ID == saved.ID }); i >= 0 {
+		comments.all[i] = saved
+	} else {
+		comments.all = append(comments.all, saved)
+	}
+	purgeItemPages(saved.ItemID)
+	return saved, nil
+}
+
+// commentByID finds a stored comment.
+func commentByID(id int64) (Comment, bool) {
+	comments.RLock()
+	defer comments.RUnlock()
+	i := slices.IndexFunc(comments.all, func(x Comment) bool { return x.ID == id })
+	if i < 0 {
+		return Comment{}, false
+	}
+	return comments.all[i], true
+}
+
+// commentThread returns the approved comments on an item as a tree.
+// Replies whose parent isn't approved are left out with it.
+func commentThread(itemID int, csrf string) []*CommentNode {
+	comments.RLock()
+	defer comments.RUnlock()
+	nodes := map[int64]*CommentNode{}
+	var roots []*CommentNode
+	for _, c := range comments.all {
+		if c.ItemID != itemID || c.Status != CommentApproved {
+			continue
+		}
+		n := &CommentNode{ID: c.ID, ItemID: c.ItemID, Author: c.Author, Body: c.Body, CreatedAt: c.CreatedAt, CSRF: csrf}
+		nodes[c.ID] = n
+		if c.ParentID == 0 {
+			roots = append(roots, n)
+		} else if p, ok := nodes[c.ParentID]; ok {
+			p.Replies = append(p.Replies, n)
+		}
+	}
+	return roots
+}
+
+// commentsHandler lists an item's approved comments as a JSON tree.
+func commentsHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := commentItem(w, r)
+	if !ok {
+		return
+	}
+	thread := commentThread(it.ID, "")
+	if thread == nil {
+		thread = []*CommentNode{}
+	}
+	writeJSON(w, http.StatusOK, thread)
+}
+
+// postCommentHandler accepts a comment as JSON or as a form post from the
+// item page, which is redirected back to the page.
+func postCommentHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := commentItem(w, r)
+	if !ok {
+		return
+	}
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	fail := func(status int, msg string) {
+		if isJSON {
+			writeJSONError(w, status, msg)
+			return
+		}
+		http.Error(w, msg, status)
+	}
+
+	if allowed, wait := commentLimiter.allow(commentRule, clientIP(r), time.Now()); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		fail(http.StatusTooManyRequests, "You're commenting too fast; please wait a moment")
+		return
+	}
+
+	var in struct {
+		ParentID int64  `json:"parent_id"`
+		Author   string `json:"author"`
+		Body     string `json:"body"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	if isJSON {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			fail(http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			fail(http.StatusBadRequest, err.Error())
+			return
+		}
+		in.Author, in.Body = r.PostForm.Get("author"), r.PostForm.Get("body")
+		in.ParentID, _ = strconv.ParseInt(r.PostForm.Get("parent_id"), 10, 64)
+	}
+	c := Comment{
+		ItemID:    it.ID,
+		ParentID:  in.ParentID,
+		Author:    strings.TrimSpace(in.Author),
+		Body:      strings.TrimSpace(in.Body),
+		Status:    CommentPending,
+		CreatedAt: time.Now().UTC(),
+		IP:        clientIP(r),
+	}
+	if err := validateComment(c); err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	saved, err := saveComment(c)
+	if err != nil {
+		log.Printf("comments: save: %v", err)
+		fail(http.StatusInternalServerError, "could not save comment")
+		return
+	}
+	convert(w, r, GoalComment)
+	if isJSON {
+		writeJSON(w, http.StatusAccepted, map[string]any{"id": saved.ID, "status": saved.Status})
+		return
+	}
+	http.Redirect(w, r, "/items/"+it.Slug+"?commented=1#comments", http.StatusSeeOther)
+}
+
+// validateComment checks the visitor-supplied fields of c.
+func validateComment(c Comment) error {
+	if c.Author == "" || utf8.RuneCountInString(c.Author) > maxCommentAuthor {
+		return fmt.Errorf("name must be 1 to %d characters", maxCommentAuthor)
+	}
+	if c.Body == "" || utf8.RuneCountInString(c.Body) > maxCommentBody {
+		return fmt.Errorf("comment must be 1 to %d characters", maxCommentBody)
+	}
+	if c.ParentID != 0 {
+		p, ok := commentByID(c.ParentID)
+		if !ok || p.ItemID != c.ItemID || p.Status != CommentApproved {
+			return errors.New("the comment you're replying to doesn't exist")
+		}
+	}
+	return nil
+}
+
+// commentItem resolves the {id} item of a comments route, writing a JSON
+// error when it isn't public.
+func commentItem(w http.ResponseWriter, r *http.Request) (*Item, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "item id must be an integer")
+		return nil, false
+	}
+	it, ok := current().byID[id]
+	if !ok || !it.visible(time.Now()) {
+		writeJSONError(w, http.StatusNotFound, "item not found")
+		return nil, false
+	}
+	return it, true
+}
+
+// adminCommentsHandler is the moderation queue, filtered by ?status=
+// (pending by default), newest first.
+func adminCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = CommentPending
+	}
+	comments.RLock()
+	var list []Comment
+	for _, c := range comments.all {
+		if c.Status == status {
+			list = append(list, c)
+		}
+	}
+	comments.RUnlock()
+	slices.Reverse(list)
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	data := map[string]interface{}{
+		"Title":    "Comments | Admin",
+		"CSRF":     csrfToken(w, r),
+		"Status":   status,
+		"Comments": list,
+		"Items":    current().byID,
+	}
+	if err := render(w, "admin_comments.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// adminModerateHandler sets a comment's status from the posted form.
+func adminModerateHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	c, ok := commentByID(id)
+	if err != nil || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	before := map[string]string{"status": c.Status}
+	switch status := r.FormValue("status"); status {
+	case CommentPending, CommentApproved, CommentSpam:
+		c.Status = status
+	default:
+		http.Error(w, "status must be pending, approved or spam", http.StatusBadRequest)
+		return
+	}
+	if _, err := saveComment(c); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	audit(r, "comment.status", "comment "+strconv.FormatInt(c.ID, 10), before, map[string]string{"status": c.Status})
+	log.Printf("admin: comment %d marked %s", c.ID, c.Status)
+	http.Redirect(w, r, "/admin/comments", http.StatusSeeOther)
+}
+
+// commentsPath is where the JSON store keeps comments, beside items.json.
+func (s *jsonStore) commentsPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".comments.json"
+}
+
+func (s *jsonStore) LoadComments() ([]Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readComments()
+}
+
+func (s *jsonStore) readComments() ([]Comment, error) {
+	data, err := os.ReadFile(s.commentsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Comment
+	return out, json.Unmarshal(data, &out)
+}
+
+func (s *jsonStore) PutComment(c Comment) (Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readComments()
+	if err != nil {
+		return c, err
+	}
+	if c.ID == 0 {
+		for _, x := range all {
+			c.ID = max(c.ID, x.ID)
+		}
+		c.ID++
+		all = append(all, c)
+	} else if i := slices.IndexFunc(all, func(x Comment) bool { return x.ID == c.ID }); i >= 0 {
+		all[i] = c
+	} else {
+		return c, ErrNotFound
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return c, err
+	}
+	return c, writeFileAtomic(s.commentsPath(), data)
+}
+
+func (s *sqliteStore) LoadComments() ([]Comment, error) {
+	rows, err := s.db.Query(`SELECT id, item_id, parent_id, author, body, status, created_at, ip
+		FROM comments ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Comment
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.ItemID, &c.ParentID, &c.Author, &c.Body, &c.Status, &c.CreatedAt, &c.IP); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) PutComment(c Comment) (Comment, error) {
+	if c.ID != 0 {
+		res, err := s.db.Exec(`UPDATE comments SET status = ?, body = ? WHERE id = ?`, c.Status, c.Body, c.ID)
+		if err != nil {
+			return c, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return c, ErrNotFound
+		}
+		return c, nil
+	}
+	res, err := s.db.Exec(`INSERT INTO comments (item_id, parent_id, author, body, status, created_at, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, c.ItemID, c.ParentID, c.Author, c.Body, c.Status, c.CreatedAt, c.IP)
+	if err != nil {
+		return c, err
+	}
+	c.ID, err = res.LastInsertId()
+	return c, err
+}
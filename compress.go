@@ -0,0 +1,203 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressibleTypes are the media types worth compressing.
+var compressibleTypes = map[string]bool{
+	"text/html":              true,
+	"text/css":               true,
+	"text/plain":             true,
+	"text/javascript":        true,
+	"text/xml":               true,
+	"application/javascript": true,
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/xml":        true,
+	"application/rss+xml":    true,
+	"application/atom+xml":   true,
+	"image/svg+xml":          true,
+}
+
+var (
+	gzipPool   = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression); return w }}
+	brotliPool = sync.Pool{New: func() any { return brotli.NewWriterLevel(io.Discard, 5) }}
+)
+
+// negotiateEncoding picks br or gzip from Accept-Encoding, or "" for none.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "br" && name != "gzip" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		// Prefer br on ties; it compresses text noticeably better.
+		if q > bestQ || q == bestQ && name == "br" {
+			best, bestQ = name, q
+		}
+	}
+	if bestQ <= 0 {
+		return ""
+	}
+	return best
+}
+
+// compress encodes compressible responses of at least minSize bytes with
+// the client's preferred encoding. Smaller bodies, ranged requests,
+// protocol upgrades and already-encoded responses pass through untouched.
+func compress(minSize int, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc, minSize: minSize}
+		defer cw.Close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the start of a response until it knows whether
+// the body is worth compressing.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // nil when passing through
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+	// Informational responses are sent straight through.
+	if code >= 100 && code < 200 {
+		c.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if !c.decided {
+		c.buf = append(c.buf, b...)
+		if len(c.buf) < c.minSize {
+			return len(b), nil
+		}
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if c.enc != nil {
+		return c.enc.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, choosing compression when big is set and the
+// content type qualifies, then writes out the buffered bytes.
+func (c *compressWriter) decide(big bool) error {
+	c.decided = true
+	hdr := c.Header()
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	ct := hdr.Get("Content-Type")
+	if ct == "" && len(c.buf) > 0 {
+		ct = http.DetectContentType(c.buf)
+		hdr.Set("Content-Type", ct)
+	}
+	mt, _, _ := mime.ParseMediaType(ct)
+	if big && compressibleTypes[mt] && hdr.Get("Content-Encoding") == "" &&
+		c.status != http.StatusNoContent && c.status != http.StatusNotModified &&
+		c.status != http.StatusPartialContent {
+		hdr.Set("Content-Encoding", c.encoding)
+		hdr.Del("Content-Length")
+		hdr.Del("Accept-Ranges")
+		if et := hdr.Get("ETag"); et != "" && !strings.HasPrefix(et, "W/") {
+			// The encoded body is a different representation.
+			hdr.Set("ETag", "W/"+et)
+		}
+		switch c.encoding {
+		case "br":
+			bw := brotliPool.Get().(*brotli.Writer)
+			bw.Reset(c.ResponseWriter)
+			c.enc = bw
+		default:
+			gw := gzipPool.Get().(*gzip.Writer)
+			gw.Reset(c.ResponseWriter)
+			c.enc = gw
+		}
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.enc != nil {
+		_, err = c.enc.Write(c.buf)
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf)
+	}
+	c.buf = nil
+	return err
+}
+
+// Flush commits to a decision so streamed responses reach the client.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		c.decide(true)
+	}
+	if f, ok := c.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response and returns the encoder to its pool.
+func (c *compressWriter) Close() {
+	if !c.decided {
+		if c.status == 0 && len(c.buf) == 0 {
+			return // handler wrote nothing; let net/http send its default
+		}
+		c.decide(false)
+	}
+	switch e := c.enc.(type) {
+	case *gzip.Writer:
+		e.Close()
+		gzipPool.Put(e)
+	case *brotli.Writer:
+		e.Close()
+		brotliPool.Put(e)
+	}
+	c.enc = nil
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every runtime setting. Values are resolved with the
+// precedence flags > environment > config file > defaults.
+type Config struct {
+	Port               string
+	ListenHost         string
+	Listen             stringList
+	listeners          []listenAddr
+	SocketMode         string
+	socketMode         fs.FileMode
+	Maintenance        bool
+	MaintenanceRetry   time.Duration
+	MaintenanceMessage string
+	MaintenancePage    string
+	FlagsFile          string
+	Experiments        string
+	Analytics          bool
+	Sites              string
+	BaseURL            string
+	CanonicalRedirect  bool
+	CanonicalAliases   string
+	NormalizeURLs      bool
+	TrackingParams     string
+	TemplateDir        string
+	Dev                bool
+	Export             string
+	ImportMap          string
+	GitRepo            string
+	GitBranch          string
+	GitDir             string
+	GitItems           string
+	GitWebhookSecret   string
+	AirtableBase       string
+	AirtableTable      string
+	AirtableView       string
+	AirtableToken      string
+	AirtableURL        string
+	AirtableMap        string
+	ContentInterval    time.Duration
+	DryRun             bool
+	StaticDir          string
+	DataPath           string
+	TLSCert            string
+	TLSKey             string
+	AutocertHosts      string
+	AutocertCache      string
+	AutocertEmail      string
+	HTTPRedirectPort   string
+	HTTP3              bool
+	ReadHeaderTimeout  time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	RequestTimeout     time.Duration
+	MaxBody            int64
+	MaxHeaderBytes     int
+	RouteLimits        stringList
+	limitRules         []limitRule
+	ShutdownGrace      time.Duration
+	AccessLog          string
+	Metrics            bool
+	TrustedProxies     string
+	RateLimits         stringList
+	rateRules          []rateRule
+	CachePolicy        stringList
+	cacheRules         []cacheRule
+	Compress           bool
+	CompressMin        int
+	Store              string
+	DBPath             string
+	PerPage            int
+	RobotsDisallowAll  bool
+	BotAgents          string
+	RenderBuffer       int
+	MaxRenders         int
+	RenderWait         time.Duration
+	PageCache          string
+	pageTTLs           map[string]time.Duration
+	Jobs               string
+	jobSchedules       map[string]jobSchedule
+	posterChain        []string
+	StaticMounts       mountList
+	VideoRate          int
+	HLSDir             string
+	ThumbDir           string
+	PosterFallbacks    string
+	KeywordPosters     string
+	ImageCache         string
+	MediaStore         string
+	UploadMax          int64
+	ShowViews          bool
+	DownloadTTL        time.Duration
+	DownloadBindIP     bool
+	PreviewTTL         time.Duration
+	CookieSecret       string
+	CommentLimit       string
+	SMTPAddr           string
+	SMTPUser           string
+	SMTPPassword       string
+	MailFrom           string
+	ContactTo          string
+	CaptchaProvider    string
+	CaptchaSiteKey     string
+	CaptchaSecret      string
+	CSP                string
+	CSPReportOnly      bool
+	CSPReportURI       string
+	HSTSMaxAge         time.Duration
+	ReferrerPolicy     string
+	PermissionsPolicy  string
+	SentryDSN          string
+	CORSOrigins        string
+	CORSMethods        string
+	CORSHeaders        string
+	CORSCredentials    bool
+	CORSMaxAge         time.Duration
+	DebugAddr          string
+	DebugAdmin         bool
+	OTLPEndpoint       string
+	WebhookURLs        stringList
+	WebhookSecret      string
+	WebhookEvents      string
+	OTLPService        string
+	Locales            string
+	GoogleClientID     string
+	GoogleSecret       string
+	GitHubClientID     string
+	GitHubSecret       string
+	S3Endpoint         string
+	S3Region           string
+	S3Bucket           string
+	S3AccessKey        string
+	S3SecretKey        string
+	S3URLExpiry        time.Duration
+	FFmpeg             string
+	ExpireDelete       bool
+	ExpireSweep        time.Duration
+	Deprecated         stringList
+	AdminUser          string
+	AdminPassword      string
+	GateUser           string
+	GatePassword       string
+	GateAllow          string
+
+	// Args are the command's positional arguments, after its flags.
+	Args []string
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port:              "8080",
+		SocketMode:        "0660",
+		TemplateDir:       "templates",
+		StaticDir:         "static",
+		DataPath:          filepath.Join("static", "data", "items.json"),
+		AutocertCache:     "autocert-cache",
+		HTTPRedirectPort:  "80",
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		RequestTimeout:    30 * time.Second,
+		MaxBody:           1 << 20,
+		MaxHeaderBytes:    64 << 10,
+		ShutdownGrace:     15 * time.Second,
+		AccessLog:         "json",
+		Metrics:           true,
+		Compress:          true,
+		CompressMin:       1024,
+		PerPage:           24,
+		Store:             "json",
+		DBPath:            "blendingwaves.db",
+		BotAgents:         defaultBotAgents,
+		RenderBuffer:      64 << 10,
+		RenderWait:        250 * time.Millisecond,
+		PageCache:         "home=30s,item=1m,feed=5m",
+		ExpireSweep:       time.Minute,
+		PreviewTTL:        7 * 24 * time.Hour,
+		AdminUser:         "admin",
+		GateUser:          "preview",
+		FFmpeg:            "ffmpeg",
+		ImageCache:        "image-cache",
+		PosterFallbacks:   defaultPosterChain,
+		KeywordPosters:    "images/posters",
+		MediaStore:        "local",
+		UploadMax:         512 << 20,
+		CommentLimit:      "0.05/3",
+		MailFrom:          "noreply@blendingwaves.com",
+		S3Endpoint:        "https://s3.amazonaws.com",
+		S3Region:          "us-east-1",
+		S3URLExpiry:       15 * time.Minute,
+		HSTSMaxAge:        180 * 24 * time.Hour,
+		OTLPService:       "blendingwaves",
+		Locales:           "en",
+		MaintenanceRetry:  10 * time.Minute,
+		Analytics:         true,
+		NormalizeURLs:     true,
+		TrackingParams:    "utm_*,fbclid,gclid,dclid,gbraid,wbraid,msclkid,yclid,mc_cid,mc_eid,igshid,_ga,_gl",
+		GitBranch:         "main",
+		GitDir:            "git-content",
+		GitItems:          "items.json",
+		AirtableTable:     "Items",
+		AirtableURL:       "https://api.airtable.com/v0",
+		ContentInterval:   5 * time.Minute,
+		CORSMethods:       "GET, POST, DELETE",
+		CORSHeaders:       "Content-Type, X-CSRF-Token",
+		CORSMaxAge:        10 * time.Minute,
+		ReferrerPolicy:    "strict-origin-when-cross-origin",
+		PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=()",
+	}
+}
+
+// bind registers one flag per setting, each writing into c.
+func (c *Config) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.Port, "port", c.Port, "TCP port to listen on")
+	fs.StringVar(&c.ListenHost, "listen-host", c.ListenHost, "IPv4 or IPv6 address to listen on (empty = all interfaces, both versions)")
+	fs.Var(&c.Listen, "listen", "address to serve on as [tcp|tcp4|tcp6://]host:port or unix:/path, optionally with ?h2=off, ?h2c or ?h3 (repeatable); replaces -listen-host and -port")
+	fs.StringVar(&c.SocketMode, "socket-mode", c.SocketMode, "octal file mode of -listen unix sockets")
+	fs.BoolVar(&c.Maintenance, "maintenance", c.Maintenance, "start in maintenance mode: public pages answer 503 until an admin turns it off")
+	fs.DurationVar(&c.MaintenanceRetry, "maintenance-retry", c.MaintenanceRetry, "Retry-After sent with the maintenance page")
+	fs.StringVar(&c.MaintenanceMessage, "maintenance-message", c.MaintenanceMessage, "text shown on the maintenance page (empty = a generic message)")
+	fs.StringVar(&c.MaintenancePage, "maintenance-page", c.MaintenancePage, "HTML file served as the maintenance page instead of the built-in one, reread when it changes")
+	fs.StringVar(&c.FlagsFile, "flags-file", c.FlagsFile, "JSON file of feature flags, e.g. {\"comments\": {\"enabled\": true, \"percent\": 20}}; admin changes override it")
+	fs.BoolVar(&c.Analytics, "analytics", c.Analytics, "count pageviews, traffic sources and estimated visitors, without cookies or stored addresses")
+	fs.StringVar(&c.Experiments, "experiments", c.Experiments, "JSON file of A/B experiments, each splitting one page's visitors between template or sort variants")
+	fs.StringVar(&c.Sites, "sites", c.Sites, "JSON file of sites to serve by Host header, each with its own config block")
+	fs.StringVar(&c.BaseURL, "base-url", c.BaseURL, "public base URL of the site, e.g. https://blendingwaves.com")
+	fs.BoolVar(&c.CanonicalRedirect, "canonical-redirect", c.CanonicalRedirect, "301-redirect the www or bare form of -base-url's host, -canonical-aliases and plain HTTP (when -base-url is https) to -base-url")
+	fs.StringVar(&c.CanonicalAliases, "canonical-aliases", c.CanonicalAliases, "comma-separated other host names of the site that -canonical-redirect sends to -base-url")
+	fs.BoolVar(&c.NormalizeURLs, "normalize-urls", c.NormalizeURLs, "301-redirect page paths with uppercase letters, repeated slashes or a trailing slash to their normal form")
+	fs.StringVar(&c.TrackingParams, "tracking-params", c.TrackingParams, "comma-separated query parameters stripped before routing, each a name or a prefix ending in * (analytics still sees utm_source)")
+	fs.StringVar(&c.TemplateDir, "template-dir", c.TemplateDir, "directory holding the HTML templates")
+	fs.BoolVar(&c.Dev, "dev", c.Dev, "development mode: re-parse templates on every render and show template errors in the browser")
+	fs.StringVar(&c.Export, "export", c.Export, "render the site as static files into this directory and exit instead of serving")
+	fs.StringVar(&c.ImportMap, "import-map", c.ImportMap, "import: comma-separated Column=field pairs naming which item field each CSV or YAML column fills")
+	fs.StringVar(&c.GitRepo, "git-repo", c.GitRepo, "Git repository URL to pull items from; the store then mirrors its items file")
+	fs.StringVar(&c.GitBranch, "git-branch", c.GitBranch, "branch of -git-repo to follow")
+	fs.StringVar(&c.GitDir, "git-dir", c.GitDir, "local checkout directory for -git-repo")
+	fs.StringVar(&c.GitItems, "git-items", c.GitItems, "path of the items file inside -git-repo")
+	fs.StringVar(&c.GitWebhookSecret, "git-webhook-secret", c.GitWebhookSecret, "secret for the push webhook at POST /hooks/git (empty = no webhook)")
+	fs.StringVar(&c.AirtableBase, "airtable-base", c.AirtableBase, "Airtable base ID to fetch items from; the store then mirrors its table")
+	fs.StringVar(&c.AirtableTable, "airtable-table", c.AirtableTable, "Airtable table holding one item per record")
+	fs.StringVar(&c.AirtableView, "airtable-view", c.AirtableView, "Airtable view to read, e.g. one filtering out drafts (empty = all records)")
+	fs.StringVar(&c.AirtableToken, "airtable-token", c.AirtableToken, "Airtable personal access token")
+	fs.StringVar(&c.AirtableURL, "airtable-url", c.AirtableURL, "Airtable API base URL")
+	fs.StringVar(&c.AirtableMap, "airtable-map", c.AirtableMap, "comma-separated Field=item_field pairs naming which item field each Airtable field fills")
+	fs.DurationVar(&c.ContentInterval, "content-interval", c.ContentInterval, "how often to fetch from -git-repo or -airtable-base (0 = only on the push webhook)")
+	fs.BoolVar(&c.DryRun, "dry-run", c.DryRun, "import: print what would change without writing anything")
+	fs.StringVar(&c.StaticDir, "static-dir", c.StaticDir, "directory served at /static/")
+	fs.StringVar(&c.DataPath, "data-path", c.DataPath, "path of items.json")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "TLS certificate file; enables HTTPS with -tls-key")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "TLS private key file")
+	fs.StringVar(&c.AutocertHosts, "autocert-hosts", c.AutocertHosts, "comma-separated hosts to obtain Let's Encrypt certificates for")
+	fs.StringVar(&c.AutocertCache, "autocert-cache", c.AutocertCache, "directory caching Let's Encrypt certificates")
+	fs.StringVar(&c.AutocertEmail, "autocert-email", c.AutocertEmail, "contact email for Let's Encrypt")
+	fs.StringVar(&c.HTTPRedirectPort, "http-redirect-port", c.HTTPRedirectPort, "plain HTTP port redirecting to HTTPS when TLS is on (empty = off)")
+	fs.BoolVar(&c.HTTP3, "http3", c.HTTP3, "also serve HTTP/3 over QUIC on the UDP port of each TLS listener, advertised with Alt-Svc")
+	fs.DurationVar(&c.ReadHeaderTimeout, "read-header-timeout", c.ReadHeaderTimeout, "time allowed to read request headers")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "time allowed to read a whole request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "time allowed to write a response (0 = none)")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "keep-alive idle timeout")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", c.RequestTimeout, "time allowed to answer a request before a 408, unless -route-limit says otherwise (0 = none)")
+	fs.Int64Var(&c.MaxBody, "max-body", c.MaxBody, "largest request body in bytes, unless -route-limit says otherwise; larger ones get a 413 (0 = no limit)")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", c.MaxHeaderBytes, "largest request header block in bytes")
+	fs.Var(&c.RouteLimits, "route-limit", "timeout and body limit for a path prefix as /prefix=timeout/max-body, either part optional (repeatable)")
+	fs.DurationVar(&c.ShutdownGrace, "shutdown-grace", c.ShutdownGrace, "how long shutdown waits for in-flight requests")
+	fs.StringVar(&c.AccessLog, "access-log", c.AccessLog, "access log format: json, combined or off")
+	fs.BoolVar(&c.Metrics, "metrics", c.Metrics, "serve Prometheus metrics at /metrics")
+	fs.StringVar(&c.TrustedProxies, "trusted-proxies", c.TrustedProxies, "comma-separated proxy CIDRs whose X-Forwarded-For is trusted")
+	fs.Var(&c.RateLimits, "rate-limit", "per-IP limit for a path prefix as /prefix=rate/burst (repeatable)")
+	fs.Var(&c.CachePolicy, "cache-policy", "Cache-Control for responses without one, as /prefix=directive, fingerprinted=directive or html=directive, first match winning; replaces the built-in policy (repeatable)")
+	fs.BoolVar(&c.Compress, "compress", c.Compress, "gzip/brotli-compress text responses")
+	fs.IntVar(&c.CompressMin, "compress-min", c.CompressMin, "smallest response body in bytes worth compressing")
+	fs.StringVar(&c.Store, "store", c.Store, "item store: json (items.json) or sqlite")
+	fs.StringVar(&c.DBPath, "db", c.DBPath, "SQLite database path, seeded from items.json when empty")
+	fs.IntVar(&c.PerPage, "per-page", c.PerPage, "default number of items per home page")
+	fs.BoolVar(&c.RobotsDisallowAll, "robots-disallow-all", c.RobotsDisallowAll, "serve a robots.txt that disallows all crawling (staging)")
+	fs.StringVar(&c.BotAgents, "bot-agents", c.BotAgents, "comma-separated user-agent substrings served the lightweight home page")
+	fs.IntVar(&c.RenderBuffer, "render-buffer", c.RenderBuffer, "initial capacity in bytes of pooled render buffers")
+	fs.IntVar(&c.MaxRenders, "max-renders", c.MaxRenders, "maximum concurrent template renders (0 = unlimited)")
+	fs.DurationVar(&c.RenderWait, "render-wait", c.RenderWait, "how long a request waits for a render slot before a 503")
+	fs.StringVar(&c.Jobs, "jobs", c.Jobs, "background job schedules overriding the defaults, as name=schedule pairs such as reindex=30m,thumbnails=@daily; a duration, @hourly, @daily, @weekly, or off to only run a job from the admin. Turning off analytics leaves view and analytics counts unsaved until shutdown")
+	fs.StringVar(&c.PageCache, "page-cache", c.PageCache, "how long rendered home, item and feed pages are cached, as route=duration pairs, plus up to 10% jitter; likes, comments and content reloads refresh them, view counts may lag (route=0 = no time-based expiry, empty = off)")
+	fs.StringVar(&c.AdminUser, "admin-user", c.AdminUser, "admin basic-auth user name")
+	fs.StringVar(&c.AdminPassword, "admin-password", c.AdminPassword, "break-glass admin basic-auth password; when empty only signed-in users with a role can use /admin")
+	fs.StringVar(&c.GateUser, "gate-user", c.GateUser, "user name of the site-wide gate for staging and preview instances")
+	fs.StringVar(&c.GatePassword, "gate-password", c.GatePassword, "password of the site-wide gate; when set, every page but the health checks asks for it (empty = no password)")
+	fs.StringVar(&c.GateAllow, "gate-allow", c.GateAllow, "comma-separated CIDRs let through the site-wide gate without its password; when set, others are refused")
+	fs.BoolVar(&c.ExpireDelete, "expire-delete", c.ExpireDelete, "periodically delete expired items from the store")
+	fs.DurationVar(&c.ExpireSweep, "expire-sweep", c.ExpireSweep, "interval between expired-item sweeps")
+	fs.IntVar(&c.VideoRate, "video-rate", c.VideoRate, "per-response video bandwidth cap in bytes per second (0 = unlimited)")
+	fs.StringVar(&c.HLSDir, "hls-dir", c.HLSDir, "cache directory for HLS renditions; enables ffmpeg transcoding when set")
+	fs.StringVar(&c.ThumbDir, "thumb-dir", c.ThumbDir, "cache directory for video posters and thumbnails; enables ffmpeg frame grabs when set")
+	fs.StringVar(&c.PosterFallbacks, "poster-fallbacks", c.PosterFallbacks, "comma-separated poster sources tried in order: item, frame, keyword, default")
+	fs.StringVar(&c.KeywordPosters, "keyword-posters", c.KeywordPosters, "directory under the static directory of stock posters named by tag or title slug (empty = off)")
+	fs.StringVar(&c.ImageCache, "image-cache", c.ImageCache, "cache directory for images resized by /img/")
+	fs.StringVar(&c.MediaStore, "media-store", c.MediaStore, "where s3: video references live: local (none) or s3")
+	fs.StringVar(&c.CookieSecret, "cookie-secret", c.CookieSecret, "key signing visitor cookies; random per run when empty")
+	fs.StringVar(&c.CommentLimit, "comment-limit", c.CommentLimit, "per-IP comment posting limit as rate/burst (per second)")
+	fs.StringVar(&c.SMTPAddr, "smtp-addr", c.SMTPAddr, "SMTP relay as host:port; mail is only logged when empty")
+	fs.StringVar(&c.SMTPUser, "smtp-user", c.SMTPUser, "SMTP user name for PLAIN auth")
+	fs.StringVar(&c.SMTPPassword, "smtp-password", c.SMTPPassword, "SMTP password")
+	fs.StringVar(&c.MailFrom, "mail-from", c.MailFrom, "sender address of outgoing mail")
+	fs.StringVar(&c.ContactTo, "contact-to", c.ContactTo, "address receiving contact form submissions")
+	fs.StringVar(&c.CaptchaProvider, "captcha-provider", c.CaptchaProvider, "captcha on public forms: turnstile, hcaptcha, recaptcha or empty for none")
+	fs.StringVar(&c.CaptchaSiteKey, "captcha-site-key", c.CaptchaSiteKey, "captcha site key")
+	fs.StringVar(&c.CaptchaSecret, "captcha-secret", c.CaptchaSecret, "captcha secret key")
+	fs.StringVar(&c.CSP, "csp", c.CSP, "Content-Security-Policy, with {nonce} for the inline-script nonce; empty for the built-in policy, off for none")
+	fs.BoolVar(&c.CSPReportOnly, "csp-report-only", c.CSPReportOnly, "send the CSP as Content-Security-Policy-Report-Only")
+	fs.StringVar(&c.CSPReportURI, "csp-report-uri", c.CSPReportURI, "URL receiving CSP violation reports")
+	fs.DurationVar(&c.HSTSMaxAge, "hsts-max-age", c.HSTSMaxAge, "Strict-Transport-Security max-age on HTTPS responses (0 = none)")
+	fs.StringVar(&c.ReferrerPolicy, "referrer-policy", c.ReferrerPolicy, "Referrer-Policy header (empty = none)")
+	fs.StringVar(&c.PermissionsPolicy, "permissions-policy", c.PermissionsPolicy, "Permissions-Policy header (empty = none)")
+	fs.StringVar(&c.DebugAddr, "debug-addr", c.DebugAddr, "loopback address serving pprof and expvar under /debug/ without auth, e.g. 127.0.0.1:6060")
+	fs.BoolVar(&c.DebugAdmin, "debug-admin", c.DebugAdmin, "also serve /debug/ on the site to users with the admin role (profiles must finish within write-timeout)")
+	fs.StringVar(&c.CORSOrigins, "cors-origins", c.CORSOrigins, "comma-separated origins allowed to call /api/ from the browser, or * for any")
+	fs.StringVar(&c.CORSMethods, "cors-methods", c.CORSMethods, "methods allowed in cross-origin API requests")
+	fs.StringVar(&c.CORSHeaders, "cors-headers", c.CORSHeaders, "request headers allowed in cross-origin API requests")
+	fs.BoolVar(&c.CORSCredentials, "cors-credentials", c.CORSCredentials, "let allowed origins send cookies with API requests")
+	fs.DurationVar(&c.CORSMaxAge, "cors-max-age", c.CORSMaxAge, "how long browsers may cache preflight results")
+	fs.StringVar(&c.SentryDSN, "sentry-dsn", c.SentryDSN, "Sentry DSN receiving panics and 500 errors; errors are only logged when empty")
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", c.OTLPEndpoint, "OTLP/HTTP collector receiving traces, e.g. http://localhost:4318; tracing is off when empty")
+	fs.StringVar(&c.OTLPService, "otlp-service", c.OTLPService, "service.name reported with traces")
+	fs.Var(&c.WebhookURLs, "webhook-url", "endpoint receiving signed JSON on item changes (repeatable)")
+	fs.StringVar(&c.WebhookSecret, "webhook-secret", c.WebhookSecret, "HMAC key signing webhook payloads")
+	fs.StringVar(&c.Locales, "locales", c.Locales, "comma-separated languages offered to visitors, the default first; each needs a catalog in locales/ (e.g. en,es)")
+	fs.StringVar(&c.WebhookEvents, "webhook-events", c.WebhookEvents, "comma-separated events to send: item.created, item.updated, item.published, item.deleted; empty for all")
+	fs.StringVar(&c.GoogleClientID, "google-client-id", c.GoogleClientID, "OAuth client ID enabling Sign in with Google")
+	fs.StringVar(&c.GoogleSecret, "google-client-secret", c.GoogleSecret, "Google OAuth client secret")
+	fs.StringVar(&c.GitHubClientID, "github-client-id", c.GitHubClientID, "OAuth app client ID enabling Sign in with GitHub")
+	fs.StringVar(&c.GitHubSecret, "github-client-secret", c.GitHubSecret, "GitHub OAuth app client secret")
+	fs.BoolVar(&c.ShowViews, "show-views", c.ShowViews, "show view counts on item pages and in the items API")
+	fs.DurationVar(&c.DownloadTTL, "download-ttl", c.DownloadTTL, "lifetime of the signed video download links offered on item pages (0 = no downloads)")
+	fs.BoolVar(&c.DownloadBindIP, "download-bind-ip", c.DownloadBindIP, "only honor a download link from the address it was issued to")
+	fs.DurationVar(&c.PreviewTTL, "preview-ttl", c.PreviewTTL, "lifetime of the preview links editors make for unpublished items (they also end when -cookie-secret changes)")
+	fs.Int64Var(&c.UploadMax, "upload-max", c.UploadMax, "largest admin media upload in bytes (large files may also need a longer -route-limit for /admin/media than its 10m)")
+	fs.StringVar(&c.S3Endpoint, "s3-endpoint", c.S3Endpoint, "S3-compatible endpoint URL, e.g. https://storage.googleapis.com for GCS")
+	fs.StringVar(&c.S3Region, "s3-region", c.S3Region, "S3 signing region")
+	fs.StringVar(&c.S3Bucket, "s3-bucket", c.S3Bucket, "media bucket; empty when s3-endpoint already names it")
+	fs.StringVar(&c.S3AccessKey, "s3-access-key", c.S3AccessKey, "S3 access key ID")
+	fs.StringVar(&c.S3SecretKey, "s3-secret-key", c.S3SecretKey, "S3 secret access key")
+	fs.DurationVar(&c.S3URLExpiry, "s3-url-expiry", c.S3URLExpiry, "lifetime of signed media URLs")
+	fs.StringVar(&c.FFmpeg, "ffmpeg", c.FFmpeg, "ffmpeg binary used for transcoding")
+	fs.Var(&c.StaticMounts, "static-mount", "extra static directory as urlpath=dir[;cache-control] (repeatable)")
+	fs.Var(&c.Deprecated, "deprecate-route", "mark a route deprecated as pattern[=sunset YYYY-MM-DD] (repeatable)")
+}
+
+// validate checks settings that can't be expressed by flag types alone,
+// normalising and deriving fields as it goes.
+func (c *Config) validate() error {
+	var errs []error
+	if c.DebugAddr != "" {
+		if err := checkLoopback(c.DebugAddr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 0 || n > 65535 {
+		errs = append(errs, fmt.Errorf("port must be a number from 0 to 65535, got %q", c.Port))
+	}
+	// Settings that can't be empty, always or once the setting in the
+	// reason is on. Defaults fill most in, but a file or the environment
+	// can still blank them.
+	for _, req := range []struct {
+		name, value string
+		needed      bool
+		reason      string
+	}{
+		{"template-dir", c.TemplateDir, true, ""},
+		{"static-dir", c.StaticDir, true, ""},
+		{"data-path", c.DataPath, c.Store == "json", "store json"},
+		{"db", c.DBPath, c.Store == "sqlite", "store sqlite"},
+		{"admin-user", c.AdminUser, c.AdminPassword != "", "admin-password"},
+		{"autocert-cache", c.AutocertCache, c.AutocertHosts != "", "autocert-hosts"},
+		{"git-dir", c.GitDir, c.GitRepo != "", "git-repo"},
+		{"git-items", c.GitItems, c.GitRepo != "", "git-repo"},
+		{"airtable-token", c.AirtableToken, c.AirtableBase != "", "airtable-base"},
+		{"airtable-table", c.AirtableTable, c.AirtableBase != "", "airtable-base"},
+		{"mail-from", c.MailFrom, c.SMTPAddr != "", "smtp-addr"},
+		{"s3-endpoint", c.S3Endpoint, c.MediaStore == "s3", "media-store s3"},
+		{"s3-bucket", c.S3Bucket, c.MediaStore == "s3", "media-store s3"},
+	} {
+		switch {
+		case !req.needed || strings.TrimSpace(req.value) != "":
+		case req.reason == "":
+			errs = append(errs, fmt.Errorf("%s is required", req.name))
+		default:
+			errs = append(errs, fmt.Errorf("%s is required with %s", req.name, req.reason))
+		}
+	}
+	c.listeners = nil
+	for _, s := range c.Listen {
+		a, err := parseListenAddr(s, c.HTTP3)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.listeners = append(c.listeners, a)
+	}
+	if m, err := strconv.ParseUint(c.SocketMode, 8, 32); err != nil || m > 0o777 {
+		errs = append(errs, fmt.Errorf("socket-mode must be an octal file mode such as 0660, got %q", c.SocketMode))
+	} else {
+		c.socketMode = fs.FileMode(m)
+	}
+	for name, d := range map[string]time.Duration{
+		"read-header-timeout": c.ReadHeaderTimeout,
+		"read-timeout":        c.ReadTimeout,
+		"write-timeout":       c.WriteTimeout,
+		"idle-timeout":        c.IdleTimeout,
+		"request-timeout":     c.RequestTimeout,
+		"shutdown-grace":      c.ShutdownGrace,
+		"content-interval":    c.ContentInterval,
+	} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", name))
+		}
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls-cert and tls-key must be set together"))
+	}
+	if c.TLSCert != "" && c.AutocertHosts != "" {
+		errs = append(errs, errors.New("use either tls-cert/tls-key or autocert-hosts, not both"))
+	}
+	if !tlsEnabled(*c) && slices.ContainsFunc(c.listenAddrs(), func(a listenAddr) bool { return a.http3 }) {
+		errs = append(errs, errors.New("HTTP/3 needs TLS: set tls-cert/tls-key or autocert-hosts"))
+	}
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("base-url must be an absolute URL, got %q", c.BaseURL))
+		}
+		c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	}
+	// The default directories fall back to the embedded copies; one set
+	// explicitly must exist.
+	def := defaultConfig()
+	for _, dir := range [][2]string{{c.TemplateDir, def.TemplateDir}, {c.StaticDir, def.StaticDir}} {
+		if dir[0] == dir[1] {
+			continue
+		}
+		if fi, err := os.Stat(dir[0]); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("%s is not a directory", dir[0]))
+		}
+	}
+	if c.MaintenancePage != "" {
+		if f, err := os.Open(c.MaintenancePage); err != nil {
+			errs = append(errs, fmt.Errorf("maintenance-page: %w", err))
+		} else {
+			f.Close()
+		}
+	}
+	switch c.AccessLog {
+	case "json", "combined", "off":
+	default:
+		errs = append(errs, fmt.Errorf("access-log must be json, combined or off, got %q", c.AccessLog))
+	}
+	c.rateRules = nil
+	for _, spec := range c.RateLimits {
+		rule, err := parseRateRule(spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.rateRules = append(c.rateRules, rule)
+	}
+	c.limitRules = nil
+	for _, spec := range c.RouteLimits {
+		rule, err := parseLimitRule(spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.limitRules = append(c.limitRules, rule)
+	}
+	if c.MaxBody < 0 {
+		errs = append(errs, errors.New("max-body must not be negative"))
+	}
+	if c.MaxHeaderBytes < 4<<10 {
+		errs = append(errs, errors.New("max-header-bytes must be at least 4096"))
+	}
+	c.cacheRules = nil
+	for _, spec := range c.CachePolicy {
+		rule, err := parseCacheRule(spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.cacheRules = append(c.cacheRules, rule)
+	}
+	if c.Store != "json" && c.Store != "sqlite" {
+		errs = append(errs, fmt.Errorf("store must be json or sqlite, got %q", c.Store))
+	}
+	if c.RenderBuffer <= 0 {
+		errs = append(errs, errors.New("render-buffer must be positive"))
+	}
+	if c.PerPage < 1 || c.PerPage > maxPerPage {
+		errs = append(errs, fmt.Errorf("per-page must be between 1 and %d", maxPerPage))
+	}
+	if c.MaxRenders < 0 {
+		errs = append(errs, errors.New("max-renders must not be negative"))
+	}
+	if c.ExpireDelete && c.ExpireSweep <= 0 {
+		errs = append(errs, errors.New("expire-sweep must be positive when expire-delete is set"))
+	}
+	if c.VideoRate < 0 {
+		errs = append(errs, errors.New("video-rate must not be negative"))
+	}
+	if c.HLSDir != "" || c.ThumbDir != "" {
+		if _, err := exec.LookPath(c.FFmpeg); err != nil {
+			errs = append(errs, fmt.Errorf("hls-dir and thumb-dir need ffmpeg: %w", err))
+		}
+	}
+	if chain, err := parsePosterChain(c.PosterFallbacks); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.posterChain = chain
+	}
+	if c.KeywordPosters != "" && !safePath(c.KeywordPosters) {
+		errs = append(errs, fmt.Errorf("keyword-posters must be a relative path inside the static directory, got %q", c.KeywordPosters))
+	}
+	if c.PreviewTTL <= 0 {
+		errs = append(errs, errors.New("preview-ttl must be positive"))
+	}
+	if c.DownloadTTL < 0 {
+		errs = append(errs, errors.New("download-ttl must not be negative"))
+	}
+	if c.UploadMax <= 0 {
+		errs = append(errs, errors.New("upload-max must be positive"))
+	}
+	if c.MediaStore != "local" && c.MediaStore != "s3" {
+		errs = append(errs, fmt.Errorf("media-store must be local or s3, got %q", c.MediaStore))
+	}
+	if c.S3URLExpiry < time.Second || c.S3URLExpiry > 7*24*time.Hour {
+		errs = append(errs, errors.New("s3-url-expiry must be between 1s and 7 days"))
+	}
+	if c.RenderWait < 0 {
+		errs = append(errs, errors.New("render-wait must not be negative"))
+	}
+	if ttls, err := parsePageTTLs(c.PageCache); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.pageTTLs = ttls
+	}
+	if schedules, err := parseJobSchedules(c.Jobs); err != nil {
+		errs = append(errs, err)
+	} else {
+		c.jobSchedules = schedules
+	}
+	return errors.Join(errs...)
+}
+
+// envName maps a flag name to its environment variable, e.g.
+// max-renders -> MAX_RENDERS.
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// resetter is implemented by repeatable flag values so an explicit flag
+// replaces, rather than extends, values from the file or environment.
+type resetter interface{ Reset() }
+
+func (m *mountList) Reset() { *m = nil }
+
+// stringList implements flag.Value for a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+func (l *stringList) Reset()             { *l = nil }
+
+// loadConfig resolves the configuration from args, the environment and
+// the optional -config file.
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet("blendingwaves", flag.ContinueOnError)
+	cfg.bind(fs)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a JSON config file")
+
+	// First pass: find -config and remember which flags were given.
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	cfg = defaultConfig()
+	if *path != "" {
+		if err := applyConfigFile(fs, *path); err != nil {
+			return cfg, err
+		}
+	}
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			// A list from the environment replaces the file's.
+			if r, ok := f.Value.(resetter); ok {
+				r.Reset()
+			}
+			if err := fs.Set(f.Name, v); err != nil && envErr == nil {
+				envErr = fmt.Errorf("%s: %w", envName(f.Name), err)
+			}
+		}
+	})
+	if envErr != nil {
+		return cfg, envErr
+	}
+
+	// Second pass: explicit flags win over everything else.
+	for name := range explicit {
+		if r, ok := fs.Lookup(name).Value.(resetter); ok {
+			r.Reset()
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	cfg.Args = fs.Args()
+	err := cfg.validate()
+	return cfg, err
+}
+
+// applyConfigFile sets flags from a JSON object keyed by flag name.
+// Arrays set a repeatable flag once per element.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	for key, val := range raw {
+		if fs.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("config %s: unknown key %q", path, key)
+		}
+		var values []string
+		var list []json.RawMessage
+		if json.Unmarshal(val, &list) == nil {
+			for _, v := range list {
+				values = append(values, jsonScalar(v))
+			}
+		} else {
+			values = []string{jsonScalar(val)}
+		}
+		for _, v := range values {
+			if err := fs.Set(key, v); err != nil {
+				return fmt.Errorf("config %s: %s: %w", path, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// jsonScalar returns a JSON string's contents, or the literal text of a
+// number or boolean.
+func jsonScalar(v json.RawMessage) string {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	return string(v)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a -config file for the test and returns its path.
+func writeConfigFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	file := writeConfigFile(t, `{"per-page": 10, "render-wait": "1s", "bot-agents": "filebot", "rate-limit": ["/api/=5/10"]}`)
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		perPage  int
+		wait     time.Duration
+		bots     string
+		rateRule []string
+	}{
+		{"defaults", nil, nil, 24, 250 * time.Millisecond, defaultBotAgents, nil},
+		{"file", nil, []string{"-config", file}, 10, time.Second, "filebot", []string{"/api/=5/10"}},
+		{"env over file", map[string]string{"PER_PAGE": "12"}, []string{"-config", file}, 12, time.Second, "filebot", []string{"/api/=5/10"}},
+		{"flag over file", nil, []string{"-config", file, "-per-page", "30", "-bot-agents", "flagbot"}, 30, time.Second, "flagbot", []string{"/api/=5/10"}},
+		{"flag over env and file", map[string]string{"PER_PAGE": "12"}, []string{"-per-page", "40", "-config", file}, 40, time.Second, "filebot", []string{"/api/=5/10"}},
+		{"repeatable flag replaces file", nil, []string{"-config", file, "-rate-limit", "/=1/2"}, 10, time.Second, "filebot", []string{"/=1/2"}},
+		{"repeatable env replaces file", map[string]string{"RATE_LIMIT": "/search=3/6"}, []string{"-config", file}, 10, time.Second, "filebot", []string{"/search=3/6"}},
+		{"repeatable flag replaces env", map[string]string{"RATE_LIMIT": "/search=3/6"}, []string{"-config", file, "-rate-limit", "/=1/2"}, 10, time.Second, "filebot", []string{"/=1/2"}},
+		{"config from env", map[string]string{"CONFIG_FILE": file}, []string{"-render-wait", "2s"}, 10, 2 * time.Second, "filebot", []string{"/api/=5/10"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := loadConfig(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.PerPage != tt.perPage || cfg.RenderWait != tt.wait || cfg.BotAgents != tt.bots {
+				t.Errorf("per-page %d, render-wait %v, bot-agents %q; want %d, %v, %q", cfg.PerPage, cfg.RenderWait, cfg.BotAgents, tt.perPage, tt.wait, tt.bots)
+			}
+			if strings.Join(cfg.RateLimits, " ") != strings.Join(tt.rateRule, " ") {
+				t.Errorf("rate-limit %q, want %q", cfg.RateLimits, tt.rateRule)
+			}
+		})
+	}
+}
+
+func TestConfigRequired(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		want []string // error fragments; none for a valid config
+	}{
+		{"defaults", "", nil, nil},
+		{"blank data path", "", []string{"-data-path", ""}, []string{"data-path is required with store json"}},
+		{"sqlite needs no data path", "", []string{"-store", "sqlite", "-data-path", ""}, nil},
+		{"blank db", "", []string{"-store", "sqlite", "-db", " "}, []string{"db is required with store sqlite"}},
+		{"blanked in the file", `{"template-dir": "", "static-dir": ""}`, nil, []string{"template-dir is required", "static-dir is required"}},
+		{"flag fills in the file's blank", `{"template-dir": ""}`, []string{"-template-dir", "templates"}, nil},
+		{"s3 without bucket", "", []string{"-media-store", "s3", "-s3-endpoint", "https://s3.example.com"}, []string{"s3-bucket is required with media-store s3"}},
+		{"smtp without sender", "", []string{"-smtp-addr", "mail:25", "-mail-from", ""}, []string{"mail-from is required with smtp-addr"}},
+		{"admin password without user", "", []string{"-admin-password", "pw", "-admin-user", ""}, []string{"admin-user is required with admin-password"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, tt.file)}, args...)
+			}
+			_, err := loadConfig(args)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no error, want %q", tt.want)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q lacks %q", err, w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// contactTo receives contact form submissions; when empty they are only
+// logged.
+var contactTo string
+
+const (
+	maxContactName    = 100
+	maxContactMessage = 5000
+)
+
+// contactForm is what a visitor posts to /contact.
+type contactForm struct {
+	Name    string
+	Email   string
+	Message string
+}
+
+func contactHandler(w http.ResponseWriter, r *http.Request) {
+	renderContact(w, r, http.StatusOK, contactForm{}, "")
+}
+
+// contactPostHandler validates a submission and queues it for delivery.
+// Submissions that fill in the hidden honeypot field are dropped while
+// looking successful, so bots learn nothing.
+func contactPostHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f := contactForm{
+		Name:    strings.TrimSpace(r.PostForm.Get("name")),
+		Email:   strings.TrimSpace(r.PostForm.Get("email")),
+		Message: strings.TrimSpace(r.PostForm.Get("message")),
+	}
+	if r.PostForm.Get("website") != "" {
+		log.Printf("contact: honeypot filled from %s, dropping", clientIP(r))
+		http.Redirect(w, r, "/contact?sent=1", http.StatusSeeOther)
+		return
+	}
+	if err := f.validate(); err != nil {
+		renderContact(w, r, http.StatusBadRequest, f, err.Error())
+		return
+	}
+	if err := verifyCaptcha(r); err != nil {
+		renderContact(w, r, http.StatusBadRequest, f, err.Error())
+		return
+	}
+
+	msg := Message{
+		To:      []string{contactTo},
+		ReplyTo: f.Email,
+		Subject: "Contact form: " + f.Name,
+		Body:    fmt.Sprintf("From: %s <%s>\nIP: %s\n\n%s\n", f.Name, f.Email, clientIP(r), f.Message),
+	}
+	if contactTo == "" {
+		msg.To = nil
+		logMailer{}.Send(msg)
+	} else if !sendMail(msg) {
+		renderContact(w, r, http.StatusServiceUnavailable, f, "We couldn't send your message right now; please try again later.")
+		return
+	}
+	http.Redirect(w, r, "/contact?sent=1", http.StatusSeeOther)
+}
+
+func (f contactForm) validate() error {
+	if f.Name == "" || utf8.RuneCountInString(f.Name) > maxContactName {
+		return fmt.Errorf("name must be 1 to %d characters", maxContactName)
+	}
+	if addr, err := mail.ParseAddress(f.Email); err != nil || addr.Address != f.Email {
+		return fmt.Errorf("please enter a valid email address")
+	}
+	if f.Message == "" || utf8.RuneCountInString(f.Message) > maxContactMessage {
+		return fmt.Errorf("message must be 1 to %d characters", maxContactMessage)
+	}
+	return nil
+}
+
+func renderContact(w http.ResponseWriter, r *http.Request, status int, f contactForm, formErr string) {
+	data := map[string]interface{}{
+		"Title":   "Contact | BlendingWaves",
+		"Form":    f,
+		"Error":   formErr,
+		"Sent":    r.URL.Query().Has("sent"),
+		"CSRF":    csrfToken(w, r),
+		"Captcha": captcha,
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if err := renderStatus(w, status, "contact.html", data); err != nil {
+		renderError(w, err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ContentSource supplies the whole catalog from outside the store: a Git
+// repository or a headless CMS. The store mirrors the last good fetch,
+// which doubles as the local cache: while the source is down, or after a
+// fetch that fails validation, the site keeps serving what it had.
+type ContentSource interface {
+	// Name identifies the source in logs and the item history.
+	Name() string
+	// Fetch returns every item and a version that changes when they do.
+	Fetch(ctx context.Context) (items []Item, version string, err error)
+}
+
+// contentFetchTimeout bounds one fetch from a source.
+const contentFetchTimeout = 2 * time.Minute
+
+// content is the configured source and its sync state.
+var content struct {
+	src     ContentSource // nil when the store is edited directly
+	trigger chan struct{}
+
+	mu      sync.Mutex // one sync at a time
+	version string     // version last applied
+}
+
+// setContentSource picks the source the git-* or airtable-* settings
+// describe, if any.
+func setContentSource(cfg Config) error {
+	var srcs []ContentSource
+	if cfg.GitRepo != "" {
+		src, err := newGitSource(cfg)
+		if err != nil {
+			return err
+		}
+		srcs = append(srcs, src)
+	}
+	if cfg.AirtableBase != "" {
+		src, err := newAirtableSource(cfg)
+		if err != nil {
+			return err
+		}
+		srcs = append(srcs, src)
+	}
+	switch len(srcs) {
+	case 0:
+		return nil
+	case 1:
+		content.src = srcs[0]
+		content.trigger = make(chan struct{}, 1)
+		return nil
+	}
+	return errors.New("use either git-repo or airtable-base as the content source, not both")
+}
+
+// startContentSync syncs once and then every interval, or only when
+// queueContentSync asks if interval is 0.
+func startContentSync(interval time.Duration) {
+	if content.src == nil {
+		return
+	}
+	if err := syncContent(context.Background()); err != nil {
+		log.Printf("%s sync: %v; serving the stored items", content.src.Name(), err)
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.Tick(interval)
+	}
+	go func() {
+		for {
+			select {
+			case <-tick:
+			case <-content.trigger:
+			}
+			if err := syncContent(context.Background()); err != nil {
+				log.Printf("%s sync: %v", content.src.Name(), err)
+			}
+		}
+	}()
+}
+
+// queueContentSync asks for a sync soon without waiting for it.
+func queueContentSync() {
+	select {
+	case content.trigger <- struct{}{}:
+	default: // one is already queued
+	}
+}
+
+// syncContent fetches from the source and, when the version moved,
+// replaces the stored items with the fetched ones. Items are checked
+// first; a fetch that fails validation is not applied.
+func syncContent(ctx context.Context) error {
+	content.mu.Lock()
+	defer content.mu.Unlock()
+	name := content.src.Name()
+	ctx, span := startSpan(ctx, name+".sync")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, contentFetchTimeout)
+	defer cancel()
+	items, version, err := content.src.Fetch(ctx)
+	if err != nil {
+		span.fail(err)
+		return err
+	}
+	if version == content.version {
+		return nil
+	}
+	short := version[:min(len(version), 12)]
+	span.set("version", short)
+	if err := checkItems(items, nil); err != nil {
+		span.fail(err)
+		return fmt.Errorf("version %s: %w", short, err)
+	}
+	changed, err := applyContent(items, name+"@"+short)
+	if err != nil {
+		span.fail(err)
+		return err
+	}
+	content.version = version
+	if changed == 0 {
+		log.Printf("%s sync: %s, no item changes", name, short)
+		return nil
+	}
+	log.Printf("%s sync: %s, %d items changed", name, short, changed)
+	return reloadItems(ctx)
+}
+
+// applyContent makes the store hold exactly items, writing only those
+// that differ and recording each change as by who. It returns how many
+// items changed.
+func applyContent(items []Item, who string) (int, error) {
+	existing, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	old := make(map[int]Item, len(existing))
+	for _, it := range existing {
+		old[it.ID] = it
+	}
+	changed := 0
+	for _, it := range items {
+		prev, ok := old[it.ID]
+		delete(old, it.ID)
+		if ok && len(itemDiff(prev, it)) == 0 {
+			continue
+		}
+		if it.CreatedAt == nil {
+			it.CreatedAt = prev.CreatedAt
+		}
+		if err := putItem(store, who, RevSync, it); err != nil {
+			return changed, fmt.Errorf("item %d: %w", it.ID, err)
+		}
+		changed++
+	}
+	for id := range old {
+		if err := deleteItem(store, who, id); err != nil {
+			return changed, fmt.Errorf("item %d: %w", id, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy is the cross-origin access granted to API routes.
+type corsPolicy struct {
+	origins     map[string]bool
+	any         bool // "*": every origin, without credentials
+	methods     string
+	headers     string
+	credentials bool
+	maxAge      string
+}
+
+// cors is nil when no origins are allowed.
+var cors *corsPolicy
+
+// corsExposed are the API response headers cross-origin scripts may read.
+const corsExposed = "Link, X-Total-Count, X-Request-ID, Retry-After"
+
+// setCORS parses a comma-separated origin list. "*" allows any origin but
+// can't be combined with credentials, which browsers refuse.
+func setCORS(origins, methods, headers string, credentials bool, maxAge time.Duration) error {
+	cors = nil
+	p := &corsPolicy{
+		origins:     map[string]bool{},
+		methods:     methods,
+		headers:     headers,
+		credentials: credentials,
+		maxAge:      strconv.Itoa(int(maxAge / time.Second)),
+	}
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		if o == "*" {
+			p.any = true
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return fmt.Errorf("cors origin %q must look like https://app.example.com", o)
+		}
+		p.origins[strings.ToLower(o)] = true
+	}
+	if !p.any && len(p.origins) == 0 {
+		return nil
+	}
+	if p.any && credentials {
+		return fmt.Errorf("cors-credentials can't be used with the * origin")
+	}
+	cors = p
+	return nil
+}
+
+// allowed reports whether origin may call the API.
+func (p *corsPolicy) allowed(origin string) bool {
+	return p != nil && origin != "" && (p.any || p.origins[strings.ToLower(origin)])
+}
+
+// trustedOrigin reports whether origin was explicitly allowed, so its
+// requests are as trusted as same-site ones and need no CSRF token.
+func trustedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return cors != nil && origin != "" && cors.origins[strings.ToLower(origin)] &&
+		isAPI(r.URL.Path)
+}
+
+// withCORS adds CORS headers to API responses for allowed origins and
+// answers their preflight requests.
+func withCORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cors == nil || !isAPI(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		hdr := w.Header()
+		hdr.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if !cors.allowed(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if cors.any {
+			hdr.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			hdr.Set("Access-Control-Allow-Origin", origin)
+		}
+		if cors.credentials {
+			hdr.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			hdr.Add("Vary", "Access-Control-Request-Method")
+			hdr.Add("Vary", "Access-Control-Request-Headers")
+			hdr.Set("Access-Control-Allow-Methods", cors.methods)
+			hdr.Set("Access-Control-Allow-Headers", cors.headers)
+			hdr.Set("Access-Control-Max-Age", cors.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		hdr.Set("Access-Control-Expose-Headers", corsExposed)
+		h.ServeHTTP(w, r)
+	})
+}
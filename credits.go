@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// creditGroup is one creator on the credits page: the sources of theirs
+// the site uses and the items that use them.
+type creditGroup struct {
+	Creator string       `json:"creator"`
+	Sources []string     `json:"sources"`
+	Items   []creditItem `json:"items"`
+}
+
+// creditItem links a credits entry back to an item using the footage.
+type creditItem struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// parseCredit splits a VideoCredit into who to attribute and the source
+// URL. "Video by Name: https://..." credits Name; a bare URL credits the
+// site it is on; anything else is credited as written.
+func parseCredit(s string) (creator, source string) {
+	s = strings.TrimSpace(s)
+	i := strings.Index(s, "http")
+	if i < 0 || !wellFormedURL(s[i:]) {
+		return s, ""
+	}
+	creator, source = strings.TrimSpace(strings.TrimRight(s[:i], ":-–— ")), s[i:]
+	if len(creator) > len("video by ") && strings.EqualFold(creator[:len("video by ")], "video by ") {
+		creator = strings.TrimSpace(creator[len("video by "):])
+	}
+	if creator == "" {
+		if u, err := url.Parse(source); err == nil {
+			creator = strings.TrimPrefix(u.Hostname(), "www.")
+		}
+	}
+	return creator, source
+}
+
+// credits groups the credits of the items live at now by creator,
+// alphabetically, each source and item listed once in catalog order.
+func (c *catalog) credits(now time.Time) []creditGroup {
+	var groups []creditGroup
+	index := map[string]int{}
+	for _, it := range c.live(now) {
+		for _, vc := range it.VideoCredit {
+			creator, source := parseCredit(vc)
+			if creator == "" {
+				continue
+			}
+			key := strings.ToLower(creator)
+			i, ok := index[key]
+			if !ok {
+				i = len(groups)
+				index[key] = i
+				groups = append(groups, creditGroup{Creator: creator})
+			}
+			g := &groups[i]
+			if source != "" && !slices.Contains(g.Sources, source) {
+				g.Sources = append(g.Sources, source)
+			}
+			link := "/items/" + it.Slug
+			if !slices.ContainsFunc(g.Items, func(ci creditItem) bool { return ci.URL == link }) {
+				g.Items = append(g.Items, creditItem{Title: it.KeywordTitle, URL: link})
+			}
+		}
+	}
+	slices.SortStableFunc(groups, func(a, b creditGroup) int {
+		return cmp.Compare(strings.ToLower(a.Creator), strings.ToLower(b.Creator))
+	})
+	return groups
+}
+
+// creditsHandler attributes the footage the site uses, generated from
+// the items' video credits.
+func creditsHandler(w http.ResponseWriter, r *http.Request) {
+	groups := current().credits(time.Now())
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]any{"credits": groups})
+		return
+	}
+	title := translate(writerLocale(w), "Credits")
+	data := map[string]interface{}{
+		"Title":   title + " | BlendingWaves",
+		"Credits": groups,
+		"Meta": PageMeta{
+			Title:       title,
+			Description: "The creators whose footage appears on BlendingWaves.",
+			URL:         siteURL(r, "/credits"),
+			Type:        "website",
+			Image:       siteURL(r, defaultThumbnail),
+			TwitterCard: "summary",
+		},
+	}
+	if err := render(w, "credits.html", data); err != nil {
+		renderError(w, err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// csrfHeader carries the token for fetch() and multipart requests, whose
+// bodies the middleware doesn't parse.
+const csrfHeader = "X-CSRF-Token"
+
+// maxFormBody caps urlencoded bodies read by csrfProtect.
+const maxFormBody = 1 << 20
+
+// csrfToken returns the form token for the visitor, issuing a visitor
+// cookie when there is none. Tokens are an HMAC of the visitor ID, so a
+// page on another site can't produce one.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	id, _ := visitorID(w, r, true)
+	return csrfFor(id)
+}
+
+func csrfFor(visitor string) string {
+	m := hmac.New(sha256.New, cookieSecret)
+	m.Write([]byte("csrf:" + visitor))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// csrfField renders the hidden form input carrying token.
+func csrfField(token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="csrf_token" value="` + template.HTMLEscapeString(token) + `">`)
+}
+
+// validCSRF checks the token in the X-CSRF-Token header, or else the
+// posted csrf_token of a urlencoded form, against the visitor cookie.
+func validCSRF(r *http.Request) bool {
+	id, ok := visitorID(nil, r, false)
+	if !ok {
+		return false
+	}
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mt == "application/x-www-form-urlencoded" {
+			token = r.PostFormValue("csrf_token")
+		}
+	}
+	return token != "" && hmac.Equal([]byte(token), []byte(csrfFor(id)))
+}
+
+// fromBrowser reports whether r looks like it was sent by a browser,
+// which always names the requesting origin on cross-site posts. Scripts
+// and API clients send neither header and can't be tricked into
+// forging a request, so they need no token.
+func fromBrowser(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != ""
+}
+
+// csrfExempt are browser-posted paths that need no token: forging a
+// request to them changes nothing of the visitor's.
+var csrfExempt = map[string]bool{
+	"/collect": true,
+}
+
+// csrfProtect rejects browser requests with unsafe methods that don't
+// carry the visitor's CSRF token. API calls from origins allowed by
+// -cors-origins and the paths in csrfExempt are exempt.
+func csrfProtect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !fromBrowser(r) || trustedOrigin(r) || csrfExempt[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get(csrfHeader) == "" {
+			// Parsing the form for its token would bypass handlers' own
+			// body limits, so cap it here.
+			r.Body = http.MaxBytesReader(w, r.Body, maxFormBody)
+		}
+		if !validCSRF(r) {
+			csrfFailed(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// csrfFailed sends the 403 for a missing or stale token: JSON for API
+// clients, otherwise a page sending the visitor back to the form.
+func csrfFailed(w http.ResponseWriter, r *http.Request) {
+	const msg = "invalid or missing CSRF token"
+	if isAPI(r.URL.Path) || wantsJSON(r) {
+		writeJSONError(w, http.StatusForbidden, msg)
+		return
+	}
+	back := localNext(strings.TrimPrefix(r.Referer(), siteURL(r, "")), "/")
+	data := map[string]interface{}{
+		"Title": "Form expired | BlendingWaves",
+		"Back":  back,
+	}
+	if err := renderStatus(w, http.StatusForbidden, "403.html", data); err != nil {
+		http.Error(w, msg, http.StatusForbidden)
+	}
+}
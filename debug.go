@@ -0,0 +1,60 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+var startTime = time.Now()
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("uptime_seconds", expvar.Func(func() any { return int64(time.Since(startTime).Seconds()) }))
+	expvar.Publish("catalog_items", expvar.Func(func() any {
+		if c := current(); c != nil {
+			return len(c.items)
+		}
+		return 0
+	}))
+	expvar.Publish("render_busy_total", expvar.Func(func() any { return renderBusyCnt.Load() }))
+}
+
+// debugMux serves net/http/pprof and expvar under /debug/. Those packages
+// also register on http.DefaultServeMux, which is why the site uses its
+// own mux.
+func debugMux() *http.ServeMux {
+	m := http.NewServeMux()
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	m.Handle("/debug/vars", expvar.Handler())
+	return m
+}
+
+// registerDebug mounts the debug endpoints on the site for admins.
+func registerDebug() {
+	handle("/debug/", requireRole(RoleAdmin, debugMux()))
+}
+
+// checkLoopback rejects debug listen addresses reachable from outside
+// the host.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("debug-addr: %w", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("debug-addr must listen on a loopback address such as 127.0.0.1:6060, got %q", addr)
+	}
+	return nil
+}
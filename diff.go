@@ -0,0 +1,97 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// itemRef names an item in a catalog diff.
+type itemRef struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+// itemModified is an item in both catalogs, with the fields that differ.
+type itemModified struct {
+	itemRef
+	Changes []fieldChange `json:"changes"`
+}
+
+// catalogDiff is what replacing one catalog with another would change.
+type catalogDiff struct {
+	Added     []itemRef      `json:"added"`
+	Removed   []itemRef      `json:"removed"`
+	Modified  []itemModified `json:"modified"`
+	Unchanged int            `json:"unchanged"`
+}
+
+// diffCatalogs compares a catalog with a candidate replacement, matching
+// items by ID. Each list is in ID order.
+func diffCatalogs(old, new []Item) catalogDiff {
+	d := catalogDiff{Added: []itemRef{}, Removed: []itemRef{}, Modified: []itemModified{}}
+	before := make(map[int]Item, len(old))
+	for _, it := range old {
+		before[it.ID] = it
+	}
+	kept := make(map[int]bool, len(new))
+	for _, it := range new {
+		kept[it.ID] = true
+		prev, ok := before[it.ID]
+		if !ok {
+			d.Added = append(d.Added, itemRef{it.ID, it.KeywordTitle})
+			continue
+		}
+		if changes := itemChanges(prev, it); len(changes) > 0 {
+			d.Modified = append(d.Modified, itemModified{itemRef{it.ID, it.KeywordTitle}, changes})
+		} else {
+			d.Unchanged++
+		}
+	}
+	for _, it := range old {
+		if !kept[it.ID] {
+			d.Removed = append(d.Removed, itemRef{it.ID, it.KeywordTitle})
+		}
+	}
+	byID := func(a, b itemRef) int { return cmp.Compare(a.ID, b.ID) }
+	slices.SortFunc(d.Added, byID)
+	slices.SortFunc(d.Removed, byID)
+	slices.SortFunc(d.Modified, func(a, b itemModified) int { return byID(a.itemRef, b.itemRef) })
+	return d
+}
+
+// adminDiffHandler answers POST /admin/diff, whose body is a candidate
+// items.json, with what replacing the catalog being served by it would
+// change. Nothing is applied. A candidate that doesn't validate gets 422 and the
+// problems found, with the line each item starts on.
+func adminDiffHandler(w http.ResponseWriter, r *http.Request) {
+	candidate, lines, err := decodeItems("candidate", r.Body)
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("candidate exceeds %d bytes", tooBig.Limit))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkItems(candidate, lines); err != nil {
+		var invalid *invalidItemsError
+		if !errors.As(err, &invalid) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		problems := make([]string, len(invalid.Problems))
+		for i, p := range invalid.Problems {
+			problems[i] = p.String()
+		}
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":    fmt.Sprintf("the candidate has %s", pluralize(len(problems), "problem", "problems")),
+			"problems": problems,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, diffCatalogs(current().raw, candidate))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAdminDiff(t *testing.T) {
+	withAdmin(t)
+	useItems(t, testItem(1, "Alpha"), testItem(2, "Beta"), testItem(3, "Gamma"))
+
+	renamed := testItem(2, "Beta")
+	renamed.KeywordTitle = "Beta Two"
+	renamed.Tags = []string{"New"}
+	tests := []struct {
+		name      string
+		candidate []Item
+		added     []int
+		removed   []int
+		modified  map[int][]string
+		unchanged int
+	}{
+		{"same", []Item{testItem(1, "Alpha"), testItem(2, "Beta"), testItem(3, "Gamma")}, nil, nil, nil, 3},
+		{"add", []Item{testItem(1, "Alpha"), testItem(2, "Beta"), testItem(3, "Gamma"), testItem(4, "Delta")}, []int{4}, nil, nil, 3},
+		{"remove", []Item{testItem(1, "Alpha")}, nil, []int{2, 3}, nil, 1},
+		{"modify", []Item{testItem(1, "Alpha"), renamed, testItem(3, "Gamma")}, nil, nil, map[int][]string{2: {"keyword_title", "tags"}}, 2},
+		{"all three", []Item{testItem(5, "Epsilon"), renamed, testItem(3, "Gamma")}, []int{5}, []int{1}, map[int][]string{2: {"keyword_title", "tags"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.candidate)
+			w := do(adminRequest("POST", "/admin/diff", bytes.NewReader(body)))
+			if w.Code != 200 {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var d catalogDiff
+			if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+				t.Fatal(err)
+			}
+			if got := refIDs(d.Added); !slices.Equal(got, tt.added) {
+				t.Errorf("added = %v, want %v", got, tt.added)
+			}
+			if got := refIDs(d.Removed); !slices.Equal(got, tt.removed) {
+				t.Errorf("removed = %v, want %v", got, tt.removed)
+			}
+			if len(d.Modified) != len(tt.modified) {
+				t.Errorf("modified = %+v, want %v", d.Modified, tt.modified)
+			}
+			for _, m := range d.Modified {
+				var fields []string
+				for _, ch := range m.Changes {
+					fields = append(fields, ch.Field)
+				}
+				if want := tt.modified[m.ID]; strings.Join(fields, ",") != strings.Join(want, ",") {
+					t.Errorf("item %d changed %v, want %v", m.ID, fields, want)
+				}
+			}
+			if d.Unchanged != tt.unchanged {
+				t.Errorf("unchanged = %d, want %d", d.Unchanged, tt.unchanged)
+			}
+		})
+	}
+	if n := len(current().items); n != 3 {
+		t.Errorf("diff changed the catalog: %d items", n)
+	}
+
+	// A store edit not yet reloaded isn't what the site serves, so the
+	// diff is against the catalog in use.
+	if err := store.Put(renamed); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal([]Item{testItem(1, "Alpha"), testItem(2, "Beta"), testItem(3, "Gamma")})
+	w := do(adminRequest("POST", "/admin/diff", bytes.NewReader(body)))
+	var d catalogDiff
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Modified) != 0 || d.Unchanged != 3 {
+		t.Errorf("diff against an unreloaded store edit: %+v", d)
+	}
+}
+
+func TestAdminDiffInvalid(t *testing.T) {
+	withAdmin(t)
+	useItems(t, testItem(1, "Alpha"))
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"not json", "{", 400},
+		{"duplicate id", `[{"id":1,"keyword_title":"A","texts":["x"]},{"id":1,"keyword_title":"B","texts":["y"]}]`, 422},
+	}
+	for _, tt := range tests {
+		w := do(adminRequest("POST", "/admin/diff", strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.code, w.Body)
+		}
+	}
+	if w := do(adminRequest("POST", "/admin/diff", strings.NewReader("[]"))); w.Code != 200 {
+		t.Errorf("empty candidate: status %d", w.Code)
+	}
+}
+
+func refIDs(refs []itemRef) []int {
+	var ids []int
+	for _, r := range refs {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
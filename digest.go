@@ -0,0 +1,349 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// digestWindow is how far back the first digest reaches; later ones
+// cover the items published since the previous send.
+const digestWindow = week
+
+// digestShown caps the sends the admin page lists.
+const digestShown = 100
+
+// DigestSend is one entry of the digest send log: when it went out, the
+// items it covered and how many confirmed subscribers it reached.
+type DigestSend struct {
+	ID     int64     `json:"id"`
+	At     time.Time `json:"at"`
+	Since  time.Time `json:"since"`
+	Items  []int     `json:"items"`
+	Sent   int       `json:"sent"`
+	Failed int       `json:"failed"`
+	Error  string    `json:"error,omitempty"` // the first failure
+}
+
+// digestStore is implemented by repositories that keep the digest send
+// log. AddDigest assigns the send's ID; Digests lists every send, oldest
+// first.
+type digestStore interface {
+	AddDigest(d *DigestSend) error
+	Digests() ([]DigestSend, error)
+}
+
+// digestEntry is an item as the digest email shows it.
+type digestEntry struct {
+	Title   string
+	URL     string
+	Excerpt string
+	Image   string
+}
+
+// digestItems returns the live items published after since, newest
+// first. Items without a recorded creation or publish time are left out:
+// there's no telling whether they're new.
+func digestItems(c *catalog, since, now time.Time) []Item {
+	var out []Item
+	for _, it := range c.live(now) {
+		at, ok := digestedAt(&it)
+		if ok && at.After(since) && !at.After(now) {
+			out = append(out, it)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b Item) int {
+		at, _ := digestedAt(&a)
+		bt, _ := digestedAt(&b)
+		return bt.Compare(at)
+	})
+	return out
+}
+
+// digestedAt is when the item went public: its PublishAt if it was
+// scheduled, else its CreatedAt.
+func digestedAt(it *Item) (time.Time, bool) {
+	switch {
+	case it.PublishAt != nil:
+		return *it.PublishAt, true
+	case it.CreatedAt != nil:
+		return *it.CreatedAt, true
+	}
+	return time.Time{}, false
+}
+
+// digestSince is where the next digest starts: the last send, or
+// digestWindow before now when there was none.
+func digestSince(ds digestStore, now time.Time) (time.Time, error) {
+	sends, err := ds.Digests()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(sends) == 0 {
+		return now.Add(-digestWindow), nil
+	}
+	return sends[len(sends)-1].At, nil
+}
+
+// digestData is what digest_email.html renders for items, addressed to
+// the subscriber whose unsubscribe link is unsub.
+func digestData(items []Item, since time.Time, unsub string) map[string]interface{} {
+	entries := make([]digestEntry, len(items))
+	for i, it := range items {
+		e := digestEntry{Title: it.KeywordTitle, URL: baseURL + "/items/" + it.Slug}
+		if len(it.Texts) > 0 {
+			e.Excerpt = excerpt(200, it.Texts[0])
+		}
+		if len(it.VideoPath) > 0 {
+			if img := thumbURL(it.VideoPath[0], "medium"); img != "" {
+				e.Image = baseURL + img
+			}
+		}
+		entries[i] = e
+	}
+	return map[string]interface{}{
+		"Title":       "New on BlendingWaves",
+		"Since":       since,
+		"Items":       entries,
+		"Unsubscribe": unsub,
+	}
+}
+
+// digestMessage renders the digest of items for email.
+func digestMessage(items []Item, since time.Time, email, unsub string) (Message, error) {
+	ts, err := templates()
+	if err != nil {
+		return Message{}, err
+	}
+	var html strings.Builder
+	if err := ts["digest_email.html"].Execute(&html, digestData(items, since, unsub)); err != nil {
+		return Message{}, err
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "New on BlendingWaves since %s:\n\n", since.Format("January 2"))
+	for _, it := range items {
+		fmt.Fprintf(&text, "%s\n%s/items/%s\n\n", it.KeywordTitle, baseURL, it.Slug)
+	}
+	fmt.Fprintf(&text, "You get this weekly digest because you subscribed to the BlendingWaves newsletter.\nUnsubscribe: %s\n", unsub)
+	return Message{
+		To:          []string{email},
+		Subject:     fmt.Sprintf("New on BlendingWaves: %s", pluralize(len(items), "new project", "new projects")),
+		Body:        text.String(),
+		HTML:        html.String(),
+		Unsubscribe: unsub,
+	}, nil
+}
+
+// sendDigest is the digest job: it mails every confirmed subscriber the
+// items published since the last send and records the send. Nothing is
+// sent, or logged, when there's nothing new or no one to send it to.
+// Deliveries that fail are queued for the mail worker's retries.
+func sendDigest(ctx context.Context) (string, error) {
+	if baseURL == "" {
+		return "skipped: -base-url is not set", nil
+	}
+	ds, ok := store.(digestStore)
+	if !ok {
+		return "", errors.New("store does not support the digest send log")
+	}
+	ss, err := subscribers()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	since, err := digestSince(ds, now)
+	if err != nil {
+		return "", err
+	}
+	items := digestItems(current(), since, now)
+	if len(items) == 0 {
+		return "no new items since " + since.Format("2006-01-02 15:04"), nil
+	}
+	all, err := ss.Subscribers()
+	if err != nil {
+		return "", err
+	}
+	all = slices.DeleteFunc(all, func(s Subscriber) bool { return s.Status != SubscriberConfirmed })
+	if len(all) == 0 {
+		return "no confirmed subscribers", nil
+	}
+	send := DigestSend{At: now.UTC(), Since: since.UTC()}
+	for _, it := range items {
+		send.Items = append(send.Items, it.ID)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, sub := range all {
+		if err := ctx.Err(); err != nil {
+			send.Error = cmp.Or(send.Error, err.Error())
+			break
+		}
+		msg, err := digestMessage(items, since, sub.Email, unsubscribeURL(r, sub.Email))
+		if err != nil {
+			return "", err
+		}
+		if err := mailer.Send(msg); err != nil {
+			send.Failed++
+			send.Error = cmp.Or(send.Error, fmt.Sprintf("%s: %v", sub.Email, err))
+			sendMail(msg)
+			continue
+		}
+		send.Sent++
+	}
+	if err := ds.AddDigest(&send); err != nil {
+		return "", fmt.Errorf("send log: %w", err)
+	}
+	log.Printf("digest: sent %d items to %d subscribers, %d failed", len(items), send.Sent, send.Failed)
+	result := fmt.Sprintf("sent %s to %s", pluralize(len(items), "item", "items"), pluralize(send.Sent, "subscriber", "subscribers"))
+	if send.Failed > 0 {
+		return result, fmt.Errorf("%d deliveries failed and were queued for retry; first: %s", send.Failed, send.Error)
+	}
+	return result, nil
+}
+
+// adminDigestsHandler lists the digest send log, newest first, with the
+// items the next digest would cover.
+func adminDigestsHandler(w http.ResponseWriter, r *http.Request) {
+	ds, ok := store.(digestStore)
+	if !ok {
+		serverError(w, errors.New("store does not support the digest send log"))
+		return
+	}
+	sends, err := ds.Digests()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	now := time.Now()
+	since, err := digestSince(ds, now)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	slices.Reverse(sends)
+	c := current()
+	titles := map[int]string{}
+	for _, it := range c.items {
+		titles[it.ID] = it.KeywordTitle
+	}
+	data := map[string]interface{}{
+		"Title":   "Digest | Admin",
+		"Sends":   sends[:min(len(sends), digestShown)],
+		"Total":   len(sends),
+		"Since":   since,
+		"Pending": digestItems(c, since, now),
+		"Titles":  titles,
+		"Job":     jobNamed("digest").status(),
+		"BaseURL": baseURL,
+		"CSRF":    csrfToken(w, r),
+	}
+	if err := render(w, "admin_digests.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// adminDigestPreviewHandler renders the next digest as subscribers would
+// get it, with a dummy unsubscribe link.
+func adminDigestPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	ds, ok := store.(digestStore)
+	if !ok {
+		serverError(w, errors.New("store does not support the digest send log"))
+		return
+	}
+	now := time.Now()
+	since, err := digestSince(ds, now)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	data := digestData(digestItems(current(), since, now), since, "#unsubscribe")
+	if err := render(w, "digest_email.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// digestsPath is where the JSON store keeps the digest send log.
+func (s *jsonStore) digestsPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".digests.json"
+}
+
+func (s *jsonStore) readDigests() ([]DigestSend, error) {
+	data, err := os.ReadFile(s.digestsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []DigestSend
+	return out, json.Unmarshal(data, &out)
+}
+
+func (s *jsonStore) Digests() ([]DigestSend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readDigests()
+}
+
+func (s *jsonStore) AddDigest(d *DigestSend) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readDigests()
+	if err != nil {
+		return err
+	}
+	d.ID = 1
+	if len(all) > 0 {
+		d.ID = all[len(all)-1].ID + 1
+	}
+	data, err := json.MarshalIndent(append(all, *d), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.digestsPath(), data)
+}
+
+func (s *sqliteStore) Digests() ([]DigestSend, error) {
+	rows, err := s.db.Query(`SELECT id, data FROM digests ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DigestSend
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var d DigestSend
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			return nil, err
+		}
+		d.ID = id
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) AddDigest(d *DigestSend) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`INSERT INTO digests (sent_at, data) VALUES (?, ?)`, d.At, string(data))
+	if err != nil {
+		return err
+	}
+	d.ID, err = res.LastInsertId()
+	return err
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downloadTTL is how long a signed download link stays valid; 0 turns
+// downloads off. downloadBindIP ties each link to the address it was
+// issued to.
+var (
+	downloadTTL    time.Duration
+	downloadBindIP bool
+)
+
+// DownloadURL is the URL that issues download links for the item's i-th
+// video.
+func (it Item) DownloadURL(i int) string {
+	return "/download/" + strconv.Itoa(it.ID) + "/" + strconv.Itoa(i)
+}
+
+// downloadToken signs the item's index-th video for ip, empty when links
+// aren't bound to an address, until exp.
+func downloadToken(id, index int, ip string, exp time.Time) string {
+	return signToken("download", strconv.Itoa(id)+"/"+strconv.Itoa(index)+"/"+ip, exp)
+}
+
+// downloadIssueHandler answers POST /download/{id}/{index}, sent by the
+// item page's form with the visitor's CSRF token, with a redirect to a
+// freshly signed link. Issuing on POST keeps the unsigned path from being
+// shared as a permanent link.
+func downloadIssueHandler(w http.ResponseWriter, r *http.Request) {
+	it, i, ok := downloadTarget(r)
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	var ip string
+	if downloadBindIP {
+		ip = clientIP(r)
+	}
+	token := downloadToken(it.ID, i, ip, time.Now().Add(downloadTTL))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, it.DownloadURL(i)+"?token="+url.QueryEscape(token), http.StatusSeeOther)
+}
+
+// downloadHandler serves GET /download/{id}/{index}?token= as an
+// attachment once the token checks out, and counts the download.
+// Videos in the object store redirect to a signed URL of their own.
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	it, i, ok := downloadTarget(r)
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	value, err := verifyToken("download", r.URL.Query().Get("token"), time.Now())
+	if err == nil {
+		want := strconv.Itoa(it.ID) + "/" + strconv.Itoa(i) + "/"
+		if value != want && value != want+clientIP(r) {
+			err = errBadToken
+		}
+	}
+	if err != nil {
+		msg := "This download link is invalid."
+		if errors.Is(err, errExpiredToken) {
+			msg = "This download link has expired."
+		}
+		http.Error(w, msg+" Open the item page for a new one.", http.StatusForbidden)
+		return
+	}
+	// Count whole downloads, not the ranged requests resuming one.
+	if rg := r.Header.Get("Range"); rg == "" || rg == "bytes=0-" {
+		countDownload(r, it.ID)
+	}
+	vp := it.VideoPath[i]
+	if isRemoteMedia(vp) {
+		u, err := media.URL(vp)
+		if err != nil {
+			serverError(w, fmt.Errorf("download %s: %w", vp, err))
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	name, ok := staticFile(vp)
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	f, err := staticFS.Open(name)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	rs, seekable := f.(io.ReadSeeker)
+	if err != nil || !fi.Mode().IsRegular() || !seekable {
+		notFound(w, r)
+		return
+	}
+	if ct := videoType(name); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	filename := slugify(it.KeywordTitle)
+	if len(it.VideoPath) > 1 {
+		filename += "-" + strconv.Itoa(i+1)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filename + path.Ext(name),
+	}))
+	w.Header().Set("Cache-Control", "private, no-store")
+	if videoRate > 0 {
+		w = &throttledWriter{ResponseWriter: w, ctx: r.Context(), rate: videoRate, start: time.Now()}
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), rs)
+}
+
+// downloadTarget resolves the item and video index of a download path.
+func downloadTarget(r *http.Request) (*Item, int, bool) {
+	it, ok := current().lookup(r.PathValue("id"), time.Now())
+	i, err := strconv.Atoi(r.PathValue("index"))
+	if !ok || err != nil || i < 0 || i >= len(it.VideoPath) {
+		return nil, 0, false
+	}
+	return it, i, true
+}
+
+// countDownload records a download unless it came from a bot.
+func countDownload(r *http.Request, id int) {
+	if r.Method == http.MethodHead || isBot(r) {
+		return
+	}
+	views.Lock()
+	v := views.pending[id]
+	v.Download++
+	views.pending[id] = v
+	views.Unlock()
+}
+
+// While downloads are on, the videos' other URLs mustn't serve as
+// permanent download links: /video/ and /hls/ only answer players
+// holding a media pass, a signed cookie every page hands out that lasts
+// as long as a download link, and the catalog's files aren't served from
+// /static/ at all. Feeds, the sitemap and page metadata, read by clients
+// without the cookie, leave the video URLs out.
+
+// mediaPassCookie holds the visitor's media pass.
+const mediaPassCookie = "media_pass"
+
+// protectedMedia reports whether videos need a media pass.
+func protectedMedia() bool {
+	return downloadTTL > 0
+}
+
+// mediaPasses gives visitors loading a page a media pass for its players,
+// renewing one past half its life. It runs outside the page cache, so the
+// cookie isn't cached with the page.
+func mediaPasses(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if protectedMedia() && r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			if issued, ok := mediaPass(r); !ok || time.Since(issued) > downloadTTL/2 {
+				issueMediaPass(w, r)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// mediaPassFor is what a pass signs: the address it is bound to, when
+// links are, and when it was issued.
+func mediaPassFor(r *http.Request, issued time.Time) string {
+	var ip string
+	if downloadBindIP {
+		ip = clientIP(r)
+	}
+	return strconv.FormatInt(issued.Unix(), 10) + "/" + ip
+}
+
+func issueMediaPass(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	http.SetCookie(w, &http.Cookie{
+		Name:     mediaPassCookie,
+		Value:    signToken("media", mediaPassFor(r, now), now.Add(downloadTTL)),
+		Path:     "/",
+		MaxAge:   int(downloadTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// mediaPass returns when the request's media pass was issued, if it
+// carries a valid one.
+func mediaPass(r *http.Request) (time.Time, bool) {
+	c, err := r.Cookie(mediaPassCookie)
+	if err != nil {
+		return time.Time{}, false
+	}
+	value, err := verifyToken("media", c.Value, time.Now())
+	if err != nil {
+		return time.Time{}, false
+	}
+	stamp, _, _ := strings.Cut(value, "/")
+	unix, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	issued := time.Unix(unix, 0)
+	if value != mediaPassFor(r, issued) {
+		return time.Time{}, false
+	}
+	return issued, true
+}
+
+// needMediaPass answers a video request lacking a pass with a 403, and
+// reports whether it did.
+func needMediaPass(w http.ResponseWriter, r *http.Request) bool {
+	if !protectedMedia() {
+		return false
+	}
+	if _, ok := mediaPass(r); ok {
+		return false
+	}
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	http.Error(w, "Open the item page to play this video.", http.StatusForbidden)
+	return true
+}
+
+// publicVideoURL is the absolute URL of the item's i-th video for feeds,
+// the sitemap and page metadata, or "" while videos need a pass.
+func publicVideoURL(r *http.Request, it *Item, i int) string {
+	if protectedMedia() {
+		return ""
+	}
+	return siteURL(r, it.VideoURL(i))
+}
+
+// protectedVideoFile reports whether name, a path under static/, is a
+// catalog video that mustn't be served directly.
+func protectedVideoFile(name string) bool {
+	return protectedMedia() && current().videoFiles[name]
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestProtectedVideos checks that while signed downloads are on, a
+// video can't be fetched for keeps through the player's URLs or the
+// static file.
+func TestProtectedVideos(t *testing.T) {
+	prevTTL, prevBind := downloadTTL, downloadBindIP
+	t.Cleanup(func() { downloadTTL, downloadBindIP = prevTTL, prevBind })
+	downloadTTL, downloadBindIP = time.Hour, false
+	it := testItem(1, "Alpha")
+	it.VideoPath = []string{"/static/video/agents.mp4"}
+	useItems(t, it)
+
+	if w := get("/video/1/0"); w.Code != http.StatusForbidden {
+		t.Errorf("/video/1/0 without a pass = %d, want 403", w.Code)
+	}
+	page := get("/items/alpha", "Accept", "text/html")
+	var pass *http.Cookie
+	for _, c := range page.Result().Cookies() {
+		if c.Name == mediaPassCookie {
+			pass = c
+		}
+	}
+	if pass == nil {
+		t.Fatal("the item page gave no media pass")
+	}
+	if w := get("/video/1/0", "Cookie", pass.String()); w.Code != 200 {
+		t.Errorf("/video/1/0 with the pass = %d, want 200", w.Code)
+	}
+	if w := get("/video/1/0", "Cookie", mediaPassCookie+"="+pass.Value+"x"); w.Code != http.StatusForbidden {
+		t.Errorf("/video/1/0 with a forged pass = %d, want 403", w.Code)
+	}
+	for _, c := range get("/items/alpha", "Accept", "text/html", "Cookie", pass.String()).Result().Cookies() {
+		if c.Name == mediaPassCookie {
+			t.Errorf("a fresh pass was renewed")
+		}
+	}
+
+	for _, path := range []string{"/static/video/agents.mp4", "/static/video/../video/agents.mp4", assetURL("/static/video/agents.mp4")} {
+		if w := get(path, "Cookie", pass.String()); w.Code != 404 && w.Code/100 != 3 {
+			t.Errorf("GET %s = %d, want 404", path, w.Code)
+		}
+	}
+	if w := get(assetURL("/static/video/chalk.mp4")); w.Code != 200 {
+		t.Errorf("the header video, in no item, = %d, want 200", w.Code)
+	}
+
+	// Only the player links the video, not what other clients read.
+	for _, path := range []string{"/feed.xml", "/feed.atom", "/sitemap.xml"} {
+		if strings.Contains(get(path).Body.String(), "/video/1/0") {
+			t.Errorf("%s links the video", path)
+		}
+	}
+	if body := get("/items/alpha").Body.String(); strings.Contains(body, "og:video") || strings.Contains(body, "contentUrl") {
+		t.Errorf("the item page's metadata links the video")
+	}
+	if body := get("/sitemap.xml").Body.String(); !strings.Contains(body, "<video:player_loc>") {
+		t.Errorf("the sitemap has no player_loc for the video")
+	}
+
+	// A pass bound to an address is no good from another.
+	downloadBindIP = true
+	r := httptest.NewRequest("GET", "/items/alpha", nil)
+	r.Header.Set("Accept", "text/html")
+	r.RemoteAddr = "198.51.100.7:4000"
+	bound := do(r).Result().Cookies()
+	if len(bound) == 0 {
+		t.Fatal("no bound pass")
+	}
+	for _, tt := range []struct {
+		addr string
+		code int
+	}{{"198.51.100.7:4001", 200}, {"203.0.113.9:4000", 403}} {
+		r := httptest.NewRequest("GET", "/video/1/0", nil)
+		r.RemoteAddr = tt.addr
+		r.AddCookie(bound[0])
+		if w := do(r); w.Code != tt.code {
+			t.Errorf("bound pass from %s = %d, want %d", tt.addr, w.Code, tt.code)
+		}
+	}
+
+	downloadTTL = 0
+	useItems(t, it) // a fresh catalog, so no page is cached from before
+	if w := get("/video/1/0"); w.Code != 200 {
+		t.Errorf("with downloads off /video/1/0 = %d, want 200", w.Code)
+	}
+	if w := get("/static/video/agents.mp4"); w.Code != 200 {
+		t.Errorf("with downloads off the static file = %d, want 200", w.Code)
+	}
+	if body := get("/items/alpha").Body.String(); !strings.Contains(body, "og:video") {
+		t.Errorf("with downloads off the item page has no og:video")
+	}
+}
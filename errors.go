@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notFoundHandler answers every path no other route claims.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	notFound(w, r)
+}
+
+// notFound sends a 404: JSON for API clients, the styled page otherwise.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") || wantsJSON(r) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	data := map[string]interface{}{
+		"Title": "Not found | BlendingWaves",
+		"Path":  r.URL.Path,
+	}
+	if err := renderStatus(w, http.StatusNotFound, "404.html", data); err != nil {
+		http.NotFound(w, r)
+	}
+}
+
+// newErrorID returns a short random ID tying a 500 page to its log line.
+func newErrorID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// serverError logs and reports err under a fresh ID and sends the 500
+// page showing it.
+func serverError(w http.ResponseWriter, err error) {
+	id, rid := newErrorID(), w.Header().Get(requestIDHeader)
+	log.Printf("error %s (request %s): %v", id, orDash(rid), err)
+	spanFrom(writerContext(w)).fail(err)
+	report(ErrorEvent{ID: id, RequestID: rid, Message: err.Error(), Time: time.Now()})
+	errorPage(w, id)
+}
+
+// errorPage sends the 500 page for the error logged under id.
+func errorPage(w http.ResponseWriter, id string) {
+	data := map[string]interface{}{
+		"Title":     "Error | BlendingWaves",
+		"ErrorID":   id,
+		"RequestID": w.Header().Get(requestIDHeader),
+	}
+	if err := renderStatus(w, http.StatusInternalServerError, "500.html", data); err != nil {
+		http.Error(w, "Internal server error (error ID "+id+")", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SSE tuning: how often idle streams get a comment line so proxies
+// don't close them, how long browsers wait before reconnecting, and the
+// most streams served at once.
+const (
+	sseHeartbeat      = 25 * time.Second
+	sseRetry          = 5 * time.Second
+	sseMaxSubscribers = 1000
+)
+
+// catalogUpdate is one "items" event: the reload's version, which is
+// also the event ID, and the number of live items.
+type catalogUpdate struct {
+	Version int64 `json:"version"`
+	Items   int   `json:"items"`
+}
+
+// eventHub fans catalog updates out to the /events subscribers. Each
+// subscriber has a one-slot channel; an update arriving while the
+// previous one is unsent replaces it, since only the latest matters.
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan catalogUpdate]struct{}
+	closed bool
+}
+
+var hub = &eventHub{subs: map[chan catalogUpdate]struct{}{}}
+
+var errHubFull = errors.New("too many event subscribers")
+
+// subscribe registers a subscriber; the channel is closed when the hub
+// shuts down.
+func (h *eventHub) subscribe() (chan catalogUpdate, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed || len(h.subs) >= sseMaxSubscribers {
+		return nil, errHubFull
+	}
+	ch := make(chan catalogUpdate, 1)
+	h.subs[ch] = struct{}{}
+	return ch, nil
+}
+
+func (h *eventHub) unsubscribe(ch chan catalogUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// broadcast delivers u to every subscriber without blocking.
+func (h *eventHub) broadcast(u catalogUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case <-ch: // drop the stale update
+		default:
+		}
+		ch <- u
+	}
+}
+
+// close ends every stream so graceful shutdown isn't held up by them.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// update describes c as an event.
+func (c *catalog) update() catalogUpdate {
+	return catalogUpdate{Version: c.loadedAt.UnixMilli(), Items: len(c.live(time.Now()))}
+}
+
+// eventsHandler streams an "items" event after every catalog reload. A
+// reconnecting browser sends Last-Event-ID and is caught up right away
+// when it missed a reload.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	ch, err := hub.subscribe()
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(sseRetry/time.Second)))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer hub.unsubscribe(ch)
+
+	rc := http.NewResponseController(w)
+	// Streams outlive -write-timeout by design.
+	rc.SetWriteDeadline(time.Time{})
+	hdr := w.Header()
+	hdr.Set("Content-Type", "text/event-stream")
+	hdr.Set("Cache-Control", "no-cache")
+	hdr.Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+
+	send := func(u catalogUpdate) error {
+		_, err := fmt.Fprintf(w, "id: %d\nevent: items\ndata: {\"version\":%d,\"items\":%d}\n\n", u.Version, u.Version, u.Items)
+		if err == nil {
+			err = rc.Flush()
+		}
+		return err
+	}
+	now := current().update()
+	if last, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil && last < now.Version {
+		if send(now) != nil {
+			return
+		}
+	} else if rc.Flush() != nil {
+		return
+	}
+
+	tick := time.NewTicker(sseHeartbeat)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case u, ok := <-ch:
+			if !ok || send(u) != nil {
+				return
+			}
+		case <-tick.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
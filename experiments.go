@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Experiment splits the visitors of one page between variants, each
+// rendering its own template or ordering the items its own way, and
+// counts which variant leads more of them to its goals. A visitor stays
+// in the variant their visitor cookie hashes to.
+//
+//	[{"name": "home-layout", "page": "home.html", "goals": ["item-click", "like"],
+//	  "variants": [{"name": "control"}, {"name": "grid", "template": "home_grid.html", "sort": "popular"}]}]
+type Experiment struct {
+	Name     string    `json:"name"`
+	Page     string    `json:"page"`
+	Goals    []string  `json:"goals"`
+	Variants []Variant `json:"variants"`
+}
+
+// Variant is one arm of an experiment. An empty Template renders the
+// page as usual; Sort is the item order used when the visitor picked
+// none. Weight is its share of visitors, 1 if unset.
+type Variant struct {
+	Name     string `json:"name"`
+	Weight   int    `json:"weight,omitempty"`
+	Template string `json:"template,omitempty"`
+	Sort     string `json:"sort,omitempty"`
+}
+
+// Goals the server records itself; pages can report others, such as
+// item-click, through /api/experiments/goal.
+const (
+	GoalLike      = "like"
+	GoalComment   = "comment"
+	GoalSubscribe = "subscribe"
+)
+
+// goalView is the event counting a visitor into a variant.
+const goalView = "view"
+
+// experimentCookie lists the experiments a visitor has been counted in
+// and the goals they have reached in each, so every visitor counts once
+// per variant and goal. It is not signed: tampering with it only skews
+// one's own visits.
+const experimentCookie = "bw_exp"
+
+// experiments are the running experiments, from -experiments.
+var experiments []Experiment
+
+// ExperimentKey names one counter: the visitors of a variant, or those
+// of them who reached a goal.
+type ExperimentKey struct {
+	Experiment string `json:"experiment"`
+	Variant    string `json:"variant"`
+	Event      string `json:"event"`
+}
+
+// experimentStore is implemented by repositories that persist experiment
+// counts. AddExperimentCounts adds the deltas to the stored totals.
+type experimentStore interface {
+	LoadExperimentCounts() (map[ExperimentKey]int64, error)
+	AddExperimentCounts(deltas map[ExperimentKey]int64) error
+}
+
+// experimentCounts buffers counts like views does.
+var experimentCounts = struct {
+	sync.Mutex
+	totals  map[ExperimentKey]int64
+	pending map[ExperimentKey]int64
+}{totals: map[ExperimentKey]int64{}, pending: map[ExperimentKey]int64{}}
+
+// setExperiments reads the -experiments file. Variant templates join
+// templateFiles, so they are parsed and checked with the rest.
+func setExperiments(path string) error {
+	experiments = nil
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("experiments: %w", err)
+	}
+	var list []Experiment
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("experiments %s: %w", path, err)
+	}
+	pages := map[string]string{}
+	for i := range list {
+		e := &list[i]
+		if !flagName.MatchString(e.Name) {
+			return fmt.Errorf("experiments %s: name %q must be lowercase letters, digits and dashes", path, e.Name)
+		}
+		if !slices.Contains(templateFiles, e.Page) {
+			return fmt.Errorf("experiment %s: unknown page %q", e.Name, e.Page)
+		}
+		if other, dup := pages[e.Page]; dup {
+			return fmt.Errorf("experiment %s: %s already runs experiment %s", e.Name, e.Page, other)
+		}
+		pages[e.Page] = e.Name
+		if len(e.Variants) < 2 {
+			return fmt.Errorf("experiment %s: needs at least two variants", e.Name)
+		}
+		seen := map[string]bool{}
+		for j := range e.Variants {
+			v := &e.Variants[j]
+			switch {
+			case !flagName.MatchString(v.Name) || seen[v.Name]:
+				return fmt.Errorf("experiment %s: variant %q must be a unique lowercase name", e.Name, v.Name)
+			case v.Weight < 0:
+				return fmt.Errorf("experiment %s: variant %s: weight must not be negative", e.Name, v.Name)
+			case !validSort(v.Sort):
+				return fmt.Errorf("experiment %s: variant %s: unknown sort %q", e.Name, v.Name, v.Sort)
+			case v.Template != "" && (!strings.HasSuffix(v.Template, ".html") || strings.HasPrefix(v.Template, "admin_")):
+				return fmt.Errorf("experiment %s: variant %s: template must be a page .html file", e.Name, v.Name)
+			}
+			seen[v.Name] = true
+			if v.Weight == 0 {
+				v.Weight = 1
+			}
+			if v.Template != "" && !slices.Contains(templateFiles, v.Template) {
+				templateFiles = append(templateFiles, v.Template)
+			}
+		}
+		for _, g := range e.Goals {
+			if !flagName.MatchString(g) || g == goalView {
+				return fmt.Errorf("experiment %s: goal %q must be a lowercase name other than %q", e.Name, g, goalView)
+			}
+		}
+	}
+	experiments = list
+	return nil
+}
+
+// startExperiments loads the stored counts; the analytics job flushes
+// pending ones.
+func startExperiments() {
+	es, ok := store.(experimentStore)
+	if !ok || len(experiments) == 0 {
+		return
+	}
+	totals, err := es.LoadExperimentCounts()
+	if err != nil {
+		log.Printf("experiments: load: %v", err)
+	} else {
+		experimentCounts.Lock()
+		experimentCounts.totals = totals
+		experimentCounts.Unlock()
+	}
+}
+
+// flushExperiments writes pending counts to the store, keeping them for
+// the next flush on failure.
+func flushExperiments() {
+	es, ok := store.(experimentStore)
+	if !ok {
+		return
+	}
+	experimentCounts.Lock()
+	pending := experimentCounts.pending
+	experimentCounts.pending = map[ExperimentKey]int64{}
+	experimentCounts.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	err := es.AddExperimentCounts(pending)
+	experimentCounts.Lock()
+	defer experimentCounts.Unlock()
+	for k, n := range pending {
+		if err != nil {
+			experimentCounts.pending[k] += n
+		} else {
+			experimentCounts.totals[k] += n
+		}
+	}
+	if err != nil {
+		log.Printf("experiments: flush: %v", err)
+	}
+}
+
+func countExperiment(k ExperimentKey) {
+	experimentCounts.Lock()
+	experimentCounts.pending[k]++
+	experimentCounts.Unlock()
+}
+
+func experimentCount(k ExperimentKey) int64 {
+	experimentCounts.Lock()
+	defer experimentCounts.Unlock()
+	return experimentCounts.totals[k] + experimentCounts.pending[k]
+}
+
+// variant returns the variant visitor is in.
+func (e Experiment) variant(visitor string) Variant {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	n := cohort(e.Name, visitor, total)
+	for _, v := range e.Variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return e.Variants[0]
+}
+
+// experimentFor puts the visitor in a variant of the experiment running
+// on page, counting them into it on their first view. It returns the
+// experiment and variant, or false when there is none for this request:
+// bots, static exports and HEAD requests see the page as usual.
+func experimentFor(w http.ResponseWriter, r *http.Request, page string) (Experiment, Variant, bool) {
+	i := slices.IndexFunc(experiments, func(e Experiment) bool { return e.Page == page })
+	if i < 0 || exporting || isBot(r) || r.Method == http.MethodHead {
+		return Experiment{}, Variant{}, false
+	}
+	e := experiments[i]
+	visitor, _ := visitorID(w, r, true)
+	v := e.variant(visitor)
+	w.Header().Add("Vary", "Cookie")
+	marks := experimentMarks(r)
+	if !marks[e.Name] {
+		countExperiment(ExperimentKey{e.Name, v.Name, goalView})
+		setExperimentMarks(w, r, append(slices.Collect(maps.Keys(marks)), e.Name))
+	}
+	return e, v, true
+}
+
+// convert records that the visitor reached goal, once for each
+// experiment they were counted in that has it as a goal.
+func convert(w http.ResponseWriter, r *http.Request, goal string) {
+	if len(experiments) == 0 || isBot(r) {
+		return
+	}
+	visitor, ok := visitorID(w, r, false)
+	if !ok {
+		return
+	}
+	marks := experimentMarks(r)
+	changed := false
+	for _, e := range experiments {
+		mark := e.Name + ":" + goal
+		if !marks[e.Name] || marks[mark] || !slices.Contains(e.Goals, goal) {
+			continue
+		}
+		countExperiment(ExperimentKey{e.Name, e.variant(visitor).Name, goal})
+		marks[mark], changed = true, true
+	}
+	if changed {
+		setExperimentMarks(w, r, slices.Collect(maps.Keys(marks)))
+	}
+}
+
+func experimentMarks(r *http.Request) map[string]bool {
+	marks := map[string]bool{}
+	if c, err := r.Cookie(experimentCookie); err == nil {
+		for _, m := range strings.Split(c.Value, ".") {
+			if m != "" {
+				marks[m] = true
+			}
+		}
+	}
+	return marks
+}
+
+func setExperimentMarks(w http.ResponseWriter, r *http.Request, marks []string) {
+	slices.Sort(marks)
+	http.SetCookie(w, &http.Cookie{
+		Name:     experimentCookie,
+		Value:    strings.Join(marks, "."),
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// experimentGoalHandler records a goal reached in the browser, such as
+// a click, posted as {"goal": "item-click"}. Only goals some experiment
+// has are accepted.
+func experimentGoalHandler(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Goal string `json:"goal"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&in); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if !slices.ContainsFunc(experiments, func(e Experiment) bool { return slices.Contains(e.Goals, in.Goal) }) {
+		writeJSONError(w, http.StatusNotFound, "no experiment has goal "+in.Goal)
+		return
+	}
+	convert(w, r, in.Goal)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VariantResult is one variant's numbers in /admin/api/experiments:
+// the visitors counted into it and, per goal, how many of them reached
+// it and what share that is.
+type VariantResult struct {
+	Name        string             `json:"name"`
+	Weight      int                `json:"weight"`
+	Visitors    int64              `json:"visitors"`
+	Conversions map[string]int64   `json:"conversions"`
+	Rates       map[string]float64 `json:"rates"`
+}
+
+// adminExperimentsHandler reports every experiment's results.
+func adminExperimentsHandler(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Experiment
+		Results []VariantResult `json:"results"`
+	}
+	out := []result{}
+	for _, e := range experiments {
+		res := result{Experiment: e}
+		for _, v := range e.Variants {
+			vr := VariantResult{
+				Name:        v.Name,
+				Weight:      v.Weight,
+				Visitors:    experimentCount(ExperimentKey{e.Name, v.Name, goalView}),
+				Conversions: map[string]int64{},
+				Rates:       map[string]float64{},
+			}
+			for _, g := range e.Goals {
+				n := experimentCount(ExperimentKey{e.Name, v.Name, g})
+				vr.Conversions[g] = n
+				if vr.Visitors > 0 {
+					vr.Rates[g] = float64(n) / float64(vr.Visitors)
+				}
+			}
+			res.Results = append(res.Results, vr)
+		}
+		out = append(out, res)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// experimentsPath is where the JSON store keeps counts, beside items.json.
+func (s *jsonStore) experimentsPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".experiments.json"
+}
+
+// experimentRow is how the JSON store writes one counter.
+type experimentRow struct {
+	ExperimentKey
+	Count int64 `json:"count"`
+}
+
+func (s *jsonStore) readExperimentCounts() (map[ExperimentKey]int64, error) {
+	out := map[ExperimentKey]int64{}
+	var rows []experimentRow
+	if ok, err := readRecoverable(s.experimentsPath(), &rows); !ok {
+		return out, err
+	}
+	for _, row := range rows {
+		out[row.ExperimentKey] = row.Count
+	}
+	return out, nil
+}
+
+func (s *jsonStore) LoadExperimentCounts() (map[ExperimentKey]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readExperimentCounts()
+}
+
+func (s *jsonStore) AddExperimentCounts(deltas map[ExperimentKey]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts, err := s.readExperimentCounts()
+	if err != nil {
+		return err
+	}
+	for k, n := range deltas {
+		counts[k] += n
+	}
+	rows := make([]experimentRow, 0, len(counts))
+	for k, n := range counts {
+		rows = append(rows, experimentRow{k, n})
+	}
+	slices.SortFunc(rows, func(a, b experimentRow) int {
+		return strings.Compare(a.Experiment+"\x00"+a.Variant+"\x00"+a.Event, b.Experiment+"\x00"+b.Variant+"\x00"+b.Event)
+	})
+	data, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.experimentsPath(), data)
+}
+
+func (s *sqliteStore) LoadExperimentCounts() (map[ExperimentKey]int64, error) {
+	rows, err := s.db.Query(`SELECT experiment, variant, event, count FROM experiment_counts`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[ExperimentKey]int64{}
+	for rows.Next() {
+		var k ExperimentKey
+		var n int64
+		if err := rows.Scan(&k.Experiment, &k.Variant, &k.Event, &n); err != nil {
+			return nil, err
+		}
+		out[k] = n
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) AddExperimentCounts(deltas map[ExperimentKey]int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for k, n := range deltas {
+		if _, err := tx.Exec(`INSERT INTO experiment_counts (experiment, variant, event, count) VALUES (?, ?, ?, ?)
+			ON CONFLICT(experiment, variant, event) DO UPDATE SET count = count + excluded.count`,
+			k.Experiment, k.Variant, k.Event, n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// expired reports whether the item's expiry has passed at now.
+func (it *Item) expired(now time.Time) bool {
+	return it.ExpireAt != nil && !now.Before(*it.ExpireAt)
+}
+
+// expiryJanitor deletes expired items from the store every interval.
+func expiryJanitor(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if n, err := deleteExpired(store, time.Now()); err != nil {
+			log.Printf("expiry janitor: %v", err)
+		} else if n > 0 {
+			log.Printf("expiry janitor: removed %d expired item(s)", n)
+			if err := reloadItems(context.Background()); err != nil {
+				log.Printf("expiry janitor: reload: %v", err)
+			}
+		}
+	}
+}
+
+// deleteExpired removes the items expired at now from repo, returning how
+// many were removed.
+func deleteExpired(repo ItemRepository, now time.Time) (int, error) {
+	all, err := repo.List()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for i := range all {
+		if !all[i].expired(now) {
+			continue
+		}
+		if err := repo.Delete(all[i].ID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiredHidden(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	gone := testItem(1, "Old Promo")
+	gone.ExpireAt = &past
+	running := testItem(2, "Summer Promo")
+	running.ExpireAt = &future
+	useItems(t, gone, running, testItem(3, "Evergreen"))
+
+	w := get("/api/items")
+	var items []Item
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range items {
+		if it.ID == 1 {
+			t.Errorf("GET /api/items lists the expired item")
+		}
+	}
+	if len(items) != 2 {
+		t.Errorf("GET /api/items: %d items, want 2", len(items))
+	}
+	for _, path := range []string{"/api/items/1", "/items/old-promo"} {
+		if w := get(path); w.Code != 404 {
+			t.Errorf("GET %s = %d, want 404", path, w.Code)
+		}
+	}
+	if w := get("/items/summer-promo"); w.Code != 200 {
+		t.Errorf("GET /items/summer-promo = %d, want 200 before its expiry", w.Code)
+	}
+	for _, path := range []string{"/", "/feed.xml", "/sitemap.xml", "/search?q=promo"} {
+		if body := get(path).Body.String(); strings.Contains(body, "Old Promo") || strings.Contains(body, "old-promo") {
+			t.Errorf("GET %s shows the expired item", path)
+		}
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	a, b, c := testItem(1, "Alpha"), testItem(2, "Beta"), testItem(3, "Gamma")
+	a.ExpireAt, b.ExpireAt = &past, &future
+	useItems(t, a, b, c)
+
+	n, err := deleteExpired(store, now)
+	if err != nil || n != 1 {
+		t.Fatalf("deleteExpired = %d, %v; want 1", n, err)
+	}
+	left, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, it := range left {
+		ids = append(ids, it.ID)
+	}
+	if len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Errorf("store holds %v after the sweep, want [2 3]", ids)
+	}
+	if n, err := deleteExpired(store, now); err != nil || n != 0 {
+		t.Errorf("second sweep = %d, %v; want 0", n, err)
+	}
+	if n, err := deleteExpired(store, future); err != nil || n != 1 {
+		t.Errorf("sweep after Beta's expiry = %d, %v; want 1", n, err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exporting is set while -export renders the site. Pages then link to
+// static pagination paths, drop controls that need the server (sorting,
+// the language switcher) and don't count views.
+var exporting bool
+
+// exportSkip lists path prefixes that only work against the live server
+// and are left out of an export.
+var exportSkip = []string{
+	"/api/", "/admin", "/auth/", "/search", "/favorites", "/random",
+	"/login", "/logout", "/register", "/me", "/contact", "/newsletter",
+	"/events", "/ws", "/hooks/", "/graphql", "/graphiql", "/healthz", "/readyz", "/metrics",
+}
+
+// exportLinks finds root-relative href and src attributes in a page.
+var exportLinks = regexp.MustCompile(`(?:href|src)="(/[^"/][^"]*|/)"`)
+
+// exportSite renders the site through h into dir: every live item and
+// tag page, the home page and its pages, the legal pages, feeds, the
+// sitemap, robots.txt and every asset those reference. Pages are written
+// as dir/<path>/index.html so any static host serves them at the same
+// URLs; the not-found page becomes 404.html. Under a base path, dir is
+// what gets served there.
+func exportSite(dir string, h http.Handler) error {
+	if baseURL == "" {
+		log.Printf("Exporting without -base-url; absolute links will point at http://localhost")
+	}
+	exporting = true
+	defer func() { exporting = false }()
+
+	c, now := current(), time.Now()
+	queue := []string{"/", "/feed.xml", "/feed.atom", "/sitemap.xml", "/robots.txt", "/credits", "/collections"}
+	for _, p := range legalPages {
+		queue = append(queue, p.path)
+	}
+	for _, it := range c.live(now) {
+		queue = append(queue, "/items/"+it.Slug)
+	}
+	for _, t := range c.tagCloud(now) {
+		queue = append(queue, "/tags/"+t.Slug)
+	}
+	for _, col := range allCollections() {
+		if len(col.items(c, now)) > 0 {
+			queue = append(queue, "/collections/"+col.Slug)
+		}
+	}
+
+	seen := map[string]bool{}
+	pages, files := 0, 0
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if seen[p] || skipExport(p) {
+			continue
+		}
+		seen[p] = true
+		rec := exportGet(h, p)
+		if rec.Code != http.StatusOK {
+			log.Printf("export: %s: %d, skipped", p, rec.Code)
+			continue
+		}
+		html := strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html")
+		if err := writeExport(dir, exportPath(p, html), rec.Body.Bytes()); err != nil {
+			return err
+		}
+		if !html {
+			files++
+			continue
+		}
+		pages++
+		for _, m := range exportLinks.FindAllStringSubmatch(rec.Body.String(), -1) {
+			link, _, _ := strings.Cut(m[1], "#")
+			link, _, _ = strings.Cut(link, "?") // asset ?v= busting only
+			link, _ = trimBase(link)
+			queue = append(queue, link)
+		}
+	}
+
+	rec := exportGet(h, "/404.html")
+	if err := writeExport(dir, "404.html", rec.Body.Bytes()); err != nil {
+		return err
+	}
+	// Copy all of static/, not just what the pages mention, since main.js
+	// and styles.css refer to files too, except the data the server hides.
+	err := fs.WalkDir(staticFS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || isHiddenStatic(p) {
+			return err
+		}
+		data, err := fs.ReadFile(staticFS, p)
+		if err != nil {
+			return err
+		}
+		files++
+		return writeExport(dir, path.Join("static", p), data)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Exported %d pages and %d files to %s", pages, files, dir)
+	return nil
+}
+
+// skipExport reports whether p only makes sense on the live server.
+func skipExport(p string) bool {
+	for _, s := range exportSkip {
+		if p == s || strings.HasPrefix(p, strings.TrimSuffix(s, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// exportGet renders p in-process with a plain browser-like request. The
+// static pagination paths staticPageURL hands out are asked for as the
+// ?page= the server understands.
+func exportGet(h http.Handler, p string) *httptest.ResponseRecorder {
+	target := p
+	if base, rest, ok := strings.Cut(p, "/page/"); ok {
+		if n, err := strconv.Atoi(strings.TrimSuffix(rest, "/")); err == nil {
+			target = cmp.Or(base, "/") + "?page=" + strconv.Itoa(n)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if baseURL != "" {
+		if _, host, ok := strings.Cut(baseURL, "://"); ok {
+			r.Host, _, _ = strings.Cut(host, "/")
+		}
+	}
+	r.Header.Set("Accept", "text/html,*/*")
+	r.Header.Set("User-Agent", "Mozilla/5.0 (compatible; blendingwaves-export)")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+// exportPath maps a URL path to the file it is written to: pages become
+// index.html in a directory of that name, anything else keeps its path.
+func exportPath(p string, html bool) string {
+	p = strings.TrimPrefix(p, "/")
+	if html && path.Ext(p) != ".html" {
+		return path.Join(p, "index.html")
+	}
+	return p
+}
+
+// writeExport writes data to name under dir, creating directories.
+func writeExport(dir, name string, data []byte) error {
+	dst := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, data, 0o644); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	return nil
+}
+
+// staticPageURL is pageURL for exports: page n of the listing at base
+// as a path, since static hosts ignore query strings.
+func staticPageURL(base string, n int) string {
+	base = strings.TrimSuffix(base, "/")
+	if n == 1 {
+		return base + "/"
+	}
+	return base + "/page/" + strconv.Itoa(n) + "/"
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"cmp"
+	"encoding/xml"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedLimit is the number of newest items included in feeds.
+const feedLimit = 50
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Self        atomLink  `xml:"atom:link"`
+	LastBuild   string    `xml:"lastBuildDate"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	GUID        string       `xml:"guid"`
+	Description string       `xml:"description"`
+	PubDate     string       `xml:"pubDate"`
+	Category    []string     `xml:"category,omitempty"`
+	Enclosure   *rssEnclosed `xml:"enclosure,omitempty"`
+}
+
+type rssEnclosed struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length int    `xml:"length,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+}
+
+// publishedAt is the item's CreatedAt, or the catalog load time for
+// items that predate the field.
+func (c *catalog) publishedAt(it *Item) time.Time {
+	if it.CreatedAt != nil {
+		return *it.CreatedAt
+	}
+	return c.loadedAt
+}
+
+// feedItems returns up to feedLimit live items, newest first.
+func (c *catalog) feedItems(now time.Time) []Item {
+	live := c.live(now)
+	slices.SortStableFunc(live, func(a, b Item) int {
+		return cmp.Compare(c.publishedAt(&b).UnixNano(), c.publishedAt(&a).UnixNano())
+	})
+	return live[:min(len(live), feedLimit)]
+}
+
+// feedHandler serves RSS 2.0, or Atom for /feed.atom and ?format=atom.
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	cachePage(w, r, pageKey{route: "feed"}, "", func(w http.ResponseWriter) { writeFeed(w, r) })
+}
+
+func writeFeed(w http.ResponseWriter, r *http.Request) {
+	c := current()
+	entries := c.feedItems(time.Now())
+	updated := c.loadedAt
+	if len(entries) > 0 {
+		updated = c.publishedAt(&entries[0])
+	}
+
+	var doc any
+	ctype := "application/rss+xml; charset=utf-8"
+	if strings.HasSuffix(r.URL.Path, ".atom") || r.URL.Query().Get("format") == "atom" {
+		ctype = "application/atom+xml; charset=utf-8"
+		feed := atomFeed{
+			Title:   "BlendingWaves",
+			ID:      siteURL(r, "/"),
+			Updated: updated.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Href: siteURL(r, "/feed.atom"), Rel: "self", Type: "application/atom+xml"},
+				{Href: siteURL(r, "/"), Rel: "alternate", Type: "text/html"},
+			},
+		}
+		for i := range entries {
+			it := &entries[i]
+			link := siteURL(r, "/items/"+it.Slug)
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:   it.KeywordTitle,
+				ID:      link,
+				Updated: c.publishedAt(it).UTC().Format(time.RFC3339),
+				Links:   []atomLink{{Href: link, Rel: "alternate", Type: "text/html"}},
+				Summary: plainTexts(it.Texts),
+			})
+		}
+		doc = feed
+	} else {
+		ch := rssChannel{
+			Title:       "BlendingWaves",
+			Link:        siteURL(r, "/"),
+			Description: "Shape Industries Using Statistics and AI.",
+			Self:        atomLink{Href: siteURL(r, "/feed.xml"), Rel: "self", Type: "application/rss+xml"},
+			LastBuild:   updated.UTC().Format(time.RFC1123Z),
+		}
+		for i := range entries {
+			it := &entries[i]
+			link := siteURL(r, "/items/"+it.Slug)
+			item := rssItem{
+				Title:       it.KeywordTitle,
+				Link:        link,
+				GUID:        link,
+				Description: plainTexts(it.Texts),
+				PubDate:     c.publishedAt(it).UTC().Format(time.RFC1123Z),
+				Category:    it.Tags,
+			}
+			if len(it.VideoPath) > 0 && !protectedMedia() {
+				item.Enclosure = &rssEnclosed{URL: publicVideoURL(r, it, 0), Type: "video/mp4"}
+				if name, ok := staticFile(it.VideoPath[0]); ok {
+					if fi, err := fs.Stat(staticFS, name); err == nil {
+						item.Enclosure.Length = int(fi.Size())
+					}
+				}
+			}
+			ch.Items = append(ch.Items, item)
+		}
+		doc = rssFeed{Version: "2.0", Atom: "http://www.w3.org/2005/Atom", Channel: ch}
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(out)))
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/js"
+)
+
+// fingerprint is an asset served under a URL carrying a hash of its
+// contents, such as /styles.1a2b3c4d5e.css: a new version gets a new
+// URL, so every version can be cached forever. CSS and JS is minified
+// and kept in data; other files are served from fsys.
+type fingerprint struct {
+	url  string
+	fsys fs.FS
+	name string
+	data []byte
+	etag string
+}
+
+// fingerprints maps logical asset paths, as templates name them, and
+// the fingerprinted URLs to the assets. Both are built once by
+// buildAssets and only read after.
+var fingerprints = struct {
+	byPath map[string]*fingerprint
+	byURL  map[string]*fingerprint
+}{byPath: map[string]*fingerprint{}, byURL: map[string]*fingerprint{}}
+
+// unfingerprinted are static directories left out: item data changes
+// with each deploy of the catalog rather than of the code, and nothing
+// links to it by URL from a page.
+var unfingerprinted = []string{"data/"}
+
+// minifier minifies stylesheets and scripts by media type.
+var minifier = func() *minify.M {
+	m := minify.New()
+	m.AddFunc("text/css", css.Minify)
+	m.AddFunc("text/javascript", js.Minify)
+	return m
+}()
+
+// buildAssets fingerprints styles.css, main.js and the files under the
+// static directory, minifying the CSS and JS. In -dev mode assets are
+// served as they are, so edits show up on reload.
+func buildAssets() {
+	fingerprints.byPath = map[string]*fingerprint{}
+	fingerprints.byURL = map[string]*fingerprint{}
+	if devMode {
+		return
+	}
+	start := time.Now()
+	for _, name := range []string{"styles.css", "main.js"} {
+		addFingerprint("/"+name, rootFS, name)
+	}
+	fs.WalkDir(staticFS, ".", func(name string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return nil
+		case d.IsDir() && slices.ContainsFunc(unfingerprinted, func(p string) bool { return strings.HasPrefix(name+"/", p) }):
+			return fs.SkipDir
+		case d.Type().IsRegular():
+			addFingerprint("/static/"+name, staticFS, name)
+		}
+		return nil
+	})
+	log.Printf("Fingerprinted %d assets in %v", len(fingerprints.byPath), time.Since(start).Round(time.Millisecond))
+}
+
+// addFingerprint fingerprints the file name of fsys, served at logical.
+// CSS and JS is read and minified; other files, videos among them, are
+// hashed as they stream by.
+func addFingerprint(logical string, fsys fs.FS, name string) {
+	f := &fingerprint{fsys: fsys, name: name}
+	h := sha256.New()
+	ext := path.Ext(name)
+	mt, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	if mt == "text/css" || mt == "text/javascript" {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			log.Printf("assets: %s: %v", logical, err)
+			return
+		}
+		if min, err := minifier.Bytes(mt, data); err != nil {
+			log.Printf("assets: minify %s: %v", logical, err)
+		} else {
+			data = min
+		}
+		f.data = data
+		h.Write(data)
+	} else {
+		file, err := fsys.Open(name)
+		if err != nil {
+			log.Printf("assets: %s: %v", logical, err)
+			return
+		}
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			log.Printf("assets: %s: %v", logical, err)
+			return
+		}
+	}
+	sum := hex.EncodeToString(h.Sum(nil)[:5])
+	f.url = strings.TrimSuffix(logical, ext) + "." + sum + ext
+	f.etag = `"` + sum + `"`
+	fingerprints.byPath[logical] = f
+	fingerprints.byURL[f.url] = f
+}
+
+// assetURL is the "asset" template func: the fingerprinted URL of the
+// asset at path, e.g. /styles.1a2b3c4d5e.css for /styles.css. Unknown
+// paths, and every path in -dev mode, are returned as they are.
+func assetURL(path string) string {
+	if f, ok := fingerprints.byPath[path]; ok {
+		return f.url
+	}
+	return path
+}
+
+// withAssets serves the fingerprinted URLs assetURL hands out, and
+// passes other requests to h.
+func withAssets(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := fingerprints.byURL[r.URL.Path]
+		if !ok || r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if f.fsys == staticFS && protectedVideoFile(f.name) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", f.etag)
+		if f.data == nil {
+			http.ServeFileFS(w, r, f.fsys, f.name)
+			return
+		}
+		http.ServeContent(w, r, f.name, time.Time{}, bytes.NewReader(f.data))
+	})
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FeatureFlag turns a feature on for everyone, nobody, or a share of
+// visitors. Percent picks visitors by a hash of their visitor cookie and
+// the flag name, so each visitor keeps their cohort and the cohorts of
+// different flags are independent. Staff see every enabled feature.
+type FeatureFlag struct {
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	Percent     int    `json:"percent"`
+	Description string `json:"description,omitempty"`
+}
+
+// defaultFlags are the features the code checks, as they are when
+// nothing overrides them.
+var defaultFlags = []FeatureFlag{
+	{Name: "comments", Enabled: true, Percent: 100, Description: "visitor comments on item pages"},
+	{Name: "search-suggest", Enabled: true, Percent: 100, Description: "title and tag completions in the search box"},
+}
+
+// flagStore is implemented by repositories that persist flag changes
+// made through the admin API.
+type flagStore interface {
+	LoadFlags() ([]FeatureFlag, error)
+	PutFlag(f FeatureFlag) error
+	DeleteFlag(name string) error
+}
+
+// flags holds the flags in effect: the defaults, then the -flags-file,
+// then the store, each overriding the one before. base is the first two,
+// which deleting a flag's override falls back to.
+var flags = struct {
+	sync.RWMutex
+	base map[string]FeatureFlag
+	m    map[string]FeatureFlag
+}{}
+
+// flagsFile is the -flags-file, read by loadFlags.
+var flagsFile string
+
+var flagName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// loadFlags builds the flags in effect at startup.
+func loadFlags() {
+	base := map[string]FeatureFlag{}
+	for _, f := range defaultFlags {
+		base[f.Name] = f
+	}
+	if flagsFile != "" {
+		fromFile, err := readFlagsFile(flagsFile)
+		if err != nil {
+			log.Fatalf("Failed to load flags: %v", err)
+		}
+		for _, f := range fromFile {
+			base[f.Name] = f
+		}
+	}
+	m := maps.Clone(base)
+	if fs, ok := store.(flagStore); ok {
+		stored, err := fs.LoadFlags()
+		if err != nil {
+			log.Printf("flags: load: %v", err)
+		}
+		for _, f := range stored {
+			m[f.Name] = f
+		}
+	}
+	flags.Lock()
+	flags.base, flags.m = base, m
+	flags.Unlock()
+}
+
+// readFlagsFile reads a JSON object of flag name to settings. Percent
+// defaults to 100, so {"comments": {"enabled": false}} is enough.
+func readFlagsFile(path string) ([]FeatureFlag, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]struct {
+		Enabled     bool   `json:"enabled"`
+		Percent     *int   `json:"percent"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var out []FeatureFlag
+	for name, v := range raw {
+		f := FeatureFlag{Name: name, Enabled: v.Enabled, Percent: 100, Description: v.Description}
+		if v.Percent != nil {
+			f.Percent = *v.Percent
+		}
+		if err := checkFlag(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func checkFlag(f FeatureFlag) error {
+	if !flagName.MatchString(f.Name) {
+		return fmt.Errorf("flag name %q must be lowercase letters, digits and dashes", f.Name)
+	}
+	if f.Percent < 0 || f.Percent > 100 {
+		return fmt.Errorf("flag %s: percent must be from 0 to 100, got %d", f.Name, f.Percent)
+	}
+	return nil
+}
+
+// lookupFlag returns the flag called name; unknown flags are off.
+func lookupFlag(name string) FeatureFlag {
+	flags.RLock()
+	defer flags.RUnlock()
+	return flags.m[name]
+}
+
+// Features answers which flags are on for one request. Templates get it
+// as .Features: {{ if .Features.On "comments" }}.
+type Features struct {
+	visitor string
+	staff   bool
+}
+
+// On reports whether the feature called name is on for this visitor.
+func (f Features) On(name string) bool {
+	flag := lookupFlag(name)
+	switch {
+	case !flag.Enabled:
+		return false
+	case flag.Percent >= 100 || f.staff:
+		return true
+	case f.visitor == "":
+		return false
+	}
+	return cohort(name, f.visitor, 100) < flag.Percent
+}
+
+// cohort places visitor in one of n buckets for the flag or experiment
+// called name.
+func cohort(name, visitor string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + visitor))
+	return int(h.Sum32() % uint32(n))
+}
+
+// featureWriter carries the request's Features to handlers and render.
+type featureWriter struct {
+	http.ResponseWriter
+	features Features
+}
+
+func (f *featureWriter) Unwrap() http.ResponseWriter { return f.ResponseWriter }
+
+func (f *featureWriter) Flush() {
+	if fl, ok := f.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// withFeatures works out each request's Features. Visitors only get a
+// visitor cookie for it while some flag is partly rolled out; a new one
+// is added to the request too, so handlers see the same visitor.
+func withFeatures(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := Features{staff: staff(r)}
+		had, _ := visitorID(w, r, false)
+		f.visitor = had
+		if had == "" && partialRollout() {
+			f.visitor, _ = visitorID(w, r, true)
+			r.AddCookie(&http.Cookie{Name: visitorCookie, Value: signVisitor(f.visitor)})
+		}
+		h.ServeHTTP(&featureWriter{w, f}, r)
+	})
+}
+
+// partialRollout reports whether any enabled flag reaches only some
+// visitors.
+func partialRollout() bool {
+	if exporting {
+		return false
+	}
+	flags.RLock()
+	defer flags.RUnlock()
+	for _, f := range flags.m {
+		if f.Enabled && f.Percent > 0 && f.Percent < 100 {
+			return true
+		}
+	}
+	return false
+}
+
+// writerFeatures returns the Features withFeatures found for w.
+func writerFeatures(w http.ResponseWriter) Features {
+	if f, ok := findWriter[*featureWriter](w); ok {
+		return f.features
+	}
+	return Features{}
+}
+
+// featureOn is the handler-level check: whether name is on for the
+// request w answers.
+func featureOn(w http.ResponseWriter, name string) bool {
+	return writerFeatures(w).On(name)
+}
+
+// requireFeature answers 404 in place of h while name is off, as if the
+// route didn't exist.
+func requireFeature(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureOn(w, name) {
+			notFound(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// adminFlagsHandler lists every flag in effect as JSON.
+func adminFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	flags.RLock()
+	out := slices.SortedFunc(maps.Values(flags.m), func(a, b FeatureFlag) int { return strings.Compare(a.Name, b.Name) })
+	flags.RUnlock()
+	writeJSON(w, http.StatusOK, map[string]any{"flags": out})
+}
+
+// adminPutFlagHandler creates or changes the flag {name} from a JSON
+// body, taking effect at once and persisting when the store can.
+func adminPutFlagHandler(w http.ResponseWriter, r *http.Request) {
+	f := lookupFlag(r.PathValue("name"))
+	var before any
+	if f.Name == "" {
+		f = FeatureFlag{Percent: 100}
+	} else {
+		before = f
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&f); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	f.Name = r.PathValue("name")
+	if err := checkFlag(f); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if fs, ok := store.(flagStore); ok {
+		if err := fs.PutFlag(f); err != nil {
+			log.Printf("flags: save %s: %v", f.Name, err)
+			writeJSONError(w, http.StatusInternalServerError, "saving the flag failed")
+			return
+		}
+	}
+	flags.Lock()
+	flags.m[f.Name] = f
+	flags.Unlock()
+	audit(r, "flag.put", f.Name, before, f)
+	log.Printf("admin: %s set flag %s: enabled=%t percent=%d", actor(r), f.Name, f.Enabled, f.Percent)
+	writeJSON(w, http.StatusOK, f)
+}
+
+// adminDeleteFlagHandler drops the admin override of {name}, putting the
+// flag back as the code or -flags-file sets it.
+func adminDeleteFlagHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var before any
+	if f := lookupFlag(name); f.Name != "" {
+		before = f
+	}
+	if fs, ok := store.(flagStore); ok {
+		if err := fs.DeleteFlag(name); err != nil {
+			log.Printf("flags: delete %s: %v", name, err)
+			writeJSONError(w, http.StatusInternalServerError, "deleting the flag failed")
+			return
+		}
+	}
+	flags.Lock()
+	f, ok := flags.base[name]
+	if ok {
+		flags.m[name] = f
+	} else {
+		delete(flags.m, name)
+	}
+	flags.Unlock()
+	var after any
+	if ok {
+		after = f
+	}
+	audit(r, "flag.delete", name, before, after)
+	log.Printf("admin: %s reset flag %s", actor(r), name)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, f)
+}
+
+// flagsPath is where the JSON store keeps flag changes.
+func (s *jsonStore) flagsPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".flags.json"
+}
+
+func (s *jsonStore) readFlags() ([]FeatureFlag, error) {
+	data, err := os.ReadFile(s.flagsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []FeatureFlag
+	return out, json.Unmarshal(data, &out)
+}
+
+func (s *jsonStore) LoadFlags() ([]FeatureFlag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readFlags()
+}
+
+func (s *jsonStore) PutFlag(f FeatureFlag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readFlags()
+	if err != nil {
+		return err
+	}
+	if i := slices.IndexFunc(all, func(x FeatureFlag) bool { return x.Name == f.Name }); i >= 0 {
+		all[i] = f
+	} else {
+		all = append(all, f)
+	}
+	return s.writeFlags(all)
+}
+
+func (s *jsonStore) DeleteFlag(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readFlags()
+	if err != nil {
+		return err
+	}
+	return s.writeFlags(slices.DeleteFunc(all, func(x FeatureFlag) bool { return x.Name == name }))
+}
+
+func (s *jsonStore) writeFlags(all []FeatureFlag) error {
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.flagsPath(), data)
+}
+
+func (s *sqliteStore) LoadFlags() ([]FeatureFlag, error) {
+	rows, err := s.db.Query(`SELECT data FROM flags ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []FeatureFlag
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var f FeatureFlag
+		if err := json.Unmarshal([]byte(data), &f); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) PutFlag(f FeatureFlag) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO flags (name, data) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET data = excluded.data`, f.Name, string(data))
+	return err
+}
+
+func (s *sqliteStore) DeleteFlag(name string) error {
+	_, err := s.db.Exec(`DELETE FROM flags WHERE name = ?`, name)
+	return err
+}
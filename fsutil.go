@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// readRecoverable decodes the JSON file at path into v and reports
+// whether it did. It is for the sidecars of counters the site can afford
+// to lose, such as views and likes: a file that doesn't decode is moved
+// aside to path.corrupt with a warning, so the server starts afresh
+// instead of failing every read and write after one bad write. A missing
+// file isn't read either; both leave the caller to start empty.
+func readRecoverable(path string, v any) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		if rerr := os.Rename(path, path+".corrupt"); rerr != nil {
+			return false, errors.Join(err, rerr)
+		}
+		log.Printf("warning: %s is corrupt (%v); moved it to %s.corrupt and starting afresh", path, err, path)
+		return false, nil
+	}
+	return true, nil
+}
+
+// writeFileAtomic writes data to a temp file beside path and renames it
+// into place so readers never see a partial file. The file keeps the
+// mode it had, and a new one is made 0644 rather than the 0600 temp
+// files get, so other users such as a backup job can still read it.
+func writeFileAtomic(path string, data []byte) error {
+	mode := fs.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// keyLocks serializes work on one key, such as making a cached file, while
+// work on different keys runs in parallel. The zero value is ready to use.
+type keyLocks struct {
+	mu sync.Mutex
+	m  map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	users int // holding or waiting for the lock
+}
+
+// lock locks key and returns the func that unlocks it.
+func (l *keyLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.m == nil {
+		l.m = map[string]*keyLock{}
+	}
+	k := l.m[key]
+	if k == nil {
+		k = &keyLock{}
+		l.m[key] = k
+	}
+	k.users++
+	l.mu.Unlock()
+	k.Lock()
+	return func() {
+		k.Unlock()
+		l.mu.Lock()
+		if k.users--; k.users == 0 {
+			delete(l.m, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCorruptSidecars(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  func(*jsonStore) string
+		load  func(*jsonStore) (int, error) // how many entries were read
+		write func(*jsonStore) error
+	}{
+		{
+			"views", (*jsonStore).viewsPath,
+			func(s *jsonStore) (int, error) { v, err := s.LoadViews(); return len(v), err },
+			func(s *jsonStore) error { return s.AddViews(map[int]Views{1: {Page: 1}}) },
+		},
+		{
+			"likes", (*jsonStore).likesPath,
+			func(s *jsonStore) (int, error) { v, err := s.LoadLikes(); return len(v), err },
+			func(s *jsonStore) error { return s.SetLike("visitor", 1, true) },
+		},
+		{
+			"analytics", (*jsonStore).analyticsPath,
+			func(s *jsonStore) (int, error) { v, err := s.Analytics("", "9999"); return len(v), err },
+			func(s *jsonStore) error {
+				return s.AddAnalytics(map[AnalyticsKey]int64{{Day: "2026-01-01", Kind: "page", Key: "/"}: 1})
+			},
+		},
+		{
+			"experiments", (*jsonStore).experimentsPath,
+			func(s *jsonStore) (int, error) { v, err := s.LoadExperimentCounts(); return len(v), err },
+			func(s *jsonStore) error {
+				return s.AddExperimentCounts(map[ExperimentKey]int64{{Experiment: "e", Variant: "a", Event: "view"}: 1})
+			},
+		},
+	}
+	fixtures := []string{`{"1": {"page": 3`, `[1, 2, 3]`, `"views"`}
+	for _, tt := range tests {
+		for _, bad := range fixtures {
+			s := &jsonStore{path: filepath.Join(t.TempDir(), "items.json")}
+			path := tt.path(s)
+			if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := tt.load(s); err != nil || n != 0 {
+				t.Errorf("%s %q: load = %d entries, %v; want a fresh start", tt.name, bad, n, err)
+			}
+			if got, err := os.ReadFile(path + ".corrupt"); err != nil || string(got) != bad {
+				t.Errorf("%s %q: .corrupt holds %q, %v", tt.name, bad, got, err)
+			}
+			if err := tt.write(s); err != nil {
+				t.Errorf("%s %q: write after recovery: %v", tt.name, bad, err)
+			}
+			if n, err := tt.load(s); err != nil || n != 1 {
+				t.Errorf("%s %q: reload = %d entries, %v; want 1", tt.name, bad, n, err)
+			}
+		}
+	}
+}
+
+func TestCorruptItemsStayFatal(t *testing.T) {
+	s := &jsonStore{path: filepath.Join(t.TempDir(), "items.json")}
+	if err := os.WriteFile(s.path, []byte(`[{"id": 1,`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.List(); err == nil {
+		t.Error("List of a corrupt items.json succeeded")
+	}
+	if _, err := os.Stat(s.path + ".corrupt"); err == nil {
+		t.Error("a corrupt items.json was moved aside")
+	}
+}
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	dir := t.TempDir()
+	fresh := filepath.Join(dir, "fresh.json")
+	if err := writeFileAtomic(fresh, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(fresh); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Errorf("new file: mode %v, %v; want 0644", fi.Mode().Perm(), err)
+	}
+
+	for _, mode := range []os.FileMode{0o600, 0o640, 0o664} {
+		path := filepath.Join(dir, "existing.json")
+		if err := os.WriteFile(path, []byte("[]"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFileAtomic(path, []byte(`["new"]`)); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("rewriting a %v file left it %v", mode, fi.Mode().Perm())
+		}
+		if data, _ := os.ReadFile(path); string(data) != `["new"]` {
+			t.Errorf("rewritten file holds %q", data)
+		}
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "*.tmp*")); len(left) > 0 {
+		t.Errorf("temp files left behind: %v", left)
+	}
+}
+
+func TestKeyLocks(t *testing.T) {
+	var locks keyLocks
+	unlock := locks.lock("a")
+
+	// Another key isn't held up.
+	done := make(chan struct{})
+	go func() {
+		locks.lock("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking b waited for a")
+	}
+
+	// The same key waits for the holder.
+	var second atomic.Bool
+	got := make(chan struct{})
+	go func() {
+		unlock := locks.lock("a")
+		second.Store(true)
+		unlock()
+		close(got)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if second.Load() {
+		t.Fatal("a was locked twice at once")
+	}
+	unlock()
+	<-got
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.m) != 0 {
+		t.Errorf("%d keys left after every lock was released", len(locks.m))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// formatDate is the "date" template func. It takes a time.Time or a
+// *time.Time, showing nothing for nil or zero, in the given layout
+// (default "Jan 2, 2006").
+func formatDate(t any, layout ...string) string {
+	var tm time.Time
+	switch v := t.(type) {
+	case time.Time:
+		tm = v
+	case *time.Time:
+		if v != nil {
+			tm = *v
+		}
+	}
+	if tm.IsZero() {
+		return ""
+	}
+	l := "Jan 2, 2006"
+	if len(layout) > 0 {
+		l = layout[0]
+	}
+	return tm.Format(l)
+}
+
+// truncateFunc is the "truncate" template func, taking the length first
+// so it reads well in a pipeline: {{ .Title | truncate 40 }}.
+func truncateFunc(n int, s string) string { return truncate(s, n) }
+
+// excerpt is the "excerpt" template func: Markdown text reduced to plain
+// text and cut at a word boundary near n runes.
+func excerpt(n int, s string) string {
+	s = markdownText(s)
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	cut := string(r[:n])
+	if i := strings.LastIndexByte(cut, ' '); i > n/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// pluralize is the "plural" template func: {{ plural 3 "item" "items" }}
+// gives "3 items". n may be any integer type.
+func pluralize(n any, one, many string) string {
+	word := many
+	switch v := n.(type) {
+	case int:
+		if v == 1 {
+			word = one
+		}
+	case int64:
+		if v == 1 {
+			word = one
+		}
+	}
+	return fmt.Sprintf("%d %s", n, word)
+}
+
+// externalURL is the "external" template func. It passes absolute http
+// and https URLs through and returns "" for anything else, so editor
+// supplied links can't smuggle in other schemes.
+func externalURL(s string) string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+// jsonFunc is the "json" template func, for handing server data to
+// main.js: {{ json .Items }} inside a script. Marshal escapes <, > and &,
+// so the output can't close the script element.
+func jsonFunc(v any) (template.JS, error) { return jsonLD(v) }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/netip"
+	"slices"
+)
+
+// gateConf keeps a staging or preview instance private: only clients in
+// allow, or those giving the user and password, get through. The gate
+// is off when neither is set.
+var gateConf struct {
+	user     string
+	password string
+	allow    []netip.Prefix
+}
+
+// gateOpen are the paths answered without passing the gate: the load
+// balancer's health checks, and the git webhook, which proves itself
+// with its signature.
+var gateOpen = []string{"/healthz", "/readyz", "/hooks/git"}
+
+// setGate sets up the gate from -gate-user, -gate-password and the
+// comma-separated CIDRs of -gate-allow.
+func setGate(user, password, allow string) error {
+	prefixes, err := parsePrefixes(allow, "gate-allow")
+	if err != nil {
+		return err
+	}
+	if password != "" && user == "" {
+		return errors.New("gate-password needs gate-user")
+	}
+	gateConf.user, gateConf.password, gateConf.allow = user, password, prefixes
+	return nil
+}
+
+// gateOn reports whether the gate is set up.
+func gateOn() bool {
+	return gateConf.password != "" || len(gateConf.allow) > 0
+}
+
+// gate answers requests that don't pass the gate with a 401 asking for
+// the gate's credentials, or a 403 when only the allowlist admits. The
+// admin's basic-auth credentials pass too, since a browser sends one
+// set: with both in use, sign in at /admin with the admin's. Every
+// response tells crawlers not to index the site, should a link to it
+// leak.
+func gate(h http.Handler) http.Handler {
+	if !gateOn() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		if slices.Contains(gateOpen, r.URL.Path) || passesGate(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if gateConf.password == "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="BlendingWaves preview", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// passesGate reports whether r comes from an allowed network or carries
+// the gate's or the admin's credentials.
+func passesGate(r *http.Request) bool {
+	if addr, err := netip.ParseAddr(clientIP(r)); err == nil {
+		addr = addr.Unmap()
+		if slices.ContainsFunc(gateConf.allow, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+			return true
+		}
+	}
+	if gateConf.password == "" {
+		return false
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(user), []byte(gateConf.user)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(gateConf.password)) == 1 {
+		return true
+	}
+	return basicAdmin(r)
+}
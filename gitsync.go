@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// gitSource reads items from a Git repository, so editors change
+// content through commits and review it in pull requests.
+type gitSource struct {
+	repo   string
+	branch string
+	dir    string // local checkout
+	items  string // items file inside the repository
+}
+
+// gitHookSecret authenticates the push webhook; nil turns it off.
+var gitHookSecret []byte
+
+func newGitSource(cfg Config) (*gitSource, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git-repo needs the git command: %w", err)
+	}
+	if !fs.ValidPath(cfg.GitItems) {
+		return nil, fmt.Errorf("git-items must be a path inside the repository, got %q", cfg.GitItems)
+	}
+	if cfg.GitWebhookSecret != "" {
+		gitHookSecret = []byte(cfg.GitWebhookSecret)
+	}
+	return &gitSource{repo: cfg.GitRepo, branch: cfg.GitBranch, dir: cfg.GitDir, items: cfg.GitItems}, nil
+}
+
+func (g *gitSource) Name() string { return "git" }
+
+// Fetch pulls the branch and reads its items; the version is the commit.
+func (g *gitSource) Fetch(ctx context.Context) ([]Item, string, error) {
+	head, err := g.pull(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	items, err := g.read()
+	if err != nil {
+		return nil, "", fmt.Errorf("commit %.12s: %w", head, err)
+	}
+	return items, head, nil
+}
+
+// pull brings the checkout up to date with the branch, cloning it the
+// first time, and returns the commit it is at.
+func (g *gitSource) pull(ctx context.Context) (string, error) {
+	if _, err := os.Stat(filepath.Join(g.dir, ".git")); errors.Is(err, fs.ErrNotExist) {
+		if _, err := runGit(ctx, "", "clone", "--quiet", "--depth", "1", "--branch", g.branch, g.repo, g.dir); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err := runGit(ctx, g.dir, "fetch", "--quiet", "--depth", "1", "origin", g.branch); err != nil {
+			return "", err
+		}
+		if _, err := runGit(ctx, g.dir, "reset", "--quiet", "--hard", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+	}
+	out, err := runGit(ctx, g.dir, "rev-parse", "HEAD")
+	return strings.TrimSpace(string(out)), err
+}
+
+// gitPassEnv are the GIT_ variables runGit passes on: how to reach the
+// repository, such as a deploy key in GIT_SSH_COMMAND.
+var gitPassEnv = []string{"GIT_SSH", "GIT_SSH_COMMAND", "GIT_SSH_VARIANT", "GIT_PROXY_COMMAND", "GIT_SSL_CAINFO", "GIT_SSL_CAPATH"}
+
+// gitEnv is environ for a git child that never prompts. GIT_ variables
+// other than gitPassEnv are dropped: ones like GIT_DIR, GIT_WORK_TREE or
+// GIT_INDEX_FILE in the server's own environment would point git at
+// another repository than -git-dir.
+func gitEnv(environ []string) []string {
+	env := make([]string, 0, len(environ)+1)
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "GIT_") && !slices.Contains(gitPassEnv, name) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env, "GIT_TERMINAL_PROMPT=0")
+}
+
+// runGit runs git in dir, never prompting for credentials.
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = gitEnv(os.Environ())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// read decodes and validates the items file in the checkout, naming the
+// line of any problem. A texts entry naming a .md file in the repository,
+// relative to the items file, is replaced by that file, so long texts can
+// live as Markdown.
+func (g *gitSource) read() ([]Item, error) {
+	root, err := os.OpenRoot(g.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	fsys := root.FS()
+	f, err := fsys.Open(g.items)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	items, lines, err := decodeItems(g.items, f)
+	if err != nil {
+		return nil, err
+	}
+	base := path.Dir(g.items)
+	for i := range items {
+		for j, t := range items[i].Texts {
+			if !strings.HasSuffix(t, ".md") || strings.ContainsAny(t, " \n") {
+				continue
+			}
+			data, err := fs.ReadFile(fsys, path.Join(base, t))
+			if err != nil {
+				return nil, fmt.Errorf("item %d: texts[%d]: %w", items[i].ID, j, err)
+			}
+			items[i].Texts[j] = strings.TrimSpace(string(data))
+		}
+	}
+	if err := checkItems(items, lines); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// gitHookHandler is the push webhook: POST /hooks/git from GitHub
+// (X-Hub-Signature-256) or GitLab (X-Gitlab-Token) queues a sync.
+func gitHookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !validGitHook(r, body) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	queueContentSync()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validGitHook checks the push came from the configured repository host.
+func validGitHook(r *http.Request, body []byte) bool {
+	if len(gitHookSecret) == 0 {
+		return false
+	}
+	if tok := r.Header.Get("X-Gitlab-Token"); tok != "" {
+		return subtle.ConstantTimeCompare([]byte(tok), gitHookSecret) == 1
+	}
+	sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	m := hmac.New(sha256.New, gitHookSecret)
+	m.Write(body)
+	return hmac.Equal(got, m.Sum(nil))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGitEnv(t *testing.T) {
+	got := gitEnv([]string{
+		"PATH=/usr/bin",
+		"GIT_DIR=/srv/other/.git",
+		"GIT_WORK_TREE=/srv/other",
+		"GIT_INDEX_FILE=/tmp/index",
+		"GIT_SSH_COMMAND=ssh -i /etc/deploy_key",
+		"GITHUB_TOKEN=abc",
+	})
+	want := []string{"PATH=/usr/bin", "GIT_SSH_COMMAND=ssh -i /etc/deploy_key", "GITHUB_TOKEN=abc", "GIT_TERMINAL_PROMPT=0"}
+	if !slices.Equal(got, want) {
+		t.Errorf("gitEnv = %q, want %q", got, want)
+	}
+}
+
+// TestRunGitIgnoresGitDir runs git with GIT_DIR pointing at another
+// repository, which without gitEnv it would act on instead.
+func TestRunGitIgnoresGitDir(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git command")
+	}
+	ctx := context.Background()
+	checkout, other := t.TempDir(), t.TempDir()
+	for _, dir := range []string{checkout, other} {
+		if _, err := runGit(ctx, dir, "init", "--quiet"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("GIT_DIR", filepath.Join(other, ".git"))
+	t.Setenv("GIT_WORK_TREE", other)
+	out, err := runGit(ctx, checkout, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := filepath.EvalSymlinks(filepath.Join(checkout, ".git"))
+	if got, _ := filepath.EvalSymlinks(strings.TrimSpace(string(out))); got != want {
+		t.Errorf("git used %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+// maxGraphQLBody caps a posted GraphQL request.
+const maxGraphQLBody = 64 << 10
+
+// gqlSchema is the read-only catalog schema served at /graphql. Only
+// live items are reachable, the same set the JSON API exposes.
+var gqlSchema = mustGraphQLSchema()
+
+// gqlRequest is a GraphQL request as posted by clients.
+type gqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// gqlPage is one page of the items connection.
+type gqlPage struct {
+	Total int
+	Items []Item
+}
+
+// gqlSlice returns the first/offset window of all; first defaults to
+// -per-page and is capped at maxPerPage.
+func gqlSlice(all []Item, args map[string]any) gqlPage {
+	first, ok := args["first"].(int)
+	if !ok {
+		first = defaultPerPage
+	}
+	offset, _ := args["offset"].(int)
+	first = min(max(first, 0), maxPerPage)
+	offset = min(max(offset, 0), len(all))
+	end := min(offset+first, len(all))
+	return gqlPage{Total: len(all), Items: all[offset:end]}
+}
+
+// gqlTime formats t for the schema; nil stays null.
+func gqlTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+func mustGraphQLSchema() graphql.Schema {
+	commentType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Comment",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":     &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"author": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"body":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"createdAt": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source.(*CommentNode).CreatedAt.Format(time.RFC3339), nil
+				}},
+			}
+		}),
+	})
+	// Replies refer back to Comment, so they are added once it exists.
+	commentType.AddFieldConfig("replies", &graphql.Field{
+		Type: graphql.NewList(commentType),
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			return p.Source.(*CommentNode).Replies, nil
+		},
+	})
+
+	item := func(p graphql.ResolveParams) Item { return p.Source.(Item) }
+	itemType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Item",
+		Fields: graphql.Fields{
+			"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (any, error) { return item(p).ID, nil }},
+			"slug": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) { return item(p).Slug, nil }},
+			"title": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return item(p).KeywordTitle, nil
+			}},
+			"texts": &graphql.Field{Type: graphql.NewList(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) { return item(p).Texts, nil }},
+			"tags":  &graphql.Field{Type: graphql.NewList(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) { return item(p).Tags, nil }},
+			"videoURLs": &graphql.Field{Type: graphql.NewList(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) {
+				it := item(p)
+				urls := make([]string, len(it.VideoPath))
+				for i := range it.VideoPath {
+					urls[i] = it.VideoURL(i)
+				}
+				return urls, nil
+			}},
+			"videoCredits": &graphql.Field{Type: graphql.NewList(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return item(p).VideoCredit, nil
+			}},
+			"link":      &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) { return item(p).ItemLink, nil }},
+			"shortURL":  &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) { return item(p).ShortURL, nil }},
+			"pinned":    &graphql.Field{Type: graphql.Boolean, Resolve: func(p graphql.ResolveParams) (any, error) { return item(p).Pinned, nil }},
+			"createdAt": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) { return gqlTime(item(p).CreatedAt), nil }},
+			"updatedAt": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) { return gqlTime(item(p).UpdatedAt), nil }},
+			"likes":     &graphql.Field{Type: graphql.Int, Resolve: func(p graphql.ResolveParams) (any, error) { return likeCount(item(p).ID), nil }},
+			"comments": &graphql.Field{Type: graphql.NewList(commentType), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return commentThread(item(p).ID, ""), nil
+			}},
+		},
+	})
+
+	pageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ItemPage",
+		Fields: graphql.Fields{
+			"total": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(gqlPage).Total, nil }},
+			"items": &graphql.Field{Type: graphql.NewList(itemType), Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(gqlPage).Items, nil }},
+		},
+	})
+	pageArgs := graphql.FieldConfigArgument{
+		"first":  &graphql.ArgumentConfig{Type: graphql.Int, Description: "Page size; defaults to -per-page."},
+		"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+	}
+
+	tagType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Tag",
+		Fields: graphql.Fields{
+			"name":  &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(TagCount).Name, nil }},
+			"slug":  &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(TagCount).Slug, nil }},
+			"count": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (any, error) { return p.Source.(TagCount).Count, nil }},
+			"items": &graphql.Field{Type: pageType, Args: pageArgs, Resolve: func(p graphql.ResolveParams) (any, error) {
+				return gqlSlice(current().tagged(p.Source.(TagCount).Slug, time.Now()), p.Args), nil
+			}},
+		},
+	})
+
+	itemsArgs := graphql.FieldConfigArgument{
+		"tag":    &graphql.ArgumentConfig{Type: graphql.String, Description: "Only items carrying this tag slug."},
+		"search": &graphql.ArgumentConfig{Type: graphql.String, Description: "Full-text query; results are ranked by relevance."},
+	}
+	for k, v := range pageArgs {
+		itemsArgs[k] = v
+	}
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"items": &graphql.Field{
+				Type: graphql.NewNonNull(pageType),
+				Args: itemsArgs,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					c, now := current(), time.Now()
+					var all []Item
+					if q, _ := p.Args["search"].(string); strings.TrimSpace(q) != "" {
+						for _, res := range c.search(q, now) {
+							all = append(all, res.Item)
+						}
+					} else {
+						all = c.live(now)
+					}
+					if tag, _ := p.Args["tag"].(string); tag != "" {
+						tag = slugify(tag)
+						kept := all[:0:0]
+						for _, it := range all {
+							for _, t := range it.Tags {
+								if slugify(t) == tag {
+									kept = append(kept, it)
+									break
+								}
+							}
+						}
+						all = kept
+					}
+					return gqlSlice(all, p.Args), nil
+				},
+			},
+			"item": &graphql.Field{
+				Type: itemType,
+				Args: graphql.FieldConfigArgument{
+					"id":   &graphql.ArgumentConfig{Type: graphql.Int},
+					"slug": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key, _ := p.Args["slug"].(string)
+					if id, ok := p.Args["id"].(int); ok {
+						key = strconv.Itoa(id)
+					}
+					if it, ok := current().lookup(key, time.Now()); ok {
+						return *it, nil
+					}
+					return nil, nil
+				},
+			},
+			"tags": &graphql.Field{
+				Type: graphql.NewList(tagType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return current().tagCloud(time.Now()), nil
+				},
+			},
+			"tag": &graphql.Field{
+				Type: tagType,
+				Args: graphql.FieldConfigArgument{
+					"slug": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					slug := slugify(p.Args["slug"].(string))
+					for _, t := range current().tagCloud(time.Now()) {
+						if t.Slug == slug {
+							return t, nil
+						}
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic("graphql schema: " + err.Error())
+	}
+	return schema
+}
+
+// graphqlHandler executes queries sent as GET ?query= or as a JSON POST
+// body. The schema is read-only, so both methods are equally safe.
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req gqlRequest
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "variables must be a JSON object")
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBody)).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "body must be a JSON object with a query")
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing query")
+		return
+	}
+	ctx, span := startSpan(r.Context(), "graphql")
+	defer span.End()
+	span.set("operation", req.OperationName)
+	res := graphql.Do(graphql.Params{
+		Schema:         gqlSchema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+	status := http.StatusOK
+	if res.HasErrors() && res.Data == nil {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, res)
+}
+
+// graphiqlHandler serves the GraphiQL explorer, registered only in
+// development mode.
+func graphiqlHandler(w http.ResponseWriter, r *http.Request) {
+	visitor, _ := visitorID(w, r, true)
+	data := map[string]interface{}{
+		"Title": "GraphiQL | BlendingWaves",
+		"CSRF":  csrfFor(visitor),
+	}
+	if err := render(w, "graphiql.html", data); err != nil {
+		renderError(w, err)
+	}
+}
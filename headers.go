@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+)
+
+// itemHeaderNames are the response headers an item may set through its
+// Headers field: preloads, robots directives and cross-origin access for
+// items embedded elsewhere. Anything that could change how the page is
+// cached, secured or interpreted stays under the server's control.
+var itemHeaderNames = []string{
+	"Link",
+	"X-Robots-Tag",
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+	"Cross-Origin-Resource-Policy",
+	"Timing-Allow-Origin",
+}
+
+// maxItemHeader bounds the length of an item header value.
+const maxItemHeader = 1024
+
+// checkHeader describes what's wrong with an item header, or returns "".
+func checkHeader(name, value string) string {
+	if !slices.Contains(itemHeaderNames, textproto.CanonicalMIMEHeaderKey(name)) {
+		return fmt.Sprintf("headers: %q is not one of %s", name, strings.Join(itemHeaderNames, ", "))
+	}
+	if len(value) > maxItemHeader {
+		return fmt.Sprintf("headers: %s is longer than %d bytes", name, maxItemHeader)
+	}
+	for _, c := range value {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return fmt.Sprintf("headers: %s has a control character", name)
+		}
+	}
+	return ""
+}
+
+// checkHeaders describes what's wrong with the item's Headers, in name order.
+func checkHeaders(it *Item) []string {
+	var msgs []string
+	for _, name := range slices.Sorted(maps.Keys(it.Headers)) {
+		if msg := checkHeader(name, it.Headers[name]); msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// setItemHeaders adds the item's Headers to the response, skipping any
+// that don't pass checkHeader.
+func setItemHeaders(w http.ResponseWriter, it *Item) {
+	for name, value := range it.Headers {
+		if checkHeader(name, value) == "" {
+			w.Header().Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestItemHeaders(t *testing.T) {
+	custom := testItem(1, "Embeddable")
+	custom.Headers = map[string]string{
+		"Link":                        "</static/video/chalk.mp4>; rel=preload; as=video",
+		"access-control-allow-origin": "https://partner.example",
+	}
+	useItems(t, custom, testItem(2, "Plain"))
+
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"/items/embeddable", map[string]string{"Link": custom.Headers["Link"], "Access-Control-Allow-Origin": "https://partner.example"}},
+		{"/api/items/1", map[string]string{"Link": custom.Headers["Link"], "Access-Control-Allow-Origin": "https://partner.example"}},
+		{"/items/plain", map[string]string{"Link": "", "Access-Control-Allow-Origin": ""}},
+		{"/api/items/2", map[string]string{"Link": "", "Access-Control-Allow-Origin": ""}},
+	}
+	for _, tt := range tests {
+		w := get(tt.path)
+		if w.Code != 200 {
+			t.Fatalf("%s: status %d", tt.path, w.Code)
+		}
+		for name, want := range tt.want {
+			if got := w.Header().Get(name); got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.path, name, got, want)
+			}
+		}
+	}
+}
+
+func TestCheckHeader(t *testing.T) {
+	tests := []struct {
+		name, value string
+		problem     string
+	}{
+		{"Link", "</static/app.css>; rel=preload; as=style", ""},
+		{"x-robots-tag", "noindex", ""},
+		{"Access-Control-Allow-Origin", "*", ""},
+		{"Set-Cookie", "session=stolen", "is not one of"},
+		{"Content-Security-Policy", "default-src *", "is not one of"},
+		{"Cache-Control", "no-store", "is not one of"},
+		{"Link", "<x>\r\nSet-Cookie: a=b", "control character"},
+		{"Link", "<x>\nLocation: /evil", "control character"},
+		{"X-Robots-Tag", strings.Repeat("a", maxItemHeader+1), "longer than"},
+	}
+	for _, tt := range tests {
+		got := checkHeader(tt.name, tt.value)
+		if tt.problem == "" && got != "" || !strings.Contains(got, tt.problem) {
+			t.Errorf("checkHeader(%q, %q) = %q, want %q", tt.name, truncate(tt.value, 20), got, tt.problem)
+		}
+	}
+
+	// Unsafe headers fail validation, and are skipped if one gets through.
+	it := testItem(1, "Unsafe")
+	it.Headers = map[string]string{"Set-Cookie": "a=b", "X-Robots-Tag": "noindex"}
+	if msgs := checkItem(&it); len(msgs) != 1 || !strings.Contains(msgs[0], "Set-Cookie") {
+		t.Errorf("checkItem = %q, want the Set-Cookie problem", msgs)
+	}
+	w := httptest.NewRecorder()
+	setItemHeaders(w, &it)
+	if w.Header().Get("Set-Cookie") != "" || w.Header().Get("X-Robots-Tag") != "noindex" {
+		t.Errorf("setItemHeaders set %v", w.Header())
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// pinger is implemented by stores that can check their backing data is
+// reachable.
+type pinger interface {
+	Ping() error
+}
+
+func (s *jsonStore) Ping() error {
+	f, err := s.open()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (s *sqliteStore) Ping() error { return s.db.Ping() }
+
+// healthzHandler reports that the process is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether this instance can serve traffic: templates
+// parsed, items loaded and the data store readable.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ok := true
+	fail := func(name string, err error) {
+		checks[name] = err.Error()
+		ok = false
+	}
+	if tmpl == nil {
+		fail("templates", errors.New("not parsed"))
+	} else {
+		checks["templates"] = "ok"
+	}
+	if current() == nil {
+		fail("items", errors.New("not loaded"))
+	} else {
+		checks["items"] = "ok"
+	}
+	if p, isPinger := store.(pinger); isPinger {
+		if err := p.Ping(); err != nil {
+			fail("store", err)
+		} else {
+			checks["store"] = "ok"
+		}
+	}
+
+	status := http.StatusOK
+	checks["status"] = "ok"
+	if !ok {
+		status = http.StatusServiceUnavailable
+		checks["status"] = "unavailable"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, checks)
+}
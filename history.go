@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Revision actions.
+const (
+	RevCreate   = "create"
+	RevUpdate   = "update"
+	RevDelete   = "delete"
+	RevImport   = "import"
+	RevPublish  = "publish"
+	RevSync     = "sync"
+	RevRollback = "rollback"
+	RevRestore  = "restore"
+	RevReorder  = "reorder"
+)
+
+// Revision is one recorded change to one item: who made it, when, the
+// fields it changed and the item before and after. Before is nil for a
+// new item and After is nil for a deleted one, so rolling back to a
+// revision means restoring its After.
+type Revision struct {
+	ID      int64     `json:"id"`
+	ItemID  int       `json:"item_id"`
+	At      time.Time `json:"at"`
+	By      string    `json:"by"`
+	Action  string    `json:"action"`
+	Note    string    `json:"note,omitempty"`
+	Changes []string  `json:"changes,omitempty"`
+	Before  *Item     `json:"before,omitempty"`
+	After   *Item     `json:"after,omitempty"`
+}
+
+// Title is the item's title as of the revision.
+func (rev Revision) Title() string {
+	if rev.After != nil {
+		return rev.After.KeywordTitle
+	}
+	if rev.Before != nil {
+		return rev.Before.KeywordTitle
+	}
+	return ""
+}
+
+// revisionStore is implemented by repositories that keep item history.
+// AddRevision assigns the revision's ID; Revisions lists one item's
+// revisions, or every item's for id 0, oldest first.
+type revisionStore interface {
+	AddRevision(rev *Revision) error
+	Revisions(itemID int) ([]Revision, error)
+}
+
+// putItem stores it and records the change as a revision by who.
+func putItem(repo ItemRepository, who, action string, it Item) error {
+	before, err := repo.Get(it.ID)
+	existed := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err := repo.Put(it); err != nil {
+		return err
+	}
+	rev := Revision{ItemID: it.ID, By: who, Action: action, After: &it}
+	if existed {
+		rev.Before = &before
+		rev.Changes = itemDiff(before, it)
+		if len(rev.Changes) == 0 {
+			return nil
+		}
+		itemRenamed(repo, before, it)
+	}
+	recordRevision(repo, rev)
+	return nil
+}
+
+// deleteItem deletes item id and records it as a revision by who.
+func deleteItem(repo ItemRepository, who string, id int) error {
+	before, err := repo.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := repo.Delete(id); err != nil {
+		return err
+	}
+	recordRevision(repo, Revision{ItemID: id, By: who, Action: RevDelete, Before: &before})
+	return nil
+}
+
+// recordRevision adds rev to repo's history when it keeps one. The write
+// it describes has already happened, so a failure is logged rather than
+// undoing it.
+func recordRevision(repo ItemRepository, rev Revision) {
+	rs, ok := repo.(revisionStore)
+	if !ok {
+		return
+	}
+	rev.At = time.Now().UTC()
+	if err := rs.AddRevision(&rev); err != nil {
+		log.Printf("history: item %d: %v", rev.ItemID, err)
+	}
+}
+
+// revisions returns repo's history, or an error when it keeps none.
+func revisions(repo ItemRepository, itemID int) ([]Revision, error) {
+	rs, ok := repo.(revisionStore)
+	if !ok {
+		return nil, errors.New("store does not keep history")
+	}
+	return rs.Revisions(itemID)
+}
+
+// rollbackItem restores item id to how it was after revision revID: its
+// After, or deleted if that revision deleted it. Revision 0 is the item
+// as it was before its first recorded change.
+func rollbackItem(repo ItemRepository, who string, id int, revID int64) error {
+	all, err := revisions(repo, id)
+	if err != nil {
+		return err
+	}
+	if revID == 0 && len(all) > 0 {
+		return restore(repo, who, id, all[0].Before, "to before its first recorded change")
+	}
+	i := slices.IndexFunc(all, func(r Revision) bool { return r.ID == revID })
+	if i < 0 {
+		return fmt.Errorf("item %d has no revision %d: %w", id, revID, ErrNotFound)
+	}
+	return restore(repo, who, id, all[i].After, fmt.Sprintf("to revision %d", revID))
+}
+
+// rollbackCatalog undoes every change made after revision revID, putting
+// each item it touched back as it was before the first of them; revision
+// 0 undoes every recorded change. It returns how many items changed.
+func rollbackCatalog(repo ItemRepository, who string, revID int64) (int, error) {
+	all, err := revisions(repo, 0)
+	if err != nil {
+		return 0, err
+	}
+	if revID != 0 && !slices.ContainsFunc(all, func(r Revision) bool { return r.ID == revID }) {
+		return 0, fmt.Errorf("no revision %d: %w", revID, ErrNotFound)
+	}
+	// The Before of an item's first later revision is its state at revID.
+	var order []int
+	state := map[int]*Item{}
+	for _, r := range all {
+		if r.ID <= revID {
+			continue
+		}
+		if _, seen := state[r.ItemID]; !seen {
+			state[r.ItemID] = r.Before
+			order = append(order, r.ItemID)
+		}
+	}
+	note := fmt.Sprintf("catalog to revision %d", revID)
+	for _, id := range order {
+		if err := restore(repo, who, id, state[id], note); err != nil {
+			return 0, fmt.Errorf("item %d: %w", id, err)
+		}
+	}
+	return len(order), nil
+}
+
+// restore makes item id match to, deleting it when to is nil, and
+// records that as a rollback.
+func restore(repo ItemRepository, who string, id int, to *Item, note string) error {
+	before, err := repo.Get(id)
+	existed := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	rev := Revision{ItemID: id, By: who, Action: RevRollback, Note: note}
+	switch {
+	case to == nil && !existed:
+		return nil
+	case to == nil:
+		if err := repo.Delete(id); err != nil {
+			return err
+		}
+		rev.Before = &before
+	default:
+		if err := repo.Put(*to); err != nil {
+			return err
+		}
+		rev.After = to
+		if existed {
+			rev.Before = &before
+			rev.Changes = itemDiff(before, *to)
+		}
+	}
+	recordRevision(repo, rev)
+	return nil
+}
+
+// actor names who is behind an admin request, for the history.
+func actor(r *http.Request) string {
+	if basicAdmin(r) {
+		return adminUser
+	}
+	if u, ok := currentUser(r); ok {
+		return u.Email
+	}
+	return "unknown"
+}
+
+// adminHistoryHandler lists the whole catalog's revisions, newest first.
+func adminHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	renderHistory(w, r, 0, "History | Admin")
+}
+
+// adminItemHistoryHandler lists one item's revisions, newest first.
+func adminItemHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	renderHistory(w, r, id, fmt.Sprintf("Item %d history | Admin", id))
+}
+
+func renderHistory(w http.ResponseWriter, r *http.Request, id int, title string) {
+	all, err := revisions(store, id)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	slices.Reverse(all)
+	data := map[string]interface{}{
+		"Title":     title,
+		"ItemID":    id,
+		"Revisions": all,
+		"CSRF":      csrfToken(w, r),
+	}
+	if err := render(w, "admin_history.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// adminRollbackItemHandler restores {id} to the posted rev.
+func adminRollbackItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	rev, err := strconv.ParseInt(r.PostFormValue("rev"), 10, 64)
+	if err != nil {
+		http.Error(w, "rev must be a revision number", http.StatusBadRequest)
+		return
+	}
+	before, _ := store.Get(id)
+	if err := rollbackItem(store, actor(r), id, rev); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	after, _ := store.Get(id)
+	audit(r, "item.rollback", "item "+strconv.Itoa(id), before, after)
+	log.Printf("admin: rolled item %d back to revision %d", id, rev)
+	afterWrite(w, r)
+}
+
+// adminRollbackCatalogHandler undoes every change after {rev}.
+func adminRollbackCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	rev, err := strconv.ParseInt(r.PathValue("rev"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	n, err := rollbackCatalog(store, actor(r), rev)
+	if err != nil {
+		storeError(w, r, err)
+		return
+	}
+	audit(r, "catalog.rollback", "revision "+strconv.FormatInt(rev, 10), nil, map[string]any{"revision": rev, "items": n})
+	log.Printf("admin: rolled the catalog back to revision %d (%d items)", rev, n)
+	afterWrite(w, r)
+}
+
+// revisionsPath is where the JSON store keeps history, one revision per
+// line so recording one only appends.
+func (s *jsonStore) revisionsPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".revisions.ndjson"
+}
+
+func (s *jsonStore) readRevisions() ([]Revision, error) {
+	data, err := os.ReadFile(s.revisionsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Revision
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var rev Revision
+		if err := json.Unmarshal(sc.Bytes(), &rev); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", s.revisionsPath(), line, err)
+		}
+		out = append(out, rev)
+	}
+	return out, sc.Err()
+}
+
+func (s *jsonStore) Revisions(itemID int) ([]Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readRevisions()
+	if err != nil || itemID == 0 {
+		return all, err
+	}
+	return slices.DeleteFunc(all, func(r Revision) bool { return r.ItemID != itemID }), nil
+}
+
+func (s *jsonStore) AddRevision(rev *Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readRevisions()
+	if err != nil {
+		return err
+	}
+	rev.ID = 1
+	if len(all) > 0 {
+		rev.ID = all[len(all)-1].ID + 1
+	}
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.revisionsPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Revisions are stored as JSON documents, with the item broken out so
+// one item's history is a lookup.
+func (s *sqliteStore) Revisions(itemID int) ([]Revision, error) {
+	rows, err := s.db.Query(`SELECT data FROM revisions WHERE ? = 0 OR item_id = ? ORDER BY id`, itemID, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Revision
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var rev Revision
+		if err := json.Unmarshal([]byte(data), &rev); err != nil {
+			return nil, err
+		}
+		out = append(out, rev)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) AddRevision(rev *Revision) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO revisions (item_id, created_at, data) VALUES (?, ?, '')`, rev.ItemID, rev.At)
+	if err != nil {
+		return err
+	}
+	if rev.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE revisions SET data = ? WHERE id = ?`, string(data), rev.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
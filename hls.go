@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hlsRendition is one bitrate ladder step of the adaptive stream.
+type hlsRendition struct {
+	Height  int
+	Bitrate string
+}
+
+var hlsRenditions = []hlsRendition{
+	{Height: 360, Bitrate: "800k"},
+	{Height: 720, Bitrate: "2800k"},
+}
+
+// hlsScanInterval is how often the transcoder looks for new videos.
+const hlsScanInterval = time.Minute
+
+// hlsTimeout bounds one video's transcode, so a hung ffmpeg can't stall
+// the worker for good.
+const hlsTimeout = time.Hour
+
+var (
+	hlsDir    string // cache of renditions; empty disables HLS
+	ffmpegBin string
+
+	hlsMu    sync.Mutex
+	hlsReady = map[string]bool{} // videoKey -> renditions on disk
+)
+
+// ffmpegCmd returns an ffmpeg command that is killed once timeout has
+// passed, and the func releasing its context, to call when it is done.
+func ffmpegCmd(timeout time.Duration, args ...string) (*exec.Cmd, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return exec.CommandContext(ctx, ffmpegBin, args...), cancel
+}
+
+// videoKey names the cache directory of a video path, for HLS renditions
+// and thumbnails alike.
+func videoKey(videoPath string) string {
+	sum := sha256.Sum256([]byte(videoPath))
+	return hex.EncodeToString(sum[:8])
+}
+
+// hlsURL is the master playlist URL of videoPath, or "" when HLS is off
+// or the video hasn't been transcoded yet.
+func hlsURL(videoPath string) string {
+	if hlsDir == "" {
+		return ""
+	}
+	key := videoKey(videoPath)
+	hlsMu.Lock()
+	defer hlsMu.Unlock()
+	if !hlsReady[key] {
+		return ""
+	}
+	return "/hls/" + key + "/master.m3u8"
+}
+
+// hlsWorker transcodes every video lacking up-to-date renditions, then
+// rescans every hlsScanInterval for items added since.
+func hlsWorker() {
+	for {
+		for _, it := range current().items {
+			for _, vp := range it.VideoPath {
+				if isRemoteMedia(vp) {
+					continue
+				}
+				if err := ensureHLS(vp); err != nil {
+					log.Printf("hls: %s: %v", vp, err)
+				}
+			}
+		}
+		time.Sleep(hlsScanInterval)
+	}
+}
+
+// ensureHLS transcodes videoPath unless its cached renditions were made
+// from the current file, judged by size and modification time.
+func ensureHLS(videoPath string) error {
+	key := videoKey(videoPath)
+	hlsMu.Lock()
+	ready := hlsReady[key]
+	hlsMu.Unlock()
+
+	src, stamp, cleanup, err := localVideo(videoPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	out := filepath.Join(hlsDir, key)
+	if old, err := os.ReadFile(filepath.Join(out, "source")); err == nil && string(old) == stamp {
+		if !ready {
+			hlsMu.Lock()
+			hlsReady[key] = true
+			hlsMu.Unlock()
+		}
+		return nil
+	}
+
+	start := time.Now()
+	tmp := out + ".tmp"
+	os.RemoveAll(tmp)
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	cmd, cancel := ffmpegCmd(hlsTimeout, hlsArgs(src, tmp)...)
+	defer cancel()
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(msg)))
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "source"), []byte(stamp), 0o644); err != nil {
+		return err
+	}
+	hlsMu.Lock()
+	defer hlsMu.Unlock()
+	os.RemoveAll(out)
+	if err := os.Rename(tmp, out); err != nil {
+		return err
+	}
+	hlsReady[key] = true
+	log.Printf("hls: transcoded %s in %s", videoPath, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// hlsArgs builds the ffmpeg command line writing every rendition,
+// its media playlist and master.m3u8 into dir.
+func hlsArgs(src, dir string) []string {
+	n := len(hlsRenditions)
+	var split, streams strings.Builder
+	fmt.Fprintf(&split, "[0:v]split=%d", n)
+	for i := range hlsRenditions {
+		fmt.Fprintf(&split, "[s%d]", i)
+	}
+	for i, r := range hlsRenditions {
+		fmt.Fprintf(&split, ";[s%d]scale=-2:%d[v%d]", i, r.Height, i)
+		if i > 0 {
+			streams.WriteByte(' ')
+		}
+		fmt.Fprintf(&streams, "v:%d", i)
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", src, "-an",
+		"-filter_complex", split.String()}
+	for i, r := range hlsRenditions {
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i),
+			fmt.Sprintf("-c:v:%d", i), "libx264", fmt.Sprintf("-b:v:%d", i), r.Bitrate)
+	}
+	return append(args,
+		"-preset", "veryfast", "-g", "48", "-sc_threshold", "0",
+		"-f", "hls", "-hls_time", "6", "-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "%v_%03d.ts"),
+		"-master_pl_name", "master.m3u8",
+		"-var_stream_map", streams.String(),
+		filepath.Join(dir, "%v.m3u8"))
+}
+
+// localVideo returns an on-disk path ffmpeg can read for videoPath, with a
+// stamp of its size and modification time. Videos served from the
+// embedded copy are written to a temporary file removed by cleanup.
+func localVideo(videoPath string) (path, stamp string, cleanup func(), err error) {
+	cleanup = func() {}
+	name, ok := staticFile(videoPath)
+	if !ok {
+		return "", "", cleanup, fmt.Errorf("not a /static/ path")
+	}
+	f, err := staticFS.Open(name)
+	if err != nil {
+		return "", "", cleanup, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", "", cleanup, err
+	}
+	stamp = fileStamp(fi)
+	if osf, ok := f.(*os.File); ok {
+		return osf.Name(), stamp, cleanup, nil
+	}
+	tmp, err := os.CreateTemp("", "video-*"+filepath.Ext(name))
+	if err != nil {
+		return "", "", cleanup, err
+	}
+	cleanup = func() { os.Remove(tmp.Name()) }
+	_, err = io.Copy(tmp, f)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", "", func() {}, err
+	}
+	return tmp.Name(), stamp, cleanup, nil
+}
+
+// videoStamp returns the stamp localVideo would give videoPath, without
+// copying the video out of an embedded file system.
+func videoStamp(videoPath string) (string, error) {
+	name, ok := staticFile(videoPath)
+	if !ok {
+		return "", fmt.Errorf("not a /static/ path")
+	}
+	fi, err := fs.Stat(staticFS, name)
+	if err != nil {
+		return "", err
+	}
+	return fileStamp(fi), nil
+}
+
+// fileStamp identifies a version of a file by its size and modification
+// time.
+func fileStamp(fi fs.FileInfo) string {
+	return fmt.Sprintf("%d %d", fi.Size(), fi.ModTime().UnixNano())
+}
+
+// hlsHandler serves /hls/{key}/{file} playlists and segments from the
+// cache.
+func hlsHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := startSpan(r.Context(), "media.hls")
+	defer span.End()
+	key, file := r.PathValue("key"), r.PathValue("file")
+	if _, err := hex.DecodeString(key); err != nil || !safePath(file) || strings.ContainsRune(file, '/') {
+		notFound(w, r)
+		return
+	}
+	if needMediaPass(w, r) {
+		return
+	}
+	switch filepath.Ext(file) {
+	case ".m3u8":
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+	case ".ts":
+		w.Header().Set("Content-Type", "video/mp2t")
+		if protectedMedia() {
+			w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+	default:
+		notFound(w, r)
+		return
+	}
+	name := filepath.Join(hlsDir, key, file)
+	if _, err := os.Stat(name); err != nil {
+		notFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, name)
+}
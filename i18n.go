@@ -0,0 +1,225 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message catalogs map an English template string to its translation.
+// English is the source language and needs no catalog of its own.
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// localeCookie remembers the language a visitor picked, so unprefixed
+// links keep them in it.
+const (
+	localeCookie = "bw_lang"
+	localeMaxAge = 365 * 24 * time.Hour
+)
+
+// locales lists the supported languages, the default first, and
+// messages holds each one's catalog.
+var (
+	locales  = []string{"en"}
+	messages = map[string]map[string]string{}
+)
+
+// setLocales enables the comma-separated languages in list. Every one but
+// English must have a catalog in locales/.
+func setLocales(list string) error {
+	var langs []string
+	cats := map[string]map[string]string{}
+	for _, l := range strings.Split(list, ",") {
+		if l = strings.ToLower(strings.TrimSpace(l)); l == "" || slices.Contains(langs, l) {
+			continue
+		}
+		langs = append(langs, l)
+		data, err := localeFiles.ReadFile("locales/" + l + ".json")
+		if err != nil {
+			if l == "en" {
+				continue
+			}
+			return fmt.Errorf("no message catalog for locale %q", l)
+		}
+		var cat map[string]string
+		if err := json.Unmarshal(data, &cat); err != nil {
+			return fmt.Errorf("locales/%s.json: %v", l, err)
+		}
+		cats[l] = cat
+	}
+	if len(langs) == 0 {
+		return fmt.Errorf("at least one locale is required")
+	}
+	locales, messages = langs, cats
+	return nil
+}
+
+// translate returns key in lang, formatted with args when given. Missing
+// translations fall back to the English key.
+func translate(lang, key string, args ...any) string {
+	if s, ok := messages[lang][key]; ok && s != "" {
+		key = s
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(key, args...)
+	}
+	return key
+}
+
+// localeWriter carries the request's language, and its unprefixed URL
+// for the language switcher, to render.
+type localeWriter struct {
+	http.ResponseWriter
+	lang string
+	page string
+}
+
+func (l *localeWriter) Unwrap() http.ResponseWriter { return l.ResponseWriter }
+
+func (l *localeWriter) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writerLocale returns the language withLocale picked for w.
+func writerLocale(w http.ResponseWriter) string {
+	if l, ok := findWriter[*localeWriter](w); ok {
+		return l.lang
+	}
+	return locales[0]
+}
+
+// withLocale picks each request's language: a /es/ style path prefix,
+// which is stripped and remembered in a cookie, then that cookie, then
+// Accept-Language, then the default.
+func withLocale(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := ""
+		if l, rest, ok := localePrefix(r.URL.Path); ok {
+			lang = l
+			r2 := new(http.Request)
+			*r2 = *r
+			u := *r.URL
+			u.Path, u.RawPath = rest, ""
+			r2.URL = &u
+			r = r2
+			http.SetCookie(w, &http.Cookie{
+				Name:     localeCookie,
+				Value:    lang,
+				Path:     "/",
+				MaxAge:   int(localeMaxAge / time.Second),
+				HttpOnly: true,
+				Secure:   isHTTPS(r),
+				SameSite: http.SameSiteLaxMode,
+			})
+		} else if c, err := r.Cookie(localeCookie); err == nil && slices.Contains(locales, c.Value) {
+			lang = c.Value
+		} else {
+			lang = acceptLanguage(r.Header.Get("Accept-Language"))
+		}
+		if len(locales) > 1 {
+			w.Header().Add("Vary", "Accept-Language, Cookie")
+		}
+		w.Header().Set("Content-Language", lang)
+		h.ServeHTTP(&localeWriter{w, lang, r.URL.RequestURI()}, r)
+	})
+}
+
+// localePrefix splits a leading supported language off path, so
+// "/es/items/x" gives "es" and "/items/x".
+func localePrefix(path string) (lang, rest string, ok bool) {
+	seg, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !slices.Contains(locales, seg) {
+		return "", path, false
+	}
+	return seg, "/" + rest, true
+}
+
+// acceptLanguage returns the supported language the header rates
+// highest, matching on the primary subtag, or the default.
+func acceptLanguage(header string) string {
+	type pref struct {
+		lang string
+		q    float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if q > 0 && slices.Contains(locales, primary) {
+			prefs = append(prefs, pref{primary, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	if len(prefs) > 0 {
+		return prefs[0].lang
+	}
+	return locales[0]
+}
+
+// LocaleLink is one entry of the language switcher.
+type LocaleLink struct {
+	Lang    string
+	URL     string
+	Current bool
+}
+
+// localeLinks points every supported language at the page being
+// written to w.
+func localeLinks(w http.ResponseWriter) []LocaleLink {
+	lw, ok := findWriter[*localeWriter](w)
+	if !ok || len(locales) < 2 || exporting {
+		return nil
+	}
+	links := make([]LocaleLink, len(locales))
+	for i, l := range locales {
+		links[i] = LocaleLink{Lang: l, URL: "/" + l + lw.page, Current: l == lw.lang}
+	}
+	return links
+}
+
+// ItemText is a translation of an item's title and paragraphs.
+type ItemText struct {
+	KeywordTitle string   `json:"keyword_title,omitempty"`
+	Texts        []string `json:"texts,omitempty"`
+}
+
+// localized returns it with its title and texts in lang where a
+// translation exists.
+func (it Item) localized(lang string) Item {
+	tr, ok := it.Translations[lang]
+	if !ok {
+		return it
+	}
+	if tr.KeywordTitle != "" {
+		it.KeywordTitle = tr.KeywordTitle
+	}
+	if len(tr.Texts) > 0 {
+		it.Texts = tr.Texts
+	}
+	return it
+}
+
+// localizeAll returns a copy of items with each one localized to lang.
+func localizeAll(items []Item, lang string) []Item {
+	out := make([]Item, len(items))
+	for i, it := range items {
+		out[i] = it.localized(lang)
+	}
+	return out
+}
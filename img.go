@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// maxImageSide bounds requested widths and heights.
+const maxImageSide = 4096
+
+// imageSides are the widths and heights /img/ makes. A requested size is
+// rounded up to the next of them, so the cache holds a few copies of an
+// image however many sizes clients ask for.
+var imageSides = []int{100, 200, 320, 480, 640, 800, 1024, 1280, 1600, 1920, 2560, 3200, maxImageSide}
+
+// imageEncodeTimeout bounds one WebP encode by ffmpeg.
+const imageEncodeTimeout = 30 * time.Second
+
+var (
+	imageCacheDir string
+	imageLocks    keyLocks
+)
+
+// imageTypes maps an output format to its Content-Type.
+var imageTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// imageHandler serves /img/{path...}?w=&h=&format=, a resized and
+// re-encoded copy of a static/ image. w and h bound the output, keeping the
+// aspect ratio and never upscaling, after rounding up to one of
+// imageSides; format is jpeg, png or webp and defaults to the source's.
+// Results are cached on disk by content.
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := startSpan(r.Context(), "media.image")
+	defer span.End()
+	name := r.PathValue("path")
+	span.set("image", name)
+	q := r.URL.Query()
+	width, errW := imageSide(q.Get("w"))
+	height, errH := imageSide(q.Get("h"))
+	if errW != nil || errH != nil {
+		http.Error(w, "w and h must be between 1 and "+strconv.Itoa(maxImageSide), http.StatusBadRequest)
+		return
+	}
+	format := q.Get("format")
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if _, ok := imageTypes[format]; format != "" && !ok {
+		http.Error(w, "format must be jpeg, png or webp", http.StatusBadRequest)
+		return
+	}
+	if format == "webp" {
+		if _, err := exec.LookPath(ffmpegBin); err != nil {
+			http.Error(w, "webp output needs ffmpeg on the server", http.StatusNotImplemented)
+			return
+		}
+	}
+	if !safePath(name) {
+		notFound(w, r)
+		return
+	}
+	f, err := staticFS.Open(name)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		notFound(w, r)
+		return
+	}
+
+	key := fmt.Sprintf("%s|%d|%d|%s|%d|%d", name, width, height, format, fi.Size(), fi.ModTime().UnixNano())
+	sum := sha256.Sum256([]byte(key))
+	cached := hex.EncodeToString(sum[:16])
+	ct, err := cachedImage(cached, f, width, height, format)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			http.Error(w, name+" is not a supported image", http.StatusUnsupportedMediaType)
+			return
+		}
+		serverError(w, fmt.Errorf("image %s: %w", name, err))
+		return
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Cache-Control", "public, max-age=604800")
+	serveFileETag(w, r, os.DirFS(imageCacheDir), cached)
+}
+
+// imageSide parses a w or h parameter, rounded up to one of imageSides;
+// empty means unbounded.
+func imageSide(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxImageSide {
+		return 0, fmt.Errorf("bad size %q", v)
+	}
+	i, _ := slices.BinarySearch(imageSides, n)
+	return imageSides[i], nil
+}
+
+// cachedImage makes sure the cache holds the encoded image named cached,
+// returning its Content-Type. The type is kept in a sidecar file since it
+// isn't known before decoding when format is empty. Each image is made
+// once, however many requests for it arrive together.
+func cachedImage(cached string, src io.Reader, width, height int, format string) (string, error) {
+	path := filepath.Join(imageCacheDir, cached)
+	if ct, ok := imageCached(path); ok {
+		return ct, nil
+	}
+	defer imageLocks.lock(cached)()
+	if ct, ok := imageCached(path); ok {
+		return ct, nil
+	}
+	img, srcFormat, err := image.Decode(src)
+	if err != nil {
+		return "", err
+	}
+	if format == "" {
+		format = srcFormat
+		if _, ok := imageTypes[format]; !ok {
+			format = "png" // e.g. gif sources
+		}
+	}
+	img = fitImage(img, width, height)
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, img, format); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(imageCacheDir, 0o755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return "", err
+	}
+	ct := imageTypes[format]
+	return ct, os.WriteFile(path+".type", []byte(ct), 0o644)
+}
+
+// imageCached returns the Content-Type of the cached image at path, if
+// it is there.
+func imageCached(path string) (string, bool) {
+	ct, err := os.ReadFile(path + ".type")
+	if err != nil {
+		return "", false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return string(ct), true
+}
+
+// fitImage scales img down to fit within width x height, either of which
+// may be 0 for no bound.
+func fitImage(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	scale := 1.0
+	if width > 0 && width < sw {
+		scale = float64(width) / float64(sw)
+	}
+	if height > 0 && float64(height) < float64(sh)*scale {
+		scale = float64(height) / float64(sh)
+	}
+	if scale == 1 {
+		return img
+	}
+	dw, dh := max(int(float64(sw)*scale+0.5), 1), max(int(float64(sh)*scale+0.5), 1)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// encodeImage writes img as format. Go has no WebP encoder, so WebP is
+// produced by piping a PNG through ffmpeg.
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 82})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "webp":
+		var in bytes.Buffer
+		if err := png.Encode(&in, img); err != nil {
+			return err
+		}
+		var stderr bytes.Buffer
+		cmd, cancel := ffmpegCmd(imageEncodeTimeout, "-hide_banner", "-loglevel", "error",
+			"-f", "png_pipe", "-i", "pipe:0", "-c:v", "libwebp", "-quality", "80", "-f", "webp", "pipe:1")
+		defer cancel()
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = &in, w, &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("ffmpeg webp: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
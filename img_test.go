@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestImageSide(t *testing.T) {
+	tests := []struct {
+		v    string
+		want int
+		ok   bool
+	}{
+		{"", 0, true},
+		{"1", 100, true},
+		{"100", 100, true},
+		{"201", 320, true},
+		{"1919", 1920, true},
+		{"4000", maxImageSide, true},
+		{"4096", maxImageSide, true},
+		{"0", 0, false},
+		{"4097", 0, false},
+		{"wide", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := imageSide(tt.v)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("imageSide(%q) = %d, %v; want %d", tt.v, got, err, tt.want)
+		}
+	}
+
+	// Every size in a bucket shares one cached image.
+	seen := map[int]bool{}
+	for n := 1; n <= maxImageSide; n++ {
+		side, _ := imageSide(strconv.Itoa(n))
+		seen[side] = true
+	}
+	if len(seen) != len(imageSides) {
+		t.Errorf("%d distinct sides, want %d", len(seen), len(imageSides))
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// importListSep separates the entries of a list field inside one CSV cell,
+// e.g. "intro|second paragraph".
+const importListSep = "|"
+
+// importRecord is one row of an import file: the item fields it sets,
+// keyed by their JSON names, and where it came from for messages.
+type importRecord struct {
+	where  string
+	fields map[string]any
+}
+
+// importChange is what importing one record does to the catalog.
+type importChange struct {
+	where string
+	item  Item
+	added bool
+	diff  []string // changed fields, empty when the record changes nothing
+}
+
+// importCmd merges items from each FILE into the store. FILE is a JSON
+// array like items.json, one item per line (.ndjson), a spreadsheet
+// exported as CSV with a header row (.csv) or a YAML list (.yaml, .yml).
+// Columns are item JSON field names unless -import-map says otherwise.
+//
+// Records are matched to stored items by id, then by slug (or the slug
+// of their title), and only the fields a record sets are changed, so a
+// sheet can be re-imported to update the catalog rather than duplicate
+// it. Records that match nothing become new items. With -dry-run the
+// changes are printed and nothing is written.
+func importCmd(cfg Config) error {
+	if len(cfg.Args) == 0 {
+		return errors.New("usage: import [flags] FILE...")
+	}
+	mapping, err := parseFieldMap("import-map", cfg.ImportMap)
+	if err != nil {
+		return err
+	}
+	existing, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, path := range cfg.Args {
+		recs, err := readImport(path, mapping)
+		if err != nil {
+			return err
+		}
+		merged, changes, err := mergeImport(existing, recs, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if err := checkItems(merged, nil); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		added, updated, same := 0, 0, 0
+		for _, ch := range changes {
+			switch {
+			case ch.added:
+				added++
+				fmt.Printf("+ %s: new item %d %q\n", ch.where, ch.item.ID, ch.item.KeywordTitle)
+			case len(ch.diff) > 0:
+				updated++
+				fmt.Printf("~ %s: item %d %q\n", ch.where, ch.item.ID, ch.item.KeywordTitle)
+				for _, d := range ch.diff {
+					fmt.Printf("    %s\n", d)
+				}
+			default:
+				same++
+				if cfg.DryRun {
+					fmt.Printf("= %s: item %d unchanged\n", ch.where, ch.item.ID)
+				}
+			}
+			if cfg.DryRun || (!ch.added && len(ch.diff) == 0) {
+				continue
+			}
+			if err := putItem(store, importer(), RevImport, ch.item); err != nil {
+				return fmt.Errorf("%s: item %d: %w", ch.where, ch.item.ID, err)
+			}
+		}
+		if cfg.DryRun {
+			log.Printf("Dry run of %s: %d would be added, %d updated, %d unchanged", path, added, updated, same)
+			continue
+		}
+		existing = merged
+		log.Printf("Imported %s: %d added, %d updated, %d unchanged", path, added, updated, same)
+	}
+	return nil
+}
+
+// importer names who runs an import, for the history.
+func importer() string {
+	return cmp.Or(os.Getenv("USER"), "cli") + " (import)"
+}
+
+// mergeImport applies recs to a copy of existing. It returns the whole
+// catalog as it would be afterwards and one change per record.
+func mergeImport(existing []Item, recs []importRecord, now time.Time) ([]Item, []importChange, error) {
+	merged := slices.Clone(existing)
+	byID := map[int]int{}
+	bySlug := map[string]int{}
+	next := 1
+	index := func(i int) {
+		byID[merged[i].ID] = i
+		bySlug[cmp.Or(merged[i].Slug, slugify(merged[i].KeywordTitle))] = i
+		next = max(next, merged[i].ID+1)
+	}
+	for i := range merged {
+		index(i)
+	}
+	// Explicit IDs are reserved up front so fresh ones never collide.
+	for _, rec := range recs {
+		if id, ok := rec.fields["id"].(int); ok {
+			next = max(next, id+1)
+		}
+	}
+
+	changes := make([]importChange, 0, len(recs))
+	for _, rec := range recs {
+		data, err := json.Marshal(rec.fields)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", rec.where, err)
+		}
+		var key struct {
+			ID           int    `json:"id"`
+			Slug         string `json:"slug"`
+			KeywordTitle string `json:"keyword_title"`
+		}
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", rec.where, err)
+		}
+		i, found := byID[key.ID]
+		if key.ID == 0 {
+			i, found = bySlug[cmp.Or(key.Slug, slugify(key.KeywordTitle))]
+		}
+		var old, it Item
+		if found {
+			old = merged[i]
+			it = cloneItem(old)
+		}
+		// Unmarshalling onto the stored item only touches the fields the
+		// record has.
+		if err := json.Unmarshal(data, &it); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", rec.where, err)
+		}
+		ch := importChange{where: rec.where, added: !found}
+		if found {
+			it.ID = old.ID
+			ch.diff = itemDiff(old, it)
+			if len(ch.diff) > 0 {
+				it.UpdatedAt = &now
+			}
+			merged[i] = it
+		} else {
+			if it.ID == 0 {
+				it.ID = next
+				next++
+			}
+			if it.CreatedAt == nil {
+				it.CreatedAt = &now
+			}
+			merged = append(merged, it)
+			i = len(merged) - 1
+		}
+		index(i)
+		ch.item = it
+		changes = append(changes, ch)
+	}
+	return merged, changes, nil
+}
+
+// cloneItem copies it deeply enough that unmarshalling into the copy
+// leaves the original alone: json reuses slice arrays, maps and pointers
+// it finds in place.
+func cloneItem(it Item) Item {
+	it.Texts = slices.Clone(it.Texts)
+	it.VideoPath = slices.Clone(it.VideoPath)
+	it.VideoCredit = slices.Clone(it.VideoCredit)
+	it.Posters = slices.Clone(it.Posters)
+	it.Captions = slices.Clone(it.Captions)
+	it.Tags = slices.Clone(it.Tags)
+	it.Translations = maps.Clone(it.Translations)
+	it.Headers = maps.Clone(it.Headers)
+	for _, t := range []**time.Time{&it.CreatedAt, &it.UpdatedAt, &it.ExpireAt, &it.PublishAt} {
+		if *t != nil {
+			c := **t
+			*t = &c
+		}
+	}
+	return it
+}
+
+// itemDiff describes each field that differs between old and new as
+// "field: old → new", ignoring the timestamps an import maintains.
+func itemDiff(old, new Item) []string {
+	var diff []string
+	for _, ch := range itemChanges(old, new) {
+		diff = append(diff, fmt.Sprintf("%s: %s → %s", ch.Field, diffValue(ch.Before), diffValue(ch.After)))
+	}
+	return diff
+}
+
+// fieldChange is one field that differs between two versions of an
+// item, as JSON; Before or After is absent when the field was unset.
+type fieldChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// itemChanges lists each field that differs between old and new,
+// ignoring the timestamps an import maintains.
+func itemChanges(old, new Item) []fieldChange {
+	var a, b map[string]json.RawMessage
+	oa, _ := json.Marshal(old)
+	ob, _ := json.Marshal(new)
+	json.Unmarshal(oa, &a)
+	json.Unmarshal(ob, &b)
+	var changes []fieldChange
+	for _, f := range itemFields() {
+		if f == "created_at" || f == "updated_at" || bytes.Equal(a[f], b[f]) {
+			continue
+		}
+		changes = append(changes, fieldChange{Field: f, Before: a[f], After: b[f]})
+	}
+	return changes
+}
+
+func diffValue(v json.RawMessage) string {
+	if len(v) == 0 {
+		return "(none)"
+	}
+	return truncate(string(v), 60)
+}
+
+// itemFields lists Item's JSON field names in declaration order.
+func itemFields() []string {
+	t := reflect.TypeFor[Item]()
+	names := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	return names
+}
+
+// mappedField returns the item field a source column fills: the one
+// mapping names, or else the item field of that name in any case.
+func mappedField(col string, mapping map[string]string) (string, bool) {
+	if field, ok := mapping[strings.ToLower(col)]; ok {
+		return field, true
+	}
+	fields := itemFields()
+	if i := slices.IndexFunc(fields, func(f string) bool { return strings.EqualFold(f, col) }); i >= 0 {
+		return fields[i], true
+	}
+	return "", false
+}
+
+// parseFieldMap reads a column mapping flag such as -import-map,
+// "Title=keyword_title,Body=texts", into source column (lowercased) →
+// item field.
+func parseFieldMap(flag, s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		col, field, ok := strings.Cut(pair, "=")
+		col, field = strings.TrimSpace(col), strings.TrimSpace(field)
+		if !ok || col == "" || !slices.Contains(itemFields(), field) {
+			return nil, fmt.Errorf("-%s: %q should be Column=field, with field one of %s", flag, pair, strings.Join(itemFields(), ", "))
+		}
+		m[strings.ToLower(col)] = field
+	}
+	return m, nil
+}
+
+// readImport reads the records in path, picking the format by extension,
+// and renames their columns to item fields.
+func readImport(path string, mapping map[string]string) ([]importRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var recs []importRecord
+	text := false // cells are strings to convert, as in CSV
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		recs, err = readCSV(path, data)
+		text = true
+	case ".yaml", ".yml":
+		recs, err = readYAML(path, data)
+	case ".ndjson":
+		recs, err = readNDJSON(path, data)
+	default:
+		recs, err = readJSONArray(path, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range recs {
+		if err := recs[i].normalize(mapping, text); err != nil {
+			return nil, err
+		}
+	}
+	return recs, nil
+}
+
+// normalize renames columns to item fields and converts values to what
+// Item expects: numbers for id and order, booleans for pinned and lists
+// for the list fields. A single value, or a CSV cell split on |, fills a
+// list.
+func (rec *importRecord) normalize(mapping map[string]string, text bool) error {
+	out := make(map[string]any, len(rec.fields))
+	for col, v := range rec.fields {
+		field, ok := mappedField(col, mapping)
+		if !ok {
+			return fmt.Errorf("%s: unknown column %q; name an item field or map it with -import-map", rec.where, col)
+		}
+		s, isString := v.(string)
+		switch field {
+		case "id", "order":
+			if isString {
+				n, err := strconv.Atoi(strings.TrimSpace(s))
+				if err != nil {
+					return fmt.Errorf("%s: %s %q is not a number", rec.where, field, s)
+				}
+				v = n
+			} else if f, ok := v.(float64); ok {
+				v = int(f)
+			}
+		case "pinned":
+			if isString {
+				b, err := strconv.ParseBool(strings.TrimSpace(s))
+				if err != nil {
+					return fmt.Errorf("%s: pinned %q is not true or false", rec.where, s)
+				}
+				v = b
+			}
+		case "texts", "video_path", "video_credit", "posters", "tags":
+			if isString {
+				if text {
+					parts := strings.Split(s, importListSep)
+					for i := range parts {
+						parts[i] = strings.TrimSpace(parts[i])
+					}
+					v = parts
+				} else {
+					v = []string{s}
+				}
+			}
+		}
+		out[field] = v
+	}
+	rec.fields = out
+	return nil
+}
+
+// readCSV reads a header row naming the columns and one record per row
+// after it. Empty cells are left out, so a sheet can update just the
+// columns it fills in.
+func readCSV(path string, data []byte) ([]importRecord, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	header := rows[0]
+	var recs []importRecord
+	for n, row := range rows[1:] {
+		rec := importRecord{where: fmt.Sprintf("%s:%d", path, n+2), fields: map[string]any{}}
+		for i, cell := range row {
+			if i >= len(header) || strings.TrimSpace(header[i]) == "" {
+				if strings.TrimSpace(cell) != "" {
+					return nil, fmt.Errorf("%s: cell %d has no column name", rec.where, i+1)
+				}
+				continue
+			}
+			if strings.TrimSpace(cell) != "" {
+				rec.fields[strings.TrimSpace(header[i])] = cell
+			}
+		}
+		if len(rec.fields) > 0 {
+			recs = append(recs, rec)
+		}
+	}
+	return recs, nil
+}
+
+// readYAML reads a list of mappings, one per item.
+func readYAML(path string, data []byte) ([]importRecord, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	list := doc.Content[0]
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s:%d: items must be a YAML list", path, list.Line)
+	}
+	recs := make([]importRecord, 0, len(list.Content))
+	for _, n := range list.Content {
+		rec := importRecord{where: fmt.Sprintf("%s:%d", path, n.Line)}
+		if err := n.Decode(&rec.fields); err != nil {
+			return nil, fmt.Errorf("%s: %w", rec.where, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// readNDJSON reads one JSON object per line.
+func readNDJSON(path string, data []byte) ([]importRecord, error) {
+	var recs []importRecord
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		rec := importRecord{where: fmt.Sprintf("%s:%d", path, line)}
+		if err := json.Unmarshal(sc.Bytes(), &rec.fields); err != nil {
+			return nil, fmt.Errorf("%s: %w", rec.where, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, sc.Err()
+}
+
+// readJSONArray reads a JSON array of objects, like items.json.
+func readJSONArray(path string, data []byte) ([]importRecord, error) {
+	var objs []map[string]any
+	if err := json.Unmarshal(data, &objs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	recs := make([]importRecord, len(objs))
+	for i, o := range objs {
+		recs[i] = importRecord{where: fmt.Sprintf("%s item #%d", path, i+1), fields: o}
+	}
+	return recs, nil
+}
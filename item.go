@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// slugify lowercases s and joins its letter/digit runs with hyphens,
+// e.g. "Full Stack Web Design UIUX/Backend" -> "full-stack-web-design-uiux-backend".
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// itemPageHandler renders /items/{id-or-slug}.
+func itemPageHandler(w http.ResponseWriter, r *http.Request) {
+	c := current()
+	found, ok := c.lookup(r.PathValue("key"), time.Now())
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	lang := writerLocale(w)
+	loc := found.localized(lang)
+	it := &loc
+	countView(r, it.ID, false)
+	rememberSeen(w, r, it.ID)
+	data := map[string]interface{}{
+		"Title": it.KeywordTitle + " | BlendingWaves",
+		"Item":  it,
+		"Meta":  itemMeta(r, c, it),
+	}
+	// The visitor cookie is issued here so the like button and comment
+	// forms have a CSRF token to send.
+	visitor, _ := visitorID(w, r, true)
+	liked := likedBy(visitor, it.ID)
+	data["Liked"] = liked
+	token := csrfFor(visitor)
+	data["CSRF"] = token
+	data["Downloads"] = downloadTTL > 0
+	data["ShortLink"] = siteURL(r, "/s/"+it.ShortCode)
+	setItemHeaders(w, it)
+	key := pageKey{route: "item", item: it.ID}
+	if liked {
+		key.variant = "liked"
+	}
+	cachePage(w, r, key, token, func(w http.ResponseWriter) {
+		data["Likes"] = likeCount(it.ID)
+		if featureOn(w, "comments") {
+			data["Comments"] = commentThread(it.ID, token)
+		}
+		data["NewComment"] = &CommentNode{ItemID: it.ID, CSRF: token}
+		data["Commented"] = r.URL.Query().Has("commented")
+		if showViews {
+			data["Views"] = viewsOf(it.ID)
+		}
+		data["Related"] = localizeAll(c.relatedTo(it, time.Now()), lang)
+		data["Collections"] = collectionsOf(c, it.ID, time.Now(), lang)
+		renderPage(w, r, "item.html", data, "Item", "Likes", "Liked", "Comments", "Views", "Related", "Collections")
+	})
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// job is recurring work the server does in the background. run reports
+// what it did in a line for the admin, or why it failed.
+type job struct {
+	name        string
+	description string
+	schedule    jobSchedule
+	timeout     time.Duration
+	run         func(ctx context.Context) (string, error)
+
+	trigger chan struct{}
+
+	mu         sync.Mutex
+	running    bool
+	lastStart  time.Time
+	lastEnd    time.Time
+	lastResult string
+	lastError  string
+	next       time.Time
+	runs       int64
+	failures   int64
+}
+
+// jobSchedule is when a job runs: every interval after the last run, or,
+// aligned, at each multiple of it from local midnight, so @daily runs at
+// midnight and @hourly on the hour; @weekly counts from Monday's. A zero
+// interval only runs the job when it is triggered from the admin.
+type jobSchedule struct {
+	every time.Duration
+	align bool
+}
+
+// week is the interval of @weekly.
+const week = 7 * 24 * time.Hour
+
+// parseJobSchedule parses a duration such as 15m, @hourly, @daily,
+// @weekly, or 0 or off for manual runs only.
+func parseJobSchedule(s string) (jobSchedule, error) {
+	switch s {
+	case "0", "off":
+		return jobSchedule{}, nil
+	case "@hourly":
+		return jobSchedule{every: time.Hour, align: true}, nil
+	case "@daily":
+		return jobSchedule{every: 24 * time.Hour, align: true}, nil
+	case "@weekly":
+		return jobSchedule{every: week, align: true}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Second {
+		return jobSchedule{}, fmt.Errorf("schedule %q: want a duration of at least 1s, @hourly, @daily, @weekly or off", s)
+	}
+	return jobSchedule{every: d}, nil
+}
+
+func (s jobSchedule) String() string {
+	switch {
+	case s.every == 0:
+		return "manual"
+	case s.align && s.every == time.Hour:
+		return "@hourly"
+	case s.align && s.every == week:
+		return "@weekly"
+	case s.align:
+		return "@daily"
+	}
+	return "every " + s.every.String()
+}
+
+// nextAfter is the first run of s after t, or the zero time if it only
+// runs when triggered.
+func (s jobSchedule) nextAfter(t time.Time) time.Time {
+	if s.every == 0 {
+		return time.Time{}
+	}
+	if !s.align {
+		return t.Add(s.every)
+	}
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if s.every == week {
+		day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day.Add((t.Sub(day)/s.every + 1) * s.every)
+}
+
+// jobs are the scheduled jobs, in the order the admin lists them.
+var jobs = []*job{
+	{
+		name:        "analytics",
+		description: "Roll buffered view, experiment and analytics counts up into the store",
+		schedule:    jobSchedule{every: viewFlushInterval},
+		run: func(ctx context.Context) (string, error) {
+			flushViews()
+			flushExperiments()
+			flushAnalytics()
+			return "flushed", nil
+		},
+	},
+	{
+		name:        "reindex",
+		description: "Pick up changes made to the store behind the server's back, rebuilding the search index, suggestions and related items",
+		schedule:    jobSchedule{every: time.Hour},
+		run:         reindex,
+	},
+	{
+		name:        "sitemap",
+		description: "Rebuild the cached sitemap.xml",
+		schedule:    jobSchedule{every: time.Hour},
+		run:         refreshSitemap,
+	},
+	{
+		name:        "thumbnails",
+		description: "Generate missing or outdated video thumbnails",
+		schedule:    jobSchedule{every: 24 * time.Hour, align: true},
+		timeout:     time.Hour,
+		run:         regenerateThumbs,
+	},
+	{
+		name:        "digest",
+		description: "Email confirmed newsletter subscribers the items published since the last digest",
+		schedule:    jobSchedule{every: week, align: true},
+		timeout:     time.Hour,
+		run:         sendDigest,
+	},
+	{
+		name:        "links",
+		description: "Check the catalog's external links, backing off hosts that keep failing",
+		schedule:    jobSchedule{every: 24 * time.Hour, align: true},
+		timeout:     30 * time.Minute,
+		run:         checkLinks,
+	},
+	{
+		name:        "cache-cleanup",
+		description: "Drop expired pages from the page cache and resized images unused for " + fmt.Sprint(int(imageCacheMaxAge/(24*time.Hour))) + " days",
+		schedule:    jobSchedule{every: time.Hour},
+		run:         cleanCaches,
+	},
+}
+
+// jobNames lists the jobs by name, for errors.
+func jobNames() string {
+	names := make([]string, len(jobs))
+	for i, j := range jobs {
+		names[i] = j.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// jobNamed returns the job called name, or nil.
+func jobNamed(name string) *job {
+	for _, j := range jobs {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// parseJobSchedules parses -jobs: comma-separated name=schedule pairs,
+// such as reindex=30m,thumbnails=off, overriding each job's default.
+func parseJobSchedules(spec string) (map[string]jobSchedule, error) {
+	out := map[string]jobSchedule{}
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, sched, ok := strings.Cut(pair, "=")
+		if !ok || jobNamed(name) == nil {
+			return nil, fmt.Errorf("jobs %q: want name=schedule with name one of %s", pair, jobNames())
+		}
+		s, err := parseJobSchedule(sched)
+		if err != nil {
+			return nil, fmt.Errorf("jobs %q: %w", pair, err)
+		}
+		out[name] = s
+	}
+	return out, nil
+}
+
+// startJobs applies the configured schedules and runs every job on its
+// schedule until the process exits.
+func startJobs(schedules map[string]jobSchedule) {
+	for _, j := range jobs {
+		if s, ok := schedules[j.name]; ok {
+			j.schedule = s
+		}
+		j.trigger = make(chan struct{}, 1)
+		go j.loop()
+	}
+}
+
+// loop waits for each scheduled run, or a trigger from the admin, and
+// runs the job.
+func (j *job) loop() {
+	for {
+		next := j.schedule.nextAfter(time.Now())
+		j.mu.Lock()
+		j.next = next
+		j.mu.Unlock()
+		var due <-chan time.Time
+		var t *time.Timer
+		if !next.IsZero() {
+			t = time.NewTimer(time.Until(next))
+			due = t.C
+		}
+		select {
+		case <-due:
+		case <-j.trigger:
+			if t != nil {
+				t.Stop()
+			}
+		}
+		j.runOnce()
+	}
+}
+
+// runOnce runs the job, recording how it went. A job that panics is
+// recorded as a failure rather than taking the server down.
+func (j *job) runOnce() {
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		return
+	}
+	j.running, j.lastStart = true, time.Now()
+	j.mu.Unlock()
+
+	timeout := j.timeout
+	if timeout == 0 {
+		timeout = 10 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx, span := startSpan(ctx, "job."+j.name)
+	result, err := func() (result string, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				err = fmt.Errorf("panic: %v", v)
+				log.Printf("job %s: panic: %v\n%s", j.name, v, debug.Stack())
+			}
+		}()
+		return j.run(ctx)
+	}()
+	if err != nil {
+		span.fail(err)
+	}
+	span.End()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.running, j.lastEnd = false, time.Now()
+	j.runs++
+	j.lastResult, j.lastError = result, ""
+	if err != nil {
+		j.failures++
+		j.lastError = err.Error()
+		log.Printf("job %s: %v", j.name, err)
+	}
+}
+
+// JobStatus is one row of /admin/api/jobs.
+type JobStatus struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Schedule    string     `json:"schedule"`
+	Running     bool       `json:"running"`
+	LastStart   *time.Time `json:"last_start,omitempty"`
+	LastEnd     *time.Time `json:"last_end,omitempty"`
+	Duration    string     `json:"duration,omitempty"`
+	LastResult  string     `json:"last_result,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Next        *time.Time `json:"next,omitempty"`
+	Runs        int64      `json:"runs"`
+	Failures    int64      `json:"failures"`
+}
+
+func (j *job) status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := JobStatus{
+		Name:        j.name,
+		Description: j.description,
+		Schedule:    j.schedule.String(),
+		Running:     j.running,
+		LastResult:  j.lastResult,
+		LastError:   j.lastError,
+		Runs:        j.runs,
+		Failures:    j.failures,
+	}
+	start, end, next := j.lastStart, j.lastEnd, j.next
+	if !start.IsZero() {
+		st.LastStart = &start
+	}
+	if !end.IsZero() && !j.running {
+		st.LastEnd = &end
+		st.Duration = end.Sub(start).Round(time.Millisecond).String()
+	}
+	if !next.IsZero() {
+		st.Next = &next
+	}
+	return st
+}
+
+func jobStatuses() []JobStatus {
+	out := make([]JobStatus, len(jobs))
+	for i, j := range jobs {
+		out[i] = j.status()
+	}
+	return out
+}
+
+// adminJobsHandler lists the jobs with a button to run each now.
+func adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Title": "Jobs | Admin",
+		"Jobs":  jobStatuses(),
+		"CSRF":  csrfToken(w, r),
+	}
+	if err := render(w, "admin_jobs.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// adminJobsAPIHandler answers the jobs' status as JSON.
+func adminJobsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": jobStatuses()})
+}
+
+// adminRunJobHandler starts {name} now, unless it is already running.
+// It doesn't wait for the run: the jobs page shows when it's done.
+func adminRunJobHandler(w http.ResponseWriter, r *http.Request) {
+	j := jobNamed(r.PathValue("name"))
+	if j == nil || j.trigger == nil {
+		notFound(w, r)
+		return
+	}
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+	}
+	audit(r, "job.run", j.name, nil, nil)
+	log.Printf("admin: %s started job %s", actor(r), j.name)
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusAccepted, j.status())
+		return
+	}
+	http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+}
+
+// writeJobMetrics writes each job's runs and failures and when it last
+// finished.
+func writeJobMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP job_runs_total Background job runs by job and result.")
+	fmt.Fprintln(w, "# TYPE job_runs_total counter")
+	for _, st := range jobStatuses() {
+		fmt.Fprintf(w, "job_runs_total{job=%q,result=\"ok\"} %d\n", st.Name, st.Runs-st.Failures)
+		fmt.Fprintf(w, "job_runs_total{job=%q,result=\"error\"} %d\n", st.Name, st.Failures)
+	}
+	fmt.Fprintln(w, "# HELP job_last_run_timestamp_seconds When each background job last finished.")
+	fmt.Fprintln(w, "# TYPE job_last_run_timestamp_seconds gauge")
+	for _, st := range jobStatuses() {
+		if st.LastEnd != nil {
+			fmt.Fprintf(w, "job_last_run_timestamp_seconds{job=%q} %d\n", st.Name, st.LastEnd.Unix())
+		}
+	}
+}
+
+// reindex reloads the catalog when the store's items differ from those
+// it was built from. An unchanged catalog is left alone, so open pages
+// aren't told to refresh for nothing.
+func reindex(ctx context.Context) (string, error) {
+	all, err := store.List()
+	if err != nil {
+		return "", err
+	}
+	if itemsSum(all) == current().sum {
+		return fmt.Sprintf("unchanged, %d items", len(all)), nil
+	}
+	if err := reloadItems(ctx); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("reindexed %d items", len(current().items)), nil
+}
+
+// itemsSum is a hash of all as listed.
+func itemsSum(all []Item) [sha256.Size]byte {
+	data, _ := json.Marshal(all)
+	return sha256.Sum256(data)
+}
+
+// regenerateThumbs makes sure every local video in the catalog has an
+// up-to-date image at every size, so no visitor waits on ffmpeg.
+func regenerateThumbs(ctx context.Context) (string, error) {
+	if thumbDir == "" {
+		return "skipped: -thumb-dir is not set", nil
+	}
+	var errs []error
+	checked := 0
+	for _, it := range current().items {
+		for _, vp := range it.VideoPath {
+			if isRemoteMedia(vp) {
+				continue
+			}
+			for _, size := range slices.Sorted(maps.Keys(thumbSizes)) {
+				if err := ctx.Err(); err != nil {
+					return fmt.Sprintf("checked %d images", checked), err
+				}
+				name := filepath.Join(thumbDir, videoKey(vp), size+".jpg")
+				if err := ensureThumb(vp, name, thumbSizes[size]); err != nil {
+					errs = append(errs, fmt.Errorf("%s %s: %w", vp, size, err))
+					continue
+				}
+				checked++
+			}
+		}
+	}
+	return fmt.Sprintf("checked %d images", checked), errors.Join(errs...)
+}
+
+// imageCacheMaxAge is how long a resized image may go unwritten before
+// the cache-cleanup job removes it; it is made again when next asked for.
+const imageCacheMaxAge = 30 * 24 * time.Hour
+
+// cleanCaches drops expired pages from the page cache and old resized
+// images from -image-cache.
+func cleanCaches(ctx context.Context) (string, error) {
+	now := time.Now()
+	pageCache.Lock()
+	before := len(pageCache.pages)
+	c := current()
+	maps.DeleteFunc(pageCache.pages, func(_ string, p *cachedPage) bool { return p.catalog != c || p.expired(now) })
+	pages := before - len(pageCache.pages)
+	pageCache.Unlock()
+	if imageCacheDir == "" {
+		return fmt.Sprintf("dropped %d pages", pages), nil
+	}
+	images := 0
+	err := filepath.WalkDir(imageCacheDir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".type") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if now.Sub(info.ModTime()) < imageCacheMaxAge {
+			return nil
+		}
+		images++
+		defer imageLocks.lock(filepath.Base(path))()
+		os.Remove(path + ".type")
+		return os.Remove(path)
+	})
+	return fmt.Sprintf("dropped %d pages and %d images", pages, images), err
+}
@@ -0,0 +1,36 @@
+package main
+
+import "net/http"
+
+// legalPage is one of the site's static policy pages.
+type legalPage struct {
+	path        string
+	template    string
+	title       string
+	description string
+}
+
+var legalPages = []legalPage{
+	{"/privacy", "privacy.html", "Privacy", "How BlendingWaves collects, uses and protects personal information."},
+	{"/tou", "tou.html", "Terms of Use", "The terms governing use of the BlendingWaves sites."},
+	{"/non", "non.html", "Notice of Nondiscrimination", "BlendingWaves' notice of nondiscrimination."},
+}
+
+// handler renders the page inside the site's header and footer.
+func (p legalPage) handler(w http.ResponseWriter, r *http.Request) {
+	title := translate(writerLocale(w), p.title)
+	data := map[string]interface{}{
+		"Title": title + " | BlendingWaves",
+		"Meta": PageMeta{
+			Title:       title,
+			Description: p.description,
+			URL:         siteURL(r, p.path),
+			Type:        "website",
+			Image:       siteURL(r, defaultThumbnail),
+			TwitterCard: "summary",
+		},
+	}
+	if err := render(w, p.template, data); err != nil {
+		renderError(w, err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// visitorCookie holds a visitor's random ID and its HMAC, so favorites
+// can be tied to a browser without accounts.
+const visitorCookie = "bw_visitor"
+
+// cookieSecret signs visitor cookies. When none is configured a random one
+// is generated, and visitors get fresh identities after a restart.
+var cookieSecret []byte
+
+// setCookieSecret installs secret, or a random one when it is empty.
+func setCookieSecret(secret string) {
+	if secret != "" {
+		cookieSecret = []byte(secret)
+		return
+	}
+	cookieSecret = make([]byte, 32)
+	rand.Read(cookieSecret)
+	log.Printf("No -cookie-secret set; favorites and sign-ins won't survive a restart")
+}
+
+func signVisitor(id string) string {
+	m := hmac.New(sha256.New, cookieSecret)
+	m.Write([]byte(id))
+	return id + "." + hex.EncodeToString(m.Sum(nil))
+}
+
+// visitorID returns the visitor's verified ID. With create set, a visitor
+// without a valid cookie is issued a new one.
+func visitorID(w http.ResponseWriter, r *http.Request, create bool) (string, bool) {
+	if c, err := r.Cookie(visitorCookie); err == nil {
+		id, _, _ := strings.Cut(c.Value, ".")
+		if hmac.Equal([]byte(signVisitor(id)), []byte(c.Value)) {
+			return id, true
+		}
+	}
+	if !create {
+		return "", false
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     visitorCookie,
+		Value:    signVisitor(id),
+		Path:     "/",
+		MaxAge:   int((2 * 365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id, true
+}
+
+// likeStore is implemented by repositories that persist likes, as a set
+// of item IDs per visitor.
+type likeStore interface {
+	LoadLikes() (map[string][]int, error)
+	SetLike(visitor string, id int, liked bool) error
+}
+
+// likes mirrors the stored likes for fast reads.
+var likes = struct {
+	sync.RWMutex
+	byVisitor map[string]map[int]bool
+	counts    map[int]int
+}{byVisitor: map[string]map[int]bool{}, counts: map[int]int{}}
+
+// loadLikes reads every stored like into memory.
+func loadLikes() {
+	ls, ok := store.(likeStore)
+	if !ok {
+		return
+	}
+	all, err := ls.LoadLikes()
+	if err != nil {
+		log.Printf("likes: load: %v", err)
+		return
+	}
+	byVisitor, counts := map[string]map[int]bool{}, map[int]int{}
+	for v, ids := range all {
+		set := map[int]bool{}
+		for _, id := range ids {
+			set[id] = true
+			counts[id]++
+		}
+		byVisitor[v] = set
+	}
+	likes.Lock()
+	likes.byVisitor, likes.counts = byVisitor, counts
+	likes.Unlock()
+}
+
+// likeCount is the number of visitors who like item id.
+func likeCount(id int) int {
+	likes.RLock()
+	defer likes.RUnlock()
+	return likes.counts[id]
+}
+
+// likedBy reports whether visitor likes item id.
+func likedBy(visitor string, id int) bool {
+	likes.RLock()
+	defer likes.RUnlock()
+	return likes.byVisitor[visitor][id]
+}
+
+// setLike records visitor's like of item id in the store and in memory.
+func setLike(visitor string, id int, liked bool) error {
+	ls, ok := store.(likeStore)
+	if !ok {
+		return errors.New("store does not support likes")
+	}
+	likes.Lock()
+	defer likes.Unlock()
+	if likes.byVisitor[visitor][id] == liked {
+		return nil
+	}
+	if err := ls.SetLike(visitor, id, liked); err != nil {
+		return err
+	}
+	set := likes.byVisitor[visitor]
+	if set == nil {
+		set = map[int]bool{}
+		likes.byVisitor[visitor] = set
+	}
+	if liked {
+		set[id] = true
+		likes.counts[id]++
+	} else {
+		delete(set, id)
+		likes.counts[id]--
+	}
+	purgeItemPages(id)
+	return nil
+}
+
+// likeHandler answers POST (like) and DELETE (unlike) on
+// /api/items/{id}/like with the item's new like count.
+func likeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "item id must be an integer")
+		return
+	}
+	it, ok := current().byID[id]
+	if !ok || !it.visible(time.Now()) {
+		writeJSONError(w, http.StatusNotFound, "item not found")
+		return
+	}
+	visitor, _ := visitorID(w, r, true)
+	liked := r.Method == http.MethodPost
+	if err := setLike(visitor, id, liked); err != nil {
+		log.Printf("likes: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "could not save like")
+		return
+	}
+	if liked {
+		convert(w, r, GoalLike)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"liked": liked, "likes": likeCount(id)})
+}
+
+// favoritesHandler lists the items the visitor likes.
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	var items []Item
+	if visitor, ok := visitorID(w, r, false); ok {
+		for _, it := range current().live(time.Now()) {
+			if likedBy(visitor, it.ID) {
+				items = append(items, it)
+			}
+		}
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	data := map[string]interface{}{
+		"Title": "My favorites | BlendingWaves",
+		"Items": items,
+	}
+	if err := render(w, "favorites.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// likesPath is where the JSON store keeps likes, beside items.json.
+func (s *jsonStore) likesPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".likes.json"
+}
+
+func (s *jsonStore) LoadLikes() (map[string][]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readLikes()
+}
+
+func (s *jsonStore) readLikes() (map[string][]int, error) {
+	var out map[string][]int
+	if ok, err := readRecoverable(s.likesPath(), &out); !ok || out == nil {
+		return map[string][]int{}, err
+	}
+	return out, nil
+}
+
+func (s *jsonStore) SetLike(visitor string, id int, liked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readLikes()
+	if err != nil {
+		return err
+	}
+	ids := slices.DeleteFunc(all[visitor], func(x int) bool { return x == id })
+	if liked {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		delete(all, visitor)
+	} else {
+		all[visitor] = ids
+	}
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.likesPath(), data)
+}
+
+func (s *sqliteStore) LoadLikes() (map[string][]int, error) {
+	rows, err := s.db.Query(`SELECT visitor, item_id FROM likes`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][]int{}
+	for rows.Next() {
+		var v string
+		var id int
+		if err := rows.Scan(&v, &id); err != nil {
+			return nil, err
+		}
+		out[v] = append(out[v], id)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) SetLike(visitor string, id int, liked bool) error {
+	var err error
+	if liked {
+		_, err = s.db.Exec(`INSERT OR IGNORE INTO likes (visitor, item_id) VALUES (?, ?)`, visitor, id)
+	} else {
+		_, err = s.db.Exec(`DELETE FROM likes WHERE visitor = ? AND item_id = ?`, visitor, id)
+	}
+	return err
+}
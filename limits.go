@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// limitRule caps requests under Prefix: the time allowed to answer one,
+// none when zero, and the largest body it may send. A negative value
+// leaves the default from -request-timeout or -max-body.
+type limitRule struct {
+	Prefix  string
+	Timeout time.Duration
+	MaxBody int64
+}
+
+// parseLimitRule parses /prefix=timeout or /prefix=timeout/max-body, e.g.
+// /api/=10s/65536; either part may be left empty, as in /hooks/=/1048576,
+// to keep its default.
+func parseLimitRule(s string) (limitRule, error) {
+	prefix, spec, ok := strings.Cut(s, "=")
+	if !ok || !strings.HasPrefix(prefix, "/") {
+		return limitRule{}, fmt.Errorf("route limit %q: want /prefix=timeout/max-body", s)
+	}
+	timeoutStr, bodyStr, _ := strings.Cut(spec, "/")
+	rule := limitRule{Prefix: prefix, Timeout: -1, MaxBody: -1}
+	if timeoutStr != "" {
+		d, err := time.ParseDuration(timeoutStr)
+		if err != nil || d < 0 {
+			return limitRule{}, fmt.Errorf("route limit %q: timeout must be a duration such as 30s, or 0 for none", s)
+		}
+		rule.Timeout = d
+	}
+	if bodyStr != "" {
+		n, err := strconv.ParseInt(bodyStr, 10, 64)
+		if err != nil || n < 0 {
+			return limitRule{}, fmt.Errorf("route limit %q: max-body must be a number of bytes", s)
+		}
+		rule.MaxBody = n
+	}
+	return rule, nil
+}
+
+// builtinLimits are the routes the defaults don't suit: streams that stay
+// open, media served and uploaded in bulk. -route-limit overrides them.
+func builtinLimits(uploadMax int64) []limitRule {
+	return []limitRule{
+		{Prefix: "/events", Timeout: 0, MaxBody: -1},
+		{Prefix: "/ws", Timeout: 0, MaxBody: -1},
+		{Prefix: "/video/", Timeout: 0, MaxBody: -1},
+		{Prefix: "/hls/", Timeout: 0, MaxBody: -1},
+		{Prefix: "/static/", Timeout: 0, MaxBody: -1},
+		{Prefix: "/admin/media", Timeout: 10 * time.Minute, MaxBody: uploadMax},
+		{Prefix: "/admin/restore", Timeout: 10 * time.Minute, MaxBody: uploadMax},
+		{Prefix: "/admin/diff", Timeout: time.Minute, MaxBody: uploadMax},
+	}
+}
+
+// requestLimits resolves the limits of each request path: the longest
+// matching prefix, configured rules before built-in ones, then the
+// defaults.
+type requestLimits struct {
+	rules   []limitRule
+	timeout time.Duration
+	maxBody int64
+}
+
+func newRequestLimits(rules []limitRule, uploadMax int64, timeout time.Duration, maxBody int64) *requestLimits {
+	all := append(append([]limitRule(nil), rules...), builtinLimits(uploadMax)...)
+	sort.SliceStable(all, func(i, j int) bool { return len(all[i].Prefix) > len(all[j].Prefix) })
+	return &requestLimits{rules: all, timeout: timeout, maxBody: maxBody}
+}
+
+// limits returns the timeout and body limit for path.
+func (l *requestLimits) limits(path string) (time.Duration, int64) {
+	timeout, maxBody := l.timeout, l.maxBody
+	for _, rule := range l.rules {
+		if strings.HasPrefix(path, rule.Prefix) {
+			if rule.Timeout >= 0 {
+				timeout = rule.Timeout
+			}
+			if rule.MaxBody >= 0 {
+				maxBody = rule.MaxBody
+			}
+			break
+		}
+	}
+	return timeout, maxBody
+}
+
+// timeoutGrace is how long past its deadline a request may still take to
+// write the 408 or the end of its response.
+const timeoutGrace = 5 * time.Second
+
+// limitRequests bounds each request by the limits of its path. A body
+// declared larger than the limit gets a 413 before the handler runs, so
+// a client that sent Expect: 100-continue is refused before uploading
+// it; one that streams past it fails the handler's read and gets the 413
+// then.
+// The deadline cancels the request's context and is set on the
+// connection, so a client trickling its body or not reading the response
+// can't hold the handler; a request that runs out of time without
+// answering gets a 408.
+func limitRequests(l *requestLimits, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, maxBody := l.limits(r.URL.Path)
+		if maxBody > 0 && r.ContentLength > maxBody {
+			limitExceeded(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBody))
+			return
+		}
+		hasBody := r.Body != nil && r.Body != http.NoBody
+		if timeout == 0 && !hasBody {
+			h.ServeHTTP(w, r)
+			return
+		}
+		lw := &limitWriter{ResponseWriter: w}
+		if hasBody {
+			body := r.Body
+			if maxBody > 0 {
+				body = http.MaxBytesReader(w, body, maxBody)
+			}
+			lw.body = &limitBody{ReadCloser: body}
+			r.Body = lw.body
+		}
+		if timeout > 0 {
+			deadline := time.Now().Add(timeout)
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(deadline)
+			rc.SetWriteDeadline(deadline.Add(timeoutGrace))
+			r = r.WithContext(ctx)
+		}
+		h.ServeHTTP(lw, r)
+		switch {
+		case lw.wroteHeader && !lw.discard:
+		case lw.body != nil && lw.body.tooLarge:
+			limitExceeded(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBody))
+		case lw.discard || r.Context().Err() == context.DeadlineExceeded:
+			w.Header().Set("Connection", "close")
+			limitExceeded(w, r, http.StatusRequestTimeout, "request timed out")
+		}
+	})
+}
+
+// limitExceeded answers a request over its limits: JSON for API
+// clients, plain text otherwise.
+func limitExceeded(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if isAPI(r.URL.Path) || wantsJSON(r) {
+		writeJSONError(w, status, msg)
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// limitBody notes why reading a request body failed, when it was the
+// body limit or the read deadline.
+type limitBody struct {
+	io.ReadCloser
+	tooLarge bool
+	timedOut bool
+}
+
+func (b *limitBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooBig):
+		b.tooLarge = true
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		b.timedOut = true
+	}
+	return n, err
+}
+
+// limitWriter notes whether the handler started its response. Once the
+// body has gone over its limit or its deadline, whatever the handler
+// makes of the failed read is dropped for the 413 or 408 limitRequests
+// sends.
+type limitWriter struct {
+	http.ResponseWriter
+	body        *limitBody
+	wroteHeader bool
+	discard     bool
+}
+
+func (l *limitWriter) Unwrap() http.ResponseWriter { return l.ResponseWriter }
+
+// failed reports whether the handler's response is to be dropped.
+func (l *limitWriter) failed() bool {
+	if !l.wroteHeader && l.body != nil && (l.body.tooLarge || l.body.timedOut) {
+		l.discard = true
+	}
+	return l.discard
+}
+
+func (l *limitWriter) Flush() {
+	if l.failed() {
+		return
+	}
+	l.wroteHeader = true
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (l *limitWriter) WriteHeader(status int) {
+	if l.failed() {
+		return
+	}
+	if status >= 200 {
+		l.wroteHeader = true
+	}
+	l.ResponseWriter.WriteHeader(status)
+}
+
+func (l *limitWriter) Write(b []byte) (int, error) {
+	if l.failed() {
+		return len(b), nil
+	}
+	l.wroteHeader = true
+	return l.ResponseWriter.Write(b)
+}
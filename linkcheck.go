@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The links job HEADs every external link in the catalog: each item's
+// ItemLink and video credits. A circuit breaker per host stops it
+// hammering a host that keeps failing.
+
+const (
+	breakerThreshold = 3                // consecutive failures that open a host's breaker
+	breakerCooldown  = 10 * time.Minute // how long an open breaker refuses checks
+)
+
+// Breaker states, as the link report shows them.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// hostBreaker counts one host's consecutive failures. After
+// breakerThreshold of them it opens and refuses checks until openUntil;
+// then it is half-open, and the next check is a probe whose result
+// closes it again or reopens it for another cooldown.
+type hostBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// breakers holds the breaker of every host checked, across runs.
+type breakers struct {
+	mu    sync.Mutex
+	hosts map[string]*hostBreaker
+	now   func() time.Time
+}
+
+func newBreakers() *breakers {
+	return &breakers{hosts: map[string]*hostBreaker{}, now: time.Now}
+}
+
+var linkBreakers = newBreakers()
+
+// state reports host's breaker state.
+func (b *breakers) state(host string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked(host)
+}
+
+func (b *breakers) stateLocked(host string) string {
+	hb, ok := b.hosts[host]
+	switch {
+	case !ok || hb.failures < breakerThreshold:
+		return BreakerClosed
+	case b.now().Before(hb.openUntil):
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+// allow reports whether host may be checked now.
+func (b *breakers) allow(host string) bool {
+	return b.state(host) != BreakerOpen
+}
+
+// record notes the outcome of a check of host. A failure that reaches
+// the threshold, or a failed half-open probe, (re)opens the breaker.
+func (b *breakers) record(host string, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hb := b.hosts[host]
+	if hb == nil {
+		hb = &hostBreaker{}
+		b.hosts[host] = hb
+	}
+	if ok {
+		hb.failures, hb.openUntil = 0, time.Time{}
+		return
+	}
+	hb.failures++
+	if hb.failures >= breakerThreshold {
+		hb.openUntil = b.now().Add(breakerCooldown)
+	}
+}
+
+// LinkResult is one link's line in the report.
+type LinkResult struct {
+	ItemID  int    `json:"item_id"`
+	URL     string `json:"url"`
+	Status  int    `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"` // the host's breaker was open
+}
+
+// broken reports whether the link needs an editor's attention.
+func (l LinkResult) broken() bool {
+	return !l.Skipped && (l.Error != "" || l.Status >= 400)
+}
+
+// LinkReport is the outcome of a link check, served at /admin/api/links.
+type LinkReport struct {
+	CheckedAt time.Time         `json:"checked_at"`
+	Links     []LinkResult      `json:"links"`
+	Hosts     map[string]string `json:"hosts"` // host -> breaker state after the run
+}
+
+var (
+	linkClient = &http.Client{Timeout: 10 * time.Second}
+	lastLinks  atomic.Pointer[LinkReport]
+)
+
+// itemLinks lists the item's external links.
+func itemLinks(it *Item) []string {
+	var links []string
+	for _, s := range append([]string{it.ItemLink}, it.VideoCredit...) {
+		if u := externalURL(s); u != "" && !slices.Contains(links, u) {
+			links = append(links, u)
+		}
+	}
+	return links
+}
+
+// runLinkCheck checks every external link of items, skipping hosts whose
+// breaker is open.
+func runLinkCheck(ctx context.Context, client *http.Client, b *breakers, items []Item) *LinkReport {
+	rep := &LinkReport{CheckedAt: time.Now(), Links: []LinkResult{}, Hosts: map[string]string{}}
+	for i := range items {
+		for _, link := range itemLinks(&items[i]) {
+			if ctx.Err() != nil {
+				return rep
+			}
+			res := LinkResult{ItemID: items[i].ID, URL: link}
+			u, _ := url.Parse(link)
+			host := strings.ToLower(u.Host)
+			if !b.allow(host) {
+				res.Skipped = true
+			} else {
+				status, err := headLink(ctx, client, link)
+				res.Status = status
+				if err != nil {
+					res.Error = err.Error()
+				}
+				// A missing page is the link's fault; errors, rate
+				// limits and server errors are the host's.
+				b.record(host, err == nil && status != http.StatusTooManyRequests && status < 500)
+			}
+			rep.Links = append(rep.Links, res)
+			rep.Hosts[host] = b.state(host)
+		}
+	}
+	return rep
+}
+
+// headLink requests link with HEAD, falling back to GET for servers that
+// don't allow HEAD, and returns the status.
+func headLink(ctx context.Context, client *http.Client, link string) (int, error) {
+	status := 0
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, link, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("User-Agent", "BlendingWaves link checker")
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+	return status, nil
+}
+
+// checkLinks is the links job.
+func checkLinks(ctx context.Context) (string, error) {
+	rep := runLinkCheck(ctx, linkClient, linkBreakers, current().items)
+	lastLinks.Store(rep)
+	broken, skipped := 0, 0
+	for _, l := range rep.Links {
+		switch {
+		case l.Skipped:
+			skipped++
+		case l.broken():
+			broken++
+		}
+	}
+	var open []string
+	for host, state := range rep.Hosts {
+		if state == BreakerOpen {
+			open = append(open, host)
+		}
+	}
+	slices.Sort(open)
+	result := fmt.Sprintf("checked %s: %d broken, %d skipped", pluralize(len(rep.Links), "link", "links"), broken, skipped)
+	if len(open) > 0 {
+		result += "; backing off " + strings.Join(open, ", ")
+	}
+	return result, ctx.Err()
+}
+
+// adminLinksHandler answers the last link check's report, with each
+// host's breaker state as it is now.
+func adminLinksHandler(w http.ResponseWriter, r *http.Request) {
+	rep := lastLinks.Load()
+	if rep == nil {
+		writeJSONError(w, http.StatusNotFound, "the links job hasn't run yet")
+		return
+	}
+	out := *rep
+	out.Hosts = make(map[string]string, len(rep.Hosts))
+	for host := range rep.Hosts {
+		out.Hosts[host] = linkBreakers.state(host)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newBreakers()
+	b.now = func() time.Time { return now }
+	const host = "flaky.example"
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		result  *bool // nil: only check the state
+		want    string
+	}{
+		{"new host", 0, nil, BreakerClosed},
+		{"first failure", 0, ptr(false), BreakerClosed},
+		{"second failure", 0, ptr(false), BreakerClosed},
+		{"threshold", 0, ptr(false), BreakerOpen},
+		{"during cooldown", breakerCooldown / 2, nil, BreakerOpen},
+		{"after cooldown", breakerCooldown / 2, nil, BreakerHalfOpen},
+		{"failed probe", 0, ptr(false), BreakerOpen},
+		{"next cooldown", breakerCooldown, nil, BreakerHalfOpen},
+		{"good probe", 0, ptr(true), BreakerClosed},
+		{"failures start over", 0, ptr(false), BreakerClosed},
+	}
+	for _, s := range steps {
+		now = now.Add(s.advance)
+		if s.result != nil {
+			b.record(host, *s.result)
+		}
+		if got := b.state(host); got != s.want {
+			t.Fatalf("%s: state %s, want %s", s.name, got, s.want)
+		}
+		if allowed := b.allow(host); allowed != (s.want != BreakerOpen) {
+			t.Fatalf("%s: allow = %v in state %s", s.name, allowed, s.want)
+		}
+	}
+}
+
+func TestRunLinkCheck(t *testing.T) {
+	var down atomic.Bool
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch {
+		case r.Method != http.MethodHead:
+			t.Errorf("%s %s, want HEAD", r.Method, r.URL.Path)
+		case down.Load():
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	host := strings.ToLower(mustParse(t, srv.URL).Host)
+
+	var items []Item
+	for id := 1; id <= 5; id++ {
+		it := testItem(id, "Item")
+		it.ItemLink, it.VideoCredit = srv.URL+"/page", nil
+		items = append(items, it)
+	}
+	items[0].ItemLink = srv.URL + "/gone"
+
+	now := time.Now()
+	b := newBreakers()
+	b.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Healthy host: a missing page is reported but doesn't trip the breaker.
+	rep := runLinkCheck(ctx, srv.Client(), b, items)
+	if !rep.Links[0].broken() || rep.Links[0].Status != 404 || rep.Links[1].broken() {
+		t.Errorf("healthy run: %+v", rep.Links)
+	}
+	if rep.Hosts[host] != BreakerClosed {
+		t.Errorf("healthy run: breaker %s", rep.Hosts[host])
+	}
+
+	// Down host: the breaker opens and the remaining links are skipped.
+	down.Store(true)
+	hits.Store(0)
+	rep = runLinkCheck(ctx, srv.Client(), b, items)
+	if n := hits.Load(); n != breakerThreshold {
+		t.Errorf("down run: %d requests, want %d", n, breakerThreshold)
+	}
+	skipped := 0
+	for _, l := range rep.Links {
+		if l.Skipped {
+			skipped++
+		}
+	}
+	if skipped != len(items)-breakerThreshold || rep.Hosts[host] != BreakerOpen {
+		t.Errorf("down run: %d skipped, breaker %s", skipped, rep.Hosts[host])
+	}
+
+	// After the cooldown one probe goes through and, succeeding, closes it.
+	down.Store(false)
+	hits.Store(0)
+	now = now.Add(breakerCooldown)
+	if got := b.state(host); got != BreakerHalfOpen {
+		t.Fatalf("after cooldown: breaker %s", got)
+	}
+	rep = runLinkCheck(ctx, srv.Client(), b, items)
+	if n := hits.Load(); n != int32(len(items)) || rep.Hosts[host] != BreakerClosed {
+		t.Errorf("recovered run: %d requests, breaker %s", n, rep.Hosts[host])
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func mustParse(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
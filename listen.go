@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// listenAddr is one address the site is served on: a TCP address on
+// both IP versions ("tcp") or one of them ("tcp4", "tcp6"), or a unix
+// socket path ("unix"), with the HTTP versions it speaks beyond 1.1.
+// http2 is negotiated over TLS; h2c is HTTP/2 without it, for a proxy
+// in front; http3 also serves QUIC on the same UDP port.
+type listenAddr struct {
+	network string
+	address string
+	http2   bool
+	h2c     bool
+	http3   bool
+}
+
+func (a listenAddr) String() string {
+	if a.network == "unix" {
+		return "unix:" + a.address
+	}
+	return a.network + "://" + a.address
+}
+
+// parseListenAddr parses a -listen value: tcp://host:port,
+// tcp4://host:port, tcp6://[host]:port, unix:/path, or a bare host:port
+// meaning tcp. An empty host is every interface. Options follow a ?, as
+// in tcp://:443?h3 or unix:/run/bw.sock?h2c: h2 (on unless =off), h2c
+// and h3, which defaults to -http3.
+func parseListenAddr(s string, http3 bool) (listenAddr, error) {
+	spec, opts, _ := strings.Cut(s, "?")
+	a := listenAddr{network: "tcp", address: spec, http2: true, http3: http3}
+	if path, ok := strings.CutPrefix(spec, "unix:"); ok {
+		a = listenAddr{network: "unix", address: strings.TrimPrefix(path, "//"), http2: true}
+		if a.address == "" {
+			return listenAddr{}, fmt.Errorf("listen %q: no socket path", s)
+		}
+	} else if network, addr, ok := strings.Cut(spec, "://"); ok {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+			a.network, a.address = network, addr
+		default:
+			return listenAddr{}, fmt.Errorf("listen %q: network must be tcp, tcp4, tcp6 or unix", s)
+		}
+	}
+	if a.network != "unix" {
+		_, port, err := net.SplitHostPort(a.address)
+		if err != nil {
+			return listenAddr{}, fmt.Errorf("listen %q: %w", s, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return listenAddr{}, fmt.Errorf("listen %q: port must be a number from 0 to 65535", s)
+		}
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == "" {
+			continue
+		}
+		key, val, hasVal := strings.Cut(opt, "=")
+		on := true
+		if hasVal {
+			switch val {
+			case "on", "true", "1":
+			case "off", "false", "0":
+				on = false
+			default:
+				return listenAddr{}, fmt.Errorf("listen %q: %s must be on or off", s, key)
+			}
+		}
+		switch key {
+		case "h2":
+			a.http2 = on
+		case "h2c":
+			a.h2c = on
+		case "h3":
+			a.http3 = on
+		default:
+			return listenAddr{}, fmt.Errorf("listen %q: unknown option %q; want h2, h2c or h3", s, key)
+		}
+	}
+	if a.http3 && a.network == "unix" {
+		return listenAddr{}, fmt.Errorf("listen %q: HTTP/3 needs a UDP port, not a unix socket", s)
+	}
+	return a, nil
+}
+
+// listenAddrs returns where the site is served: every -listen, or else
+// -listen-host and -port on both IP versions.
+func (c *Config) listenAddrs() []listenAddr {
+	if len(c.listeners) > 0 {
+		return c.listeners
+	}
+	return []listenAddr{{network: "tcp", address: net.JoinHostPort(c.ListenHost, c.Port), http2: true, http3: c.HTTP3}}
+}
+
+// httpsPort is the port HTTP is redirected to when TLS is on: that of
+// the first TCP listener.
+func (c *Config) httpsPort() string {
+	for _, a := range c.listenAddrs() {
+		if a.network != "unix" {
+			_, port, _ := net.SplitHostPort(a.address)
+			return port
+		}
+	}
+	return c.Port
+}
+
+// openListener binds a. A unix socket left behind by an earlier run is
+// removed first, and the new one gets mode.
+func openListener(a listenAddr, mode fs.FileMode) (net.Listener, error) {
+	if a.network != "unix" {
+		return net.Listen(a.network, a.address)
+	}
+	if fi, err := os.Lstat(a.address); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", a.address)
+		}
+		if err := os.Remove(a.address); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", a.address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(a.address, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return localPeers{ln}, nil
+}
+
+// localPeers is a unix socket listener whose connections report the
+// loopback address as their peer. Whatever connects is a proxy on this
+// host, so its X-Forwarded-For is trusted when -trusted-proxies lists
+// 127.0.0.1, and rate limits and logs see an IP.
+type localPeers struct {
+	net.Listener
+}
+
+func (l localPeers) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return localConn{c}, nil
+}
+
+type localConn struct {
+	net.Conn
+}
+
+func (localConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// listenerURL describes ln for logs, as scheme://host:port or
+// scheme://unix:/path.
+func listenerURL(scheme string, ln net.Listener) string {
+	if ln.Addr().Network() == "unix" {
+		return scheme + "://unix:" + ln.Addr().String()
+	}
+	return scheme + "://" + ln.Addr().String()
+}
+
+// activatedListener is a socket systemd passed, with the name its
+// FileDescriptorName= gave it: a stream socket in ln, or a datagram
+// socket, which serves HTTP/3, in pc.
+type activatedListener struct {
+	name string
+	ln   net.Listener
+	pc   net.PacketConn
+}
+
+// systemdListenFDsStart is the first file descriptor systemd passes, as
+// sd_listen_fds(3) defines.
+const systemdListenFDsStart = 3
+
+// systemdListeners takes over the sockets systemd passed in LISTEN_FDS
+// when it started us for socket activation, in the order the .socket
+// unit lists them; nil when it didn't. systemd keeps the sockets open
+// while the service restarts, so connections arriving meanwhile queue
+// instead of being refused. A socket named "redirect" serves the HTTP
+// to HTTPS redirect when TLS is on; the others serve the site, UDP ones
+// (ListenDatagram=) over HTTP/3.
+//
+// The LISTEN_* variables are cleared so child processes, such as the
+// sites of -sites, don't take the sockets for theirs.
+func systemdListeners() ([]activatedListener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("LISTEN_FDS=%q is not a count", fds)
+	}
+	var out []activatedListener
+	for i := range n {
+		fd := systemdListenFDsStart + i
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		syscall.CloseOnExec(fd)
+		typ, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+		if err != nil {
+			return nil, fmt.Errorf("socket %d (%s): %w", fd, name, err)
+		}
+		f := os.NewFile(uintptr(fd), name)
+		a := activatedListener{name: name}
+		if typ == syscall.SOCK_DGRAM {
+			a.pc, err = net.FilePacketConn(f)
+		} else {
+			a.ln, err = net.FileListener(f)
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %d (%s): %w", fd, name, err)
+		}
+		if a.ln != nil && a.ln.Addr().Network() == "unix" {
+			a.ln = localPeers{a.ln}
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// siteListener is a listener serving the site, with the protocol options
+// of its -listen address.
+type siteListener struct {
+	ln   net.Listener
+	addr listenAddr
+}
+
+// protocols are the HTTP versions a server on a speaks: HTTP/2 by ALPN
+// over TLS, or by prior knowledge without it when h2c is set.
+func protocols(a listenAddr, overTLS bool) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	if overTLS {
+		p.SetHTTP2(a.http2)
+	} else {
+		p.SetUnencryptedHTTP2(a.h2c)
+	}
+	return p
+}
+
+// alpn returns a copy of cfg offering h2 and http/1.1 by ALPN, or only
+// http/1.1 when http2 is off. Other protocols cfg offers, such as
+// autocert's acme-tls/1, are kept after them.
+func alpn(cfg *tls.Config, http2 bool) *tls.Config {
+	c := cfg.Clone()
+	c.NextProtos = []string{"http/1.1"}
+	if http2 {
+		c.NextProtos = []string{"h2", "http/1.1"}
+	}
+	for _, p := range cfg.NextProtos {
+		if p != "h2" && p != "http/1.1" {
+			c.NextProtos = append(c.NextProtos, p)
+		}
+	}
+	return c
+}
+
+// udpNetwork is the UDP network HTTP/3 listens on beside a TCP one.
+func udpNetwork(tcp string) string {
+	return "udp" + strings.TrimPrefix(tcp, "tcp")
+}
+
+// newHTTP3Server builds the HTTP/3 server for h with the configured
+// idle timeout and header limit. QUIC has no equivalent of the read and
+// write timeouts; -request-timeout still bounds each request.
+func newHTTP3Server(cfg Config, tlsCfg *tls.Config, h http.Handler) *http3.Server {
+	return &http3.Server{
+		Handler:        h,
+		TLSConfig:      http3.ConfigureTLSConfig(tlsCfg),
+		IdleTimeout:    cfg.IdleTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+	}
+}
+
+// advertiseHTTP3 adds the Alt-Svc header announcing h3's UDP port to
+// every response of h, so browsers switch to QUIC on their next request.
+func advertiseHTTP3(h3 *http3.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3.SetQUICHeaders(w.Header())
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text email, sent with an HTML alternative when HTML
+// is set. Unsubscribe is the List-Unsubscribe link of bulk mail.
+type Message struct {
+	To          []string
+	ReplyTo     string
+	Subject     string
+	Body        string
+	HTML        string
+	Unsubscribe string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// smtpMailer delivers through an SMTP relay, authenticating with PLAIN
+// when a user is set.
+type smtpMailer struct {
+	Addr     string // host:port
+	User     string
+	Password string
+	From     string
+}
+
+func (m *smtpMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.User != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.User, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, msg.To, composeMail(m.From, msg, time.Now()))
+}
+
+// logMailer stands in when no SMTP relay is configured, so forms keep
+// working in development.
+type logMailer struct{}
+
+func (logMailer) Send(msg Message) error {
+	log.Printf("mail (no -smtp-addr set) to %s: %s\n%s", strings.Join(msg.To, ", "), msg.Subject, msg.Body)
+	return nil
+}
+
+// headerSafe strips line breaks so visitor input can't add headers.
+func headerSafe(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// composeMail renders msg as an RFC 5322 message from from.
+func composeMail(from string, msg Message, now time.Time) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+	_, domain, _ := strings.Cut(from, "@")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", headerSafe(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerSafe(strings.Join(msg.To, ", ")))
+	if msg.ReplyTo != "" {
+		fmt.Fprintf(&b, "Reply-To: %s\r\n", headerSafe(msg.ReplyTo))
+	}
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerSafe(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	if msg.Unsubscribe != "" {
+		fmt.Fprintf(&b, "List-Unsubscribe: <%s>\r\n", headerSafe(msg.Unsubscribe))
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		b.WriteString(crlf(msg.Body))
+		return b.Bytes()
+	}
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	for _, part := range [][2]string{{"text/plain", msg.Body}, {"text/html", msg.HTML}} {
+		pw, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part[0] + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		io.WriteString(pw, crlf(part[1]))
+	}
+	mw.Close()
+	return b.Bytes()
+}
+
+// crlf normalizes the line endings of s to CRLF, as SMTP requires.
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// mailAttempts and mailRetryBase bound redelivery: attempt n waits
+// mailRetryBase << n after the previous failure.
+const (
+	mailAttempts  = 5
+	mailRetryBase = 30 * time.Second
+)
+
+// mailer is the outbound mail transport; outbox queues deliveries to it.
+var (
+	mailer Mailer = logMailer{}
+	outbox        = make(chan queuedMail, 100)
+)
+
+type queuedMail struct {
+	msg     Message
+	attempt int
+}
+
+// sendMail queues msg for delivery, reporting false if the queue is full.
+func sendMail(msg Message) bool {
+	select {
+	case outbox <- queuedMail{msg: msg}:
+		return true
+	default:
+		log.Printf("mail: queue full, dropping %q to %s", msg.Subject, strings.Join(msg.To, ", "))
+		return false
+	}
+}
+
+// mailWorker delivers queued mail, requeueing failures with backoff.
+func mailWorker() {
+	for q := range outbox {
+		err := mailer.Send(q.msg)
+		if err == nil {
+			continue
+		}
+		q.attempt++
+		if q.attempt >= mailAttempts {
+			log.Printf("mail: giving up on %q to %s after %d attempts: %v",
+				q.msg.Subject, strings.Join(q.msg.To, ", "), q.attempt, err)
+			continue
+		}
+		wait := mailRetryBase << (q.attempt - 1)
+		log.Printf("mail: %q failed (attempt %d), retrying in %s: %v", q.msg.Subject, q.attempt, wait, err)
+		time.AfterFunc(wait, func() { outbox <- q })
+	}
+}
+
+// setMailer picks the SMTP relay when one is configured.
+func setMailer(cfg Config) {
+	if cfg.SMTPAddr != "" {
+		mailer = &smtpMailer{Addr: cfg.SMTPAddr, User: cfg.SMTPUser, Password: cfg.SMTPPassword, From: cfg.MailFrom}
+	}
+	go mailWorker()
+}
@@ -1,130 +1,469 @@
 package main
 
 import (
-	"encoding/json"
-	"html/template"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
 )
 
 // Item represents one entry from data/items.json
 type Item struct {
-	ID           int      `json:"id"`
-	KeywordTitle string   `json:"keyword_title"`
-	Texts        []string `json:"texts"`
-	VideoPath    []string `json:"video_path"`
-	VideoCredit  []string `json:"video_credit"`
-	ItemLink     string   `json:"ItemLink"`
+	ID           int                 `json:"id"`
+	KeywordTitle string              `json:"keyword_title"`
+	Texts        []string            `json:"texts"`
+	VideoPath    []string            `json:"video_path"`
+	VideoCredit  []string            `json:"video_credit"`
+	Posters      []string            `json:"posters,omitempty"` // /static/ poster image of each video; "" falls back
+	ItemLink     string              `json:"ItemLink"`
+	Slug         string              `json:"slug,omitempty"`       // derived from KeywordTitle when empty
+	ShortCode    string              `json:"short_code,omitempty"` // of the /s/ link; derived from ID when empty
+	ShortURL     string              `json:"short_url,omitempty"`  // filled in by the catalog
+	Tags         []string            `json:"tags,omitempty"`
+	CreatedAt    *time.Time          `json:"created_at,omitempty"`
+	UpdatedAt    *time.Time          `json:"updated_at,omitempty"`
+	Pinned       bool                `json:"pinned"`
+	Order        int                 `json:"order,omitempty"`        // position on the home page; 0 = after the ordered items
+	ExpireAt     *time.Time          `json:"expire_at,omitempty"`    // RFC3339; nil never expires
+	Status       string              `json:"status,omitempty"`       // draft, scheduled or published (empty)
+	PublishAt    *time.Time          `json:"publish_at,omitempty"`   // when a scheduled item goes live
+	Translations map[string]ItemText `json:"translations,omitempty"` // per-locale title and texts
+	Captions     []Caption           `json:"captions,omitempty"`     // WebVTT tracks of the videos
+	Headers      map[string]string   `json:"headers,omitempty"`      // extra response headers; see itemHeaderNames
+	Views        *Views              `json:"views,omitempty"`        // filled in by the API when -show-views is set
 }
 
-var items []Item
-var tmpl *template.Template // Declare tmpl at package level
+var tmpl templateSet // Declare tmpl at package level
 
-func loadItems() {
-	currDir, err := os.Getwd()
-	if err != nil {
-		log.Fatalf("Failed to get working directory: %v", err)
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	c, now := current(), time.Now()
+	order := r.URL.Query().Get("sort")
+	if !validSort(order) {
+		order = ""
+	}
+	data := map[string]interface{}{
+		"Title": "BlendingWaves",
+		"Meta":  homeMeta(r),
+		"Sorts": sortLinks(r),
+	}
+	name := "home.html"
+	key, token := pageKey{route: "home"}, ""
+	if e, v, ok := experimentFor(w, r, name); ok {
+		if v.Template != "" {
+			name = v.Template
+		}
+		if !r.URL.Query().Has("sort") {
+			order = v.Sort
+		}
+		data["Experiment"], data["Variant"] = e.Name, v.Name
+		token = csrfToken(w, r)
+		data["CSRF"] = token
+		key.variant = e.Name + ":" + v.Name
+	}
+	// Crawlers get a lightweight, fully server-rendered variant listing
+	// every item; people get the interactive page, one page at a time.
+	w.Header().Add("Vary", "User-Agent")
+	cachePage(w, r, key, token, func(w http.ResponseWriter) {
+		data["Tags"] = c.tagCloud(now)
+		live := c.sortItems(localizeAll(c.live(now), writerLocale(w)), order)
+		if isBot(r) && !wantsJSON(r) {
+			name = "home_lite.html"
+			data["Items"] = live
+		} else {
+			data["Items"], data["Paginator"] = paginate(r, live)
+		}
+		renderPage(w, r, name, data, "Items", "Tags")
+	})
+}
+
+func main() {
+	args := os.Args[1:]
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q; want one of: %s\n", name, commandNames())
+		os.Exit(2)
+	}
+	if err := cmd(configure(args)); err != nil {
+		log.Fatal(err)
 	}
-	filePath := filepath.Join(currDir, "static", "data", "items.json")
+}
 
-	f, err := os.Open(filePath)
+// configure loads the configuration from args, the environment and any
+// config file, applies it and opens the item store. Any error is fatal.
+func configure(args []string) Config {
+	cfg, err := loadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatalf("Failed to open %s: %v", filePath, err)
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	setBotAgents(cfg.BotAgents)
+	renderBufferSize = cfg.RenderBuffer
+	renderWait = cfg.RenderWait
+	setMaxRenders(cfg.MaxRenders)
+	pageTTLs = cfg.pageTTLs
+	setCachePolicy(cfg.cacheRules)
+	if cfg.ExpireDelete {
+		go expiryJanitor(cfg.ExpireSweep)
+	}
+	staticMounts = cfg.StaticMounts
+	setAssets(cfg)
+	videoRate = cfg.VideoRate
+	hlsDir, thumbDir, ffmpegBin = cfg.HLSDir, cfg.ThumbDir, cfg.FFmpeg
+	imageCacheDir = cfg.ImageCache
+	posterChain, keywordPosterDir = cfg.posterChain, cfg.KeywordPosters
+	uploadMax = cfg.UploadMax
+	showViews = cfg.ShowViews
+	downloadTTL, downloadBindIP = cfg.DownloadTTL, cfg.DownloadBindIP
+	previewTTL = cfg.PreviewTTL
+	setCookieSecret(cfg.CookieSecret)
+	if err := setCommentLimit(cfg.CommentLimit); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := setCaptcha(cfg.CaptchaProvider, cfg.CaptchaSiteKey, cfg.CaptchaSecret); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := setOAuth(map[string][2]string{
+		"google": {cfg.GoogleClientID, cfg.GoogleSecret},
+		"github": {cfg.GitHubClientID, cfg.GitHubSecret},
+	}); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	setSecurity(cfg)
+	setMaintenance(cfg)
+	flagsFile = cfg.FlagsFile
+	analyticsEnabled = cfg.Analytics
+	if err := setExperiments(cfg.Experiments); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := setCORS(cfg.CORSOrigins, cfg.CORSMethods, cfg.CORSHeaders, cfg.CORSCredentials, cfg.CORSMaxAge); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	setTracing(cfg.OTLPEndpoint, cfg.OTLPService)
+	if err := setLocales(cfg.Locales); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := setContentSource(cfg); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := setWebhooks(cfg.WebhookURLs, cfg.WebhookSecret, cfg.WebhookEvents); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.SentryDSN != "" {
+		sr, err := newSentryReporter(cfg.SentryDSN)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		reporter = sr
+	}
+	setMailer(cfg)
+	contactTo = cfg.ContactTo
+	if media, err = openMedia(cfg); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	adminUser, adminPassword = cfg.AdminUser, cfg.AdminPassword
+	baseURL = cfg.BaseURL
+	setBasePath(cfg.BaseURL)
+	setNormalize(cfg.NormalizeURLs, cfg.TrackingParams)
+	if err := setCanonical(cfg.CanonicalRedirect, cfg.BaseURL, cfg.CanonicalAliases); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	defaultPerPage = cfg.PerPage
+	robotsDisallowAll = cfg.RobotsDisallowAll
+	if err := setTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := setGate(cfg.GateUser, cfg.GatePassword, cfg.GateAllow); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	defer f.Close()
 
-	if err := json.NewDecoder(f).Decode(&items); err != nil {
-		log.Fatalf("Failed to decode items.json: %v", err)
+	// 1) Open the store; commands load items from it as they need
+	store, err = openStore(cfg.Store, cfg.DataPath, cfg.DBPath)
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", cfg.Store, err)
 	}
+	return cfg
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	data := map[string]interface{}{
-		"Title": "BlendingWaves",
-		"Items": items,
+// serveCmd runs the web server until it is told to stop.
+func serveCmd(cfg Config) error {
+	if cfg.Sites != "" {
+		return serveSites(cfg)
 	}
-	if err := tmpl.ExecuteTemplate(w, "home.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	loadItems()
+	startContentSync(cfg.ContentInterval)
+	go publishScheduler()
+	startViews()
+	startExperiments()
+	loadLikes()
+	loadComments()
+	loadFlags()
+	loadRedirects()
+	loadCollections()
+	startJobs(cfg.jobSchedules)
+	app := buildSite(cfg)
+	if cfg.Export != "" {
+		return exportSite(cfg.Export, app)
 	}
+
+	logFeatures(cfg)
+
+	var h http.Handler = app
+	if cfg.Compress {
+		h = compress(cfg.CompressMin, h)
+	}
+	h = gate(h)
+	h = limitRequests(newRequestLimits(cfg.limitRules, cfg.UploadMax, cfg.RequestTimeout, cfg.MaxBody), h)
+	h = rateLimit(cfg.rateRules, h)
+	h = metrics(h)
+	h = accessLog(cfg.AccessLog, h)
+	h = traceRequests(h)
+	h = requestIDs(h)
+	h = withBasePath(h)
+
+	err := listen(cfg, h)
+	flushViews()
+	flushExperiments()
+	flushAnalytics()
+	tracer.flush()
+	return err
 }
 
-func main() {
-	// 1) Load and resolve items
-	loadItems()
+// listen serves h on every configured address, or on the sockets
+// systemd passed when it socket-activated us, with TLS, HTTP/3 and the
+// debug listener when they are set, until the process is told to stop.
+func listen(cfg Config, h http.Handler) error {
+	activated, err := systemdListeners()
+	if err != nil {
+		log.Fatalf("Failed to use the sockets systemd passed: %v", err)
+	}
+	var lns []siteListener
+	var redirectLns []net.Listener
+	var quicConns []net.PacketConn
+	for _, a := range activated {
+		switch {
+		case a.pc != nil:
+			quicConns = append(quicConns, a.pc)
+		case a.name == "redirect":
+			redirectLns = append(redirectLns, a.ln)
+		default:
+			lns = append(lns, siteListener{a.ln, listenAddr{network: a.ln.Addr().Network(), http2: true}})
+		}
+	}
+	if len(activated) == 0 {
+		for _, a := range cfg.listenAddrs() {
+			ln, err := openListener(a, cfg.socketMode)
+			if err != nil {
+				log.Fatalf("Failed to listen on %s: %v", a, err)
+			}
+			lns = append(lns, siteListener{ln, a})
+		}
+	} else {
+		log.Printf("Socket-activated with %d sockets", len(activated))
+	}
+
+	var tlsCfg *tls.Config
+	var servers []boundServer
+	var activatedH3 *http3.Server
+	if tlsEnabled(cfg) {
+		var redirect http.Handler
+		tlsCfg, redirect, err = setupTLS(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		if len(redirectLns) == 0 && cfg.HTTPRedirectPort != "" {
+			rln, err := net.Listen("tcp", ":"+cfg.HTTPRedirectPort)
+			if err != nil {
+				log.Fatalf("Failed to bind HTTP redirect listener: %v", err)
+			}
+			redirectLns = append(redirectLns, rln)
+		}
+		for _, rln := range redirectLns {
+			servers = append(servers, boundServer{srv: newServer(cfg, redirect), ln: rln})
+			log.Printf("Redirecting %s to HTTPS", listenerURL("http", rln))
+		}
+		// Datagram sockets systemd passed serve HTTP/3, advertised by
+		// every socket-activated TLS listener.
+		if len(quicConns) > 0 {
+			activatedH3 = newHTTP3Server(cfg, tlsCfg, h)
+			for _, pc := range quicConns {
+				servers = append(servers, boundServer{h3: activatedH3, pc: pc})
+				log.Printf("Listening on https://%s (HTTP/3) …", pc.LocalAddr())
+			}
+		}
+	}
+	// Unix sockets sit behind a local proxy, which terminates TLS.
+	for _, l := range lns {
+		ln, scheme, handler := l.ln, "http", h
+		var lnTLS *tls.Config
+		if tlsCfg != nil && l.addr.network != "unix" {
+			lnTLS = alpn(tlsCfg, l.addr.http2)
+			ln = tls.NewListener(ln, lnTLS)
+			scheme = "https"
+			h3 := activatedH3
+			if l.addr.http3 {
+				pc, err := net.ListenPacket(udpNetwork(l.addr.network), l.ln.Addr().String())
+				if err != nil {
+					log.Fatalf("Failed to listen for HTTP/3 on %s: %v", l.ln.Addr(), err)
+				}
+				h3 = newHTTP3Server(cfg, lnTLS, h)
+				servers = append(servers, boundServer{h3: h3, pc: pc})
+				log.Printf("Listening on https://%s (HTTP/3) …", pc.LocalAddr())
+			}
+			if h3 != nil {
+				handler = advertiseHTTP3(h3, h)
+			}
+		}
+		srv := newServer(cfg, handler)
+		srv.Protocols = protocols(l.addr, lnTLS != nil)
+		// Event streams never finish on their own; end them when draining.
+		srv.RegisterOnShutdown(hub.close)
+		servers = append(servers, boundServer{srv: srv, ln: ln})
+		log.Printf("Listening on %s …", listenerURL(scheme, ln))
+	}
+	if cfg.DebugAddr != "" {
+		dln, err := net.Listen("tcp", cfg.DebugAddr)
+		if err != nil {
+			log.Fatalf("Failed to bind debug listener: %v", err)
+		}
+		servers = append(servers, boundServer{srv: newServer(cfg, debugMux()), ln: dln})
+		log.Printf("Debug endpoints on http://%s/debug/", cfg.DebugAddr)
+	}
+	return serve(cfg.ShutdownGrace, servers...)
+}
 
-	// Parse templates: header, footer, and home
+// buildSite parses the templates and registers every route, returning
+// the application handler without the per-connection middleware (rate
+// limits, compression, logging) the server adds around it.
+func buildSite(cfg Config) http.Handler {
+	// Parse templates: header, footer, and pages
 	var err error
-	tmpl, err = template.ParseFiles(
-		"templates/header.html",
-		"templates/footer.html",
-		"templates/home.html",
-	)
+	tmpl, err = parseTemplates(templateFS)
 	if err != nil {
-		log.Fatalf("Error parsing templates: %v", err)
+		if !cfg.Dev {
+			log.Fatalf("Error parsing templates: %v", err)
+		}
+		log.Printf("Error parsing templates: %v; pages will show it until it is fixed", err)
+	}
+	if cfg.Dev {
+		devMode = true
+		log.Printf("Development mode: templates are re-parsed on every render")
 	}
+	buildAssets()
 
 	// 2) Dynamic handler for the home page:
-	http.HandleFunc("/", homeHandler)
+	handleFunc("/{$}", homeHandler)
 
-	// 3) Serve everything under ./static/ at URL path /static/
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
+	handleFunc("/items/{key}", itemPageHandler)
+	handleFunc("/search", searchHandler)
+	handleFunc("GET /random", randomHandler)
+	handleFunc("/tags/{tag}", tagPageHandler)
+	handleFunc("GET /s/{code}", shortLinkHandler)
+	handleFunc("GET /preview/{token}", previewHandler)
+	handleFunc("GET /collections", collectionsHandler)
+	handleFunc("GET /collections/{slug}", collectionPageHandler)
+	handleFunc("GET /credits", creditsHandler)
+	handleFunc("/favorites", favoritesHandler)
+	handleFunc("GET /contact", contactHandler)
+	handleFunc("POST /contact", contactPostHandler)
+	handleFunc("GET /newsletter", newsletterHandler)
+	handleFunc("POST /newsletter", newsletterSignupHandler)
+	handleFunc("GET /newsletter/confirm", newsletterConfirmHandler)
+	handleFunc("GET /newsletter/unsubscribe", newsletterUnsubscribeHandler)
+	handleFunc("/video/{id}/{index}", videoHandler)
+	handleFunc("GET /captions/{id}/{index}", captionHandler)
+	if downloadTTL > 0 {
+		handleFunc("POST /download/{id}/{index}", downloadIssueHandler)
+		handleFunc("GET /download/{id}/{index}", downloadHandler)
+	}
+	handleFunc("/img/{path...}", imageHandler)
+	if hlsDir != "" {
+		handleFunc("/hls/{key}/{file}", hlsHandler)
+		go hlsWorker()
+	}
+	if thumbDir != "" {
+		handleFunc("/thumbs/{key}/{file}", thumbHandler)
+	}
+	handleFunc("GET /events", eventsHandler)
+	handleFunc("GET /ws", wsHandler)
+	handleFunc("/feed.xml", feedHandler)
+	handleFunc("/feed.atom", feedHandler)
+	handleFunc("/sitemap.xml", sitemapHandler)
+	handleFunc("/robots.txt", robotsHandler)
+	registerAccounts()
+	registerAdmin(cfg)
+	if cfg.DebugAdmin {
+		registerDebug()
+	}
+	handleFunc("/api/items", itemsHandler)
+	handleFunc("/api/items/{id}", itemHandler)
+	handleFunc("GET /api/items/random", randomAPIHandler)
+	handleFunc("POST /api/items/{id}/like", likeHandler)
+	handleFunc("DELETE /api/items/{id}/like", likeHandler)
+	handleFunc("GET /api/items/{id}/comments", requireFeature("comments", commentsHandler))
+	handleFunc("POST /api/items/{id}/comments", requireFeature("comments", postCommentHandler))
+	handleFunc("/api/items.ndjson", itemsNDJSONHandler)
+	handleFunc("/api/resolve", resolveHandler)
+	handleFunc("POST /api/experiments/goal", experimentGoalHandler)
+	handleFunc("POST /collect", collectHandler)
+	handleFunc("GET /api/search/suggest", requireFeature("search-suggest", suggestHandler))
+	handleFunc("/api/stats", statsHandler)
+	handleFunc("GET /api/mosaic", mosaicHandler)
+	handleFunc("GET /api/openapi.json", openAPIHandler)
+	handleFunc("GET /api/docs", apiDocsHandler)
+	handleFunc("/graphql", graphqlHandler)
+	if cfg.Dev {
+		handleFunc("GET /graphiql", graphiqlHandler)
+	}
+	if gitHookSecret != nil {
+		handleFunc("POST /hooks/git", gitHookHandler)
+	}
+	handleFunc("/healthz", healthzHandler)
+	handleFunc("/readyz", readyzHandler)
+	if cfg.Metrics {
+		handleFunc("/metrics", metricsHandler)
+	}
 
-	// Serve the CSS file at /styles.css
-	http.HandleFunc("/styles.css", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "styles.css")
-	})
+	// 3) Serve everything under the static directory at URL path /static/,
+	// except the items file and its sidecars kept there
+	hiddenStatic = privateStatic(cfg)
+	handle("/static/", hideStatic(withETags(staticFS, "/static/",
+		http.StripPrefix("/static/", http.FileServerFS(staticFS)))))
 
-	// Serve the JavaScript file at /main.js
-	http.HandleFunc("/main.js", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "main.js")
-	})
+	registerMounts()
 
-	http.HandleFunc("/privacy", func(w http.ResponseWriter, r *http.Request) {
-		if err := tmpl.ExecuteTemplate(w, "header.html", nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		// You would have a separate privacy.html template or content here
-		w.Write([]byte("<h1>Privacy Policy</h1><p>Your privacy is important to us.</p>"))
-		if err := tmpl.ExecuteTemplate(w, "footer.html", nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// Serve the CSS file at /styles.css
+	handleFunc("/styles.css", func(w http.ResponseWriter, r *http.Request) {
+		serveFileETag(w, r, rootFS, "styles.css")
 	})
 
-	http.HandleFunc("/tou", func(w http.ResponseWriter, r *http.Request) {
-		if err := tmpl.ExecuteTemplate(w, "header.html", nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		// You would have a separate tou.html template or content here
-		w.Write([]byte("<h1>Terms of Use</h1><p>Please read our terms of use.</p>"))
-		if err := tmpl.ExecuteTemplate(w, "footer.html", nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// Serve the JavaScript file at /main.js
+	handleFunc("/main.js", func(w http.ResponseWriter, r *http.Request) {
+		serveFileETag(w, r, rootFS, "main.js")
 	})
 
-	http.HandleFunc("/non", func(w http.ResponseWriter, r *http.Request) {
-		if err := tmpl.ExecuteTemplate(w, "header.html", nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		// You would have a separate non.html template or content here
-		w.Write([]byte("<h1>Nondiscrimination Policy</h1><p>We are committed to nondiscrimination.</p>"))
-		if err := tmpl.ExecuteTemplate(w, "footer.html", nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	})
+	for _, p := range legalPages {
+		handleFunc(p.path, p.handler)
+	}
 
-	ln, err := net.Listen("tcp4", ":8080")
-	if err != nil {
-		log.Fatalf("Failed to bind to IPv4: %v", err)
+	// Anything no route above claims is a 404.
+	handleFunc("/", notFoundHandler)
+	if err := setDeprecated(cfg.Deprecated); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	log.Println("Listening on http://0.0.0.0:8080 …")
-	log.Fatal(http.Serve(ln, nil))
+
+	return cachePolicy(canonicalize(normalizePaths(securityHeaders(withLocale(recoverPanics(sessions(mediaPasses(withFeatures(collectPageviews(stripTracking(maintenanceMode(withCORS(csrfProtect(withRedirects(withAssets(mux))))))))))))))))
 }
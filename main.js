@@ -5,6 +5,13 @@
  */
 const LIQUID_HERO = {};
 
+/**
+ * The path the site is mounted under, from the page's data-base; empty
+ * when it is served at the root.
+ * @type {string}
+ */
+const basePath = document.documentElement.dataset.base || '';
+
 // --- UTILITY FUNCTIONS ---
 
 /**
@@ -38,6 +45,44 @@ function manageHeaderVisibility(header) {
     window.addEventListener('scroll', updateHeader);
 }
 
+/**
+ * Subscribes to /events and swaps in the freshly rendered grid each time
+ * the server reports a catalog update.
+ * @param {HTMLElement} grid The element holding the item cards.
+ */
+function watchCatalog(grid) {
+    const source = new EventSource(basePath + '/events');
+    let pending = null;
+    source.addEventListener('items', () => {
+        // Coalesce bursts of updates into one fetch
+        clearTimeout(pending);
+        pending = setTimeout(async () => {
+            try {
+                const res = await fetch(window.location.href, { headers: { 'Accept': 'text/html' } });
+                if (!res.ok) return;
+                const doc = new DOMParser().parseFromString(await res.text(), 'text/html');
+                const fresh = doc.querySelector('.home-scroll-container');
+                if (fresh) grid.innerHTML = fresh.innerHTML;
+            } catch (err) {
+                console.error('Refreshing the grid failed:', err);
+            }
+        }, 500);
+    });
+}
+
+/**
+ * Reports an event the server can't see to the first-party analytics
+ * collector. Pageviews are counted by the server itself.
+ * @param {string} event The event name, e.g. 'outbound'.
+ * @param {string} detail A host or path qualifying it.
+ */
+function collect(event, detail) {
+    const body = new Blob([JSON.stringify({ event, detail })], { type: 'application/json' });
+    if (!navigator.sendBeacon || !navigator.sendBeacon(basePath + '/collect', body)) {
+        fetch(basePath + '/collect', { method: 'POST', body, keepalive: true }).catch(() => {});
+    }
+}
+
 // --- MAIN INITIALIZATION ---
 
 /**
@@ -47,6 +92,31 @@ document.addEventListener('DOMContentLoaded', () => {
     // Initialize the dynamic header
     manageHeaderVisibility(document.querySelector('.header-container'));
 
+    // Links that scroll to the services section
+    document.querySelectorAll('[data-scroll-down]').forEach((el) => {
+        el.addEventListener('click', (e) => {
+            e.preventDefault();
+            scrollDown();
+        });
+    });
+
+    // Count visits to other sites and video plays
+    document.addEventListener('click', (e) => {
+        const link = e.target.closest('a[href]');
+        if (link && link.host && link.host !== location.host) {
+            collect('outbound', link.hostname);
+        }
+    });
+    document.querySelectorAll('video[controls]').forEach((video) => {
+        video.addEventListener('play', () => collect('video-play', location.pathname), { once: true });
+    });
+
+    // Refresh the project grid when the catalog changes
+    const grid = document.querySelector('.home-scroll-container');
+    if (grid && typeof EventSource !== 'undefined') {
+        watchCatalog(grid);
+    }
+
     // Find the canvas and initialize the WebGL liquid effect
     const canvas = document.getElementById('interactive-liquid-canvas');
     if (canvas && typeof THREE !== 'undefined') {
@@ -74,8 +144,8 @@ LIQUID_HERO.init = function(canvas) {
 
     // --- Textures ---
     const textureLoader = new THREE.TextureLoader();
-    const topTexture = textureLoader.load('/static/images/pond_top.jpg');
-    const bottomTexture = textureLoader.load('/static/images/pond_bottom.jpg');
+    const topTexture = textureLoader.load(basePath + '/static/images/pond_top.jpg');
+    const bottomTexture = textureLoader.load(basePath + '/static/images/pond_bottom.jpg');
 
     // --- Mouse Tracking & State ---
     // We track the mouse position relative to the canvas for accuracy
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// testSite is the application handler the tests drive: the default
+// configuration over a scratch JSON store, built once by TestMain since
+// routes register on the package mux.
+var (
+	testSite   http.Handler
+	testConfig Config
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	dir, err := os.MkdirTemp("", "blendingwaves-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	testConfig = configure([]string{"-data-path", filepath.Join(dir, "items.json"), "-analytics=false"})
+	loadItems()
+	testSite = buildSite(testConfig)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testItem returns a valid, published item with the given ID and title.
+func testItem(id int, title string) Item {
+	return Item{
+		ID:           id,
+		KeywordTitle: title,
+		Texts:        []string{"About " + title + "."},
+		VideoPath:    []string{"/static/video/chalk.mp4"},
+		VideoCredit:  []string{"https://example.com/credit/" + strconv.Itoa(id)},
+	}
+}
+
+// useItems swaps in a JSON store holding items, and the catalog loaded
+// from it, with its search index built, for the rest of the test.
+func useItems(t testing.TB, items ...Item) {
+	t.Helper()
+	prevStore, prevCatalog := store, snapshot.Load()
+	s := &jsonStore{path: filepath.Join(t.TempDir(), "items.json")}
+	if err := s.write(items); err != nil {
+		t.Fatal(err)
+	}
+	store = s
+	if err := reloadItems(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-current().indexed
+	t.Cleanup(func() {
+		store = prevStore
+		snapshot.Store(prevCatalog)
+	})
+}
+
+// do sends r through the test site and returns the response.
+func do(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	testSite.ServeHTTP(w, r)
+	return w
+}
+
+// get serves a GET of target with the given header pairs.
+func get(target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	return do(r)
+}
+
+// testAdminPassword is the basic-auth password withAdmin turns on.
+const testAdminPassword = "test-password"
+
+// withAdmin enables the basic-auth admin for the rest of the test.
+func withAdmin(t testing.TB) {
+	t.Helper()
+	prev := adminPassword
+	adminPassword = testAdminPassword
+	t.Cleanup(func() { adminPassword = prev })
+}
+
+// adminRequest returns a request signed in as the basic-auth admin.
+func adminRequest(method, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.SetBasicAuth(adminUser, testAdminPassword)
+	return r
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maintenance is the switch behind the 503 page. It starts from
+// -maintenance and admins flip it at runtime; retry is the Retry-After
+// sent with the page, and page the operator's -maintenance-page file.
+var maintenance struct {
+	on      atomic.Bool
+	retry   time.Duration
+	message string
+	page    string
+}
+
+// maintenanceFile caches the -maintenance-page file, keyed by its size
+// and modification time so an edit shows up on the next request.
+var maintenanceFile struct {
+	sync.Mutex
+	stamp string
+	body  []byte
+}
+
+// maintenanceOpen lists the paths that keep working during maintenance:
+// health checks for the load balancer, the admin area, its API and the
+// sign-in it needs, and the assets the 503 page itself uses.
+var maintenanceOpen = []string{
+	"/healthz", "/readyz", "/metrics", "/admin", "/api/admin/", "/login", "/logout", "/auth/",
+	"/hooks/", "/static/", "/styles.css", "/main.js",
+}
+
+func setMaintenance(cfg Config) {
+	maintenance.on.Store(cfg.Maintenance)
+	maintenance.retry = cfg.MaintenanceRetry
+	maintenance.message = cfg.MaintenanceMessage
+	maintenance.page = cfg.MaintenancePage
+}
+
+// maintenanceHTML returns the -maintenance-page file's contents, reading
+// it again whenever it has changed since the last read.
+func maintenanceHTML() ([]byte, error) {
+	fi, err := os.Stat(maintenance.page)
+	if err != nil {
+		return nil, err
+	}
+	stamp := fmt.Sprintf("%d %d", fi.Size(), fi.ModTime().UnixNano())
+	maintenanceFile.Lock()
+	defer maintenanceFile.Unlock()
+	if stamp != maintenanceFile.stamp {
+		body, err := os.ReadFile(maintenance.page)
+		if err != nil {
+			return nil, err
+		}
+		maintenanceFile.stamp, maintenanceFile.body = stamp, body
+	}
+	return maintenanceFile.body, nil
+}
+
+// maintenanceMode answers public routes with the maintenance page while
+// it is on. Signed-in staff still see the site, so they can check it
+// before turning maintenance off.
+func maintenanceMode(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !maintenance.on.Load() || maintenancePath(r.URL.Path) || staff(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		maintenancePage(w, r)
+	})
+}
+
+func maintenancePath(p string) bool {
+	for _, open := range maintenanceOpen {
+		if p == strings.TrimSuffix(open, "/") || strings.HasPrefix(p, strings.TrimSuffix(open, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// staff reports whether r comes from someone allowed into the admin area.
+func staff(r *http.Request) bool {
+	if basicAdmin(r) {
+		return true
+	}
+	u, ok := currentUser(r)
+	return ok && hasRole(u.Role, RoleViewer)
+}
+
+// maintenancePage sends the 503: JSON for API clients, the operator's
+// page or else the styled one otherwise, telling both when to come back.
+func maintenancePage(w http.ResponseWriter, r *http.Request) {
+	if s := int(maintenance.retry.Seconds()); s > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(s))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if isAPI(r.URL.Path) || wantsJSON(r) {
+		writeJSONError(w, http.StatusServiceUnavailable, "down for maintenance")
+		return
+	}
+	if maintenance.page != "" {
+		body, err := maintenanceHTML()
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(body)
+			return
+		}
+		log.Printf("maintenance: %v; serving the built-in page", err)
+	}
+	data := map[string]interface{}{
+		"Title":   "Down for maintenance | BlendingWaves",
+		"Message": maintenance.message,
+		"Retry":   retryText(maintenance.retry),
+	}
+	if err := renderStatus(w, http.StatusServiceUnavailable, "503.html", data); err != nil {
+		http.Error(w, "Down for maintenance", http.StatusServiceUnavailable)
+	}
+}
+
+// retryText says d in whole minutes, or seconds when shorter.
+func retryText(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	if d < time.Minute {
+		return pluralize(int(d.Seconds()), "second", "seconds")
+	}
+	return pluralize(int(d.Round(time.Minute).Minutes()), "minute", "minutes")
+}
+
+// adminMaintenanceHandler turns maintenance on or off from the posted
+// "on" value.
+func adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	on, err := strconv.ParseBool(r.PostFormValue("on"))
+	if err != nil {
+		http.Error(w, "on must be true or false", http.StatusBadRequest)
+		return
+	}
+	was := maintenance.on.Swap(on)
+	audit(r, "maintenance", "maintenance", map[string]bool{"on": was}, map[string]bool{"on": on})
+	log.Printf("admin: %s turned maintenance mode %s", actor(r), map[bool]string{true: "on", false: "off"}[on])
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
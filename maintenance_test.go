@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenancePageFile(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "down.html")
+	if err := os.WriteFile(page, []byte("<h1>Back at noon</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig
+	cfg.Maintenance, cfg.MaintenancePage = true, page
+	setMaintenance(cfg)
+	t.Cleanup(func() { setMaintenance(testConfig) })
+
+	w := get("/")
+	if w.Code != 503 || w.Body.String() != "<h1>Back at noon</h1>" {
+		t.Fatalf("GET / = %d %q, want 503 with the file", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	if err := os.WriteFile(page, []byte("<h1>Back at two</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(page, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("/"); w.Body.String() != "<h1>Back at two</h1>" {
+		t.Errorf("after an edit GET / = %q, want the new file", w.Body.String())
+	}
+
+	os.Remove(page)
+	if w := get("/"); w.Code != 503 || !strings.Contains(w.Body.String(), "<html") {
+		t.Errorf("with the file gone GET / = %d, want the built-in 503 page", w.Code)
+	}
+	if w := get("/api/items"); w.Code != 503 || !strings.Contains(w.Body.String(), "maintenance") {
+		t.Errorf("GET /api/items = %d %q, want the JSON 503", w.Code, w.Body.String())
+	}
+}
+
+func TestMaintenancePageUnreadable(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AdminPassword = "secret"
+	cfg.MaintenancePage = filepath.Join(t.TempDir(), "missing.html")
+	err := cfg.validate()
+	if err == nil || !strings.Contains(err.Error(), "maintenance-page") {
+		t.Errorf("validate() = %v, want a maintenance-page error", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+)
+
+// markdownCacheMax bounds the rendered-text cache; it is emptied when
+// full, which only happens if items churn far more than they do.
+const markdownCacheMax = 4096
+
+// md renders item texts with links auto-detected. Raw HTML in the source
+// is dropped by goldmark, and its output is sanitized again so nothing
+// an editor types can run script.
+var (
+	md       = goldmark.New(goldmark.WithExtensions(extension.Linkify, extension.Strikethrough))
+	mdPolicy = bluemonday.UGCPolicy().AddTargetBlankToFullyQualifiedLinks(true)
+)
+
+// renderedText is one Texts entry as HTML and as plain text.
+type renderedText struct {
+	html template.HTML
+	text string
+}
+
+var markdownCache = struct {
+	sync.Mutex
+	m map[string]renderedText
+}{m: map[string]renderedText{}}
+
+// renderMarkdown converts src, caching the result by source text.
+func renderMarkdown(src string) renderedText {
+	markdownCache.Lock()
+	r, ok := markdownCache.m[src]
+	markdownCache.Unlock()
+	if ok {
+		return r
+	}
+	source := []byte(src)
+	doc := md.Parser().Parse(text.NewReader(source))
+	var buf bytes.Buffer
+	if err := md.Renderer().Render(&buf, source, doc); err != nil {
+		buf.Reset()
+		buf.WriteString(template.HTMLEscapeString(src))
+	}
+	r = renderedText{
+		html: template.HTML(mdPolicy.SanitizeBytes(buf.Bytes())),
+		text: plainText(doc, source),
+	}
+	markdownCache.Lock()
+	if len(markdownCache.m) >= markdownCacheMax {
+		clear(markdownCache.m)
+	}
+	markdownCache.m[src] = r
+	markdownCache.Unlock()
+	return r
+}
+
+// plainText joins the words of a parsed document, for places that show
+// an excerpt: cards, meta descriptions and feeds.
+func plainText(doc ast.Node, source []byte) string {
+	var b strings.Builder
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch n := n.(type) {
+		case *ast.Text:
+			if entering {
+				b.Write(n.Segment.Value(source))
+				if n.SoftLineBreak() || n.HardLineBreak() {
+					b.WriteByte(' ')
+				}
+			}
+		case *ast.String:
+			if entering {
+				b.Write(n.Value)
+			}
+		case *ast.AutoLink:
+			if entering {
+				b.Write(n.Label(source))
+			}
+		default:
+			if !entering && n.Type() == ast.TypeBlock {
+				b.WriteByte(' ')
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// markdownHTML is the "markdown" template func: a Texts entry as
+// sanitized HTML.
+func markdownHTML(src string) template.HTML { return renderMarkdown(src).html }
+
+// markdownText is the "plain" template func: a Texts entry with its
+// Markdown stripped.
+func markdownText(src string) string { return renderMarkdown(src).text }
+
+// plainTexts joins an item's texts, Markdown stripped, one paragraph
+// each.
+func plainTexts(texts []string) string {
+	out := make([]string, len(texts))
+	for i, t := range texts {
+		out[i] = markdownText(t)
+	}
+	return strings.Join(out, "\n\n")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaStore resolves the media references in Item.VideoPath to URLs a
+// client can fetch. Put stores size bytes of body under key, a slash
+// path such as "video/clip.mp4", and returns the reference to record.
+type MediaStore interface {
+	URL(ref string) (string, error)
+	Put(key, contentType string, body io.Reader, size int64) (string, error)
+}
+
+// media is the store selected by -media-store.
+var media MediaStore = &localMedia{Dir: "static"}
+
+// remotePrefix marks a VideoPath entry as an object key in the media
+// bucket, e.g. "s3:videos/agents.mp4".
+const remotePrefix = "s3:"
+
+// isRemoteMedia reports whether ref lives in the object store rather than
+// under /static/.
+func isRemoteMedia(ref string) bool {
+	return strings.HasPrefix(ref, remotePrefix)
+}
+
+// localMedia serves media from the static directory Dir; references are
+// already URL paths.
+type localMedia struct {
+	Dir string
+}
+
+func (*localMedia) URL(ref string) (string, error) {
+	if isRemoteMedia(ref) {
+		return "", fmt.Errorf("%s: no object store configured", ref)
+	}
+	return ref, nil
+}
+
+func (l *localMedia) Put(key, contentType string, body io.Reader, size int64) (string, error) {
+	if fi, err := os.Stat(l.Dir); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("uploads need an on-disk static directory; %s is missing", l.Dir)
+	}
+	name := filepath.Join(l.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return "", err
+	}
+	return "/static/" + key, nil
+}
+
+// s3Media signs time-limited GET URLs for objects in an S3-compatible
+// bucket (AWS S3, MinIO, R2, or GCS with HMAC keys). With a Bucket the
+// URLs are path-style; without one the Endpoint must name the bucket, as
+// in https://bucket.s3.amazonaws.com. Local references pass through
+// unchanged.
+type s3Media struct {
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Expiry    time.Duration
+}
+
+func (s *s3Media) URL(ref string) (string, error) {
+	key, ok := strings.CutPrefix(ref, remotePrefix)
+	if !ok {
+		return ref, nil
+	}
+	if key == "" {
+		return "", errors.New("empty object key")
+	}
+	return s.presign("GET", key, time.Now()), nil
+}
+
+func (s *s3Media) Put(key, contentType string, body io.Reader, size int64) (string, error) {
+	req, err := http.NewRequest("PUT", s.presign("PUT", key, time.Now()), body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("s3 put %s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return remotePrefix + key, nil
+}
+
+// presign returns a SigV4 query-signed URL for method on key, valid for
+// s.Expiry from now.
+func (s *s3Media) presign(method, key string, now time.Time) string {
+	now = now.UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	path := strings.TrimSuffix(s.Endpoint.Path, "/")
+	if s.Bucket != "" {
+		path += "/" + s.Bucket
+	}
+	path += "/" + key
+
+	q := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    s.AccessKey + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.Itoa(int(s.Expiry / time.Second)),
+		"X-Amz-SignedHeaders": "host",
+	}
+	names := make([]string, 0, len(q))
+	for k := range q {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var query strings.Builder
+	for i, k := range names {
+		if i > 0 {
+			query.WriteByte('&')
+		}
+		query.WriteString(awsEscape(k, true) + "=" + awsEscape(q[k], true))
+	}
+
+	canonical := strings.Join([]string{
+		method,
+		awsEscape(path, false),
+		query.String(),
+		"host:" + s.Endpoint.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	k := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	k = hmacSHA256(k, s.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	return s.Endpoint.Scheme + "://" + s.Endpoint.Host + awsEscape(path, false) +
+		"?" + query.String() + "&X-Amz-Signature=" + sig
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but unreserved characters, as
+// SigV4 requires; slashes survive unless slash is set.
+func awsEscape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// openMedia builds the media store named by cfg.MediaStore.
+func openMedia(cfg Config) (MediaStore, error) {
+	switch cfg.MediaStore {
+	case "local":
+		return &localMedia{Dir: cfg.StaticDir}, nil
+	case "s3":
+		u, err := url.Parse(cfg.S3Endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("s3-endpoint must be an absolute URL, got %q", cfg.S3Endpoint)
+		}
+		if cfg.S3AccessKey == "" || cfg.S3SecretKey == "" {
+			return nil, errors.New("media-store s3 needs s3-access-key and s3-secret-key")
+		}
+		return &s3Media{
+			Endpoint:  u,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			Expiry:    cfg.S3URLExpiry,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown media store %q", cfg.MediaStore)
+}
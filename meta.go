@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// siteName and siteDescription describe the site in page metadata.
+const (
+	siteName        = "BlendingWaves"
+	siteDescription = "Revolutionize your business strategies with the power of AI and Machine Learning, designed for modern impact."
+)
+
+// PageMeta is the social/SEO metadata emitted in header.html.
+type PageMeta struct {
+	Title       string
+	Description string
+	URL         string
+	Type        string // og:type
+	Image       string
+	Video       string
+	TwitterCard string
+	JSONLD      []any // schema.org objects, one <script> each
+}
+
+// VideoObject is the schema.org structured data for one video.
+type VideoObject struct {
+	Context      string `json:"@context"`
+	Type         string `json:"@type"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	ContentURL   string `json:"contentUrl,omitempty"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	UploadDate   string `json:"uploadDate"`
+	EmbedURL     string `json:"embedUrl,omitempty"`
+	CreditText   string `json:"creditText,omitempty"`
+	Caption      string `json:"caption,omitempty"`
+}
+
+// videoObjects builds a VideoObject for each of the item's videos,
+// pairing VideoPath and VideoCredit by position.
+func videoObjects(r *http.Request, c *catalog, it *Item) []any {
+	desc := it.KeywordTitle
+	if len(it.Texts) > 0 {
+		desc = markdownText(it.Texts[0])
+	}
+	var out []any
+	for i := range it.VideoPath {
+		vo := VideoObject{
+			Context:      "https://schema.org",
+			Type:         "VideoObject",
+			Name:         it.KeywordTitle,
+			Description:  desc,
+			ContentURL:   publicVideoURL(r, it, i),
+			ThumbnailURL: siteURL(r, cmp.Or(posterFor(it, i), defaultThumbnail)),
+			UploadDate:   c.publishedAt(it).UTC().Format(time.RFC3339),
+			EmbedURL:     siteURL(r, "/items/"+it.Slug),
+		}
+		if i < len(it.VideoCredit) {
+			vo.CreditText = it.VideoCredit[i]
+		}
+		if tracks := it.CaptionTracks(i, ""); len(tracks) > 0 {
+			vo.Caption = siteURL(r, tracks[0].URL)
+		}
+		out = append(out, vo)
+	}
+	return out
+}
+
+// jsonLD marshals v for a <script type="application/ld+json"> block.
+// json.Marshal escapes <, > and &, so the output can't close the script.
+func jsonLD(v any) (template.JS, error) {
+	b, err := json.Marshal(v)
+	return template.JS(b), err
+}
+
+// homeMeta describes the home page.
+func homeMeta(r *http.Request) PageMeta {
+	return PageMeta{
+		Title:       siteName,
+		Description: siteDescription,
+		URL:         siteURL(r, "/"),
+		Type:        "website",
+		Image:       siteURL(r, "/static/images/hero.png"),
+		TwitterCard: "summary_large_image",
+	}
+}
+
+// itemMeta describes an item detail page from the item's fields.
+func itemMeta(r *http.Request, c *catalog, it *Item) PageMeta {
+	m := PageMeta{
+		Title:       it.KeywordTitle,
+		Description: siteDescription,
+		URL:         siteURL(r, "/items/"+it.Slug),
+		Type:        "article",
+		Image:       siteURL(r, defaultThumbnail),
+		TwitterCard: "summary_large_image",
+	}
+	if len(it.Texts) > 0 {
+		m.Description = truncate(markdownText(it.Texts[0]), 200)
+	}
+	if len(it.VideoPath) > 0 {
+		m.Type = "video.other"
+		m.Video = publicVideoURL(r, it, 0)
+		if p := posterFor(it, 0); p != "" {
+			m.Image = siteURL(r, p)
+		}
+		m.JSONLD = videoObjects(r, c, it)
+	}
+	return m
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // one per bucket, non-cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// requestKey labels a request counter.
+type requestKey struct {
+	route  string
+	status int
+}
+
+// metricsRegistry holds the counters exposed at /metrics in the
+// Prometheus text format.
+var metricsRegistry = struct {
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	latency  map[string]*histogram
+	inFlight atomic.Int64
+}{
+	requests: map[requestKey]uint64{},
+	latency:  map[string]*histogram{},
+}
+
+// renderFailures counts template executions that returned an error.
+var renderFailures atomic.Int64
+
+// metrics records request counts, latency and in-flight requests, labelled
+// by the mux pattern that handled the request.
+func metrics(h http.Handler) http.Handler {
+	m := &metricsRegistry
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		elapsed := time.Since(start).Seconds()
+		m.mu.Lock()
+		m.requests[requestKey{route, rec.Status()}]++
+		hist := m.latency[route]
+		if hist == nil {
+			hist = &histogram{counts: make([]uint64, len(latencyBuckets))}
+			m.latency[route] = hist
+		}
+		hist.observe(elapsed)
+		m.mu.Unlock()
+	})
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
+
+// writeMetrics writes every metric in the Prometheus text format.
+func writeMetrics(w io.Writer) {
+	m := &metricsRegistry
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP http_requests_total HTTP requests by route and status.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if a.route != b.route {
+			if a.route < b.route {
+				return -1
+			}
+			return 1
+		}
+		return a.status - b.status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "http_requests_total{route=%q,status=\"%d\"} %d\n", k.route, k.status, m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP http_request_duration_seconds HTTP request latency by route.")
+	fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+	routes := make([]string, 0, len(m.latency))
+	for route := range m.latency {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+	for _, route := range routes {
+		h := m.latency[route]
+		var cum uint64
+		for i, le := range latencyBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{route=%q,le=%q} %d\n", route, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", route, h.count)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{route=%q} %g\n", route, h.sum)
+		fmt.Fprintf(w, "http_request_duration_seconds_count{route=%q} %d\n", route, h.count)
+	}
+
+	gauge(w, "http_requests_in_flight", "Requests currently being served.", m.inFlight.Load())
+	gauge(w, "items_loaded", "Items in the current catalog snapshot.", int64(len(current().items)))
+	counter(w, "template_render_failures_total", "Template executions that failed.", renderFailures.Load())
+	counter(w, "render_slot_rejections_total", "Renders rejected because no render slot freed up.", renderBusyCnt.Load())
+	fmt.Fprintln(w, "# HELP render_slot_wait_seconds_total Time spent waiting for a render slot.")
+	fmt.Fprintln(w, "# TYPE render_slot_wait_seconds_total counter")
+	fmt.Fprintf(w, "render_slot_wait_seconds_total %g\n", time.Duration(renderWaitNs.Load()).Seconds())
+	writePageCacheMetrics(w)
+	writeJobMetrics(w)
+}
+
+func gauge(w io.Writer, name, help string, v int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, v)
+}
+
+func counter(w io.Writer, name, help string, v int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// statusRecorder captures the status code and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// Status returns the recorded status, defaulting to 200.
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+// remoteIP returns the host part of r.RemoteAddr.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// orDash returns s, or "-" when s is empty, as combined logs expect.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// accessLog logs one line per request in the given format: "json" (slog)
+// or "combined" (Apache combined log format). "off" disables logging.
+func accessLog(format string, h http.Handler) http.Handler {
+	if format == "off" {
+		return h
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if format == "combined" {
+			fmt.Fprintf(os.Stdout, "%s - - [%s] %q %d %d %q %q %q\n",
+				clientIP(r), start.Format("02/Jan/2006:15:04:05 -0700"),
+				r.Method+" "+r.URL.RequestURI()+" "+r.Proto, rec.Status(), rec.bytes,
+				orDash(r.Referer()), orDash(r.UserAgent()), orDash(requestIDFrom(r.Context())))
+			return
+		}
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.Status(),
+			"bytes", rec.bytes,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote_ip", clientIP(r),
+			"user_agent", r.UserAgent(),
+			"request_id", requestIDFrom(r.Context()),
+		}
+		if s := spanFrom(r.Context()); s != nil {
+			attrs = append(attrs, "trace_id", hex.EncodeToString(s.traceID[:]))
+		}
+		logger.Info("request", attrs...)
+	})
+}
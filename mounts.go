@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// staticMount serves a directory under a URL prefix.
+type staticMount struct {
+	URLPath      string
+	Dir          string
+	CacheControl string
+}
+
+// mountList implements flag.Value for repeated -static-mount flags of the
+// form urlpath=dir or urlpath=dir;cache-control.
+type mountList []staticMount
+
+func (m *mountList) String() string {
+	var parts []string
+	for _, sm := range *m {
+		parts = append(parts, sm.URLPath+"="+sm.Dir)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *mountList) Set(v string) error {
+	urlPath, rest, ok := strings.Cut(v, "=")
+	if !ok || urlPath == "" || rest == "" {
+		return fmt.Errorf("want urlpath=dir, got %q", v)
+	}
+	dir, cc, _ := strings.Cut(rest, ";")
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+	if !strings.HasSuffix(urlPath, "/") {
+		urlPath += "/"
+	}
+	*m = append(*m, staticMount{URLPath: urlPath, Dir: dir, CacheControl: strings.TrimSpace(cc)})
+	return nil
+}
+
+var staticMounts mountList
+
+// staticFile maps a /static/ URL path to its name in staticFS, reporting
+// false for other URLs and for unsafe paths.
+func staticFile(urlPath string) (string, bool) {
+	rel, ok := strings.CutPrefix(urlPath, "/static/")
+	if !ok || !safePath(rel) {
+		return "", false
+	}
+	return rel, true
+}
+
+// registerMounts validates and wires every configured static mount.
+func registerMounts() {
+	for _, sm := range staticMounts {
+		fi, err := os.Stat(sm.Dir)
+		if err != nil || !fi.IsDir() {
+			log.Fatalf("static mount %s: %s is not a readable directory", sm.URLPath, sm.Dir)
+		}
+		handle(sm.URLPath, mountHandler(sm))
+	}
+}
+
+// mountHandler serves sm.Dir, refusing traversal and dotfiles.
+func mountHandler(sm staticMount) http.Handler {
+	dir := os.DirFS(sm.Dir)
+	fs := withETags(dir, sm.URLPath, http.StripPrefix(sm.URLPath, http.FileServerFS(dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !safePath(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		if sm.CacheControl != "" {
+			w.Header().Set("Cache-Control", sm.CacheControl)
+		}
+		fs.ServeHTTP(w, r)
+	})
+}
+
+// safePath rejects paths with parent references or hidden segments.
+func safePath(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		if strings.HasPrefix(seg, ".") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMountHandler(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "media")
+	for name, data := range map[string]string{
+		"media/clip.txt":      "clip",
+		"media/sub/notes.txt": "notes",
+		"media/.env":          "hidden",
+		"secret.txt":          "secret",
+	} {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := mountHandler(staticMount{URLPath: "/media/", Dir: dir, CacheControl: "public, max-age=60"})
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/media/clip.txt", 200, "clip"},
+		{"/media/sub/notes.txt", 200, "notes"},
+		{"/media/missing.txt", 404, ""},
+		{"/media/.env", 404, ""},
+		{"/media/../secret.txt", 404, ""},
+		{"/media/sub/../../secret.txt", 404, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.path, w.Code, tt.code)
+			continue
+		}
+		if tt.code != 200 {
+			continue
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.path, w.Body, tt.body)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+			t.Errorf("%s: Cache-Control %q", tt.path, cc)
+		}
+	}
+}
+
+func TestMountListSet(t *testing.T) {
+	tests := []struct {
+		flag    string
+		want    staticMount
+		wantErr bool
+	}{
+		{"/media/=/srv/media", staticMount{URLPath: "/media/", Dir: "/srv/media"}, false},
+		{"downloads=/srv/dl;no-store", staticMount{URLPath: "/downloads/", Dir: "/srv/dl", CacheControl: "no-store"}, false},
+		{"/media", staticMount{}, true},
+		{"=/srv/media", staticMount{}, true},
+	}
+	for _, tt := range tests {
+		var m mountList
+		err := m.Set(tt.flag)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v", tt.flag, err)
+			continue
+		}
+		if !tt.wantErr && m[0] != tt.want {
+			t.Errorf("Set(%q) = %+v, want %+v", tt.flag, m[0], tt.want)
+		}
+	}
+}
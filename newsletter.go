@@ -0,0 +1,306 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Subscriber states. Signups stay pending until the emailed link is
+// followed.
+const (
+	SubscriberPending      = "pending"
+	SubscriberConfirmed    = "confirmed"
+	SubscriberUnsubscribed = "unsubscribed"
+)
+
+// confirmTokenTTL is how long a confirmation link stays valid.
+const confirmTokenTTL = 48 * time.Hour
+
+// Subscriber is one newsletter address.
+type Subscriber struct {
+	Email       string     `json:"email"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// subscriberStore is implemented by repositories that keep subscribers,
+// keyed by lower-cased email.
+type subscriberStore interface {
+	Subscribers() ([]Subscriber, error)
+	GetSubscriber(email string) (Subscriber, error)
+	PutSubscriber(s Subscriber) error
+}
+
+func subscribers() (subscriberStore, error) {
+	ss, ok := store.(subscriberStore)
+	if !ok {
+		return nil, errors.New("store does not support subscribers")
+	}
+	return ss, nil
+}
+
+func newsletterHandler(w http.ResponseWriter, r *http.Request) {
+	renderNewsletter(w, r, http.StatusOK, "form", "")
+}
+
+// newsletterSignupHandler records a pending subscriber and mails the
+// confirmation link. Known addresses get the same answer, so the form
+// can't be used to probe who subscribes.
+func newsletterSignupHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("website") != "" {
+		http.Redirect(w, r, "/newsletter?state=check", http.StatusSeeOther)
+		return
+	}
+	addr, err := mail.ParseAddress(strings.TrimSpace(r.PostForm.Get("email")))
+	if err != nil {
+		renderNewsletter(w, r, http.StatusBadRequest, "form", "Please enter a valid email address.")
+		return
+	}
+	if err := verifyCaptcha(r); err != nil {
+		renderNewsletter(w, r, http.StatusBadRequest, "form", err.Error())
+		return
+	}
+	ss, err := subscribers()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	email := strings.ToLower(addr.Address)
+	sub, err := ss.GetSubscriber(email)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		sub = Subscriber{Email: email, Status: SubscriberPending, CreatedAt: time.Now().UTC()}
+	case err != nil:
+		serverError(w, err)
+		return
+	}
+	if sub.Status != SubscriberConfirmed {
+		sub.Status = SubscriberPending
+		if err := ss.PutSubscriber(sub); err != nil {
+			serverError(w, err)
+			return
+		}
+		link := siteURL(r, "/newsletter/confirm?token="+url.QueryEscape(
+			signToken("newsletter-confirm", email, time.Now().Add(confirmTokenTTL))))
+		sendMail(Message{
+			To:      []string{email},
+			Subject: "Confirm your BlendingWaves subscription",
+			Body: fmt.Sprintf("Please confirm your subscription to the BlendingWaves newsletter:\n\n%s\n\n"+
+				"The link is valid for %d hours. If you didn't sign up, ignore this email.\n",
+				link, int(confirmTokenTTL.Hours())),
+		})
+	}
+	convert(w, r, GoalSubscribe)
+	http.Redirect(w, r, "/newsletter?state=check", http.StatusSeeOther)
+}
+
+// newsletterConfirmHandler confirms the subscriber named by ?token=.
+func newsletterConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	updateSubscriber(w, r, "newsletter-confirm", func(s *Subscriber) {
+		now := time.Now().UTC()
+		s.Status, s.ConfirmedAt = SubscriberConfirmed, &now
+	}, "confirmed")
+}
+
+// newsletterUnsubscribeHandler unsubscribes the address in ?token=. The
+// link, included in every newsletter, doesn't expire.
+func newsletterUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	updateSubscriber(w, r, "newsletter-unsubscribe", func(s *Subscriber) {
+		s.Status = SubscriberUnsubscribed
+	}, "unsubscribed")
+}
+
+func updateSubscriber(w http.ResponseWriter, r *http.Request, purpose string, apply func(*Subscriber), state string) {
+	email, err := verifyToken(purpose, r.URL.Query().Get("token"), time.Now())
+	if err != nil {
+		msg := "This link is invalid."
+		if errors.Is(err, errExpiredToken) {
+			msg = "This link has expired; please sign up again."
+		}
+		renderNewsletter(w, r, http.StatusBadRequest, "form", msg)
+		return
+	}
+	ss, err := subscribers()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	sub, err := ss.GetSubscriber(email)
+	if err != nil {
+		renderNewsletter(w, r, http.StatusNotFound, "form", "We couldn't find that subscription.")
+		return
+	}
+	apply(&sub)
+	if err := ss.PutSubscriber(sub); err != nil {
+		serverError(w, err)
+		return
+	}
+	log.Printf("newsletter: %s %s", email, state)
+	renderNewsletter(w, r, http.StatusOK, state, "")
+}
+
+// unsubscribeURL is the link to put in newsletters sent to email.
+func unsubscribeURL(r *http.Request, email string) string {
+	return siteURL(r, "/newsletter/unsubscribe?token="+url.QueryEscape(
+		signToken("newsletter-unsubscribe", email, time.Time{})))
+}
+
+func renderNewsletter(w http.ResponseWriter, r *http.Request, status int, state, formErr string) {
+	if q := r.URL.Query().Get("state"); q == "check" && state == "form" && formErr == "" {
+		state = q
+	}
+	data := map[string]interface{}{
+		"Title":   "Newsletter | BlendingWaves",
+		"State":   state,
+		"Error":   formErr,
+		"CSRF":    csrfToken(w, r),
+		"Captcha": captcha,
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if err := renderStatus(w, status, "newsletter.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// adminSubscribersHandler exports every subscriber as CSV, with an
+// unsubscribe link for confirmed ones.
+func adminSubscribersHandler(w http.ResponseWriter, r *http.Request) {
+	ss, err := subscribers()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	all, err := ss.Subscribers()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="subscribers.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"email", "status", "created_at", "confirmed_at", "unsubscribe_url"})
+	for _, s := range all {
+		confirmed, unsub := "", ""
+		if s.ConfirmedAt != nil {
+			confirmed = s.ConfirmedAt.Format(time.RFC3339)
+		}
+		if s.Status == SubscriberConfirmed {
+			unsub = unsubscribeURL(r, s.Email)
+		}
+		cw.Write([]string{s.Email, s.Status, s.CreatedAt.Format(time.RFC3339), confirmed, unsub})
+	}
+	cw.Flush()
+}
+
+// subscribersPath is where the JSON store keeps subscribers.
+func (s *jsonStore) subscribersPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".subscribers.json"
+}
+
+func (s *jsonStore) Subscribers() ([]Subscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readSubscribers()
+}
+
+func (s *jsonStore) readSubscribers() ([]Subscriber, error) {
+	data, err := os.ReadFile(s.subscribersPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Subscriber
+	return out, json.Unmarshal(data, &out)
+}
+
+func (s *jsonStore) GetSubscriber(email string) (Subscriber, error) {
+	all, err := s.Subscribers()
+	if err != nil {
+		return Subscriber{}, err
+	}
+	if i := slices.IndexFunc(all, func(x Subscriber) bool { return x.Email == email }); i >= 0 {
+		return all[i], nil
+	}
+	return Subscriber{}, ErrNotFound
+}
+
+func (s *jsonStore) PutSubscriber(sub Subscriber) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readSubscribers()
+	if err != nil {
+		return err
+	}
+	if i := slices.IndexFunc(all, func(x Subscriber) bool { return x.Email == sub.Email }); i >= 0 {
+		all[i] = sub
+	} else {
+		all = append(all, sub)
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.subscribersPath(), data)
+}
+
+func (s *sqliteStore) Subscribers() ([]Subscriber, error) {
+	rows, err := s.db.Query(`SELECT email, status, created_at, confirmed_at FROM subscribers ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Subscriber
+	for rows.Next() {
+		var sub Subscriber
+		var confirmed sql.NullTime
+		if err := rows.Scan(&sub.Email, &sub.Status, &sub.CreatedAt, &confirmed); err != nil {
+			return nil, err
+		}
+		if confirmed.Valid {
+			sub.ConfirmedAt = &confirmed.Time
+		}
+		out = append(out, sub)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) GetSubscriber(email string) (Subscriber, error) {
+	sub := Subscriber{Email: email}
+	var confirmed sql.NullTime
+	err := s.db.QueryRow(`SELECT status, created_at, confirmed_at FROM subscribers WHERE email = ?`, email).
+		Scan(&sub.Status, &sub.CreatedAt, &confirmed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Subscriber{}, ErrNotFound
+	}
+	if confirmed.Valid {
+		sub.ConfirmedAt = &confirmed.Time
+	}
+	return sub, err
+}
+
+func (s *sqliteStore) PutSubscriber(sub Subscriber) error {
+	_, err := s.db.Exec(`INSERT INTO subscribers (email, status, created_at, confirmed_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(email) DO UPDATE SET status = excluded.status, confirmed_at = excluded.confirmed_at`,
+		sub.Email, sub.Status, sub.CreatedAt, sub.ConfirmedAt)
+	return err
+}
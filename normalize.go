@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// normalizeURLs turns on normalizePaths; trackingParams is what
+// stripTracking removes.
+var (
+	normalizeURLs  bool
+	trackingParams []string
+)
+
+// normalizeExempt are prefixes whose paths name files or are matched
+// exactly by clients, so their case and slashes are left alone. Static
+// mounts are exempt too.
+var normalizeExempt = []string{"/static/", "/img/", "/video/", "/hls/", "/api/", "/auth/", "/hooks/", "/preview/", "/graphql", "/.well-known/"}
+
+// setNormalize sets up URL normalization: whether page paths are
+// redirected to their normal form, and the comma-separated tracking
+// parameters to strip, each a name or a prefix ending in *.
+func setNormalize(on bool, params string) {
+	normalizeURLs = on
+	trackingParams = nil
+	for _, p := range strings.Split(params, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			trackingParams = append(trackingParams, p)
+		}
+	}
+}
+
+// normalizePaths 301-redirects GET and HEAD requests for a page path
+// with uppercase letters, repeated slashes or a trailing slash to the
+// lowercase, single-slashed form without it, so /Items//3/ and /items/3
+// are one page to caches, analytics and search engines. The query is
+// kept as it is.
+func normalizePaths(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !normalizeURLs || r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		p, ok := normalPath(r.URL.Path)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		u := url.URL{Path: p, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
+
+// normalPath returns the normal form of the page path p, reporting false
+// when p already is normal or is exempt.
+func normalPath(p string) (string, bool) {
+	_, rest, _ := localePrefix(p)
+	if slices.ContainsFunc(normalizeExempt, func(pre string) bool { return strings.HasPrefix(rest, pre) }) ||
+		slices.ContainsFunc(staticMounts, func(sm staticMount) bool { return strings.HasPrefix(rest, sm.URLPath) }) {
+		return "", false
+	}
+	np := strings.ToLower(p)
+	for strings.Contains(np, "//") {
+		np = strings.ReplaceAll(np, "//", "/")
+	}
+	if len(np) > 1 {
+		np = strings.TrimSuffix(np, "/")
+	}
+	return np, np != p
+}
+
+// stripTracking removes tracking parameters such as utm_source from the
+// query before routing, so handlers and caches see one URL per page. It
+// runs inside collectPageviews, which still reads them as the visit's
+// source.
+func stripTracking(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(trackingParams) == 0 || r.URL.RawQuery == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		q := r.URL.Query()
+		n := len(q)
+		for k := range q {
+			if isTrackingParam(k) {
+				q.Del(k)
+			}
+		}
+		if len(q) == n {
+			h.ServeHTTP(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.RawQuery = q.Encode()
+		r2.URL = &u
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// isTrackingParam reports whether the query parameter k is one of
+// trackingParams.
+func isTrackingParam(k string) bool {
+	k = strings.ToLower(k)
+	for _, p := range trackingParams {
+		if pre, ok := strings.CutSuffix(p, "*"); ok && strings.HasPrefix(k, pre) || k == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// oauthIdentity is what a provider tells us about the visitor.
+type oauthIdentity struct {
+	Subject  string
+	Email    string
+	Verified bool
+	Name     string
+}
+
+// oauthProvider describes an OAuth2 authorization-code provider and how
+// to read the signed-in account from its API.
+type oauthProvider struct {
+	Title    string
+	AuthURL  string
+	TokenURL string
+	Scope    string
+	identify func(token string) (oauthIdentity, error)
+}
+
+var oauthProviders = map[string]oauthProvider{
+	"google": {
+		Title:    "Google",
+		AuthURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL: "https://oauth2.googleapis.com/token",
+		Scope:    "openid email profile",
+		identify: googleIdentity,
+	},
+	"github": {
+		Title:    "GitHub",
+		AuthURL:  "https://github.com/login/oauth/authorize",
+		TokenURL: "https://github.com/login/oauth/access_token",
+		Scope:    "read:user user:email",
+		identify: githubIdentity,
+	},
+}
+
+// OAuthClient is a configured provider, exposed to templates.
+type OAuthClient struct {
+	oauthProvider
+	Name   string
+	id     string
+	secret string
+}
+
+// oauthClients holds the providers with credentials, sorted by name.
+var oauthClients []*OAuthClient
+
+// oauthStateCookie carries the provider, state, PKCE verifier and return
+// path between the redirect and the callback.
+const oauthStateCookie = "bw_oauth"
+
+const oauthStateTTL = 10 * time.Minute
+
+// setOAuth enables each provider whose client ID and secret are both set.
+func setOAuth(creds map[string][2]string) error {
+	oauthClients = nil
+	for name, c := range creds {
+		if c[0] == "" && c[1] == "" {
+			continue
+		}
+		if c[0] == "" || c[1] == "" {
+			return fmt.Errorf("%s sign-in needs both %s-client-id and %s-client-secret", name, name, name)
+		}
+		oauthClients = append(oauthClients, &OAuthClient{oauthProviders[name], name, c[0], c[1]})
+	}
+	sort.Slice(oauthClients, func(i, j int) bool { return oauthClients[i].Name < oauthClients[j].Name })
+	return nil
+}
+
+func oauthClient(name string) *OAuthClient {
+	for _, c := range oauthClients {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oauthStartHandler redirects to the provider's consent page.
+func oauthStartHandler(w http.ResponseWriter, r *http.Request) {
+	c := oauthClient(r.PathValue("provider"))
+	if c == nil {
+		notFound(w, r)
+		return
+	}
+	state, verifier := randomToken(), randomToken()
+	next := localNext(r.URL.Query().Get("next"), "/me")
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    signToken("oauth", strings.Join([]string{c.Name, state, verifier, next}, "\n"), time.Now().Add(oauthStateTTL)),
+		Path:     "/auth/",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"client_id":             {c.id},
+		"redirect_uri":          {siteURL(r, "/auth/"+c.Name+"/callback")},
+		"response_type":         {"code"},
+		"scope":                 {c.Scope},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, c.AuthURL+"?"+q.Encode(), http.StatusFound)
+}
+
+// oauthCallbackHandler finishes the flow: it checks state, trades the
+// code for a token, and signs in or links the matching local account.
+func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	c := oauthClient(r.PathValue("provider"))
+	if c == nil {
+		notFound(w, r)
+		return
+	}
+	fail := func(msg string) {
+		renderAccount(w, r, http.StatusBadRequest, "login", accountForm{}, msg)
+	}
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		fail("Your sign-in attempt expired; please try again.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/auth/", MaxAge: -1})
+	value, err := verifyToken("oauth", cookie.Value, time.Now())
+	parts := strings.Split(value, "\n")
+	if err != nil || len(parts) != 4 || parts[0] != c.Name {
+		fail("Your sign-in attempt expired; please try again.")
+		return
+	}
+	state, verifier, next := parts[1], parts[2], parts[3]
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		fail(c.Title + " sign-in was cancelled.")
+		return
+	}
+	if q.Get("state") != state || q.Get("code") == "" {
+		fail("Your sign-in attempt expired; please try again.")
+		return
+	}
+	token, err := c.exchange(q.Get("code"), verifier, siteURL(r, "/auth/"+c.Name+"/callback"))
+	if err != nil {
+		log.Printf("oauth %s: exchange: %v", c.Name, err)
+		fail("We couldn't reach " + c.Title + "; please try again.")
+		return
+	}
+	id, err := c.identify(token)
+	if err != nil {
+		log.Printf("oauth %s: identify: %v", c.Name, err)
+		fail("We couldn't reach " + c.Title + "; please try again.")
+		return
+	}
+	u, err := linkIdentity(r, c.Name, id)
+	if errors.Is(err, errIdentityTaken) {
+		fail(err.Error())
+		return
+	}
+	if errors.Is(err, errSignInFirst) {
+		fail("An account already uses your " + c.Title + " email. Sign in with its password, then continue with " + c.Title + " to link the two.")
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	startSession(w, r, u)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+var (
+	errIdentityTaken = errors.New("that account is already linked to another user")
+	errSignInFirst   = errors.New("an unverified account has that email")
+)
+
+// linkIdentity finds or creates the local user for a provider identity.
+// A signed-in user gets the identity linked; otherwise a user who already
+// linked it is signed in, and anyone else gets a new password-less
+// account. A provider-verified email only signs in to the account using
+// it when that account's email is verified too: a password account could
+// have been registered by anyone typing the address, so linking it takes
+// errSignInFirst and the owner signing in with the password.
+func linkIdentity(r *http.Request, provider string, id oauthIdentity) (User, error) {
+	us, err := users()
+	if err != nil {
+		return User{}, err
+	}
+	linked, err := us.UserByIdentity(provider, id.Subject)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return User{}, err
+	}
+	found := err == nil
+	if cur, ok := currentUser(r); ok {
+		if found && linked.ID != cur.ID {
+			return User{}, errIdentityTaken
+		}
+		linked, found = cur, false
+		if id.Verified && strings.EqualFold(id.Email, cur.Email) {
+			linked.EmailVerified = true
+		}
+	}
+	if found {
+		return linked, nil
+	}
+	u := linked
+	email := strings.ToLower(id.Email)
+	if u.ID == 0 && id.Verified && email != "" {
+		if u, err = us.UserByEmail(email); err != nil && !errors.Is(err, ErrNotFound) {
+			return User{}, err
+		}
+		if u.ID != 0 && !u.EmailVerified {
+			return User{}, errSignInFirst
+		}
+	}
+	if u.ID == 0 {
+		u = User{Email: email, Name: id.Name, CreatedAt: time.Now().UTC(), EmailVerified: id.Verified && email != ""}
+		if !u.EmailVerified {
+			// Keep unverified addresses out of the email index.
+			u.Email = provider + ":" + id.Subject
+		}
+	}
+	if u.Identities == nil {
+		u.Identities = map[string]string{}
+	}
+	u.Identities[provider] = id.Subject
+	if err := us.PutUser(&u); err != nil {
+		return User{}, err
+	}
+	log.Printf("accounts: linked %s to user %d", provider, u.ID)
+	return u, nil
+}
+
+var oauthHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// exchange trades an authorization code for an access token.
+func (c *OAuthClient) exchange(code, verifier, redirectURI string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, c.TokenURL, strings.NewReader(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {c.id},
+		"client_secret": {c.secret},
+		"code_verifier": {verifier},
+	}.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var res struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := oauthJSON(req, &res); err != nil {
+		return "", err
+	}
+	if res.AccessToken == "" {
+		return "", fmt.Errorf("no access token (%s)", res.Error)
+	}
+	return res.AccessToken, nil
+}
+
+// oauthGet fetches a provider API URL with the access token into v.
+func oauthGet(rawURL, token string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	return oauthJSON(req, v)
+}
+
+func oauthJSON(req *http.Request, v any) error {
+	resp, err := oauthHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Host, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func googleIdentity(token string) (oauthIdentity, error) {
+	var u struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := oauthGet("https://openidconnect.googleapis.com/v1/userinfo", token, &u); err != nil {
+		return oauthIdentity{}, err
+	}
+	if u.Sub == "" {
+		return oauthIdentity{}, errors.New("userinfo without sub")
+	}
+	return oauthIdentity{Subject: u.Sub, Email: u.Email, Verified: u.EmailVerified, Name: u.Name}, nil
+}
+
+func githubIdentity(token string) (oauthIdentity, error) {
+	var u struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := oauthGet("https://api.github.com/user", token, &u); err != nil {
+		return oauthIdentity{}, err
+	}
+	if u.ID == 0 {
+		return oauthIdentity{}, errors.New("user without id")
+	}
+	id := oauthIdentity{Subject: strconv.FormatInt(u.ID, 10), Name: cmp.Or(u.Name, u.Login)}
+	// The profile email may be unverified; use the primary verified one.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := oauthGet("https://api.github.com/user/emails", token, &emails); err != nil {
+		return oauthIdentity{}, err
+	}
+	for _, e := range emails {
+		if e.Primary {
+			id.Email, id.Verified = e.Email, e.Verified
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLinkIdentityVerifiedOnly(t *testing.T) {
+	useItems(t, testItem(1, "Alpha"))
+	us, err := users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Registered with a password: anyone could have typed the address.
+	typed := User{Email: "alice@example.com", PasswordHash: "x"}
+	confirmed := User{Email: "bob@example.com", EmailVerified: true}
+	for _, u := range []*User{&typed, &confirmed} {
+		if err := us.PutUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	anon := httptest.NewRequest("GET", "/auth/google/callback", nil)
+
+	if _, err := linkIdentity(anon, "google", oauthIdentity{Subject: "g-alice", Email: "Alice@example.com", Verified: true}); !errors.Is(err, errSignInFirst) {
+		t.Errorf("verified email of a password account: err %v, want errSignInFirst", err)
+	}
+	if u, _ := us.GetUser(typed.ID); len(u.Identities) != 0 {
+		t.Errorf("the password account got linked: %v", u.Identities)
+	}
+
+	u, err := linkIdentity(anon, "google", oauthIdentity{Subject: "g-bob", Email: "bob@example.com", Verified: true})
+	if err != nil || u.ID != confirmed.ID {
+		t.Errorf("verified email of a verified account: user %d, %v; want %d", u.ID, err, confirmed.ID)
+	}
+
+	u, err = linkIdentity(anon, "github", oauthIdentity{Subject: "gh-alice", Email: "alice@example.com"})
+	if err != nil || u.ID == typed.ID || u.Email != "github:gh-alice" || u.EmailVerified {
+		t.Errorf("unverified email: %+v, %v; want a new unverified account", u, err)
+	}
+
+	u, err = linkIdentity(anon, "google", oauthIdentity{Subject: "g-carol", Email: "carol@example.com", Verified: true})
+	if err != nil || u.Email != "carol@example.com" || !u.EmailVerified {
+		t.Errorf("new verified email: %+v, %v; want a new verified account", u, err)
+	}
+
+	// Signed in with the password, the owner links the provider, which
+	// confirms the email for next time.
+	signedIn := anon.WithContext(context.WithValue(anon.Context(), userKey{}, typed))
+	u, err = linkIdentity(signedIn, "google", oauthIdentity{Subject: "g-alice", Email: "alice@example.com", Verified: true})
+	if err != nil || u.ID != typed.ID || u.Identities["google"] != "g-alice" || !u.EmailVerified {
+		t.Errorf("signed-in link: %+v, %v", u, err)
+	}
+	u, err = linkIdentity(anon, "google", oauthIdentity{Subject: "g-alice", Email: "alice@example.com", Verified: true})
+	if err != nil || u.ID != typed.ID {
+		t.Errorf("after linking: user %d, %v; want %d", u.ID, err, typed.ID)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiParam is a query parameter of a documented operation. Path
+// parameters come from the route pattern itself.
+type apiParam struct {
+	Name, Type, Description string
+	Required                bool
+}
+
+// apiOp documents one route for /api/openapi.json. Body and Response are
+// zero values of the Go types the handler decodes and encodes; their
+// schemas are derived from the json struct tags.
+type apiOp struct {
+	Summary  string
+	Query    []apiParam
+	Body     any
+	Form     []apiParam // urlencoded form fields, instead of Body
+	Response any
+	Status   int    // success status; 200 when zero
+	Type     string // response media type; JSON when empty
+	Role     string // least role required, empty for public routes
+}
+
+// LikeResult is the body of the like and unlike responses.
+type LikeResult struct {
+	Liked bool `json:"liked"`
+	Likes int  `json:"likes"`
+}
+
+// CommentInput is a comment posted as JSON.
+type CommentInput struct {
+	ParentID int64  `json:"parent_id,omitempty"`
+	Author   string `json:"author"`
+	Body     string `json:"body"`
+}
+
+// apiDocs describes the routes registered in main, keyed by pattern.
+// Routes under /api/ without an entry are still listed, undocumented.
+var apiDocs = map[string]apiOp{
+	"/api/items": {
+		Summary: "List live items, optionally one page at a time",
+		Query: []apiParam{
+			{Name: "page", Type: "integer", Description: "1-based page; enables pagination with X-Total-Count and Link headers"},
+			{Name: "per_page", Type: "integer", Description: "page size, at most 100"},
+			{Name: "sort", Type: "string", Description: "newest, popular or alpha; editorial order when absent"},
+		},
+		Response: []Item{},
+	},
+	"/api/items/{id}": {Summary: "Get a live item by ID", Response: Item{}},
+	"GET /api/items/random": {
+		Summary: "A random live item, skipping ones the visitor opened recently",
+		Query:   []apiParam{{Name: "seen", Type: "integer", Description: "1 to allow recently seen items"}},
+		Response: struct {
+			Item Item   `json:"item"`
+			URL  string `json:"url"`
+		}{},
+	},
+	"/api/items.ndjson":            {Summary: "Stream every live item, one JSON document per line", Response: Item{}, Type: "application/x-ndjson"},
+	"/api/resolve":                 {Summary: "Find the item that owns a link", Query: []apiParam{{Name: "link", Type: "string", Required: true}}, Response: Item{}},
+	"/api/stats":                   {Summary: "Catalog statistics", Response: CatalogStats{}},
+	"GET /api/mosaic":              {Summary: "Each tag in use with the poster and link of its newest item", Response: []MosaicTile{}},
+	"GET /api/openapi.json":        {Summary: "This document", Response: map[string]any{}},
+	"GET /api/docs":                {Summary: "This document rendered by Swagger UI", Type: "text/html"},
+	"POST /api/items/{id}/like":    {Summary: "Like an item as the current visitor", Response: LikeResult{}},
+	"DELETE /api/items/{id}/like":  {Summary: "Remove the current visitor's like", Response: LikeResult{}},
+	"GET /api/items/{id}/comments": {Summary: "Approved comments as a reply tree", Response: []*CommentNode{}},
+	"POST /api/items/{id}/comments": {
+		Summary: "Submit a comment for moderation",
+		Body:    CommentInput{},
+		Response: struct {
+			ID     int64  `json:"id"`
+			Status string `json:"status"`
+		}{},
+		Status: http.StatusAccepted,
+	},
+	"/search": {
+		Summary: "Full-text search; send Accept: application/json",
+		Query:   []apiParam{{Name: "q", Type: "string", Required: true}},
+		Response: struct {
+			Query   string         `json:"query"`
+			Results []SearchResult `json:"results"`
+		}{},
+	},
+	"GET /collections": {
+		Summary: "Collections with live items; send Accept: application/json",
+		Response: struct {
+			Collections []collectionSummary `json:"collections"`
+		}{},
+	},
+	"GET /collections/{slug}": {
+		Summary: "A collection's live items in order; send Accept: application/json",
+		Response: struct {
+			Collection Collection `json:"collection"`
+			Items      []Item     `json:"items"`
+		}{},
+	},
+	"GET /credits": {
+		Summary: "Creators credited for the site's footage; send Accept: application/json",
+		Response: struct {
+			Credits []creditGroup `json:"credits"`
+		}{},
+	},
+	"GET /api/search/suggest": {
+		Summary: "Type-ahead completions of titles and tags",
+		Query: []apiParam{
+			{Name: "q", Type: "string", Required: true, Description: "what has been typed so far"},
+			{Name: "limit", Type: "integer", Description: "at most 20; default 8"},
+		},
+		Response: struct {
+			Query       string       `json:"query"`
+			Suggestions []Suggestion `json:"suggestions"`
+		}{},
+	},
+	"POST /api/experiments/goal": {
+		Summary: "Record that the visitor reached a goal of their experiment",
+		Body: struct {
+			Goal string `json:"goal"`
+		}{},
+		Status: http.StatusNoContent,
+	},
+	"/graphql":             {Summary: "GraphQL queries over the catalog; the schema is introspectable", Response: map[string]any{}},
+	"GET /admin/api/views": {Summary: "View counts of every item, most viewed first", Response: []ItemViews{}, Role: RoleViewer},
+	"GET /admin/api/analytics": {
+		Summary: "Pageviews, visitors, top pages, items, sources and failed searches over a date range",
+		Query: []apiParam{
+			{Name: "from", Type: "string", Description: "first day, 2006-01-02; 30 days before to when absent"},
+			{Name: "to", Type: "string", Description: "last day, 2006-01-02; today when absent"},
+		},
+		Response: AnalyticsReport{},
+		Role:     RoleViewer,
+	},
+	"GET /admin/api/experiments": {
+		Summary:  "Visitors and goal conversions of every experiment variant",
+		Response: []VariantResult{},
+		Role:     RoleViewer,
+	},
+	"POST /admin/comments/{id}/status": {
+		Summary: "Approve, reject or delete a comment",
+		Form:    []apiParam{{Name: "status", Type: "string", Required: true, Description: "approved, rejected or deleted"}},
+		Status:  http.StatusSeeOther,
+		Role:    RoleEditor,
+	},
+	"GET /admin/subscribers.csv": {Summary: "Export newsletter subscribers", Type: "text/csv", Role: RoleAdmin},
+	"POST /admin/users/{id}/role": {
+		Summary: "Change a user's role",
+		Form:    []apiParam{{Name: "role", Type: "string", Required: true, Description: "viewer, editor or admin"}},
+		Status:  http.StatusSeeOther,
+		Role:    RoleAdmin,
+	},
+	"GET /admin/api/audit": {
+		Summary: "Recorded admin changes, newest first",
+		Query:   auditQuery,
+		Response: struct {
+			Entries []AuditEntry `json:"entries"`
+		}{},
+		Role: RoleAdmin,
+	},
+	"GET /admin/audit.csv": {Summary: "Export recorded admin changes", Query: auditQuery, Type: "text/csv", Role: RoleAdmin},
+	"GET /admin/api/jobs": {
+		Summary: "Background jobs with their schedule and last run",
+		Response: struct {
+			Jobs []JobStatus `json:"jobs"`
+		}{},
+		Role: RoleViewer,
+	},
+	"POST /admin/jobs/{name}/run": {Summary: "Start a background job now", Response: JobStatus{}, Status: http.StatusAccepted, Role: RoleAdmin},
+	"GET /admin/backup":           {Summary: "Download a backup of the store as a gzipped tar", Type: "application/gzip", Role: RoleAdmin},
+	"POST /admin/restore": {
+		Summary:  "Restore the store from a backup sent as the request body",
+		Query:    []apiParam{{Name: "dry_run", Type: "boolean", Description: "true to only check the archive"}},
+		Response: map[string]any{},
+		Role:     RoleAdmin,
+	},
+}
+
+// auditQuery are the filters the audit log endpoints take.
+var auditQuery = []apiParam{
+	{Name: "actor", Type: "string", Description: "who made the change"},
+	{Name: "action", Type: "string", Description: "action prefix, e.g. item or flag.put"},
+	{Name: "target", Type: "string", Description: "part of what was changed, e.g. item 12"},
+	{Name: "from", Type: "string", Description: "first day, 2006-01-02"},
+	{Name: "to", Type: "string", Description: "last day, 2006-01-02"},
+}
+
+// openAPISpec is a builder for the document, collecting the component
+// schemas the operations refer to.
+type openAPISpec struct {
+	schemas map[string]any
+}
+
+// openAPIDocument builds the OpenAPI 3 document from the registered
+// routes, so it can't drift from what the server actually serves.
+func openAPIDocument(r *http.Request) map[string]any {
+	s := &openAPISpec{schemas: map[string]any{}}
+	paths := map[string]map[string]any{}
+	for _, pattern := range routes {
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			method, path = "", pattern
+		}
+		doc, documented := apiDocs[pattern]
+		if !documented && !isAPI(path) {
+			continue
+		}
+		methods := []string{method}
+		if method == "" {
+			methods = []string{http.MethodGet}
+			if path == "/graphql" {
+				methods = append(methods, http.MethodPost)
+			}
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		for _, m := range methods {
+			paths[path][strings.ToLower(m)] = s.operation(path, doc, documented)
+		}
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "BlendingWaves API",
+			"version":     "1",
+			"description": "Read the catalog, like items and post comments. Browser requests that change state need the X-CSRF-Token header.",
+		},
+		"servers": []map[string]string{{"url": siteURL(r, "")}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": s.schemas,
+			"securitySchemes": map[string]any{
+				"session": map[string]string{"type": "apiKey", "in": "cookie", "name": sessionCookie},
+				"basic":   map[string]string{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+}
+
+// operation documents one method of path.
+func (s *openAPISpec) operation(path string, doc apiOp, documented bool) map[string]any {
+	op := map[string]any{"summary": doc.Summary}
+	if !documented {
+		op["summary"] = "Undocumented"
+	}
+	tag := "items"
+	switch {
+	case strings.HasPrefix(path, "/admin/"):
+		tag = "admin"
+	case strings.HasPrefix(path, "/search"), strings.HasPrefix(path, "/api/search/"):
+		tag = "search"
+	case path == "/graphql":
+		tag = "graphql"
+	case strings.HasSuffix(path, "/like"):
+		tag = "likes"
+	case strings.HasSuffix(path, "/comments"):
+		tag = "comments"
+	}
+	op["tags"] = []string{tag}
+
+	var params []map[string]any
+	for _, seg := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			name = strings.TrimSuffix(strings.TrimSuffix(name, "}"), "...")
+			params = append(params, map[string]any{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]string{"type": "string"},
+			})
+		}
+	}
+	for _, q := range doc.Query {
+		params = append(params, map[string]any{
+			"name": q.Name, "in": "query", "required": q.Required, "description": q.Description,
+			"schema": map[string]string{"type": q.Type},
+		})
+	}
+	if params != nil {
+		op["parameters"] = params
+	}
+
+	switch {
+	case doc.Body != nil:
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": s.schema(reflect.TypeOf(doc.Body))}},
+		}
+	case doc.Form != nil:
+		props := map[string]any{}
+		var required []string
+		for _, f := range doc.Form {
+			props[f.Name] = map[string]string{"type": f.Type, "description": f.Description}
+			if f.Required {
+				required = append(required, f.Name)
+			}
+		}
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{"application/x-www-form-urlencoded": map[string]any{
+				"schema": map[string]any{"type": "object", "properties": props, "required": required},
+			}},
+		}
+	}
+
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ok := map[string]any{"description": http.StatusText(status)}
+	if doc.Response != nil || doc.Type != "" {
+		mt := doc.Type
+		if mt == "" {
+			mt = "application/json"
+		}
+		content := map[string]any{}
+		if doc.Response != nil {
+			content["schema"] = s.schema(reflect.TypeOf(doc.Response))
+		}
+		ok["content"] = map[string]any{mt: content}
+	}
+	responses := map[string]any{strconv.Itoa(status): ok}
+	if !documented {
+		responses = map[string]any{"default": map[string]string{"description": "Undocumented response"}}
+	}
+	if doc.Role != "" {
+		op["security"] = []map[string][]string{{"session": {}}, {"basic": {}}}
+		op["description"] = "Requires the " + doc.Role + " role."
+		responses["403"] = map[string]string{"description": "Signed in without the " + doc.Role + " role"}
+	}
+	op["responses"] = responses
+	return op
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the JSON schema of t. Named structs become components
+// referenced by $ref; json tags give the property names.
+func (s *openAPISpec) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		sc := s.schema(t.Elem())
+		if _, ref := sc["$ref"]; !ref {
+			sc["nullable"] = true
+		}
+		return sc
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, seen := s.schemas[t.Name()]; !seen {
+			s.schemas[t.Name()] = nil // placeholder so recursive types terminate
+			s.schemas[t.Name()] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// object is the schema of struct type t, with embedded structs' fields
+// flattened the way encoding/json does.
+func (s *openAPISpec) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || !f.IsExported() && !f.Anonymous {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = s.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	sc := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		sc["required"] = required
+	}
+	return sc
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument(r))
+}
+
+// apiDocsHandler serves Swagger UI over /api/openapi.json. Its "try it
+// out" requests carry the visitor's CSRF token like the site's own
+// scripts do.
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Title": "API reference | BlendingWaves",
+		"CSRF":  csrfToken(w, r),
+	}
+	if err := render(w, "api_docs.html", data); err != nil {
+		renderError(w, err)
+	}
+}
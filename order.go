@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// itemPlacement is one entry of a reorder request: the item, and whether
+// to pin it when that's to change.
+type itemPlacement struct {
+	ID     int   `json:"id"`
+	Pinned *bool `json:"pinned,omitempty"`
+}
+
+// placement is the editorial order of items as the audit log records it.
+type placement struct {
+	Order  []int `json:"order"`
+	Pinned []int `json:"pinned"`
+}
+
+func placementOf(items []Item) placement {
+	p := placement{Order: []int{}, Pinned: []int{}}
+	for _, it := range items {
+		p.Order = append(p.Order, it.ID)
+		if it.Pinned {
+			p.Pinned = append(p.Pinned, it.ID)
+		}
+	}
+	return p
+}
+
+// adminOrderHandler answers PATCH /api/admin/items/order, sent by the
+// admin list's drag-and-drop. The body lists every item in the order the
+// home page should show them, {"items": [{"id": 3, "pinned": true},
+// {"id": 1}, …]}; each item's order becomes its position and pinned, when
+// given, pins or unpins it. Only the items that change are saved, each
+// as a revision. A list that misses items is refused with 409, as it was
+// most likely made before someone added one.
+func adminOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Items []itemPlacement `json:"items"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	all, err := store.List()
+	if err != nil {
+		log.Printf("admin: reorder: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "listing the items failed")
+		return
+	}
+	byID := make(map[int]Item, len(all))
+	for _, it := range all {
+		byID[it.ID] = it
+	}
+	seen := make(map[int]bool, len(req.Items))
+	for _, p := range req.Items {
+		if _, ok := byID[p.ID]; !ok {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("item %d does not exist", p.ID))
+			return
+		}
+		if seen[p.ID] {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("item %d is listed more than once", p.ID))
+			return
+		}
+		seen[p.ID] = true
+	}
+	if len(seen) != len(all) {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("the order lists %d of %d items; reload the list and try again", len(seen), len(all)))
+		return
+	}
+	editorialOrder(all)
+	before := placementOf(all)
+	changed := 0
+	for i, p := range req.Items {
+		it := byID[p.ID]
+		was := it
+		it.Order = i + 1
+		if p.Pinned != nil {
+			it.Pinned = *p.Pinned
+		}
+		if it.Order == was.Order && it.Pinned == was.Pinned {
+			continue
+		}
+		if err := putItem(store, actor(r), RevReorder, it); err != nil {
+			log.Printf("admin: reorder item %d: %v", it.ID, err)
+			writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("saving item %d failed; %d items were saved", it.ID, changed))
+			return
+		}
+		byID[it.ID] = it
+		changed++
+	}
+	if err := reloadItems(r.Context()); err != nil {
+		log.Printf("admin: reload after reorder: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "saved, but reloading the catalog failed: "+err.Error())
+		return
+	}
+	after := make([]Item, len(req.Items))
+	for i, p := range req.Items {
+		after[i] = byID[p.ID]
+	}
+	editorialOrder(after)
+	audit(r, "item.reorder", pluralize(changed, "item", "items"), before, placementOf(after))
+	log.Printf("admin: %s reordered the items, changing %d", actor(r), changed)
+	writeJSON(w, http.StatusOK, map[string]any{"changed": changed, "items": placementOf(after)})
+}
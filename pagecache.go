@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pageTTLs are how long a rendered page stays in the cache, by route:
+// home, item and feed. A route without one isn't cached; one whose TTL
+// is 0 is cached until a reload, like or comment replaces its pages.
+var pageTTLs map[string]time.Duration
+
+// pageRoutes are the routes -page-cache can name.
+var pageRoutes = []string{"home", "item", "feed"}
+
+// pageCacheMax bounds the pages kept, since every query string of a
+// cached route is its own page.
+const pageCacheMax = 2000
+
+// pageCacheJitter is the most, as a fraction of the TTL, a cached page's
+// expiry is pushed back at random, so instances that cached a page
+// together don't all render it again at the same moment.
+const pageCacheJitter = 0.1
+
+// Placeholders stand in the cached body for what differs per request.
+const (
+	nonceHole = "\x00nonce\x00"
+	csrfHole  = "\x00csrf\x00"
+)
+
+// parsePageTTLs parses -page-cache: comma-separated route=duration
+// pairs, such as home=30s,item=1m. A duration of 0 caches the route
+// with no time-based expiry; empty turns the cache off.
+func parsePageTTLs(spec string) (map[string]time.Duration, error) {
+	ttls := map[string]time.Duration{}
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		route, d, ok := strings.Cut(pair, "=")
+		if !ok || !slices.Contains(pageRoutes, route) {
+			return nil, fmt.Errorf("page-cache %q: want route=duration with route one of %s", pair, strings.Join(pageRoutes, ", "))
+		}
+		ttl, err := time.ParseDuration(d)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("page-cache %q: duration must be like 30s or 5m", pair)
+		}
+		ttls[route] = ttl
+	}
+	return ttls, nil
+}
+
+// pageKey names the variant of a page a handler renders, beyond what
+// cachePage takes from the request: the item of an item page, so its
+// pages can be purged, and the experiment variant or other visitor state
+// the page shows.
+type pageKey struct {
+	route   string
+	item    int
+	variant string
+}
+
+// cachedPage is one rendered page: the headers its render added and the
+// body, with the nonce and CSRF token punched out.
+type cachedPage struct {
+	catalog *catalog
+	expires time.Time // zero when the route has no TTL
+	item    int
+	header  http.Header
+	body    []byte
+}
+
+// pageCache holds the rendered pages and how often each route was
+// answered from it.
+var pageCache = struct {
+	sync.Mutex
+	pages  map[string]*cachedPage
+	hits   map[string]int64
+	misses map[string]int64
+}{pages: map[string]*cachedPage{}, hits: map[string]int64{}, misses: map[string]int64{}}
+
+// cachePage answers r with the cached copy of the page key names when it
+// is fresh, or else calls render and keeps the page it writes when that
+// is a 200. The handler does what must happen on every view, such as
+// counting it or issuing cookies, before calling cachePage; render does
+// the rest. token is the visitor's CSRF token when the page has one.
+//
+// A page is the same for everyone sharing its key, host, URL, language,
+// feature flags and kind of client. Copies made from an older catalog
+// are never served, so a content reload empties the cache in effect;
+// staff, -dev and exports always render afresh. Pages also expire after
+// their route's TTL, plus some jitter, unless that is 0. Requests that miss while the page
+// is being rendered wait for that render rather than starting their own.
+func cachePage(w http.ResponseWriter, r *http.Request, key pageKey, token string, render func(http.ResponseWriter)) {
+	ttl, cached := pageTTLs[key.route]
+	if !cached || r.Method != http.MethodGet && r.Method != http.MethodHead || exporting || devMode || staff(r) {
+		render(w)
+		return
+	}
+	c := current()
+	k := pageCacheKey(w, r, key)
+	p := freshPage(k, c)
+	if p == nil {
+		done, leader := startFlight(k)
+		if leader {
+			defer finishFlight(k, done)
+		} else {
+			select {
+			case <-done:
+			case <-r.Context().Done():
+				return
+			}
+			// When that render couldn't be cached, this one renders too.
+			p = freshPage(k, c)
+		}
+	}
+	pageCache.Lock()
+	if p != nil {
+		pageCache.hits[key.route]++
+	} else {
+		pageCache.misses[key.route]++
+	}
+	pageCache.Unlock()
+	nonce := cspNonce(w)
+	if p != nil {
+		for name, vs := range p.header {
+			for _, v := range vs {
+				w.Header().Add(name, v)
+			}
+		}
+		w.Header().Set("X-Page-Cache", "hit")
+		if tag := p.header.Get("ETag"); tag != "" && notModified(w, r, tag) {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		body := p.body
+		if nonce != "" {
+			body = bytes.ReplaceAll(body, []byte(nonceHole), []byte(nonce))
+		}
+		if token != "" {
+			body = bytes.ReplaceAll(body, []byte(csrfHole), []byte(token))
+		}
+		w.Write(body)
+		return
+	}
+	before := w.Header().Clone()
+	w.Header().Set("X-Page-Cache", "miss")
+	rec := &pageRecorder{ResponseWriter: w}
+	render(rec)
+	if rec.status != http.StatusOK {
+		return
+	}
+	body := rec.body.Bytes()
+	if nonce != "" {
+		body = bytes.ReplaceAll(body, []byte(nonce), []byte(nonceHole))
+	}
+	if token != "" {
+		body = bytes.ReplaceAll(body, []byte(token), []byte(csrfHole))
+	}
+	now := time.Now()
+	var expires time.Time
+	if ttl > 0 {
+		expires = now.Add(ttl + rand.N(time.Duration(float64(ttl)*pageCacheJitter)+1))
+	}
+	p = &cachedPage{catalog: c, expires: expires, item: key.item, header: addedHeaders(before, w.Header()), body: body}
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	if len(pageCache.pages) >= pageCacheMax {
+		maps.DeleteFunc(pageCache.pages, func(_ string, p *cachedPage) bool { return p.catalog != c || p.expired(now) })
+	}
+	if len(pageCache.pages) < pageCacheMax {
+		pageCache.pages[k] = p
+	}
+}
+
+// freshPage returns the cached page k when it is from catalog c and
+// hasn't expired, or nil.
+func freshPage(k string, c *catalog) *cachedPage {
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	p, ok := pageCache.pages[k]
+	if !ok || p.catalog != c || p.expired(time.Now()) {
+		return nil
+	}
+	return p
+}
+
+// expired reports whether the page's TTL has run out by now.
+func (p *cachedPage) expired(now time.Time) bool {
+	return !p.expires.IsZero() && now.After(p.expires)
+}
+
+// pageFlights are the pages being rendered for the cache, by key, each
+// with a channel closed when its render is done.
+var pageFlights = struct {
+	sync.Mutex
+	m map[string]chan struct{}
+}{m: map[string]chan struct{}{}}
+
+// startFlight returns the channel of page k's render, and whether the
+// caller is to do it, because nobody else is.
+func startFlight(k string) (chan struct{}, bool) {
+	pageFlights.Lock()
+	defer pageFlights.Unlock()
+	if done, ok := pageFlights.m[k]; ok {
+		return done, false
+	}
+	done := make(chan struct{})
+	pageFlights.m[k] = done
+	return done, true
+}
+
+// finishFlight releases the requests waiting on page k's render.
+func finishFlight(k string, done chan struct{}) {
+	pageFlights.Lock()
+	delete(pageFlights.m, k)
+	pageFlights.Unlock()
+	close(done)
+}
+
+// pageCacheKey is everything besides key that the page w answers r with
+// depends on.
+func pageCacheKey(w http.ResponseWriter, r *http.Request, key pageKey) string {
+	f := writerFeatures(w)
+	flags.RLock()
+	names := slices.Sorted(maps.Keys(flags.m))
+	flags.RUnlock()
+	on := slices.DeleteFunc(names, func(name string) bool { return !f.On(name) })
+	return strings.Join([]string{
+		key.route, strconv.Itoa(key.item), key.variant,
+		r.Host, r.URL.RequestURI(), writerLocale(w), strings.Join(on, ","),
+		strconv.FormatBool(isBot(r)), strconv.FormatBool(wantsJSON(r)),
+	}, "\x00")
+}
+
+// addedHeaders returns the header values in after that weren't in
+// before, leaving out cookies, which belong to one visitor, and the
+// cache's own header.
+func addedHeaders(before, after http.Header) http.Header {
+	out := http.Header{}
+	for name, vs := range after {
+		if name == "Set-Cookie" || name == "X-Page-Cache" {
+			continue
+		}
+		old := before[name]
+		if len(old) <= len(vs) && slices.Equal(old, vs[:len(old)]) {
+			vs = vs[len(old):]
+		}
+		if len(vs) > 0 {
+			out[name] = slices.Clone(vs)
+		}
+	}
+	return out
+}
+
+// purgeItemPages drops the cached pages of item id, when its likes or
+// comments change.
+func purgeItemPages(id int) {
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	maps.DeleteFunc(pageCache.pages, func(_ string, p *cachedPage) bool { return p.item == id })
+}
+
+// pageRecorder passes a render through to the client, keeping a copy.
+type pageRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (p *pageRecorder) Unwrap() http.ResponseWriter { return p.ResponseWriter }
+
+func (p *pageRecorder) Flush() {
+	if f, ok := p.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (p *pageRecorder) WriteHeader(status int) {
+	if p.status == 0 {
+		p.status = status
+	}
+	p.ResponseWriter.WriteHeader(status)
+}
+
+func (p *pageRecorder) Write(b []byte) (int, error) {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	p.body.Write(b)
+	return p.ResponseWriter.Write(b)
+}
+
+// writePageCacheMetrics writes the cache's hits and misses by route.
+func writePageCacheMetrics(w io.Writer) {
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	fmt.Fprintln(w, "# HELP page_cache_requests_total Page cache lookups by route and result.")
+	fmt.Fprintln(w, "# TYPE page_cache_requests_total counter")
+	for _, route := range pageRoutes {
+		if _, ok := pageTTLs[route]; !ok {
+			continue
+		}
+		fmt.Fprintf(w, "page_cache_requests_total{route=%q,result=\"hit\"} %d\n", route, pageCache.hits[route])
+		fmt.Fprintf(w, "page_cache_requests_total{route=%q,result=\"miss\"} %d\n", route, pageCache.misses[route])
+	}
+	gauge(w, "page_cache_pages", "Rendered pages in the cache.", int64(len(pageCache.pages)))
+}
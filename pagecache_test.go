@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// usePageTTLs caches the given routes, with an empty cache, for the rest
+// of the test.
+func usePageTTLs(t *testing.T, ttls map[string]time.Duration) {
+	t.Helper()
+	prev := pageTTLs
+	pageTTLs = ttls
+	clear := func() {
+		pageCache.Lock()
+		pageCache.pages = map[string]*cachedPage{}
+		pageCache.Unlock()
+	}
+	clear()
+	t.Cleanup(func() { pageTTLs = prev; clear() })
+}
+
+func TestPageCacheTTL(t *testing.T) {
+	useItems(t, testItem(1, "Alpha"))
+	const ttl = 40 * time.Millisecond
+	usePageTTLs(t, map[string]time.Duration{"home": ttl})
+
+	steps := []struct {
+		wait time.Duration
+		want string
+	}{
+		{0, "miss"},
+		{0, "hit"},
+		{ttl / 2, "hit"},
+		// Past the TTL and the most jitter can add.
+		{ttl, "miss"},
+		{0, "hit"},
+	}
+	for i, s := range steps {
+		time.Sleep(s.wait)
+		w := get("/")
+		if got := w.Header().Get("X-Page-Cache"); w.Code != 200 || got != s.want {
+			t.Errorf("step %d: status %d, X-Page-Cache %q, want %q", i, w.Code, got, s.want)
+		}
+	}
+}
+
+func TestPageCacheJitter(t *testing.T) {
+	useItems(t, testItem(1, "Alpha"))
+	const ttl = time.Hour
+	usePageTTLs(t, map[string]time.Duration{"home": ttl})
+	start := time.Now()
+	for _, q := range []string{"/?a", "/?b", "/?c", "/?d"} {
+		get(q)
+	}
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	seen := map[time.Time]bool{}
+	for _, p := range pageCache.pages {
+		if extra := p.expires.Sub(start) - ttl; extra < 0 || extra > time.Duration(float64(ttl)*pageCacheJitter)+time.Second {
+			t.Errorf("expires %v after the TTL", extra)
+		}
+		seen[p.expires] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("%d pages share one expiry", len(pageCache.pages))
+	}
+}
+
+func TestPageCacheSingleFlight(t *testing.T) {
+	useItems(t, testItem(1, "Alpha"))
+	usePageTTLs(t, map[string]time.Duration{"home": time.Minute})
+	var renders atomic.Int32
+	render := func(w http.ResponseWriter) {
+		renders.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("page"))
+	}
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			cachePage(w, httptest.NewRequest("GET", "/", nil), pageKey{route: "home"}, "", render)
+			if w.Body.String() != "page" {
+				t.Errorf("body %q", w.Body)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := renders.Load(); n != 1 {
+		t.Errorf("%d renders for 10 concurrent misses, want 1", n)
+	}
+}
+
+func TestPageCacheNoTTL(t *testing.T) {
+	ttls, err := parsePageTTLs("home=0,item=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok := ttls["home"]; !ok || ttl != 0 {
+		t.Fatalf("home=0 parsed as %v, %v; want cached with no TTL", ttl, ok)
+	}
+	if _, ok := ttls["feed"]; ok {
+		t.Fatal("an unnamed route is cached")
+	}
+
+	useItems(t, testItem(1, "Alpha"))
+	usePageTTLs(t, ttls)
+	for i, want := range []string{"miss", "hit", "hit"} {
+		if got := get("/").Header().Get("X-Page-Cache"); got != want {
+			t.Errorf("request %d: X-Page-Cache %q, want %q", i, got, want)
+		}
+	}
+	pageCache.Lock()
+	for _, p := range pageCache.pages {
+		if !p.expires.IsZero() {
+			t.Errorf("page expires at %v, want never", p.expires)
+		}
+	}
+	pageCache.Unlock()
+
+	// A reload still replaces the page.
+	useItems(t, testItem(1, "Alpha"), testItem(2, "Beta"))
+	if got := get("/").Header().Get("X-Page-Cache"); got != "miss" {
+		t.Errorf("after a reload: X-Page-Cache %q, want miss", got)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxPerPage caps ?per_page= so one request can't ask for everything.
+const maxPerPage = 100
+
+// defaultPerPage is the page size when ?per_page= is absent.
+var defaultPerPage = 24
+
+// Paginator describes one page of a listing for templates and APIs.
+type Paginator struct {
+	Page       int
+	PerPage    int
+	Total      int
+	TotalPages int
+	PrevURL    string
+	NextURL    string
+}
+
+// newPaginator reads ?page= and ?per_page= from r for a listing of total
+// entries. Out-of-range values are clamped.
+func newPaginator(r *http.Request, total int) Paginator {
+	q := r.URL.Query()
+	per := atoiDefault(q.Get("per_page"), defaultPerPage)
+	per = min(max(per, 1), maxPerPage)
+	pages := max((total+per-1)/per, 1)
+	page := min(max(atoiDefault(q.Get("page"), 1), 1), pages)
+	p := Paginator{Page: page, PerPage: per, Total: total, TotalPages: pages}
+	if page > 1 {
+		p.PrevURL = pageURL(r.URL, page-1)
+	}
+	if page < pages {
+		p.NextURL = pageURL(r.URL, page+1)
+	}
+	return p
+}
+
+// Bounds returns the slice indexes of the current page.
+func (p Paginator) Bounds() (lo, hi int) {
+	lo = min((p.Page-1)*p.PerPage, p.Total)
+	hi = min(lo+p.PerPage, p.Total)
+	return lo, hi
+}
+
+// linkHeader returns an RFC 8288 Link header for the prev/next pages.
+func (p Paginator) linkHeader() string {
+	var links []string
+	if p.PrevURL != "" {
+		links = append(links, "<"+p.PrevURL+`>; rel="prev"`)
+	}
+	if p.NextURL != "" {
+		links = append(links, "<"+p.NextURL+`>; rel="next"`)
+	}
+	return strings.Join(links, ", ")
+}
+
+// pageURL returns u's path and query with page set to n.
+func pageURL(u *url.URL, n int) string {
+	if exporting {
+		return staticPageURL(u.Path, n)
+	}
+	q := u.Query()
+	q.Set("page", strconv.Itoa(n))
+	return u.Path + "?" + q.Encode()
+}
+
+func atoiDefault(s string, def int) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return def
+}
+
+// paginate returns the requested page of all.
+func paginate[T any](r *http.Request, all []T) ([]T, Paginator) {
+	p := newPaginator(r, len(all))
+	lo, hi := p.Bounds()
+	return all[lo:hi], p
+}
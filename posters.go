@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Poster sources, in the order posterFor tries them by default.
+const (
+	PosterItem    = "item"    // the item's own Posters entry for the video
+	PosterFrame   = "frame"   // a frame grabbed from the video; needs -thumb-dir
+	PosterKeyword = "keyword" // a stock image named after a tag or the title
+	PosterDefault = "default" // the site-wide default image
+)
+
+// defaultPosterChain is the -poster-fallbacks default: every source.
+const defaultPosterChain = PosterItem + "," + PosterFrame + "," + PosterKeyword + "," + PosterDefault
+
+// posterChain lists the enabled sources in the order they are tried.
+var posterChain = []string{PosterItem, PosterFrame, PosterKeyword, PosterDefault}
+
+// keywordPosterDir is the directory under static/ holding keyword images,
+// each named by the slug of a tag or title: images/posters/ai.jpg.
+var keywordPosterDir string
+
+// keywordPosterExts are the image types looked for in keywordPosterDir.
+var keywordPosterExts = []string{".jpg", ".png", ".webp"}
+
+// parsePosterChain parses a comma-separated list of poster sources.
+func parsePosterChain(list string) ([]string, error) {
+	var chain []string
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		switch s {
+		case "":
+			continue
+		case PosterItem, PosterFrame, PosterKeyword, PosterDefault:
+		default:
+			return nil, fmt.Errorf("poster-fallbacks: unknown source %q; want item, frame, keyword or default", s)
+		}
+		if slices.Contains(chain, s) {
+			return nil, fmt.Errorf("poster-fallbacks: %s is listed twice", s)
+		}
+		chain = append(chain, s)
+	}
+	return chain, nil
+}
+
+// posterFor returns the poster image of the item's index-th video, from
+// the first source in posterChain that has one, or "" when none does.
+// Templates, page metadata and the sitemap all pick posters through it.
+func posterFor(it *Item, index int) string {
+	for _, src := range posterChain {
+		if u := posterFrom(src, it, index); u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+// posterFrom returns the poster src has for the item's index-th video.
+func posterFrom(src string, it *Item, index int) string {
+	switch src {
+	case PosterItem:
+		if index < len(it.Posters) {
+			return it.Posters[index]
+		}
+	case PosterFrame:
+		if index < len(it.VideoPath) {
+			return thumbURL(it.VideoPath[index], "poster")
+		}
+	case PosterKeyword:
+		return keywordPoster(it)
+	case PosterDefault:
+		return defaultThumbnail
+	}
+	return ""
+}
+
+// keywordPoster finds the keyword image of the item's first tag that has
+// one, or else of its title.
+func keywordPoster(it *Item) string {
+	if keywordPosterDir == "" || staticFS == nil {
+		return ""
+	}
+	keys := make([]string, 0, len(it.Tags)+1)
+	for _, t := range it.Tags {
+		keys = append(keys, slugify(t))
+	}
+	keys = append(keys, slugify(it.KeywordTitle))
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		for _, ext := range keywordPosterExts {
+			name := path.Join(keywordPosterDir, k+ext)
+			if _, err := fs.Stat(staticFS, name); err == nil {
+				return "/static/" + name
+			}
+		}
+	}
+	return ""
+}
+
+// checkPosters describes what's wrong with the item's poster images.
+func checkPosters(it *Item) []string {
+	var msgs []string
+	if len(it.Posters) > len(it.VideoPath) {
+		msgs = append(msgs, fmt.Sprintf("posters has %d entries for %d videos", len(it.Posters), len(it.VideoPath)))
+	}
+	for i, p := range it.Posters {
+		if p == "" {
+			continue
+		}
+		rel, ok := staticFile(p)
+		if !ok {
+			msgs = append(msgs, fmt.Sprintf("posters[%d] %q must be a /static/ path", i, p))
+			continue
+		}
+		if staticFS != nil {
+			if _, err := fs.Stat(staticFS, rel); err != nil {
+				msgs = append(msgs, fmt.Sprintf("posters[%d] %q does not exist under static/", i, p))
+			}
+		}
+	}
+	return msgs
+}
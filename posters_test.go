@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPosterFor(t *testing.T) {
+	prevFS, prevChain, prevDir, prevThumbs := staticFS, posterChain, keywordPosterDir, thumbDir
+	t.Cleanup(func() { staticFS, posterChain, keywordPosterDir, thumbDir = prevFS, prevChain, prevDir, prevThumbs })
+	staticFS = fstest.MapFS{
+		"images/cover.jpg":             {},
+		"images/posters/trading.png":   {},
+		"images/posters/ai-agents.jpg": {},
+	}
+	keywordPosterDir = "images/posters"
+
+	plain := testItem(1, "Ecommerce")
+	tagged := testItem(2, "Quant Desk")
+	tagged.Tags = []string{"Finance", "Trading"}
+	titled := testItem(3, "AI Agents")
+	own := testItem(4, "Own Poster")
+	own.Posters = []string{"/static/images/cover.jpg"}
+	partial := testItem(5, "AI Agents")
+	partial.VideoPath = append(partial.VideoPath, "/static/video/agents.mp4")
+	partial.Posters = []string{"", "/static/images/cover.jpg"}
+
+	frame := "/thumbs/" + videoKey(plain.VideoPath[0]) + "/poster.jpg"
+	tests := []struct {
+		name   string
+		chain  string
+		thumbs string
+		it     Item
+		index  int
+		want   string
+	}{
+		{"item poster", defaultPosterChain, "cache", own, 0, "/static/images/cover.jpg"},
+		{"video frame", defaultPosterChain, "cache", plain, 0, frame},
+		{"tag keyword", defaultPosterChain, "", tagged, 0, "/static/images/posters/trading.png"},
+		{"title keyword", defaultPosterChain, "", titled, 0, "/static/images/posters/ai-agents.jpg"},
+		{"global default", defaultPosterChain, "", plain, 0, defaultThumbnail},
+		{"empty entry falls back", defaultPosterChain, "", partial, 0, "/static/images/posters/ai-agents.jpg"},
+		{"entry per video", defaultPosterChain, "", partial, 1, "/static/images/cover.jpg"},
+		{"frame disabled", "item,keyword,default", "cache", plain, 0, defaultThumbnail},
+		{"keyword first", "keyword,item", "", partial, 1, "/static/images/posters/ai-agents.jpg"},
+		{"reordered", "default,item", "", own, 0, defaultThumbnail},
+		{"all disabled", "", "cache", own, 0, ""},
+	}
+	for _, tt := range tests {
+		chain, err := parsePosterChain(tt.chain)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		posterChain, thumbDir = chain, tt.thumbs
+		if got := posterFor(&tt.it, tt.index); got != tt.want {
+			t.Errorf("%s: posterFor = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePosterChain(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    string
+		wantErr string
+	}{
+		{defaultPosterChain, defaultPosterChain, ""},
+		{" frame , default ", "frame,default", ""},
+		{"", "", ""},
+		{"item,poster", "", "unknown source"},
+		{"item,item", "", "listed twice"},
+	}
+	for _, tt := range tests {
+		got, err := parsePosterChain(tt.list)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parsePosterChain(%q) error = %v, want %q", tt.list, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || strings.Join(got, ",") != tt.want {
+			t.Errorf("parsePosterChain(%q) = %q, %v; want %q", tt.list, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Presence tuning: how often idle sockets are pinged, how long a ping
+// or write may take, and the most sockets served at once.
+const (
+	wsPingEvery  = 30 * time.Second
+	wsWriteWait  = 10 * time.Second
+	wsMaxClients = 5000
+)
+
+// watching is the message sent to an item's viewers whenever its count
+// changes.
+type watching struct {
+	Item     int `json:"item"`
+	Watching int `json:"watching"`
+}
+
+// viewer is one open /ws connection. send holds at most the latest
+// count; older ones are dropped since only the newest matters.
+type viewer struct {
+	item int
+	send chan watching
+}
+
+// presenceHub tracks who is watching which item.
+type presenceHub struct {
+	mu      sync.Mutex
+	items   map[int]map[*viewer]struct{}
+	clients int
+}
+
+var presence = &presenceHub{items: map[int]map[*viewer]struct{}{}}
+
+var errPresenceFull = errors.New("too many live viewers")
+
+// join adds a viewer of item and tells everyone watching it the new
+// count.
+func (h *presenceHub) join(item int) (*viewer, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients >= wsMaxClients {
+		return nil, errPresenceFull
+	}
+	v := &viewer{item: item, send: make(chan watching, 1)}
+	if h.items[item] == nil {
+		h.items[item] = map[*viewer]struct{}{}
+	}
+	h.items[item][v] = struct{}{}
+	h.clients++
+	h.notify(item)
+	return v, nil
+}
+
+// leave removes v and updates the remaining viewers of its item.
+func (h *presenceHub) leave(v *viewer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.items[v.item][v]; !ok {
+		return
+	}
+	delete(h.items[v.item], v)
+	h.clients--
+	if len(h.items[v.item]) == 0 {
+		delete(h.items, v.item)
+		return
+	}
+	h.notify(v.item)
+}
+
+// notify queues the current count for every viewer of item. h.mu must
+// be held.
+func (h *presenceHub) notify(item int) {
+	msg := watching{Item: item, Watching: len(h.items[item])}
+	for v := range h.items[item] {
+		select {
+		case <-v.send:
+		default:
+		}
+		v.send <- msg
+	}
+}
+
+// hijackWriter exposes the connection beneath the middleware wrappers,
+// which the websocket library needs to find as an http.Hijacker.
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+func (h hijackWriter) Hijack() (conn net.Conn, rw *bufio.ReadWriter, err error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
+
+// wsHandler upgrades /ws?item=ID and keeps the caller counted as a
+// viewer of that item until the socket closes, pushing the item's count
+// each time someone arrives or leaves.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := current().lookup(r.URL.Query().Get("item"), time.Now())
+	if !ok {
+		http.Error(w, "unknown item", http.StatusNotFound)
+		return
+	}
+	v, err := presence.join(it.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer presence.leave(v)
+
+	// The socket outlives the server's read and write timeouts.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	conn, err := websocket.Accept(hijackWriter{w}, r, nil)
+	if err != nil {
+		log.Printf("ws: %v", err)
+		return
+	}
+	defer conn.CloseNow()
+
+	// Viewers send nothing; CloseRead handles control frames and cancels
+	// ctx once the peer goes away.
+	ctx := conn.CloseRead(context.Background())
+	ping := time.NewTicker(wsPingEvery)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-v.send:
+			data, _ := json.Marshal(msg)
+			wctx, cancel := context.WithTimeout(ctx, wsWriteWait)
+			err := conn.Write(wctx, websocket.MessageText, data)
+			cancel()
+			if err != nil {
+				return
+			}
+		case <-ping.C:
+			pctx, cancel := context.WithTimeout(ctx, wsWriteWait)
+			err := conn.Ping(pctx)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// previewTTL is how long a preview link stays valid.
+var previewTTL time.Duration
+
+// adminPreviewHandler issues a preview link for item {id}: a signed URL
+// that shows the item's page, draft or not, until it expires. Links
+// signed with a random -cookie-secret die with the process.
+func adminPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := adminItem(w, r)
+	if !ok {
+		return
+	}
+	exp := time.Now().Add(previewTTL).UTC()
+	u := siteURL(r, "/preview/"+signToken("preview", strconv.Itoa(it.ID), exp))
+	audit(r, "item.preview", "item "+strconv.Itoa(it.ID), nil, map[string]any{"expires": exp})
+	writeJSON(w, http.StatusCreated, map[string]any{"url": u, "expires": exp})
+}
+
+// previewHandler renders the page of the item signed into /preview/{token}
+// for whoever holds the link. The page is kept out of search engines and
+// caches, and views aren't counted; once the item is public the link
+// forwards to its real page.
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.Header().Set("Cache-Control", "private, no-store")
+	value, err := verifyToken("preview", r.PathValue("token"), time.Now())
+	if err != nil {
+		msg := "This preview link is invalid."
+		if errors.Is(err, errExpiredToken) {
+			msg = "This preview link has expired; ask an editor for a new one."
+		}
+		http.Error(w, msg, http.StatusForbidden)
+		return
+	}
+	id, _ := strconv.Atoi(value)
+	c := current()
+	found, ok := c.byID[id]
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	if found.visible(time.Now()) {
+		http.Redirect(w, r, "/items/"+found.Slug, http.StatusFound)
+		return
+	}
+	lang := writerLocale(w)
+	loc := found.localized(lang)
+	it := &loc
+	data := map[string]interface{}{
+		"Title":     it.KeywordTitle + " (preview) | BlendingWaves",
+		"Item":      it,
+		"Meta":      itemMeta(r, c, it),
+		"Canonical": "",
+		"NoIndex":   true,
+		"Preview":   true,
+	}
+	if err := render(w, "item.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// templatePreviewTimeout bounds a template preview's render.
+var templatePreviewTimeout = 5 * time.Second
+
+// TemplatePreview is the body of POST /admin/template/preview.
+type TemplatePreview struct {
+	Template string                 `json:"template"`
+	Data     map[string]interface{} `json:"data"`
+}
+
+// adminTemplatePreviewHandler renders one of the site's templates with
+// the data in the request into a buffer and answers the HTML, or the
+// error executing it, so a theme can be tried out without a deploy.
+// Only the names in templateFiles can be rendered. Templates can't be
+// interrupted, so a render that outlasts templatePreviewTimeout gets a
+// 504 and finishes in the background, holding its render slot.
+func adminTemplatePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	var req TemplatePreview
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "body must be a JSON object with template and data")
+		return
+	}
+	if !slices.Contains(templateFiles, req.Template) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("template must be one of %s", strings.Join(templateFiles, ", ")))
+		return
+	}
+	if req.Data == nil {
+		req.Data = map[string]interface{}{}
+	}
+	pageDefaults(w, http.StatusOK, req.Data)
+	set, err := templates()
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if !acquireRender() {
+		renderError(w, errRenderBusy)
+		return
+	}
+	done := make(chan error, 1)
+	var buf bytes.Buffer
+	go func() {
+		defer releaseRender()
+		done <- set[req.Template].ExecuteTemplate(&buf, req.Template, req.Data)
+	}()
+	t := time.NewTimer(templatePreviewTimeout)
+	defer t.Stop()
+	select {
+	case err = <-done:
+	case <-t.C:
+		writeJSONError(w, http.StatusGatewayTimeout, fmt.Sprintf("rendering %s took longer than %s", req.Template, templatePreviewTimeout))
+		return
+	case <-r.Context().Done():
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(withBaseHTML(buf.Bytes()))
+}
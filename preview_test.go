@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTemplatePreview(t *testing.T) {
+	withAdmin(t)
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{"render", `{"template":"404.html","data":{"Path":"/preview-goes-here"}}`, 200, "<code>/preview-goes-here</code>"},
+		{"exec error", `{"template":"home.html","data":{"Items":true}}`, 422, "range can't iterate"},
+		{"unknown template", `{"template":"../main.go","data":{}}`, 400, "template must be one of"},
+		{"bad body", `not json`, 400, "JSON object"},
+	}
+	for _, tt := range tests {
+		w := do(adminRequest("POST", "/admin/template/preview", strings.NewReader(tt.body)))
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: %d %q, want %d containing %q", tt.name, w.Code, w.Body.String(), tt.status, tt.want)
+		}
+	}
+
+	anon := httptest.NewRequest("POST", "/admin/template/preview", strings.NewReader(tests[0].body))
+	if w := do(anon); w.Code != 401 {
+		t.Errorf("anonymous preview: status %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Item statuses. An empty Status predates the workflow and counts as
+// published.
+const (
+	StatusDraft     = "draft"
+	StatusScheduled = "scheduled"
+	StatusPublished = "published"
+)
+
+// publishCheckMax bounds how long the scheduler sleeps, so items
+// scheduled while it waits are still picked up promptly.
+const publishCheckMax = time.Minute
+
+// published reports whether the item is public at now. A scheduled item
+// is public from its PublishAt even before the scheduler records it.
+func (it *Item) published(now time.Time) bool {
+	switch it.Status {
+	case "", StatusPublished:
+		return true
+	case StatusScheduled:
+		return it.PublishAt != nil && !now.Before(*it.PublishAt)
+	}
+	return false
+}
+
+// visible reports whether public routes should show the item at now.
+func (it *Item) visible(now time.Time) bool {
+	return it.published(now) && !it.expired(now)
+}
+
+// validStatus checks an editor-supplied status and publish time.
+func validStatus(status string, publishAt *time.Time) error {
+	switch status {
+	case "", StatusDraft, StatusPublished:
+		return nil
+	case StatusScheduled:
+		if publishAt == nil {
+			return fmt.Errorf("a scheduled item needs a publish time")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown status %q", status)
+}
+
+// publishScheduler flips scheduled items to published in the store once
+// their PublishAt passes, waking at the next due time.
+func publishScheduler() {
+	for {
+		now := time.Now()
+		wait := publishCheckMax
+		for _, it := range current().items {
+			if it.Status != StatusScheduled || it.PublishAt == nil {
+				continue
+			}
+			if d := it.PublishAt.Sub(now); d < wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		if n, err := publishDue(store, time.Now()); err != nil {
+			log.Printf("publish scheduler: %v", err)
+		} else if n > 0 {
+			log.Printf("publish scheduler: published %d item(s)", n)
+			if err := reloadItems(context.Background()); err != nil {
+				log.Printf("publish scheduler: reload: %v", err)
+			}
+		}
+	}
+}
+
+// publishDue marks the scheduled items due at now as published in repo,
+// returning how many changed.
+func publishDue(repo ItemRepository, now time.Time) (int, error) {
+	all, err := repo.List()
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, it := range all {
+		if it.Status != StatusScheduled || !it.published(now) {
+			continue
+		}
+		it.Status = StatusPublished
+		if err := putItem(repo, "scheduler", RevPublish, it); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
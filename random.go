@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// seenCookie lists the IDs of the items the visitor opened most
+// recently, newest first, so "surprise me" can avoid repeating them. It
+// only steers a random pick and needs no signature.
+const (
+	seenCookie = "bw_seen"
+	seenMax    = 20
+	seenMaxAge = 30 * 24 * time.Hour
+)
+
+// seenItems parses the visitor's seen cookie, ignoring junk.
+func seenItems(r *http.Request) []int {
+	c, err := r.Cookie(seenCookie)
+	if err != nil {
+		return nil
+	}
+	var ids []int
+	for _, f := range strings.Split(c.Value, ".") {
+		if id, err := strconv.Atoi(f); err == nil && len(ids) < seenMax {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// rememberSeen moves id to the front of the visitor's seen list.
+func rememberSeen(w http.ResponseWriter, r *http.Request, id int) {
+	ids := slices.DeleteFunc(seenItems(r), func(s int) bool { return s == id })
+	ids = append([]int{id}, ids[:min(len(ids), seenMax-1)]...)
+	parts := make([]string, len(ids))
+	for i, s := range ids {
+		parts[i] = strconv.Itoa(s)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     seenCookie,
+		Value:    strings.Join(parts, "."),
+		Path:     "/",
+		MaxAge:   int(seenMaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// randomItem picks a live item, avoiding the visitor's recently seen
+// ones unless ?seen=1 is given. Once everything has been seen it picks
+// among the items seen longest ago.
+func randomItem(r *http.Request) (Item, bool) {
+	live := current().live(time.Now())
+	if len(live) == 0 {
+		return Item{}, false
+	}
+	if r.URL.Query().Get("seen") != "1" {
+		seen := seenItems(r)
+		for n := len(seen); n > 0; n-- {
+			fresh := slices.DeleteFunc(slices.Clone(live), func(it Item) bool { return slices.Contains(seen[:n], it.ID) })
+			if len(fresh) > 0 {
+				live = fresh
+				break
+			}
+		}
+	}
+	return live[rand.IntN(len(live))], true
+}
+
+// randomHandler redirects /random to a random item page.
+func randomHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := randomItem(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, "/items/"+it.Slug, http.StatusFound)
+}
+
+// randomAPIHandler returns a random item with its page URL for the
+// frontend's "surprise me" button.
+func randomAPIHandler(w http.ResponseWriter, r *http.Request) {
+	it, ok := randomItem(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no items")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{"item": it, "url": "/items/" + it.Slug})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trustedProxies are the networks whose X-Forwarded-For is believed.
+var trustedProxies []netip.Prefix
+
+// setTrustedProxies parses a comma-separated list of CIDRs or addresses.
+func setTrustedProxies(list string) error {
+	var err error
+	trustedProxies, err = parsePrefixes(list, "trusted proxy")
+	return err
+}
+
+// parsePrefixes parses a comma-separated list of CIDRs or addresses, a
+// bare address being a network of one; what names an entry in errors.
+func parsePrefixes(list, what string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s %q: %w", what, s, err)
+			}
+			s = netip.PrefixFrom(addr, addr.BitLen()).String()
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %w", what, s, err)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client. When the peer is a trusted
+// proxy, X-Forwarded-For is walked from the right and the first untrusted
+// hop is used.
+func clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// rateRule limits requests under a path prefix to Rate per second with
+// bursts of up to Burst.
+type rateRule struct {
+	Prefix string
+	Rate   float64
+	Burst  float64
+}
+
+// parseRateRule parses prefix=rate/burst, e.g. /static/video/=2/10.
+func parseRateRule(s string) (rateRule, error) {
+	prefix, spec, ok := strings.Cut(s, "=")
+	rateStr, burstStr, ok2 := strings.Cut(spec, "/")
+	if !ok || !ok2 || !strings.HasPrefix(prefix, "/") {
+		return rateRule{}, fmt.Errorf("rate limit %q: want /prefix=rate/burst", s)
+	}
+	rate, err1 := strconv.ParseFloat(rateStr, 64)
+	burst, err2 := strconv.ParseFloat(burstStr, 64)
+	if err1 != nil || err2 != nil || rate <= 0 || burst < 1 {
+		return rateRule{}, fmt.Errorf("rate limit %q: rate must be > 0 and burst >= 1", s)
+	}
+	return rateRule{Prefix: prefix, Rate: rate, Burst: burst}, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds one token bucket per (rule, client IP).
+type rateLimiter struct {
+	rules []rateRule // longest prefix first
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newRateLimiter(rules []rateRule) *rateLimiter {
+	rules = append([]rateRule(nil), rules...)
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].Prefix) > len(rules[j].Prefix) })
+	return &rateLimiter{rules: rules, buckets: map[string]*bucket{}}
+}
+
+// allow takes a token for key under rule, returning how long to wait for
+// the next token when none is available.
+func (l *rateLimiter) allow(rule rateRule, key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rule.Burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(rule.Burst, b.tokens+now.Sub(b.last).Seconds()*rule.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+}
+
+// sweep drops buckets idle long enough to have refilled completely.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > 10*time.Minute {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func (l *rateLimiter) match(p string) (rateRule, bool) {
+	for _, rule := range l.rules {
+		if strings.HasPrefix(p, rule.Prefix) {
+			return rule, true
+		}
+	}
+	return rateRule{}, false
+}
+
+// rateLimit rejects clients that exceed the rule for the request path
+// with 429 and a Retry-After header.
+func rateLimit(rules []rateRule, h http.Handler) http.Handler {
+	if len(rules) == 0 {
+		return h
+	}
+	l := newRateLimiter(rules)
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			l.sweep(now)
+		}
+	}()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := l.match(r.URL.Path)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		allowed, wait := l.allow(rule, rule.Prefix+"|"+clientIP(r), time.Now())
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// ErrorEvent is one captured failure: a recovered panic or an error that
+// produced a 500 page.
+type ErrorEvent struct {
+	ID        string // the ID shown on the error page
+	RequestID string
+	Message   string
+	Stack     string // empty for plain errors
+	Method    string
+	URL       string
+	Time      time.Time
+}
+
+// ErrorReporter ships error events to a tracking service. Report must
+// not block the request.
+type ErrorReporter interface {
+	Report(ev ErrorEvent)
+}
+
+// reporter receives every error event; nil when only logging.
+var reporter ErrorReporter
+
+func report(ev ErrorEvent) {
+	if reporter != nil {
+		reporter.Report(ev)
+	}
+}
+
+// recoverPanics turns a handler panic into a logged stack trace, a report
+// and, when nothing has been written yet, the 500 page with the error ID.
+func recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v) // net/http's signal to drop the connection quietly
+			}
+			ev := ErrorEvent{
+				ID:        newErrorID(),
+				RequestID: requestIDFrom(r.Context()),
+				Message:   fmt.Sprint(v),
+				Stack:     string(debug.Stack()),
+				Method:    r.Method,
+				URL:       r.URL.String(),
+				Time:      time.Now(),
+			}
+			log.Printf("panic %s (request %s): %s %s: %s\n%s", ev.ID, orDash(ev.RequestID), ev.Method, ev.URL, ev.Message, ev.Stack)
+			report(ev)
+			if rec.status == 0 {
+				errorPage(w, ev.ID)
+			}
+		}()
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// sentryReporter posts events to a Sentry project's store endpoint.
+type sentryReporter struct {
+	endpoint string
+	auth     string
+	client   *http.Client
+}
+
+// newSentryReporter parses a DSN of the form https://KEY@HOST/PROJECT.
+func newSentryReporter(dsn string) (*sentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.Host == "" {
+		return nil, fmt.Errorf("sentry-dsn must look like https://KEY@HOST/PROJECT, got %q", dsn)
+	}
+	path, project, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if project == "" {
+		path, project = "", path
+	}
+	if path != "" {
+		path = "/" + path
+	}
+	return &sentryReporter{
+		endpoint: u.Scheme + "://" + u.Host + path + "/api/" + project + "/store/",
+		auth:     "Sentry sentry_version=7, sentry_client=blendingwaves/1.0, sentry_key=" + u.User.Username(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *sentryReporter) Report(ev ErrorEvent) {
+	eventID := make([]byte, 16)
+	rand.Read(eventID)
+	body := map[string]any{
+		"event_id":  hex.EncodeToString(eventID),
+		"timestamp": ev.Time.UTC().Format(time.RFC3339),
+		"level":     "error",
+		"platform":  "go",
+		"message":   ev.Message,
+		"tags":      map[string]string{"error_id": ev.ID, "request_id": ev.RequestID},
+	}
+	if ev.URL != "" {
+		body["request"] = map[string]string{"method": ev.Method, "url": ev.URL}
+	}
+	if ev.Stack != "" {
+		body["extra"] = map[string]string{"stack": ev.Stack}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", s.auth)
+		resp, err := s.client.Do(req)
+		if err != nil {
+			log.Printf("sentry: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("sentry: %s", resp.Status)
+		}
+	}()
+}
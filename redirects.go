@@ -0,0 +1,379 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redirect sends requests for one path elsewhere: an old item URL to
+// its new one, or a short campaign link such as /summer to wherever the
+// campaign lives. Auto marks those made when an item's slug changed.
+type Redirect struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Status    int       `json:"status"`
+	Note      string    `json:"note,omitempty"`
+	Auto      bool      `json:"auto,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// redirectStore is implemented by repositories that keep redirects, one
+// per source path.
+type redirectStore interface {
+	Redirects() ([]Redirect, error)
+	PutRedirect(rd Redirect) error
+	DeleteRedirect(from string) error
+}
+
+// redirectReserved are paths a redirect can't take over, so no mistake
+// in the table locks editors out of the admin or breaks the site's
+// machinery.
+var redirectReserved = []string{"/admin", "/api/", "/static/", "/login", "/logout", "/auth/", "/hooks/", "/healthz", "/readyz", "/metrics", "/collect"}
+
+// redirects is the table in effect and how often each entry was used
+// since the server started.
+var redirects = struct {
+	sync.RWMutex
+	byFrom map[string]Redirect
+	hits   map[string]int64
+}{byFrom: map[string]Redirect{}, hits: map[string]int64{}}
+
+// loadRedirects reads the table from the store.
+func loadRedirects() {
+	rs, ok := store.(redirectStore)
+	if !ok {
+		return
+	}
+	all, err := rs.Redirects()
+	if err != nil {
+		log.Printf("redirects: load: %v", err)
+		return
+	}
+	byFrom := make(map[string]Redirect, len(all))
+	for _, rd := range all {
+		byFrom[rd.From] = rd
+	}
+	redirects.Lock()
+	redirects.byFrom = byFrom
+	redirects.Unlock()
+}
+
+// withRedirects answers GET and HEAD requests for a path in the table
+// with its redirect before any route sees them. The request's query is
+// kept unless the target has its own.
+func withRedirects(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		from := redirectPath(r.URL.Path)
+		redirects.RLock()
+		rd, ok := redirects.byFrom[from]
+		redirects.RUnlock()
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		redirects.Lock()
+		redirects.hits[from]++
+		redirects.Unlock()
+		to := rd.To
+		if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, rd.Status)
+	})
+}
+
+// redirectPath is the form of p redirects are keyed by: without a
+// trailing slash, so /summer/ finds /summer.
+func redirectPath(p string) string {
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return p
+}
+
+// checkRedirect validates rd against the table, normalizing its source.
+// A redirect must not lead back to its own source, directly or through
+// other entries.
+func checkRedirect(rd *Redirect, table map[string]Redirect) error {
+	rd.From = redirectPath(strings.TrimSpace(rd.From))
+	rd.To = strings.TrimSpace(rd.To)
+	switch {
+	case !strings.HasPrefix(rd.From, "/") || rd.From == "/" || strings.ContainsAny(rd.From, "?# "):
+		return errors.New("the source must be a path like /summer, without a query")
+	case slices.ContainsFunc(redirectReserved, func(p string) bool { return rd.From == strings.TrimSuffix(p, "/") || strings.HasPrefix(rd.From, p) }):
+		return fmt.Errorf("%s is reserved and can't be redirected", rd.From)
+	case rd.Status != http.StatusMovedPermanently && rd.Status != http.StatusFound:
+		return errors.New("the status must be 301 (permanent) or 302 (temporary)")
+	case rd.To == "":
+		return errors.New("the target must not be empty")
+	case !strings.HasPrefix(rd.To, "/") && externalURL(rd.To) == "":
+		return errors.New("the target must be a path on this site or an http(s) URL")
+	case strings.HasPrefix(rd.To, "//"):
+		return errors.New("the target must be a path on this site or an http(s) URL")
+	}
+	seen := map[string]bool{rd.From: true}
+	for next := rd.To; strings.HasPrefix(next, "/"); {
+		u, err := url.Parse(next)
+		if err != nil {
+			return fmt.Errorf("target: %w", err)
+		}
+		p := redirectPath(u.Path)
+		if seen[p] {
+			return fmt.Errorf("%s would redirect in a loop through %s", rd.From, p)
+		}
+		seen[p] = true
+		hop, ok := table[p]
+		if !ok {
+			break
+		}
+		next = hop.To
+	}
+	return nil
+}
+
+// itemRenamed keeps the old URL of an item whose slug changed working:
+// it redirects the old path to the new one, points redirects that led to
+// the old path straight at the new one, and drops any redirect off the
+// new path so the item can be reached there.
+func itemRenamed(repo ItemRepository, before, after Item) {
+	rs, ok := repo.(redirectStore)
+	if !ok {
+		return
+	}
+	oldPath := "/items/" + cmp.Or(before.Slug, slugify(before.KeywordTitle))
+	newPath := "/items/" + cmp.Or(after.Slug, slugify(after.KeywordTitle))
+	if oldPath == newPath || oldPath == "/items/" {
+		return
+	}
+	all, err := rs.Redirects()
+	if err != nil {
+		log.Printf("redirects: item %d renamed: %v", after.ID, err)
+		return
+	}
+	var errs []error
+	for _, rd := range all {
+		switch {
+		case rd.From == newPath:
+			errs = append(errs, rs.DeleteRedirect(rd.From))
+		case rd.To == oldPath:
+			rd.To = newPath
+			errs = append(errs, rs.PutRedirect(rd))
+		}
+	}
+	errs = append(errs, rs.PutRedirect(Redirect{
+		From:      oldPath,
+		To:        newPath,
+		Status:    http.StatusMovedPermanently,
+		Note:      fmt.Sprintf("item %d renamed", after.ID),
+		Auto:      true,
+		CreatedAt: time.Now().UTC(),
+	}))
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("redirects: item %d renamed: %v", after.ID, err)
+	}
+	loadRedirects()
+}
+
+// adminRedirectsHandler lists the table with the form adding an entry.
+func adminRedirectsHandler(w http.ResponseWriter, r *http.Request) {
+	renderRedirects(w, r, http.StatusOK, Redirect{Status: http.StatusMovedPermanently}, "")
+}
+
+func renderRedirects(w http.ResponseWriter, r *http.Request, status int, form Redirect, problem string) {
+	redirects.RLock()
+	all := slices.SortedFunc(maps.Values(redirects.byFrom), func(a, b Redirect) int { return strings.Compare(a.From, b.From) })
+	hits := maps.Clone(redirects.hits)
+	redirects.RUnlock()
+	data := map[string]interface{}{
+		"Title":     "Redirects | Admin",
+		"Redirects": all,
+		"Hits":      hits,
+		"Form":      form,
+		"Error":     problem,
+		"CSRF":      csrfToken(w, r),
+	}
+	if err := renderStatus(w, status, "admin_redirects.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// adminPutRedirectHandler adds or replaces the redirect from the posted
+// from, to and status.
+func adminPutRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	rs, ok := store.(redirectStore)
+	if !ok {
+		http.Error(w, "store does not keep redirects", http.StatusNotImplemented)
+		return
+	}
+	status, _ := strconv.Atoi(r.PostFormValue("status"))
+	rd := Redirect{
+		From:      r.PostFormValue("from"),
+		To:        r.PostFormValue("to"),
+		Status:    status,
+		Note:      strings.TrimSpace(r.PostFormValue("note")),
+		CreatedAt: time.Now().UTC(),
+	}
+	redirects.RLock()
+	err := checkRedirect(&rd, redirects.byFrom)
+	var before any
+	if prev, ok := redirects.byFrom[rd.From]; ok {
+		before = prev
+	}
+	redirects.RUnlock()
+	if err != nil {
+		renderRedirects(w, r, http.StatusBadRequest, rd, err.Error())
+		return
+	}
+	if err := rs.PutRedirect(rd); err != nil {
+		serverError(w, err)
+		return
+	}
+	loadRedirects()
+	audit(r, "redirect.put", rd.From, before, rd)
+	log.Printf("admin: %s redirected %s to %s (%d)", actor(r), rd.From, rd.To, rd.Status)
+	http.Redirect(w, r, "/admin/redirects", http.StatusSeeOther)
+}
+
+// adminDeleteRedirectHandler removes the redirect from the posted from.
+func adminDeleteRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	rs, ok := store.(redirectStore)
+	if !ok {
+		http.Error(w, "store does not keep redirects", http.StatusNotImplemented)
+		return
+	}
+	from := r.PostFormValue("from")
+	redirects.RLock()
+	before := redirects.byFrom[from]
+	redirects.RUnlock()
+	if err := rs.DeleteRedirect(from); err != nil {
+		storeError(w, r, err)
+		return
+	}
+	loadRedirects()
+	audit(r, "redirect.delete", from, before, nil)
+	log.Printf("admin: %s removed the redirect from %s", actor(r), from)
+	http.Redirect(w, r, "/admin/redirects", http.StatusSeeOther)
+}
+
+// redirectsPath is where the JSON store keeps redirects, beside items.json.
+func (s *jsonStore) redirectsPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".redirects.json"
+}
+
+func (s *jsonStore) readRedirects() ([]Redirect, error) {
+	data, err := os.ReadFile(s.redirectsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Redirect
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.redirectsPath(), err)
+	}
+	return out, nil
+}
+
+func (s *jsonStore) writeRedirects(all []Redirect) error {
+	slices.SortFunc(all, func(a, b Redirect) int { return strings.Compare(a.From, b.From) })
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.redirectsPath(), data)
+}
+
+func (s *jsonStore) Redirects() ([]Redirect, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readRedirects()
+}
+
+func (s *jsonStore) PutRedirect(rd Redirect) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readRedirects()
+	if err != nil {
+		return err
+	}
+	if i := slices.IndexFunc(all, func(x Redirect) bool { return x.From == rd.From }); i >= 0 {
+		all[i] = rd
+	} else {
+		all = append(all, rd)
+	}
+	return s.writeRedirects(all)
+}
+
+func (s *jsonStore) DeleteRedirect(from string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readRedirects()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(all, func(x Redirect) bool { return x.From == from })
+	if i < 0 {
+		return ErrNotFound
+	}
+	return s.writeRedirects(slices.Delete(all, i, i+1))
+}
+
+func (s *sqliteStore) Redirects() ([]Redirect, error) {
+	rows, err := s.db.Query(`SELECT data FROM redirects ORDER BY source`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Redirect
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var rd Redirect
+		if err := json.Unmarshal([]byte(data), &rd); err != nil {
+			return nil, err
+		}
+		out = append(out, rd)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) PutRedirect(rd Redirect) error {
+	data, err := json.Marshal(rd)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO redirects (source, data) VALUES (?, ?)
+		ON CONFLICT(source) DO UPDATE SET data = excluded.data`, rd.From, string(data))
+	return err
+}
+
+func (s *sqliteStore) DeleteRedirect(from string) error {
+	res, err := s.db.Exec(`DELETE FROM redirects WHERE source = ?`, from)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
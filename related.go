@@ -0,0 +1,104 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+)
+
+// Related-items tuning: how many are shown, how many candidates are kept
+// per item so unpublished ones can be skipped, and the worth of a shared
+// tag relative to full keyword similarity.
+const (
+	relatedShow = 4
+	relatedKeep = 12
+	tagWeight   = 2.0
+)
+
+// buildRelated ranks, for every item, the others sharing its tags or
+// vocabulary. Keyword similarity is the cosine between the items' stemmed
+// title and text terms, with the title counting double.
+func buildRelated(c *catalog) [][]int {
+	analyzer := searchMapping().AnalyzerNamed(en.AnalyzerName)
+	terms := make([]map[string]float64, len(c.items))
+	norms := make([]float64, len(c.items))
+	add := func(i int, text string, w float64) {
+		for _, tok := range analyzer.Analyze([]byte(text)) {
+			terms[i][string(tok.Term)] += w
+		}
+	}
+	for i := range c.items {
+		terms[i] = map[string]float64{}
+		add(i, c.items[i].KeywordTitle, 2)
+		add(i, plainTexts(c.items[i].Texts), 1)
+		for _, w := range terms[i] {
+			norms[i] += w * w
+		}
+		norms[i] = math.Sqrt(norms[i])
+	}
+	// Only items sharing a term or a tag can score, so candidates come
+	// from postings rather than comparing every pair.
+	postings := map[string][]int{}
+	for i := range terms {
+		for t := range terms[i] {
+			postings[t] = append(postings[t], i)
+		}
+	}
+	itemTags := make([][]string, len(c.items))
+	for slug, idx := range c.byTag {
+		for _, i := range idx {
+			itemTags[i] = append(itemTags[i], slug)
+		}
+	}
+
+	type scored struct {
+		i     int
+		score float64
+	}
+	related := make([][]int, len(c.items))
+	for i := range c.items {
+		scores := map[int]float64{}
+		for _, slug := range itemTags[i] {
+			for _, j := range c.byTag[slug] {
+				scores[j] += tagWeight
+			}
+		}
+		for t, w := range terms[i] {
+			for _, j := range postings[t] {
+				scores[j] += w * terms[j][t] / (norms[i] * norms[j])
+			}
+		}
+		delete(scores, i)
+		cands := make([]scored, 0, len(scores))
+		for j, score := range scores {
+			cands = append(cands, scored{j, score})
+		}
+		slices.SortFunc(cands, func(a, b scored) int {
+			return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(a.i, b.i))
+		})
+		for _, s := range cands[:min(len(cands), relatedKeep)] {
+			related[i] = append(related[i], s.i)
+		}
+	}
+	return related
+}
+
+// relatedTo returns up to relatedShow live items related to it.
+func (c *catalog) relatedTo(it *Item, now time.Time) []Item {
+	i := slices.IndexFunc(c.items, func(o Item) bool { return o.ID == it.ID })
+	if i < 0 {
+		return nil
+	}
+	var out []Item
+	for _, j := range c.related[i] {
+		if c.items[j].visible(now) {
+			if out = append(out, c.items[j]); len(out) == relatedShow {
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// layoutFiles are parsed into every page's template set: the shell
+// (layout.html) and the chrome it includes.
+var layoutFiles = []string{
+	"layout.html",
+	"header.html",
+	"footer.html",
+}
+
+// adminLayout is added to the set of every admin_* page; it overrides the
+// layout's blocks with the admin bar.
+const adminLayout = "admin_head.html"
+
+// templateFiles lists every page template parsed from templateFS. Each
+// gets its own set so pages can all define the layout's blocks.
+var templateFiles = []string{
+	"home.html",
+	"home_lite.html",
+	"item.html",
+	"search.html",
+	"tag.html",
+	"collections.html",
+	"collection.html",
+	"admin_items.html",
+	"admin_item_form.html",
+	"403.html",
+	"404.html",
+	"500.html",
+	"503.html",
+	"favorites.html",
+	"admin_comments.html",
+	"admin_history.html",
+	"admin_stats.html",
+	"admin_redirects.html",
+	"admin_collections.html",
+	"contact.html",
+	"newsletter.html",
+	"account.html",
+	"me.html",
+	"admin_users.html",
+	"admin_audit.html",
+	"admin_jobs.html",
+	"admin_digests.html",
+	"digest_email.html",
+	"graphiql.html",
+	"api_docs.html",
+	"privacy.html",
+	"tou.html",
+	"non.html",
+	"credits.html",
+}
+
+// templateFuncs are the helpers available to every template.
+var templateFuncs = template.FuncMap{
+	"join":     strings.Join,
+	"slug":     slugify,
+	"jsonLD":   jsonLD,
+	"hls":      hlsURL,
+	"thumb":    thumbURL,
+	"poster":   posterFor,
+	"formTime": formTime,
+	"csrf":     csrfField,
+	"t":        translate,
+	"markdown": markdownHTML,
+	"plain":    markdownText,
+	"date":     formatDate,
+	"captions": formatCaptions,
+	"truncate": truncateFunc,
+	"excerpt":  excerpt,
+	"plural":   pluralize,
+	"external": externalURL,
+	"asset":    assetURL,
+	"base":     basePathFunc,
+	"json":     jsonFunc,
+}
+
+// templateSet maps each page in templateFiles to its parsed set.
+type templateSet map[string]*template.Template
+
+// parseTemplates parses every page in templateFiles from fsys on top of
+// the shared layout.
+func parseTemplates(fsys fs.FS) (templateSet, error) {
+	base, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, layoutFiles...)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := template.Must(base.Clone()).ParseFS(fsys, adminLayout)
+	if err != nil {
+		return nil, err
+	}
+	set := templateSet{}
+	for _, name := range templateFiles {
+		parent := base
+		if strings.HasPrefix(name, "admin_") {
+			parent = admin
+		}
+		t, err := template.Must(parent.Clone()).ParseFS(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		set[name] = t
+	}
+	return set, nil
+}
+
+// devMode re-parses templateFS on every render; in production the
+// templates parsed at startup are used.
+var devMode bool
+
+// templates returns the template set to render with.
+func templates() (templateSet, error) {
+	if !devMode {
+		return tmpl, nil
+	}
+	return parseTemplates(templateFS)
+}
+
+// maxPooledBuffer caps the size of buffers returned to the pool so one
+// unusually large render doesn't pin its memory forever.
+const maxPooledBuffer = 1 << 20
+
+// renderBufferSize is the initial capacity of pooled render buffers.
+var renderBufferSize int
+
+var bufPool = sync.Pool{
+	New: func() any { return bytes.NewBuffer(make([]byte, 0, renderBufferSize)) },
+}
+
+// errRenderBusy is returned by render when no render slot frees up in time.
+var errRenderBusy = errors.New("render: too many concurrent renders")
+
+// renderSlots bounds concurrent template renders independently of the
+// number of in-flight requests. Nil means unlimited.
+var (
+	renderSlots   chan struct{}
+	renderWait    time.Duration
+	renderWaitNs  atomic.Int64 // total time spent waiting for a slot
+	renderBusyCnt atomic.Int64 // renders rejected with errRenderBusy
+)
+
+// setMaxRenders sizes the render semaphore; n <= 0 disables it.
+func setMaxRenders(n int) {
+	if n <= 0 {
+		renderSlots = nil
+		return
+	}
+	renderSlots = make(chan struct{}, n)
+}
+
+// acquireRender waits up to renderWait for a render slot.
+func acquireRender() bool {
+	if renderSlots == nil {
+		return true
+	}
+	start := time.Now()
+	defer func() { renderWaitNs.Add(int64(time.Since(start))) }()
+	select {
+	case renderSlots <- struct{}{}:
+		return true
+	default:
+	}
+	t := time.NewTimer(renderWait)
+	defer t.Stop()
+	select {
+	case renderSlots <- struct{}{}:
+		return true
+	case <-t.C:
+		renderBusyCnt.Add(1)
+		return false
+	}
+}
+
+func releaseRender() {
+	if renderSlots != nil {
+		<-renderSlots
+	}
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufPool.Put(buf)
+}
+
+// render executes the named template into a pooled buffer and only then
+// copies it to w, so a failing template never leaves a half-written page.
+func render(w http.ResponseWriter, name string, data any) error {
+	return renderStatus(w, http.StatusOK, name, data)
+}
+
+// renderStatus is render with an explicit status code.
+func renderStatus(w http.ResponseWriter, status int, name string, data any) (err error) {
+	_, span := startSpan(writerContext(w), "render "+name)
+	defer func() {
+		span.fail(err)
+		span.End()
+	}()
+	if m, ok := data.(map[string]interface{}); ok {
+		pageDefaults(w, status, m)
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := executeTemplate(buf, name, data); err != nil {
+		if !errors.Is(err, errRenderBusy) {
+			renderFailures.Add(1)
+		}
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = w.Write(withBaseHTML(buf.Bytes()))
+	return err
+}
+
+// executeTemplate executes the named template into buf under a render
+// slot. The slot is given back before the page is written out, so slow
+// clients can't hold every slot and starve other renders.
+func executeTemplate(buf *bytes.Buffer, name string, data any) error {
+	if !acquireRender() {
+		return errRenderBusy
+	}
+	defer releaseRender()
+	set, err := templates()
+	if err != nil {
+		return err
+	}
+	t, ok := set[name]
+	if !ok {
+		return fmt.Errorf("render: no template %q", name)
+	}
+	return t.ExecuteTemplate(buf, name, data)
+}
+
+// pageDefaults fills in what every page gets unless m sets it: the CSP
+// nonce for its inline scripts, the visitor's feature flags, their
+// language and, when it is found, its canonical URL.
+func pageDefaults(w http.ResponseWriter, status int, m map[string]interface{}) {
+	if _, set := m["Nonce"]; !set {
+		m["Nonce"] = cspNonce(w)
+	}
+	if _, set := m["Features"]; !set {
+		m["Features"] = writerFeatures(w)
+	}
+	if _, set := m["Lang"]; !set {
+		m["Lang"] = writerLocale(w)
+		m["Locales"] = localeLinks(w)
+	}
+	if _, set := m["Canonical"]; !set && status == http.StatusOK {
+		if meta, ok := m["Meta"].(PageMeta); ok {
+			m["Canonical"] = meta.URL
+		} else {
+			m["Canonical"] = canonicalURL(w)
+		}
+	}
+}
+
+// renderPage answers a page route: the named template for browsers, or
+// for clients that want JSON (see wantsJSON) an object holding the data
+// entries named by jsonKeys, under their lower-cased names. A Paginator in
+// data becomes X-Total-Count and Link headers, as on the API. The JSON
+// carries an ETag of its content, so a client polling an item page gets
+// 304 until the item, or what the page shows beside it, changes.
+func renderPage(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}, jsonKeys ...string) {
+	w.Header().Add("Vary", "Accept")
+	if !wantsJSON(r) {
+		if err := render(w, name, data); err != nil {
+			renderError(w, err)
+		}
+		return
+	}
+	out := make(map[string]any, len(jsonKeys))
+	for _, k := range jsonKeys {
+		if v, ok := data[k]; ok {
+			out[strings.ToLower(k)] = v
+		}
+	}
+	if p, ok := data["Paginator"].(Paginator); ok {
+		w.Header().Set("X-Total-Count", strconv.Itoa(p.Total))
+		if link := p.linkHeader(); link != "" {
+			w.Header().Set("Link", link)
+		}
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(out); err != nil {
+		serverError(w, err)
+		return
+	}
+	if notModified(w, r, contentETag(buf.Bytes())) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// renderError reports a failed render: 503 when the render limit was hit,
+// the 500 page otherwise.
+func renderError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errRenderBusy) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Server busy, please retry", http.StatusServiceUnavailable)
+		return
+	}
+	if devMode {
+		log.Printf("render: %v", err)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		devErrorPage.Execute(w, diagnose(err))
+		return
+	}
+	serverError(w, err)
+}
+
+// templateErrorPos finds the file and line in a template error, from
+// "template: item.html:12: ..." or "html/template:item.html:12:5: ...".
+var templateErrorPos = regexp.MustCompile(`template: ?([^:\s]+):(\d+)`)
+
+// devContext is how many lines either side of the offending one the
+// -dev error page quotes.
+const devContext = 3
+
+// devError is what the -dev error page shows.
+type devError struct {
+	Template string
+	Line     int
+	Err      string
+	Source   []sourceLine // the offending line and its neighbours
+}
+
+type sourceLine struct {
+	N         int
+	Text      string
+	Offending bool
+}
+
+// diagnose describes a render error for the -dev error page, quoting the
+// template source around the line it names.
+func diagnose(err error) devError {
+	d := devError{Err: err.Error()}
+	m := templateErrorPos.FindStringSubmatch(d.Err)
+	if m == nil {
+		return d
+	}
+	d.Template = m[1]
+	d.Line, _ = strconv.Atoi(m[2])
+	src, rerr := fs.ReadFile(templateFS, d.Template)
+	if rerr != nil {
+		return d
+	}
+	lines := strings.Split(string(src), "\n")
+	for n := max(1, d.Line-devContext); n <= min(len(lines), d.Line+devContext); n++ {
+		d.Source = append(d.Source, sourceLine{n, lines[n-1], n == d.Line})
+	}
+	return d
+}
+
+// devErrorPage shows template errors in the browser in -dev mode.
+var devErrorPage = template.Must(template.New("dev-error").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Template error</title></head>
+<body style="font-family: monospace; padding: 2em">
+<h1 style="color: #b00020">Template error</h1>
+{{ with .Template }}<p>In <strong>{{ . }}</strong>{{ with $.Line }}, line {{ . }}{{ end }}:</p>{{ end }}
+<pre style="white-space: pre-wrap">{{ .Err }}</pre>
+{{ with .Source }}<pre style="background: #f4f4f4; padding: 1em">
+{{- range . }}
+<span{{ if .Offending }} style="background: #ffd6d6; font-weight: bold"{{ end }}>{{ printf "%4d" .N }}  {{ .Text }}</span>
+{{- end }}
+</pre>{{ end }}
+<p>Fix the template and reload; the server keeps running.</p>
+</body></html>
+`))
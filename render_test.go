@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// BenchmarkRenderBuffer renders the home page into a pooled buffer and,
+// for comparison, into a fresh one each time; the pooled run should
+// allocate far fewer bytes per op.
+func BenchmarkRenderBuffer(b *testing.B) {
+	set, err := templates()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var items []Item
+	for id := 1; id <= 12; id++ {
+		items = append(items, testItem(id, fmt.Sprintf("Project %02d", id)))
+	}
+	data := map[string]interface{}{"Title": "BlendingWaves", "Items": items, "Lang": "en", "Nonce": "bench"}
+	page := set["home.html"]
+	benchmarks := []struct {
+		name string
+		get  func() *bytes.Buffer
+		put  func(*bytes.Buffer)
+	}{
+		{"pooled", getBuffer, putBuffer},
+		{"fresh", func() *bytes.Buffer { return bytes.NewBuffer(make([]byte, 0, renderBufferSize)) }, func(*bytes.Buffer) {}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				buf := bm.get()
+				if err := page.ExecuteTemplate(buf, "home.html", data); err != nil {
+					b.Fatal(err)
+				}
+				bm.put(buf)
+			}
+		})
+	}
+}
+
+func TestPutBufferDropsOversized(t *testing.T) {
+	tests := []struct {
+		size int
+		kept bool
+	}{
+		{renderBufferSize, true},
+		{maxPooledBuffer, true},
+		{maxPooledBuffer + 1, false},
+	}
+	for _, tt := range tests {
+		buf := bytes.NewBuffer(make([]byte, 0, tt.size))
+		buf.WriteString("leftover")
+		putBuffer(buf)
+		if got := buf.Len() == 0; got != tt.kept {
+			t.Errorf("cap %d: reset and pooled = %v, want %v", tt.size, got, tt.kept)
+		}
+	}
+}
+
+func TestDevTemplateError(t *testing.T) {
+	prevFS, prevDev := templateFS, devMode
+	t.Cleanup(func() { templateFS, devMode = prevFS, prevDev })
+	broken := fstest.MapFS{}
+	err := fs.WalkDir(templateFS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(templateFS, name)
+		broken[name] = &fstest.MapFile{Data: data}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := broken["privacy.html"]
+	broken["privacy.html"] = &fstest.MapFile{Data: []byte("{{ define \"content\" }}\n<p>fine</p>\n<p>{{ if }}</p>\n{{ end }}\n")}
+	templateFS = broken
+
+	tests := []struct {
+		dev  bool
+		want []string
+	}{
+		{true, []string{"Template error", "privacy.html", "line 3", "missing value for if", "&lt;p&gt;{{ if }}&lt;/p&gt;", "&lt;p&gt;fine&lt;/p&gt;"}},
+		// Production renders from the templates parsed at startup.
+		{false, []string{"Privacy"}},
+	}
+	for _, tt := range tests {
+		devMode = tt.dev
+		w := get("/privacy")
+		body := w.Body.String()
+		if tt.dev && w.Code != 500 {
+			t.Errorf("dev: status %d, want 500", w.Code)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(body, s) {
+				t.Errorf("dev=%v: page lacks %q:\n%s", tt.dev, s, body)
+			}
+		}
+	}
+	// Fixing the template is enough; there is nothing to restart.
+	devMode = true
+	broken["privacy.html"] = fixed
+	if w := get("/privacy"); w.Code != 200 {
+		t.Errorf("after the fix: status %d", w.Code)
+	}
+}
+
+func TestRenderSemaphore(t *testing.T) {
+	prevWait := renderWait
+	t.Cleanup(func() { setMaxRenders(testConfig.MaxRenders); renderWait = prevWait })
+	useItems(t, testItem(1, "Alpha"))
+
+	for _, limit := range []int{1, 3} {
+		setMaxRenders(limit)
+		renderWait = 5 * time.Second
+		var active, peak atomic.Int32
+		var wg sync.WaitGroup
+		for range 4 * limit {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !acquireRender() {
+					t.Error("acquireRender timed out")
+					return
+				}
+				n := active.Add(1)
+				for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+				}
+				time.Sleep(5 * time.Millisecond)
+				active.Add(-1)
+				releaseRender()
+			}()
+		}
+		wg.Wait()
+		if got := peak.Load(); got != int32(limit) {
+			t.Errorf("limit %d: %d renders at once", limit, got)
+		}
+	}
+
+	// With every slot taken, a page waits renderWait and then gets 503.
+	setMaxRenders(1)
+	renderWait = 10 * time.Millisecond
+	acquireRender()
+	busy := renderBusyCnt.Load()
+	w := get("/privacy")
+	releaseRender()
+	if w.Code != 503 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("all slots busy: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if renderBusyCnt.Load() != busy+1 {
+		t.Errorf("render_slot_rejections_total not incremented")
+	}
+	if w := get("/privacy"); w.Code != 200 {
+		t.Errorf("slot free again: status %d", w.Code)
+	}
+}
+
+// blockedWriter is a client that stops reading: Write blocks until
+// release is closed.
+type blockedWriter struct {
+	httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (b *blockedWriter) Write(p []byte) (int, error) {
+	close(b.writing)
+	<-b.release
+	return b.ResponseRecorder.Write(p)
+}
+
+func TestRenderSlotFreeWhileWriting(t *testing.T) {
+	prevWait := renderWait
+	t.Cleanup(func() { setMaxRenders(testConfig.MaxRenders); renderWait = prevWait })
+	useItems(t, testItem(1, "Alpha"))
+	setMaxRenders(1)
+	renderWait = 50 * time.Millisecond
+
+	slow := &blockedWriter{ResponseRecorder: *httptest.NewRecorder(), writing: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error)
+	go func() { done <- render(slow, "privacy.html", map[string]interface{}{}) }()
+	<-slow.writing
+	if w := get("/privacy"); w.Code != 200 {
+		t.Errorf("render while a slow client is written to: status %d", w.Code)
+	}
+	close(slow.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
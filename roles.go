@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// Roles, from least to most privileged. Each role includes the ones
+// before it: viewers can read the admin area, editors can change content
+// and moderate, and admins can also manage users and export subscribers.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// hasRole reports whether role grants need. An empty role grants nothing.
+func hasRole(role, need string) bool {
+	have := slices.Index(roles, role)
+	return have >= 0 && have >= slices.Index(roles, need)
+}
+
+// basicAdmin reports whether r carries the configured admin credentials.
+// They act as a break-glass admin account, e.g. to assign the first roles.
+func basicAdmin(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	return ok && adminPassword != "" &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(adminUser)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(adminPassword)) == 1
+}
+
+// requireRole guards h so only the basic-auth admin or signed-in users
+// holding need get through. Signed-in users without it get a 403; anyone
+// else is asked for basic auth when a password is configured, or sent to
+// the login page. It decides on the headers alone: the body isn't read,
+// so Go only sends 100 Continue to clients that get through.
+func requireRole(need string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if basicAdmin(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if u, ok := currentUser(r); ok {
+			if !hasRole(u.Role, need) {
+				http.Error(w, "Forbidden: requires the "+need+" role", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		if adminPassword == "" && r.Method == http.MethodGet {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="BlendingWaves admin", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	us, err := users()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	all, err := us.Users()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	self, _ := currentUser(r)
+	data := map[string]interface{}{
+		"Title": "Users | Admin",
+		"Users": all,
+		"Roles": roles,
+		"Self":  self.ID,
+		"CSRF":  csrfToken(w, r),
+	}
+	if err := render(w, "admin_users.html", data); err != nil {
+		renderError(w, err)
+	}
+}
+
+// adminRoleHandler sets a user's role; an empty role revokes admin
+// access. Admins can't change their own role, so the last one can't lock
+// everybody out.
+func adminRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	role := r.PostFormValue("role")
+	if role != "" && !slices.Contains(roles, role) {
+		http.Error(w, "unknown role "+strconv.Quote(role), http.StatusBadRequest)
+		return
+	}
+	if self, ok := currentUser(r); ok && self.ID == id {
+		http.Error(w, "you can't change your own role", http.StatusForbidden)
+		return
+	}
+	us, err := users()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	u, err := us.GetUser(id)
+	if errors.Is(err, ErrNotFound) {
+		notFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	before := map[string]string{"role": u.Role}
+	u.Role = role
+	if err := us.PutUser(&u); err != nil {
+		serverError(w, err)
+		return
+	}
+	audit(r, "user.role", u.Email, before, map[string]string{"role": role})
+	log.Printf("admin: user %d role set to %q", id, role)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// mux routes the site. It is kept off http.DefaultServeMux, where
+// imported packages such as net/http/pprof register handlers of their
+// own.
+var mux = http.NewServeMux()
+
+// routes records every pattern registered through handle, in order.
+var routes []string
+
+// deprecatedRoutes maps a route pattern to its Sunset header value, empty
+// when no sunset date was given.
+var deprecatedRoutes = map[string]string{}
+
+// setDeprecated parses pattern[=YYYY-MM-DD] entries into deprecatedRoutes.
+// It runs once the routes are registered, so a pattern that names none of
+// them is an error rather than a setting that never takes effect.
+func setDeprecated(entries []string) error {
+	for _, e := range entries {
+		pattern, date, _ := strings.Cut(e, "=")
+		if !slices.Contains(routes, pattern) {
+			return fmt.Errorf("deprecate-route %s: no route is registered with that pattern", pattern)
+		}
+		sunset := ""
+		if date != "" {
+			t, err := time.Parse(time.DateOnly, date)
+			if err != nil {
+				return fmt.Errorf("deprecate-route %s: %w", pattern, err)
+			}
+			sunset = t.UTC().Format(http.TimeFormat)
+		}
+		deprecatedRoutes[pattern] = sunset
+	}
+	return nil
+}
+
+// deprecate wraps h so responses advertise the route's deprecation once
+// setDeprecated has marked it.
+func deprecate(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sunset, ok := deprecatedRoutes[pattern]; ok {
+			log.Printf("deprecated route %s hit: %s %s", pattern, r.Method, r.URL.Path)
+			w.Header().Set("Deprecation", "true")
+			if sunset != "" {
+				w.Header().Set("Sunset", sunset)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handle registers h on the default mux and records the pattern. API
+// routes also answer with a trailing slash, without redirecting, so clients
+// needn't care which form they use; HTML routes keep the mux's canonical
+// redirect behaviour.
+func handle(pattern string, h http.Handler) {
+	h = deprecate(pattern, h)
+	h = routeSpan(pattern, h)
+	mux.Handle(pattern, h)
+	path := pattern
+	if _, p, ok := strings.Cut(pattern, " "); ok {
+		path = p // "GET /api/..."
+	}
+	if strings.HasPrefix(path, "/api/") && !strings.HasSuffix(path, "/") {
+		mux.Handle(pattern+"/{$}", h)
+	}
+	routes = append(routes, pattern)
+}
+
+// handleFunc is handle for plain functions.
+func handleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	handle(pattern, http.HandlerFunc(h))
+}
+
+// features returns the capabilities actually wired up, derived from the
+// resolved settings and the registered routes.
+func features(cfg Config) []string {
+	var fs []string
+	if tlsEnabled(cfg) {
+		fs = append(fs, "tls")
+		if slices.ContainsFunc(cfg.listenAddrs(), func(a listenAddr) bool { return a.http3 }) {
+			fs = append(fs, "http3")
+		}
+	}
+	if cfg.Dev {
+		fs = append(fs, "dev")
+	}
+	if cfg.Maintenance {
+		fs = append(fs, "maintenance")
+	}
+	if gateOn() {
+		fs = append(fs, "gate")
+	}
+	if cfg.Compress {
+		fs = append(fs, "compress")
+	}
+	if cors != nil {
+		fs = append(fs, "cors")
+	}
+	if cfg.DebugAddr != "" {
+		fs = append(fs, "debug-listener")
+	}
+	if cfg.OTLPEndpoint != "" {
+		fs = append(fs, "tracing")
+	}
+	if canonical.on {
+		fs = append(fs, "canonical:"+canonical.host)
+	}
+	if cfg.Analytics {
+		fs = append(fs, "analytics")
+	}
+	if len(experiments) > 0 {
+		fs = append(fs, fmt.Sprintf("experiments:%d", len(experiments)))
+	}
+	if content.src != nil {
+		fs = append(fs, "content:"+content.src.Name())
+	}
+	if len(webhooks.hooks) > 0 {
+		fs = append(fs, "webhooks")
+	}
+	if len(locales) > 1 {
+		fs = append(fs, "i18n:"+strings.Join(locales, ","))
+	}
+	if cfg.HLSDir != "" {
+		fs = append(fs, "hls")
+	}
+	if cfg.MediaStore != "local" {
+		fs = append(fs, "media:"+cfg.MediaStore)
+	}
+	if cfg.ThumbDir != "" {
+		fs = append(fs, "thumbnails")
+	}
+	for _, c := range oauthClients {
+		fs = append(fs, "oauth:"+c.Name)
+	}
+	if len(botAgents) > 0 {
+		fs = append(fs, "bot-lite")
+	}
+	for _, p := range routes {
+		if p == "/feed.xml" {
+			fs = append(fs, "feeds")
+		}
+		if _, ok := deprecatedRoutes[p]; ok {
+			fs = append(fs, "route:"+p+"(deprecated)")
+			continue
+		}
+		fs = append(fs, "route:"+p)
+	}
+	return fs
+}
+
+// adminFeaturesHandler lists the features summary as JSON. It is computed
+// per request from the route registry, so it matches what is served.
+func adminFeaturesHandler(cfg Config) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string][]string{"features": features(cfg)})
+	}
+}
+
+// logFeatures prints a one-line, space-separated feature summary.
+func logFeatures(cfg Config) {
+	log.Printf("features: %s", strings.Join(features(cfg), " "))
+}
+
+// baseURL is the configured public origin, without a trailing slash.
+// Empty means "derive from the request".
+var baseURL string
+
+// siteURL returns the absolute URL of path, using baseURL when set and
+// the request's host otherwise.
+func siteURL(r *http.Request, path string) string {
+	if baseURL != "" {
+		return baseURL + path
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
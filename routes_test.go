@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAdminFeatures(t *testing.T) {
+	withAdmin(t)
+	if w := get("/admin/features"); w.Code != 401 {
+		t.Fatalf("anonymous: status %d, want 401", w.Code)
+	}
+	w := do(adminRequest("GET", "/admin/features", nil))
+	if w.Code != 200 {
+		t.Fatalf("status %d", w.Code)
+	}
+	var got struct{ Features []string }
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		feature string
+		want    bool
+	}{
+		{"route:/api/items", true},
+		{"route:GET /admin/features", true},
+		{"feeds", true},
+		{"bot-lite", true},
+		// Off in the test configuration, so never registered.
+		{"route:GET /download/{id}/{index}", false},
+		{"route:GET /graphiql", false},
+		{"route:/hls/{key}/{file}", false},
+		{"route:POST /hooks/git", false},
+		{"dev", false},
+		{"tls", false},
+		{"hls", false},
+	}
+	for _, tt := range tests {
+		if has := slices.Contains(got.Features, tt.feature); has != tt.want {
+			t.Errorf("features has %q = %v, want %v", tt.feature, has, tt.want)
+		}
+	}
+}
+
+func TestAPITrailingSlash(t *testing.T) {
+	alpha := testItem(1, "Alpha")
+	alpha.ItemLink = "https://example.com/alpha"
+	useItems(t, alpha)
+	paths := []string{
+		"/api/items",
+		"/api/items/1",
+		"/api/items/random",
+		"/api/items/1/comments",
+		"/api/resolve?link=https://example.com/alpha",
+		"/api/search/suggest?q=al",
+		"/api/openapi.json",
+	}
+	for _, p := range paths {
+		path, query, _ := strings.Cut(p, "?")
+		bare, slashed := get(p), get(path+"/?"+query)
+		if bare.Code != slashed.Code || bare.Body.String() != slashed.Body.String() {
+			t.Errorf("%s: %d %q; with a trailing slash %d %q", p, bare.Code, truncate(bare.Body.String(), 60), slashed.Code, truncate(slashed.Body.String(), 60))
+		}
+		if slashed.Code/100 == 3 {
+			t.Errorf("%s/: redirected to %s", path, slashed.Header().Get("Location"))
+		}
+	}
+}
+
+func TestDeprecatedRoute(t *testing.T) {
+	prevMux, prevRoutes, prevDeprecated := mux, routes, deprecatedRoutes
+	mux, routes, deprecatedRoutes = http.NewServeMux(), nil, map[string]string{}
+	t.Cleanup(func() { mux, routes, deprecatedRoutes = prevMux, prevRoutes, prevDeprecated })
+	var logs bytes.Buffer
+	prevLog := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prevLog) })
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	handleFunc("GET /api/old", ok)
+	handleFunc("/legacy", ok)
+	handleFunc("GET /api/new", ok)
+	if err := setDeprecated([]string{"GET /api/old=2027-01-31", "/legacy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path        string
+		deprecation string
+		sunset      string
+	}{
+		{"/api/old", "true", "Sun, 31 Jan 2027 00:00:00 GMT"},
+		{"/api/old/", "true", "Sun, 31 Jan 2027 00:00:00 GMT"},
+		{"/legacy", "true", ""},
+		{"/api/new", "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != 200 || w.Body.String() != "ok" {
+			t.Errorf("%s: %d %q", tt.path, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Deprecation"); got != tt.deprecation {
+			t.Errorf("%s: Deprecation %q, want %q", tt.path, got, tt.deprecation)
+		}
+		if got := w.Header().Get("Sunset"); got != tt.sunset {
+			t.Errorf("%s: Sunset %q, want %q", tt.path, got, tt.sunset)
+		}
+	}
+	if !strings.Contains(logs.String(), "deprecated route GET /api/old hit: GET /api/old") {
+		t.Errorf("no log of the deprecated hit; logged %q", logs.String())
+	}
+
+	if err := setDeprecated([]string{"/legacy=31/01/2027"}); err == nil {
+		t.Error("setDeprecated accepted a malformed sunset date")
+	}
+	for _, typo := range []string{"/api/old", "GET /api/olds", "POST /api/old=2027-01-31"} {
+		if err := setDeprecated([]string{typo}); err == nil || !strings.Contains(err.Error(), "no route") {
+			t.Errorf("setDeprecated(%q) = %v, want an unknown route error", typo, err)
+		}
+	}
+}
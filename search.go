@@ -0,0 +1,247 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Field boosts for search scoring: a title or tag hit counts for more
+// than a hit in the body text or the credits.
+const (
+	titleBoost  = 3.0
+	textBoost   = 1.0
+	creditBoost = 0.5
+	prefixBoost = 0.5 // partial words rank below whole ones
+)
+
+// searchDoc is what gets indexed for each item.
+type searchDoc struct {
+	Title   string   `json:"title"`
+	Tags    []string `json:"tags"`
+	Texts   []string `json:"texts"`
+	Credits []string `json:"credits"`
+}
+
+// searchIndex is an in-memory bleve index over one catalog snapshot,
+// keyed by the item's position in catalog.items. Each reload builds a
+// fresh one in the background, and the catalog only hands it out once
+// it is complete, so a search never sees a half-built index.
+type searchIndex struct {
+	idx bleve.Index
+}
+
+// searchMapping analyzes every field as English, so "videos" matches
+// "video" and stop words are ignored.
+func searchMapping() mapping.IndexMapping {
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = en.AnalyzerName
+	text.Store = false
+	doc := bleve.NewDocumentMapping()
+	for _, f := range []string{"title", "tags", "texts", "credits"} {
+		doc.AddFieldMappingsAt(f, text)
+	}
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	m.DefaultAnalyzer = en.AnalyzerName
+	return m
+}
+
+func buildSearchIndex(items []Item) *searchIndex {
+	idx, err := bleve.NewMemOnly(searchMapping())
+	if err != nil {
+		log.Printf("search: %v", err)
+		return &searchIndex{}
+	}
+	batch := idx.NewBatch()
+	for i := range items {
+		it := &items[i]
+		batch.Index(strconv.Itoa(i), searchDoc{Title: it.KeywordTitle, Tags: it.Tags, Texts: []string{plainTexts(it.Texts)}, Credits: it.VideoCredit})
+	}
+	if err := idx.Batch(batch); err != nil {
+		log.Printf("search: index: %v", err)
+	}
+	return &searchIndex{idx: idx}
+}
+
+// parseSearch turns q into a bleve query: "quoted text" is a phrase, a
+// trailing * asks for a prefix, and every other word also matches as a
+// prefix at a lower score. Items matching more of the parts score higher.
+func parseSearch(q string) query.Query {
+	var parts []query.Query
+	field := func(mk func(field string) query.Query, boosts map[string]float64) query.Query {
+		var qs []query.Query
+		for f, b := range boosts {
+			fq := mk(f)
+			fq.(query.BoostableQuery).SetBoost(b)
+			qs = append(qs, fq)
+		}
+		return bleve.NewDisjunctionQuery(qs...)
+	}
+	all := map[string]float64{"title": titleBoost, "tags": titleBoost, "texts": textBoost, "credits": creditBoost}
+	for i, chunk := range strings.Split(q, `"`) {
+		if i%2 == 1 {
+			if chunk = strings.TrimSpace(chunk); chunk != "" {
+				parts = append(parts, field(func(f string) query.Query {
+					p := bleve.NewMatchPhraseQuery(chunk)
+					p.SetField(f)
+					return p
+				}, all))
+			}
+			continue
+		}
+		for _, word := range strings.Fields(strings.ToLower(chunk)) {
+			prefix, explicit := strings.CutSuffix(word, "*")
+			prefix = strings.TrimFunc(prefix, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+			if prefix == "" {
+				continue
+			}
+			var alts []query.Query
+			if !explicit {
+				alts = append(alts, field(func(f string) query.Query {
+					m := bleve.NewMatchQuery(prefix)
+					m.SetField(f)
+					return m
+				}, all))
+			}
+			if explicit || len([]rune(prefix)) >= 3 {
+				boost := prefixBoost
+				if explicit {
+					boost = 1
+				}
+				alts = append(alts, field(func(f string) query.Query {
+					p := bleve.NewPrefixQuery(prefix)
+					p.SetField(f)
+					return p
+				}, map[string]float64{"title": titleBoost * boost, "tags": titleBoost * boost, "texts": textBoost * boost}))
+			}
+			parts = append(parts, bleve.NewDisjunctionQuery(alts...))
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return bleve.NewDisjunctionQuery(parts...)
+}
+
+// SearchResult is one ranked hit.
+type SearchResult struct {
+	Item  Item    `json:"item"`
+	Score float64 `json:"score"`
+}
+
+// search ranks live items in c against q by relevance, best first. While
+// the catalog's index is being built it falls back to scan.
+func (c *catalog) search(q string, now time.Time) []SearchResult {
+	bq := parseSearch(q)
+	if bq == nil {
+		return nil
+	}
+	index := c.searchIndex()
+	if index == nil {
+		return c.scan(q, now)
+	}
+	if index.idx == nil {
+		return nil
+	}
+	req := bleve.NewSearchRequestOptions(bq, len(c.items), 0, false)
+	res, err := index.idx.Search(req)
+	if err != nil {
+		log.Printf("search %q: %v", q, err)
+		return nil
+	}
+	results := make([]SearchResult, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		i, err := strconv.Atoi(h.ID)
+		if err != nil || i >= len(c.items) || !c.items[i].visible(now) {
+			continue
+		}
+		results = append(results, SearchResult{Item: c.items[i], Score: math.Round(h.Score*1000) / 1000})
+	}
+	return results
+}
+
+// scan ranks live items in c against q by reading every one, for the
+// moments after a reload when the index isn't built yet. It matches
+// parseSearch's terms as plain substrings, without stemming, and scores
+// each hit by its field's boost.
+func (c *catalog) scan(q string, now time.Time) []SearchResult {
+	var terms []string
+	for i, chunk := range strings.Split(strings.ToLower(q), `"`) {
+		if i%2 == 1 {
+			if chunk = strings.Join(strings.Fields(chunk), " "); chunk != "" {
+				terms = append(terms, chunk)
+			}
+			continue
+		}
+		for _, word := range strings.Fields(chunk) {
+			word = strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+			if word != "" {
+				terms = append(terms, word)
+			}
+		}
+	}
+	var results []SearchResult
+	for i := range c.items {
+		it := &c.items[i]
+		if !it.visible(now) {
+			continue
+		}
+		fields := []struct {
+			text  string
+			boost float64
+		}{
+			{it.KeywordTitle, titleBoost},
+			{strings.Join(it.Tags, " "), titleBoost},
+			{plainTexts(it.Texts), textBoost},
+			{strings.Join(it.VideoCredit, " "), creditBoost},
+		}
+		score := 0.0
+		for _, t := range terms {
+			for _, f := range fields {
+				if strings.Contains(strings.ToLower(f.text), t) {
+					score += f.boost
+				}
+			}
+		}
+		if score > 0 {
+			results = append(results, SearchResult{Item: *it, Score: score})
+		}
+	}
+	slices.SortStableFunc(results, func(a, b SearchResult) int { return cmp.Compare(b.Score, a.Score) })
+	return results
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	results := current().search(q, time.Now())
+	if len(results) == 0 {
+		countSearchMiss(r, q)
+	}
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"query":   q,
+			"results": results,
+		})
+		return
+	}
+	data := map[string]interface{}{
+		"Title":   "Search | BlendingWaves",
+		"Query":   q,
+		"Results": results,
+	}
+	if err := render(w, "search.html", data); err != nil {
+		renderError(w, err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSearchWhileIndexing(t *testing.T) {
+	trading := testItem(1, "Quant Trading")
+	trading.Tags = []string{"Finance"}
+	agents := testItem(2, "AI Agents")
+	agents.Texts = []string{"Agents that trade on **quant** signals."}
+	draft := testItem(3, "Quant Draft")
+	draft.Status = StatusDraft
+	useItems(t, trading, agents, draft, testItem(4, "Ecommerce"))
+	warm := current()
+
+	// A copy of the snapshot whose index is still being built.
+	cold := *warm
+	cold.index, cold.indexed = nil, make(chan struct{})
+	snapshot.Store(&cold)
+
+	ids := func(results []SearchResult) []int {
+		var ids []int
+		for _, r := range results {
+			ids = append(ids, r.Item.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	now := time.Now()
+	for _, q := range []string{"quant", "finance", "agents", `"quant trading"`, "ecommerce", "nothing"} {
+		want, got := ids(warm.search(q, now)), ids(cold.search(q, now))
+		if !slices.Equal(got, want) {
+			t.Errorf("search %q while indexing = %v, want %v", q, got, want)
+		}
+	}
+	if got := cold.search("quant", now); len(got) == 0 || got[0].Item.ID != 1 {
+		t.Errorf("search quant while indexing = %+v, want the title hit first", got)
+	}
+
+	var body struct{ Results []SearchResult }
+	w := get("/search?q=quant", "Accept", "application/json")
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(body.Results); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("GET /search while indexing = %v, want [1 2]", got)
+	}
+
+	// Once the index is ready, searches use it.
+	cold.index = warm.index
+	close(cold.indexed)
+	same := func(a, b SearchResult) bool { return a.Item.ID == b.Item.ID && a.Score == b.Score }
+	if got, want := cold.search("quant", now), warm.search("quant", now); !slices.EqualFunc(got, want, same) {
+		t.Errorf("search after indexing = %+v, want the index's %+v", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// securityConfig is the header set emitted by securityHeaders.
+type securityConfig struct {
+	csp         string // policy with {nonce} placeholders; empty for none
+	cspHeader   string
+	hsts        string
+	referrer    string
+	permissions string
+}
+
+var security securityConfig
+
+// defaultCSP builds a policy allowing this site, the fonts and scripts
+// the templates load, the captcha widget and the media store. Inline
+// scripts need the per-request nonce; inline style attributes are allowed.
+func defaultCSP(cfg Config) string {
+	scripts := []string{"'self'", "'nonce-{nonce}'", "https://cdnjs.cloudflare.com"}
+	frames := []string{"'self'"}
+	media := []string{"'self'", "blob:"}
+	connect := []string{"'self'"}
+	if captcha != nil {
+		if u, err := url.Parse(captcha.Script); err == nil {
+			host := u.Scheme + "://" + u.Host
+			scripts = append(scripts, host)
+			frames = append(frames, host)
+			connect = append(connect, host)
+		}
+	}
+	if cfg.MediaStore == "s3" {
+		if u, err := url.Parse(cfg.S3Endpoint); err == nil && u.Host != "" {
+			host := u.Scheme + "://" + u.Host
+			if cfg.S3Bucket == "" {
+				host = u.Scheme + "://*." + u.Host
+			}
+			media = append(media, u.Scheme+"://"+u.Host, host)
+		}
+	}
+	directives := []string{
+		"default-src 'self'",
+		"script-src " + strings.Join(scripts, " "),
+		"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com https://cdnjs.cloudflare.com",
+		"font-src 'self' https://fonts.gstatic.com",
+		"img-src 'self' data:",
+		"media-src " + strings.Join(media, " "),
+		"connect-src " + strings.Join(connect, " "),
+		"frame-src " + strings.Join(frames, " "),
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}
+	return strings.Join(directives, "; ")
+}
+
+// setSecurity configures the headers from cfg. A CSP of "off" disables
+// the policy; an empty one selects defaultCSP.
+func setSecurity(cfg Config) {
+	s := securityConfig{
+		cspHeader:   "Content-Security-Policy",
+		referrer:    cfg.ReferrerPolicy,
+		permissions: cfg.PermissionsPolicy,
+	}
+	switch cfg.CSP {
+	case "off":
+	case "":
+		s.csp = defaultCSP(cfg)
+	default:
+		s.csp = cfg.CSP
+	}
+	if s.csp != "" && cfg.CSPReportURI != "" {
+		s.csp += "; report-uri " + cfg.CSPReportURI
+	}
+	if cfg.CSPReportOnly {
+		s.cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	if cfg.HSTSMaxAge > 0 {
+		s.hsts = "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge/time.Second)) + "; includeSubDomains"
+	}
+	security = s
+}
+
+// nonceWriter carries the request's CSP nonce to render.
+type nonceWriter struct {
+	http.ResponseWriter
+	nonce string
+}
+
+func (n *nonceWriter) Unwrap() http.ResponseWriter { return n.ResponseWriter }
+
+func (n *nonceWriter) Flush() {
+	if f, ok := n.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// cspNonce returns the nonce securityHeaders issued for the response w,
+// or "" when there is none.
+func cspNonce(w http.ResponseWriter) string {
+	if n, ok := findWriter[*nonceWriter](w); ok {
+		return n.nonce
+	}
+	return ""
+}
+
+// securityHeaders sets the configured security headers on every
+// response. HSTS is only sent over HTTPS, as browsers ignore it otherwise.
+func securityHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		hdr.Set("X-Content-Type-Options", "nosniff")
+		if security.referrer != "" {
+			hdr.Set("Referrer-Policy", security.referrer)
+		}
+		if security.permissions != "" {
+			hdr.Set("Permissions-Policy", security.permissions)
+		}
+		if security.hsts != "" && isHTTPS(r) {
+			hdr.Set("Strict-Transport-Security", security.hsts)
+		}
+		if security.csp != "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			nonce := base64.StdEncoding.EncodeToString(b)
+			hdr.Set(security.cspHeader, strings.ReplaceAll(security.csp, "{nonce}", nonce))
+			w = &nonceWriter{w, nonce}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isHTTPS reports whether the client reached us over TLS, directly or
+// through a trusted proxy.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || isTrustedProxy(remoteIP(r)) && r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// privateStatic lists the prefixes of static paths that must not be
+// served: data/, where the items file, the embedded copy included, and
+// its sidecars of users, subscribers and the like live by default, and
+// wherever -data-path puts them when that is elsewhere under -static-dir.
+func privateStatic(cfg Config) []string {
+	private := []string{"data/"}
+	rel, err := filepath.Rel(cfg.StaticDir, cfg.DataPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return private
+	}
+	rel = strings.ToLower(filepath.ToSlash(rel))
+	// Beside the public files, hide items.json and its items.*.json.
+	p := strings.TrimSuffix(rel, path.Ext(rel)) + "."
+	if dir := path.Dir(rel); dir != "." {
+		p = dir + "/"
+	}
+	if !slices.Contains(private, p) {
+		private = append(private, p)
+	}
+	return private
+}
+
+// hiddenStatic is privateStatic of the running configuration.
+var hiddenStatic []string
+
+// isHiddenStatic reports whether rel, a path under static/, is one of
+// hiddenStatic's. Case is ignored so a case-insensitive filesystem can't
+// be asked for DATA/items.json instead.
+func isHiddenStatic(rel string) bool {
+	rel = strings.ToLower(strings.TrimPrefix(path.Clean("/"+rel), "/"))
+	for _, p := range hiddenStatic {
+		if strings.HasPrefix(rel+"/", p) {
+			return true
+		}
+	}
+	return false
+}
+
+// hideStatic answers 404 for the hidden static paths, and for the
+// catalog's videos while they are protected.
+func hideStatic(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rel := strings.TrimPrefix(r.URL.Path, "/static/")
+		if isHiddenStatic(rel) || protectedVideoFile(strings.TrimPrefix(path.Clean("/"+rel), "/")) {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStaticDataHidden(t *testing.T) {
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/static/data/items.json", 404},
+		{"/static/data/items.users.json", 404},
+		{"/static/data/items.subscribers.json", 404},
+		{"/static/data/images_credit.json", 404},
+		{"/static/data/", 404},
+		{"/static/data", 404},
+		{"/static/DATA/items.json", 404},
+		{"/static/Data/Items.Users.JSON", 404},
+		{"/static/images/logo.png", 200},
+	}
+	for _, tt := range tests {
+		if w := get(tt.path); w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+
+	useItems(t, testItem(1, "Alpha"))
+	dir := t.TempDir()
+	if err := exportSite(dir, testSite); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "static", "data")); !os.IsNotExist(err) {
+		t.Errorf("the export has static/data: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "static", "images", "logo.png")); err != nil {
+		t.Errorf("the export lacks the public images: %v", err)
+	}
+}
+
+func TestPrivateStatic(t *testing.T) {
+	tests := []struct {
+		static, data string
+		want         []string
+	}{
+		{"static", "static/data/items.json", []string{"data/"}},
+		{"static", "static/private/Catalog.json", []string{"data/", "private/"}},
+		{"static", "static/catalog.json", []string{"data/", "catalog."}},
+		{"static", "/var/lib/blendingwaves/items.json", []string{"data/"}},
+		{"static", "items.json", []string{"data/"}},
+	}
+	for _, tt := range tests {
+		cfg := testConfig
+		cfg.StaticDir, cfg.DataPath = tt.static, tt.data
+		if got := privateStatic(cfg); !slices.Equal(got, tt.want) {
+			t.Errorf("privateStatic(%s, %s) = %q, want %q", tt.static, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestCookiesSecureBehindProxy(t *testing.T) {
+	prev, prevClients := trustedProxies, oauthClients
+	t.Cleanup(func() { trustedProxies, oauthClients = prev, prevClients })
+	oauthClients = []*OAuthClient{{Name: "github", id: "id", secret: "secret"}}
+	if err := setTrustedProxies("192.0.2.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	setters := map[string]func(http.ResponseWriter, *http.Request){
+		"session":     func(w http.ResponseWriter, r *http.Request) { startSession(w, r, User{ID: 1}) },
+		"session end": endSession,
+		"media pass":  issueMediaPass,
+		"visitor":     func(w http.ResponseWriter, r *http.Request) { visitorID(w, r, true) },
+		"experiments": func(w http.ResponseWriter, r *http.Request) { setExperimentMarks(w, r, []string{"x"}) },
+		"oauth state": func(w http.ResponseWriter, r *http.Request) {
+			r.SetPathValue("provider", "github")
+			oauthStartHandler(w, r)
+		},
+	}
+	tests := []struct {
+		name   string
+		remote string
+		proto  string
+		secure bool
+	}{
+		{"trusted proxy over https", "192.0.2.7:4000", "https", true},
+		{"trusted proxy over http", "192.0.2.7:4000", "http", false},
+		{"untrusted client claiming https", "203.0.113.9:4000", "https", false},
+	}
+	for name, set := range setters {
+		for _, tt := range tests {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+			w := httptest.NewRecorder()
+			set(w, r)
+			cookies := w.Result().Cookies()
+			if len(cookies) == 0 {
+				t.Errorf("%s, %s: no cookie set", name, tt.name)
+				continue
+			}
+			if cookies[0].Secure != tt.secure {
+				t.Errorf("%s, %s: Secure = %v, want %v", name, tt.name, cookies[0].Secure, tt.secure)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newServer builds the http.Server with the configured timeouts.
+func newServer(cfg Config, h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// boundServer is a server together with the listener it serves: an
+// HTTP/1 and 2 server on a stream listener, or an HTTP/3 server on a UDP
+// socket.
+type boundServer struct {
+	srv *http.Server
+	ln  net.Listener
+	h3  *http3.Server
+	pc  net.PacketConn
+}
+
+func (b boundServer) serve() error {
+	if b.h3 != nil {
+		return b.h3.Serve(b.pc)
+	}
+	return b.srv.Serve(b.ln)
+}
+
+func (b boundServer) shutdown(ctx context.Context) error {
+	if b.h3 != nil {
+		return b.h3.Shutdown(ctx)
+	}
+	return b.srv.Shutdown(ctx)
+}
+
+// serve runs every server until one fails or SIGINT/SIGTERM arrives, then
+// drains in-flight requests on all of them for up to grace.
+func serve(grace time.Duration, servers ...boundServer) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, len(servers))
+	for _, b := range servers {
+		go func() { errc <- b.serve() }()
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errc:
+	case <-ctx.Done():
+		log.Printf("Shutting down, draining requests for up to %s …", grace)
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	for _, b := range servers {
+		if err := b.shutdown(shutdownCtx); err != nil && serveErr == nil {
+			serveErr = err
+		}
+	}
+	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	log.Println("Server stopped")
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KindShare counts visits through short links, keyed by the item's slug
+// and where the link was followed from.
+const KindShare = "share"
+
+// shortCodeLen is the length of derived short codes. Six base-36 digits
+// cover every ID below 2^31.
+const shortCodeLen = 6
+
+// shortCodePattern is what an editor may set as an item's short code.
+var shortCodePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// shortCode derives the item's short code from its ID. The ID is
+// scrambled by a bijection of the 31-bit integers, so codes are stable
+// and distinct but don't count up visibly.
+func shortCode(id int) string {
+	const mask = 1<<31 - 1
+	n := (uint64(id)&mask ^ 0x2c1b3c6d) * 0x5bd1e995 & mask
+	s := strconv.FormatUint(n, 36)
+	return strings.Repeat("0", max(shortCodeLen-len(s), 0)) + s
+}
+
+// indexShortCodes fills in missing short codes and the short URLs, and
+// builds byShort. Codes set by editors are indexed first, so a derived
+// code can't shadow one; a clash gets the item ID appended.
+func (c *catalog) indexShortCodes() {
+	c.byShort = make(map[string]*Item, len(c.items))
+	for _, explicit := range []bool{true, false} {
+		for i := range c.items {
+			it := &c.items[i]
+			if (it.ShortCode != "") != explicit {
+				continue
+			}
+			if it.ShortCode == "" {
+				it.ShortCode = shortCode(it.ID)
+			}
+			if _, taken := c.byShort[it.ShortCode]; taken {
+				fixed := it.ShortCode + "-" + strconv.Itoa(it.ID)
+				log.Printf("warning: item %d short code %q is not unique; using %q", it.ID, it.ShortCode, fixed)
+				it.ShortCode = fixed
+			}
+			it.ShortURL = baseURL + "/s/" + it.ShortCode
+			c.byShort[it.ShortCode] = it
+		}
+	}
+}
+
+// checkShortCode describes what's wrong with an editor's short code.
+func checkShortCode(code string) string {
+	if code == "" || shortCodePattern.MatchString(code) {
+		return ""
+	}
+	return fmt.Sprintf("short_code %q must be up to 32 lowercase letters, digits and hyphens", code)
+}
+
+// shortLinkHandler redirects /s/{code} to the item's page, counting the
+// visit by where it came from: ?via= for links tagged with the channel
+// they were shared on, or else the referring site. The query, but for
+// via, is passed on.
+func shortLinkHandler(w http.ResponseWriter, r *http.Request) {
+	c := current()
+	it, ok := c.byShort[strings.ToLower(r.PathValue("code"))]
+	if !ok || !it.visible(time.Now()) {
+		notFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	via := strings.ToLower(strings.TrimSpace(q.Get("via")))
+	q.Del("via")
+	if analyticsEnabled && countable(r) && !exporting {
+		src := cmp.Or(trafficSource(r), "(site)")
+		if via != "" {
+			src = "via:" + truncate(via, 60)
+		}
+		now := time.Now().UTC()
+		visitorHash(r, now) // starts a new day's counters if need be
+		collector.Lock()
+		collector.add(AnalyticsKey{now.Format(time.DateOnly), KindShare, it.Slug + " " + src})
+		collector.Unlock()
+	}
+	target := "/items/" + it.Slug
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Video   string       `xml:"xmlns:video,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string         `xml:"loc"`
+	LastMod string         `xml:"lastmod,omitempty"`
+	Videos  []sitemapVideo `xml:"video:video"`
+}
+
+type sitemapVideo struct {
+	Thumbnail   string `xml:"video:thumbnail_loc"`
+	Title       string `xml:"video:title"`
+	Description string `xml:"video:description"`
+	ContentLoc  string `xml:"video:content_loc,omitempty"`
+	PlayerLoc   string `xml:"video:player_loc,omitempty"` // the item page, when the file needs a media pass
+}
+
+// staticPages are the fixed public pages listed in the sitemap.
+var staticPages = []string{"/", "/privacy", "/tou", "/non", "/credits", "/collections"}
+
+// defaultThumbnail stands in for video thumbnails when -thumb-dir is off.
+const defaultThumbnail = "/static/images/logo.png"
+
+// modifiedAt is when the item last changed, as far as we know.
+func (c *catalog) modifiedAt(it *Item) time.Time {
+	if it.UpdatedAt != nil {
+		return *it.UpdatedAt
+	}
+	return c.publishedAt(it)
+}
+
+// sitemapMaxAge is how long the sitemap job's copy of the sitemap is
+// served; the job rebuilds it every hour by default.
+const sitemapMaxAge = time.Hour
+
+// sitemapCache is the sitemap the sitemap job last built for -base-url,
+// good for its catalog until expires.
+var sitemapCache struct {
+	sync.Mutex
+	catalog *catalog
+	expires time.Time
+	body    []byte
+}
+
+// sitemapHandler lists the public pages, item pages (with the Google
+// video extension for their videos), tag and collection pages, from the sitemap
+// job's copy when it is current.
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	c, now := current(), time.Now()
+	sitemapCache.Lock()
+	body := sitemapCache.body
+	if sitemapCache.catalog != c || !now.Before(sitemapCache.expires) || baseURL == "" {
+		body = nil
+	}
+	sitemapCache.Unlock()
+	if body == nil {
+		var err error
+		if body, err = buildSitemap(r, c, now); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(body)
+}
+
+// refreshSitemap is the sitemap job: it builds the sitemap ahead of the
+// crawlers asking for it. The copy lasts until an item in it goes live or
+// expires, or sitemapMaxAge. Without -base-url the sitemap names whatever
+// host it is asked on, so there's nothing to build ahead.
+func refreshSitemap(ctx context.Context) (string, error) {
+	if baseURL == "" {
+		return "skipped: -base-url is not set", nil
+	}
+	c, now := current(), time.Now()
+	body, err := buildSitemap(httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil), c, now)
+	if err != nil {
+		return "", err
+	}
+	expires := now.Add(sitemapMaxAge)
+	for _, it := range c.items {
+		for _, t := range []*time.Time{it.PublishAt, it.ExpireAt} {
+			if t != nil && t.After(now) && t.Before(expires) {
+				expires = *t
+			}
+		}
+	}
+	sitemapCache.Lock()
+	sitemapCache.catalog, sitemapCache.expires, sitemapCache.body = c, expires, body
+	sitemapCache.Unlock()
+	return fmt.Sprintf("built %d bytes", len(body)), nil
+}
+
+// buildSitemap renders the sitemap of c at now, with the URLs r is
+// answered with.
+func buildSitemap(r *http.Request, c *catalog, now time.Time) ([]byte, error) {
+	set := urlSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+		Video: "http://www.google.com/schemas/sitemap-video/1.1",
+	}
+	for _, p := range staticPages {
+		set.URLs = append(set.URLs, sitemapURL{Loc: siteURL(r, p)})
+	}
+	for _, it := range c.live(now) {
+		u := sitemapURL{
+			Loc:     siteURL(r, "/items/"+it.Slug),
+			LastMod: c.modifiedAt(&it).UTC().Format(time.DateOnly),
+		}
+		desc := it.KeywordTitle
+		if len(it.Texts) > 0 {
+			desc = markdownText(it.Texts[0])
+		}
+		for i := range it.VideoPath {
+			v := sitemapVideo{
+				Thumbnail:   siteURL(r, cmp.Or(posterFor(&it, i), defaultThumbnail)),
+				Title:       it.KeywordTitle,
+				Description: truncate(desc, 2048),
+				ContentLoc:  publicVideoURL(r, &it, i),
+			}
+			if v.ContentLoc == "" {
+				v.PlayerLoc = u.Loc
+			}
+			u.Videos = append(u.Videos, v)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	for _, t := range c.tagCloud(now) {
+		set.URLs = append(set.URLs, sitemapURL{Loc: siteURL(r, "/tags/"+t.Slug)})
+	}
+	for _, col := range allCollections() {
+		if len(col.items(c, now)) > 0 {
+			set.URLs = append(set.URLs, sitemapURL{Loc: siteURL(r, "/collections/"+col.Slug)})
+		}
+	}
+	out, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// robotsDisallowAll makes robots.txt turn every crawler away, for staging.
+var robotsDisallowAll bool
+
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if robotsDisallowAll {
+		b.WriteString("Disallow: /\n")
+	} else {
+		b.WriteString("Disallow: /admin\n")
+		b.WriteString("Disallow: /api/\n")
+		b.WriteString("Disallow: /preview/\n")
+		fmt.Fprintf(&b, "\nSitemap: %s\n", siteURL(r, "/sitemap.xml"))
+	}
+	w.Write([]byte(b.String()))
+}
+
+// truncate shortens s to at most n runes, ending with an ellipsis when cut.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
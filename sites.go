@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// siteSpec is one entry of the -sites file: a name for logs, the hosts
+// it answers for, and its config block, whose keys are the same as a
+// config file's. The default site also gets requests for unknown hosts.
+//
+//	[
+//	  {"name": "blendingwaves", "hosts": ["blendingwaves.com", "www.blendingwaves.com"],
+//	   "default": true, "config": {"data-path": "bw/items.json", "base-url": "https://blendingwaves.com"}},
+//	  {"name": "sister", "hosts": ["sister.example"],
+//	   "config": {"data-path": "sister/items.json", "template-dir": "sister/templates"}}
+//	]
+type siteSpec struct {
+	Name    string                     `json:"name"`
+	Hosts   []string                   `json:"hosts"`
+	Default bool                       `json:"default"`
+	Config  map[string]json.RawMessage `json:"config"`
+}
+
+// siteOnly lists settings a site block can't have: they belong to the
+// front server or are set for each site by it.
+var siteOnly = []string{"config", "sites", "port", "listen-host", "listen", "socket-mode"}
+
+// site is a running site: its own server process of this binary on a
+// loopback port, and the proxy that reaches it. cmd is nil while the
+// process is down.
+type site struct {
+	siteSpec
+	args  []string
+	port  string
+	proxy *httputil.ReverseProxy
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+}
+
+// serveSites runs every site in the -sites file and routes requests to
+// them by Host header. Each site is a separate server process running
+// with its own config block, since a site's items, templates and
+// settings are process-wide; a site that exits is restarted.
+func serveSites(cfg Config) error {
+	specs, err := readSites(cfg.Sites)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	byHost := map[string]*site{}
+	var fallback *site
+	var sites []*site
+	for _, spec := range specs {
+		s, err := newSite(spec)
+		if err != nil {
+			return err
+		}
+		for _, h := range s.Hosts {
+			byHost[strings.ToLower(h)] = s
+		}
+		if s.Default {
+			fallback = s
+		}
+		sites = append(sites, s)
+	}
+
+	stopping := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, s := range sites {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.supervise(exe, stopping)
+		}()
+	}
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		s := byHost[strings.ToLower(host)]
+		if s == nil {
+			s = fallback
+		}
+		if s == nil {
+			http.Error(w, "No site is served for "+host, http.StatusMisdirectedRequest)
+			return
+		}
+		s.proxy.ServeHTTP(w, r)
+	})
+	log.Printf("Serving %d sites by Host header", len(sites))
+	err = listen(cfg, router)
+
+	close(stopping)
+	for _, s := range sites {
+		s.signal(syscall.SIGTERM)
+	}
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(cfg.ShutdownGrace + 5*time.Second):
+		log.Printf("sites: still running after %s; killing them", cfg.ShutdownGrace)
+		for _, s := range sites {
+			s.signal(syscall.SIGKILL)
+		}
+		<-done
+	}
+	return err
+}
+
+// readSites reads and checks the -sites file.
+func readSites(path string) ([]siteSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sites: %w", err)
+	}
+	var specs []siteSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("sites %s: %w", path, err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("sites %s: no sites", path)
+	}
+	names, hosts := map[string]bool{}, map[string]string{}
+	defaults := 0
+	for i, s := range specs {
+		if s.Name == "" {
+			return nil, fmt.Errorf("sites %s: site #%d has no name", path, i+1)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("sites %s: two sites are named %q", path, s.Name)
+		}
+		names[s.Name] = true
+		if len(s.Hosts) == 0 && !s.Default {
+			return nil, fmt.Errorf("sites %s: site %q has no hosts", path, s.Name)
+		}
+		for _, h := range s.Hosts {
+			h = strings.ToLower(h)
+			if other, dup := hosts[h]; dup {
+				return nil, fmt.Errorf("sites %s: host %s is claimed by %q and %q", path, h, other, s.Name)
+			}
+			hosts[h] = s.Name
+		}
+		if s.Default {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return nil, fmt.Errorf("sites %s: only one site can be the default", path)
+	}
+	return specs, nil
+}
+
+// newSite turns spec's config block into flags for its process, checking
+// them the way a config file is checked, and picks its loopback port.
+func newSite(spec siteSpec) (*site, error) {
+	fs := flag.NewFlagSet(spec.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var probe Config
+	probe.bind(fs)
+	var args []string
+	for key, val := range spec.Config {
+		if fs.Lookup(key) == nil || slices.Contains(siteOnly, key) {
+			return nil, fmt.Errorf("site %q: config key %q can't be set per site", spec.Name, key)
+		}
+		var list []json.RawMessage
+		if json.Unmarshal(val, &list) != nil {
+			list = []json.RawMessage{val}
+		}
+		for _, v := range list {
+			arg := "-" + key + "=" + jsonScalar(v)
+			if err := fs.Parse([]string{arg}); err != nil {
+				return nil, fmt.Errorf("site %q: %s: %w", spec.Name, key, err)
+			}
+			args = append(args, arg)
+		}
+	}
+	if _, ok := spec.Config["trusted-proxies"]; !ok {
+		args = append(args, "-trusted-proxies=127.0.0.1/32")
+	}
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	target := &url.URL{Scheme: "http", Host: "127.0.0.1:" + port}
+	s := &site{siteSpec: spec, args: args, port: port}
+	s.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			r.Out.Host = r.In.Host
+		},
+		// Streams (events, video) must reach the client as they come.
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("site %s: %v", spec.Name, err)
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Site unavailable, try again shortly", http.StatusBadGateway)
+		},
+	}
+	return s, nil
+}
+
+// freePort asks the kernel for an unused loopback port.
+func freePort() (string, error) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	return port, err
+}
+
+// supervise runs the site's process until stopping closes, restarting it
+// with growing delays if it exits.
+func (s *site) supervise(exe string, stopping <-chan struct{}) {
+	backoff := time.Second
+	for {
+		args := append([]string{"serve", "-listen-host=127.0.0.1", "-port=" + s.port}, s.args...)
+		cmd := exec.Command(exe, args...)
+		out := prefixed(s.Name)
+		cmd.Stdout, cmd.Stderr = out, out
+		started := time.Now()
+		s.mu.Lock()
+		err := cmd.Start()
+		if err == nil {
+			s.cmd = cmd
+		}
+		s.mu.Unlock()
+		if err == nil {
+			log.Printf("site %s: started on 127.0.0.1:%s (pid %d)", s.Name, s.port, cmd.Process.Pid)
+			err = cmd.Wait()
+			s.mu.Lock()
+			s.cmd = nil
+			s.mu.Unlock()
+		}
+		out.Close()
+		select {
+		case <-stopping:
+			return
+		default:
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("site %s: exited (%v); restarting in %s", s.Name, err, backoff)
+		select {
+		case <-stopping:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// signal sends sig to the site's process if it is running.
+func (s *site) signal(sig os.Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd != nil {
+		if err := s.cmd.Process.Signal(sig); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			log.Printf("site %s: %v", s.Name, err)
+		}
+	}
+}
+
+// prefixed copies a site's output to stderr line by line, tagged with
+// its name so the sites' logs can be told apart.
+func prefixed(name string) io.WriteCloser {
+	r, w := io.Pipe()
+	go func() {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			fmt.Fprintf(os.Stderr, "%s | %s\n", name, sc.Text())
+		}
+		io.Copy(io.Discard, r)
+	}()
+	return w
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// SortOption is one choice of listing order offered to visitors.
+type SortOption struct {
+	Key   string
+	Label string
+}
+
+// sortOptions are the ?sort= values, after the editorial default.
+var sortOptions = []SortOption{
+	{"", "Featured"},
+	{"newest", "Newest"},
+	{"popular", "Popular"},
+	{"alpha", "A–Z"},
+}
+
+// validSort reports whether key is a known ?sort= value.
+func validSort(key string) bool {
+	return slices.ContainsFunc(sortOptions, func(o SortOption) bool { return o.Key == key })
+}
+
+// sortItems returns items in the given order, pinned items first in
+// every one and in ID order among themselves. "newest" uses CreatedAt, "popular" the view counts with
+// likes as a tie-breaker, and "alpha" the title; the empty order keeps
+// the catalog's editorial order.
+func (c *catalog) sortItems(items []Item, order string) []Item {
+	if order == "" {
+		return items
+	}
+	out := slices.Clone(items)
+	switch order {
+	case "newest":
+		slices.SortStableFunc(out, func(a, b Item) int {
+			return cmp.Or(pinnedFirst(a, b), c.publishedAt(&b).Compare(c.publishedAt(&a)), cmp.Compare(b.ID, a.ID))
+		})
+	case "popular":
+		pop := make(map[int]int64, len(out))
+		for _, it := range out {
+			v := viewsOf(it.ID)
+			pop[it.ID] = v.Page + v.Video
+		}
+		slices.SortStableFunc(out, func(a, b Item) int {
+			return cmp.Or(pinnedFirst(a, b), cmp.Compare(pop[b.ID], pop[a.ID]), cmp.Compare(likeCount(b.ID), likeCount(a.ID)), cmp.Compare(a.ID, b.ID))
+		})
+	case "alpha":
+		slices.SortStableFunc(out, func(a, b Item) int {
+			return cmp.Or(pinnedFirst(a, b), strings.Compare(strings.ToLower(a.KeywordTitle), strings.ToLower(b.KeywordTitle)), cmp.Compare(a.ID, b.ID))
+		})
+	}
+	return out
+}
+
+// pinnedFirst orders a pinned item before an unpinned one, and two
+// pinned items by ID, leaving only unpinned items to the sort.
+func pinnedFirst(a, b Item) int {
+	switch {
+	case a.Pinned && b.Pinned:
+		return cmp.Compare(a.ID, b.ID)
+	case a.Pinned == b.Pinned:
+		return 0
+	case a.Pinned:
+		return -1
+	}
+	return 1
+}
+
+// sortLinks returns the options with the URL of each, keeping the other
+// query parameters but going back to the first page.
+func sortLinks(r *http.Request) []map[string]any {
+	if exporting {
+		return nil
+	}
+	current := r.URL.Query().Get("sort")
+	links := make([]map[string]any, len(sortOptions))
+	for i, o := range sortOptions {
+		q := r.URL.Query()
+		q.Del("page")
+		q.Del("sort")
+		if o.Key != "" {
+			q.Set("sort", o.Key)
+		}
+		u := r.URL.Path
+		if len(q) > 0 {
+			u += "?" + q.Encode()
+		}
+		links[i] = map[string]any{"Label": o.Label, "URL": u, "Current": o.Key == current}
+	}
+	return links
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiIDs returns the IDs of the items a JSON array response lists.
+func apiIDs(t *testing.T, target string) []int {
+	t.Helper()
+	w := get(target)
+	if w.Code != 200 {
+		t.Fatalf("%s: status %d", target, w.Code)
+	}
+	var items []struct {
+		ID     int  `json:"id"`
+		Pinned bool `json:"pinned"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatalf("%s: %v", target, err)
+	}
+	if !strings.Contains(w.Body.String(), `"pinned":`) {
+		t.Errorf("%s: items lack the pinned flag", target)
+	}
+	ids := make([]int, len(items))
+	for i, it := range items {
+		ids[i] = it.ID
+	}
+	return ids
+}
+
+func TestPinnedOrder(t *testing.T) {
+	var items []Item
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for id := 1; id <= 6; id++ {
+		it := testItem(id, fmt.Sprintf("Project %d", id))
+		it.Tags = []string{"All"}
+		it.Pinned = id == 5 || id == 3
+		created := start.Add(time.Duration(id) * time.Hour)
+		it.CreatedAt = &created
+		items = append(items, it)
+	}
+	useItems(t, items...)
+	resetViews(t)
+	views.Lock()
+	for id, n := range map[int]int64{1: 50, 3: 5, 5: 20, 6: 30} {
+		views.totals[id] = Views{Page: n}
+	}
+	views.Unlock()
+
+	tests := []struct {
+		target string
+		want   []int
+	}{
+		{"/api/items", []int{3, 5, 1, 2, 4, 6}},
+		{"/api/items?per_page=2&page=1", []int{3, 5}},
+		{"/api/items?per_page=2&page=2", []int{1, 2}},
+		{"/api/items?per_page=3&page=1", []int{3, 5, 1}},
+		{"/api/items?per_page=4&page=2", []int{4, 6}},
+		// Every sort keeps the pins on top, in ID order, and sorts the rest.
+		{"/api/items?sort=newest", []int{3, 5, 6, 4, 2, 1}},
+		{"/api/items?sort=newest&per_page=3&page=1", []int{3, 5, 6}},
+		{"/api/items?sort=popular", []int{3, 5, 1, 6, 2, 4}},
+		{"/api/items?sort=popular&per_page=2&page=2", []int{1, 6}},
+		{"/api/items?sort=alpha", []int{3, 5, 1, 2, 4, 6}},
+	}
+	for _, tt := range tests {
+		if got := apiIDs(t, tt.target); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: %v, want %v", tt.target, got, tt.want)
+		}
+	}
+
+	// The HTML listings put the pins first too.
+	for _, page := range []string{"/", "/tags/all"} {
+		body := get(page).Body.String()
+		last := -1
+		for _, id := range []int{3, 5, 1, 2, 4, 6} {
+			at := strings.Index(body, fmt.Sprintf("Project %d<", id))
+			if at < 0 {
+				t.Errorf("%s: Project %d missing", page, id)
+				continue
+			}
+			if at < last {
+				t.Errorf("%s: Project %d out of order", page, id)
+			}
+			last = at
+		}
+	}
+}
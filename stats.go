@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// CatalogStats is the response body of /api/stats.
+type CatalogStats struct {
+	TotalItems       int       `json:"total_items"`
+	Published        int       `json:"published"`
+	Draft            int       `json:"draft"`
+	Scheduled        int       `json:"scheduled"` // set to publish at a time still to come
+	Archived         int       `json:"archived"`  // past their ExpireAt, whatever their status
+	DistinctKeywords int       `json:"distinct_keywords"`
+	DistinctCredits  int       `json:"distinct_credits"`
+	TotalVideos      int       `json:"total_videos"`
+	AvgTextsPerItem  float64   `json:"avg_texts_per_item"`
+	LastReload       time.Time `json:"last_reload"`
+}
+
+// computeStats aggregates the catalog as seen at now. Only published,
+// unexpired items count towards the keyword, credit, video and text
+// figures.
+func computeStats(c *catalog, now time.Time) CatalogStats {
+	all := c.items
+	st := CatalogStats{TotalItems: len(all), LastReload: c.loadedAt}
+	keywords := map[string]bool{}
+	credits := map[string]bool{}
+	texts := 0
+	for i := range all {
+		it := &all[i]
+		switch {
+		case it.expired(now):
+			st.Archived++
+			continue
+		case it.Status == StatusDraft:
+			st.Draft++
+			continue
+		case !it.published(now):
+			st.Scheduled++
+			continue
+		}
+		st.Published++
+		keywords[it.KeywordTitle] = true
+		for _, c := range it.VideoCredit {
+			credits[c] = true
+		}
+		st.TotalVideos += len(it.VideoPath)
+		texts += len(it.Texts)
+	}
+	st.DistinctKeywords = len(keywords)
+	st.DistinctCredits = len(credits)
+	if st.Published > 0 {
+		st.AvgTextsPerItem = float64(texts) / float64(st.Published)
+	}
+	return st
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, computeStats(current(), time.Now()))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	alpha := testItem(1, "Alpha")
+	alpha.Texts = []string{"One.", "Two."}
+	alpha.VideoPath = []string{"/static/video/agents.mp4", "/static/video/chalk.mp4"}
+	alpha.VideoCredit = []string{"https://example.com/c1", "https://example.com/c2"}
+	beta := testItem(2, "Beta")
+	beta.VideoCredit = []string{"https://example.com/c1"}
+	gamma := testItem(3, "Gamma") // scheduled, and already live
+	gamma.Status, gamma.PublishAt = StatusScheduled, &past
+	gamma.VideoCredit = []string{"https://example.com/c3"}
+	draft := testItem(4, "Draft")
+	draft.Status = StatusDraft
+	later := testItem(5, "Later")
+	later.Status, later.PublishAt = StatusScheduled, &future
+	gone := testItem(6, "Gone")
+	gone.ExpireAt = &past
+	useItems(t, alpha, beta, gamma, draft, later, gone)
+
+	w := get("/api/stats")
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var got CatalogStats
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := CatalogStats{
+		TotalItems:       6,
+		Published:        3,
+		Draft:            1,
+		Scheduled:        1,
+		Archived:         1,
+		DistinctKeywords: 3,
+		DistinctCredits:  3,
+		TotalVideos:      4,
+		AvgTextsPerItem:  4.0 / 3,
+		LastReload:       current().loadedAt,
+	}
+	if !got.LastReload.Equal(want.LastReload) {
+		t.Errorf("last_reload = %v, want %v", got.LastReload, want.LastReload)
+	}
+	got.LastReload = want.LastReload
+	if got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
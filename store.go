@@ -0,0 +1,355 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+// ErrNotFound is returned by repositories for an unknown item ID.
+var ErrNotFound = errors.New("item not found")
+
+// ItemRepository is the backing store for the catalog. Put inserts the
+// item or replaces the one with the same ID.
+type ItemRepository interface {
+	List() ([]Item, error)
+	Get(id int) (Item, error)
+	Put(it Item) error
+	Delete(id int) error
+}
+
+// store is the repository selected by -store.
+var store ItemRepository
+
+// openStore returns the repository named by kind ("json" or "sqlite").
+// An empty SQLite database is seeded from the items.json at jsonPath.
+func openStore(kind, jsonPath, dbPath string) (ItemRepository, error) {
+	switch kind {
+	case "json":
+		return &jsonStore{path: jsonPath}, nil
+	case "sqlite":
+		s, err := openSQLiteStore(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		n, err := s.count()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			if _, err := os.Stat(jsonPath); err == nil {
+				imported, err := importJSON(s, jsonPath)
+				if err != nil {
+					return nil, fmt.Errorf("import %s: %w", jsonPath, err)
+				}
+				log.Printf("Imported %d items from %s into %s", imported, jsonPath, dbPath)
+			}
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown store %q", kind)
+}
+
+// importJSON copies every item in the JSON file at path into repo.
+func importJSON(repo ItemRepository, path string) (int, error) {
+	all, err := (&jsonStore{path: path}).List()
+	if err != nil {
+		return 0, err
+	}
+	for _, it := range all {
+		if err := repo.Put(it); err != nil {
+			return 0, err
+		}
+	}
+	return len(all), nil
+}
+
+// jsonStore keeps the catalog in a single JSON array file. Writes rewrite
+// the whole file atomically. Until the file exists, reads fall back to the
+// items.json embedded in the binary.
+type jsonStore struct {
+	mu    sync.Mutex
+	path  string
+	lines []int // where each item of the last read starts
+}
+
+func (s *jsonStore) List() ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// open opens the store's file, or the embedded items when it is missing.
+func (s *jsonStore) open() (io.ReadCloser, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return embedded.Open(embeddedItems)
+	}
+	return f, err
+}
+
+func (s *jsonStore) read() ([]Item, error) {
+	f, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	all, lines, err := decodeItems(s.path, f)
+	if err != nil {
+		return nil, err
+	}
+	s.lines = lines
+	return all, nil
+}
+
+// itemLines returns the line each item of the last List starts on, for
+// validation errors.
+func (s *jsonStore) itemLines() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lines
+}
+
+func (s *jsonStore) write(all []Item) error {
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+func (s *jsonStore) Get(id int) (Item, error) {
+	all, err := s.List()
+	if err != nil {
+		return Item{}, err
+	}
+	for _, it := range all {
+		if it.ID == id {
+			return it, nil
+		}
+	}
+	return Item{}, ErrNotFound
+}
+
+func (s *jsonStore) Put(it Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range all {
+		if all[i].ID == it.ID {
+			all[i] = it
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		all = append(all, it)
+	}
+	return s.write(all)
+}
+
+func (s *jsonStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	for i := range all {
+		if all[i].ID == id {
+			return s.write(append(all[:i], all[i+1:]...))
+		}
+	}
+	return ErrNotFound
+}
+
+// sqliteStore keeps each item as a JSON document keyed by ID, so new Item
+// fields need no schema migration.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS items (
+	id         INTEGER PRIMARY KEY,
+	data       TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS users (
+	id    INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL UNIQUE,
+	data  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS subscribers (
+	email        TEXT PRIMARY KEY,
+	status       TEXT NOT NULL,
+	created_at   TIMESTAMP NOT NULL,
+	confirmed_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS comments (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	item_id    INTEGER NOT NULL,
+	parent_id  INTEGER NOT NULL DEFAULT 0,
+	author     TEXT    NOT NULL,
+	body       TEXT    NOT NULL,
+	status     TEXT    NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	ip         TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS comments_item ON comments (item_id);
+CREATE TABLE IF NOT EXISTS likes (
+	visitor TEXT    NOT NULL,
+	item_id INTEGER NOT NULL,
+	PRIMARY KEY (visitor, item_id)
+);
+CREATE TABLE IF NOT EXISTS revisions (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	item_id    INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	data       TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS revisions_item ON revisions (item_id);
+CREATE TABLE IF NOT EXISTS item_views (
+	item_id INTEGER PRIMARY KEY,
+	page     INTEGER NOT NULL DEFAULT 0,
+	video    INTEGER NOT NULL DEFAULT 0,
+	download INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS experiment_counts (
+	experiment TEXT    NOT NULL,
+	variant    TEXT    NOT NULL,
+	event      TEXT    NOT NULL,
+	count      INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (experiment, variant, event)
+);
+CREATE TABLE IF NOT EXISTS analytics (
+	day   TEXT    NOT NULL,
+	kind  TEXT    NOT NULL,
+	key   TEXT    NOT NULL,
+	count INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, kind, key)
+);
+CREATE TABLE IF NOT EXISTS redirects (
+	source TEXT PRIMARY KEY,
+	data   TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS collections (
+	slug TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS flags (
+	name TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS digests (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	sent_at TIMESTAMP NOT NULL,
+	data    TEXT    NOT NULL
+);
+CREATE TABLE IF NOT EXISTS audit (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at TIMESTAMP NOT NULL,
+	actor      TEXT    NOT NULL,
+	action     TEXT    NOT NULL,
+	data       TEXT    NOT NULL
+);`
+
+// sqliteColumns add the columns sqliteSchema gained after its tables were
+// first created, which CREATE TABLE IF NOT EXISTS leaves out of older
+// databases.
+var sqliteColumns = []string{
+	`ALTER TABLE item_views ADD COLUMN download INTEGER NOT NULL DEFAULT 0`,
+}
+
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite schema: %w", err)
+	}
+	for _, stmt := range sqliteColumns {
+		if _, err := db.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			db.Close()
+			return nil, fmt.Errorf("sqlite schema: %w", err)
+		}
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) count() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&n)
+	return n, err
+}
+
+func (s *sqliteStore) List() ([]Item, error) {
+	rows, err := s.db.Query(`SELECT data FROM items ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []Item
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var it Item
+		if err := json.Unmarshal([]byte(data), &it); err != nil {
+			return nil, err
+		}
+		all = append(all, it)
+	}
+	return all, rows.Err()
+}
+
+func (s *sqliteStore) Get(id int) (Item, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM items WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, ErrNotFound
+	}
+	if err != nil {
+		return Item{}, err
+	}
+	var it Item
+	err = json.Unmarshal([]byte(data), &it)
+	return it, err
+}
+
+func (s *sqliteStore) Put(it Item) error {
+	data, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO items (id, data) VALUES (?, ?)
+		ON CONFLICT(id) DO UPDATE SET data = excluded.data, updated_at = CURRENT_TIMESTAMP`, it.ID, string(data))
+	return err
+}
+
+func (s *sqliteStore) Delete(id int) error {
+	res, err := s.db.Exec(`DELETE FROM items WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Suggestion limits: the default and largest ?limit=, and how many
+// candidates each trie node keeps so invisible items can be skipped
+// without walking the subtree.
+const (
+	suggestDefault = 8
+	suggestMax     = 20
+	suggestKeep    = 32
+)
+
+// Suggestion is one type-ahead completion.
+type Suggestion struct {
+	Text string `json:"text"`
+	Type string `json:"type"` // "title" or "tag"
+	URL  string `json:"url"`
+}
+
+// suggestEntry is a completion with what's needed to check it is still
+// live: the item index for titles, the slug for tags.
+type suggestEntry struct {
+	Suggestion
+	item int
+	tag  string
+	rank float64
+}
+
+// suggestTrie maps normalized prefixes to their best completions. Every
+// word of a title or tag starts a path, so "web" completes "Full Stack
+// Web Design"; paths from the first word rank higher.
+type suggestTrie struct {
+	entries []suggestEntry
+	root    *trieNode
+}
+
+type trieNode struct {
+	next map[rune]*trieNode
+	top  []trieHit // best first, at most suggestKeep
+}
+
+type trieHit struct {
+	entry int
+	rank  float64
+}
+
+// suggestNorm lowercases s and reduces it to single-spaced letter/digit
+// words.
+func suggestNorm(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// buildSuggest indexes c's titles and tags. Titles rank by how often
+// their item is viewed, tags by how many items carry them.
+func buildSuggest(c *catalog) *suggestTrie {
+	t := &suggestTrie{root: &trieNode{}}
+	for i := range c.items {
+		it := &c.items[i]
+		t.add(suggestEntry{
+			Suggestion: Suggestion{Text: it.KeywordTitle, Type: "title", URL: "/items/" + it.Slug},
+			item:       i, rank: 1 + float64(viewsOf(it.ID).Page)/1000,
+		})
+	}
+	for slug, idx := range c.byTag {
+		t.add(suggestEntry{
+			Suggestion: Suggestion{Text: c.tagNames[slug], Type: "tag", URL: "/tags/" + slug},
+			item:       -1, tag: slug, rank: 1 + float64(len(idx))/10,
+		})
+	}
+	return t
+}
+
+func (t *suggestTrie) add(e suggestEntry) {
+	id := len(t.entries)
+	t.entries = append(t.entries, e)
+	words := strings.Fields(suggestNorm(e.Text))
+	for w := range words {
+		rank := e.rank
+		if w == 0 {
+			rank *= 2
+		}
+		n := t.root
+		for _, r := range strings.Join(words[w:], " ") {
+			if n.next == nil {
+				n.next = map[rune]*trieNode{}
+			}
+			child := n.next[r]
+			if child == nil {
+				child = &trieNode{}
+				n.next[r] = child
+			}
+			n = child
+			n.offer(trieHit{id, rank})
+		}
+	}
+}
+
+// offer keeps h among the node's best hits, once per entry.
+func (n *trieNode) offer(h trieHit) {
+	if i := slices.IndexFunc(n.top, func(o trieHit) bool { return o.entry == h.entry }); i >= 0 {
+		if n.top[i].rank >= h.rank {
+			return
+		}
+		n.top = slices.Delete(n.top, i, i+1)
+	}
+	i, _ := slices.BinarySearchFunc(n.top, h, func(a, b trieHit) int {
+		return cmp.Or(cmp.Compare(b.rank, a.rank), cmp.Compare(a.entry, b.entry))
+	})
+	if i >= suggestKeep {
+		return
+	}
+	n.top = slices.Insert(n.top, i, h)
+	if len(n.top) > suggestKeep {
+		n.top = n.top[:suggestKeep]
+	}
+}
+
+// suggest returns up to limit live completions of q.
+func (c *catalog) suggest(q string, limit int, now time.Time) []Suggestion {
+	q = suggestNorm(q)
+	if q == "" || c.suggestions == nil {
+		return nil
+	}
+	n := c.suggestions.root
+	for _, r := range q {
+		if n = n.next[r]; n == nil {
+			return nil
+		}
+	}
+	var out []Suggestion
+	for _, h := range n.top {
+		e := &c.suggestions.entries[h.entry]
+		if e.item >= 0 && !c.items[e.item].visible(now) {
+			continue
+		}
+		if e.tag != "" && len(c.tagged(e.tag, now)) == 0 {
+			continue
+		}
+		if out = append(out, e.Suggestion); len(out) == limit {
+			break
+		}
+	}
+	return out
+}
+
+// suggestHandler serves /api/search/suggest?q=&limit= for the search
+// box's type-ahead.
+func suggestHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := min(max(atoiDefault(q.Get("limit"), suggestDefault), 1), suggestMax)
+	out := current().suggest(q.Get("q"), limit, time.Now())
+	if out == nil {
+		out = []Suggestion{}
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeJSON(w, http.StatusOK, map[string]any{"query": q.Get("q"), "suggestions": out})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TagCount is one entry of the tag cloud.
+type TagCount struct {
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int    `json:"count"`
+}
+
+// indexTags maps each tag slug to the indexes of the items carrying it
+// and remembers the first spelling seen as the display name.
+func (c *catalog) indexTags() {
+	c.byTag = map[string][]int{}
+	c.tagNames = map[string]string{}
+	for i := range c.items {
+		for _, t := range c.items[i].Tags {
+			slug := slugify(t)
+			if slug == "" || slices.Contains(c.byTag[slug], i) {
+				continue
+			}
+			if _, ok := c.tagNames[slug]; !ok {
+				c.tagNames[slug] = strings.TrimSpace(t)
+			}
+			c.byTag[slug] = append(c.byTag[slug], i)
+		}
+	}
+}
+
+// tagged returns the live items carrying the tag with the given slug.
+func (c *catalog) tagged(slug string, now time.Time) []Item {
+	var out []Item
+	for _, i := range c.byTag[slug] {
+		if c.items[i].visible(now) {
+			out = append(out, c.items[i])
+		}
+	}
+	return out
+}
+
+// tagCloud returns every tag used by a live item, sorted by name.
+func (c *catalog) tagCloud(now time.Time) []TagCount {
+	var cloud []TagCount
+	for slug := range c.byTag {
+		if n := len(c.tagged(slug, now)); n > 0 {
+			cloud = append(cloud, TagCount{Name: c.tagNames[slug], Slug: slug, Count: n})
+		}
+	}
+	slices.SortFunc(cloud, func(a, b TagCount) int { return strings.Compare(a.Slug, b.Slug) })
+	return cloud
+}
+
+// MosaicTile is one tag of /api/mosaic, shown by its newest live item.
+type MosaicTile struct {
+	Tag    string `json:"tag"`
+	Slug   string `json:"slug"`
+	Count  int    `json:"count"`
+	ItemID int    `json:"item_id"`
+	Title  string `json:"title"`
+	Poster string `json:"poster,omitempty"`
+	Link   string `json:"link"`
+}
+
+// mosaicHandler answers /api/mosaic: a tile per tag in use, with the
+// poster of the tag's newest live item, picked by posterFor.
+func mosaicHandler(w http.ResponseWriter, r *http.Request) {
+	c, now := current(), time.Now()
+	tiles := []MosaicTile{}
+	for _, tc := range c.tagCloud(now) {
+		// Not sortItems, which would put a pinned item first.
+		newest := slices.MaxFunc(c.tagged(tc.Slug, now), func(a, b Item) int {
+			return cmp.Or(c.publishedAt(&a).Compare(c.publishedAt(&b)), cmp.Compare(a.ID, b.ID))
+		})
+		tiles = append(tiles, MosaicTile{
+			Tag:    tc.Name,
+			Slug:   tc.Slug,
+			Count:  tc.Count,
+			ItemID: newest.ID,
+			Title:  newest.KeywordTitle,
+			Poster: posterFor(&newest, 0),
+			Link:   siteURL(r, "/items/"+newest.Slug),
+		})
+	}
+	writeJSON(w, http.StatusOK, tiles)
+}
+
+// tagPageHandler lists the items under /tags/{tag}.
+func tagPageHandler(w http.ResponseWriter, r *http.Request) {
+	c := current()
+	slug := slugify(r.PathValue("tag"))
+	tagged := c.tagged(slug, time.Now())
+	if len(tagged) == 0 {
+		notFound(w, r)
+		return
+	}
+	name := c.tagNames[slug]
+	page, p := paginate(r, tagged)
+	data := map[string]interface{}{
+		"Title":     name + " | BlendingWaves",
+		"Tag":       name,
+		"Items":     page,
+		"Paginator": p,
+	}
+	if err := render(w, "tag.html", data); err != nil {
+		renderError(w, err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMosaic(t *testing.T) {
+	prevChain := posterChain
+	t.Cleanup(func() { posterChain = prevChain })
+	day := func(d int) *time.Time { t := time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC); return &t }
+	alpha := testItem(1, "Alpha")
+	alpha.Tags, alpha.CreatedAt = []string{"AI", "Finance"}, day(1)
+	beta := testItem(2, "Beta")
+	beta.Tags, beta.CreatedAt = []string{"ai"}, day(2)
+	gamma := testItem(3, "Gamma")
+	gamma.Tags, gamma.CreatedAt = []string{"Finance", "Drafts"}, day(3)
+	gamma.Status = StatusDraft
+	pinned := testItem(4, "Pinned")
+	pinned.Tags, pinned.CreatedAt, pinned.Pinned = []string{"AI"}, day(1), true
+	useItems(t, alpha, beta, gamma, pinned)
+
+	tests := []struct {
+		chain string
+		want  []MosaicTile
+	}{
+		{defaultPosterChain, []MosaicTile{
+			{Tag: "AI", Slug: "ai", Count: 3, ItemID: 2, Title: "Beta", Poster: defaultThumbnail},
+			{Tag: "Finance", Slug: "finance", Count: 1, ItemID: 1, Title: "Alpha", Poster: defaultThumbnail},
+		}},
+		{"", []MosaicTile{
+			{Tag: "AI", Slug: "ai", Count: 3, ItemID: 2, Title: "Beta"},
+			{Tag: "Finance", Slug: "finance", Count: 1, ItemID: 1, Title: "Alpha"},
+		}},
+	}
+	for _, tt := range tests {
+		posterChain, _ = parsePosterChain(tt.chain)
+		w := get("/api/mosaic")
+		if w.Code != 200 {
+			t.Fatalf("status %d", w.Code)
+		}
+		var got []MosaicTile
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("chain %q: %d tiles, want %d: %+v", tt.chain, len(got), len(tt.want), got)
+		}
+		for i, tile := range got {
+			want := tt.want[i]
+			want.Link = "http://example.com/items/" + current().byID[want.ItemID].Slug
+			if tile != want {
+				t.Errorf("chain %q: tile %d = %+v, want %+v", tt.chain, i, tile, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// thumbSizes maps each generated image to its width in pixels.
+var thumbSizes = map[string]int{
+	"poster": 1280,
+	"medium": 640,
+	"small":  320,
+}
+
+// thumbOffset is how far into a video, in seconds, its frame is grabbed.
+const thumbOffset = "1"
+
+// thumbTimeout bounds one frame grab.
+const thumbTimeout = 30 * time.Second
+
+var (
+	thumbDir   string // cache of generated images; empty disables them
+	thumbLocks keyLocks
+)
+
+// thumbURL is the URL of videoPath's image at size, or "" when thumbnails
+// are off. Images are generated on first request.
+func thumbURL(videoPath, size string) string {
+	if thumbDir == "" || isRemoteMedia(videoPath) {
+		return ""
+	}
+	if _, ok := thumbSizes[size]; !ok {
+		return ""
+	}
+	return "/thumbs/" + videoKey(videoPath) + "/" + size + ".jpg"
+}
+
+// videoForKey finds the catalog video path whose videoKey is key.
+func videoForKey(key string) (string, bool) {
+	for _, it := range current().items {
+		for _, vp := range it.VideoPath {
+			if videoKey(vp) == key {
+				return vp, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ensureThumb grabs a frame of videoPath scaled to width into name,
+// unless name was already made from the current video. Images are made
+// one at a time each, but different images in parallel.
+func ensureThumb(videoPath, name string, width int) error {
+	stamp, err := videoStamp(videoPath)
+	if err != nil {
+		return err
+	}
+	if thumbFresh(name, stamp) {
+		return nil
+	}
+	defer thumbLocks.lock(name)()
+	if thumbFresh(name, stamp) {
+		return nil // made while this request waited
+	}
+	src, stamp, cleanup, err := localVideo(videoPath)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp := name + ".tmp.jpg"
+	defer os.Remove(tmp)
+	cmd, cancel := ffmpegCmd(thumbTimeout, "-hide_banner", "-loglevel", "error", "-y",
+		"-ss", thumbOffset, "-i", src, "-frames:v", "1",
+		"-vf", "scale="+strconv.Itoa(width)+":-2", "-q:v", "3", tmp)
+	defer cancel()
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(msg)))
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return os.WriteFile(name+".source", []byte(stamp), 0o644)
+}
+
+// thumbFresh reports whether the image name exists and was made from
+// the video version stamp.
+func thumbFresh(name, stamp string) bool {
+	old, err := os.ReadFile(name + ".source")
+	if err != nil || string(old) != stamp {
+		return false
+	}
+	_, err = os.Stat(name)
+	return err == nil
+}
+
+// thumbHandler serves /thumbs/{key}/{file}, generating the image on first
+// request.
+func thumbHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := startSpan(r.Context(), "media.thumbnail")
+	defer span.End()
+	key := r.PathValue("key")
+	size, ok := strings.CutSuffix(r.PathValue("file"), ".jpg")
+	width, known := thumbSizes[size]
+	vp, found := videoForKey(key)
+	if !ok || !known || !found {
+		notFound(w, r)
+		return
+	}
+	name := filepath.Join(thumbDir, key, size+".jpg")
+	if err := ensureThumb(vp, name, width); err != nil {
+		serverError(w, fmt.Errorf("thumbnail %s %s: %w", vp, size, err))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	serveFileETag(w, r, os.DirFS(thumbDir), key+"/"+size+".jpg")
+}
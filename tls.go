@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsEnabled reports whether the main listener should terminate TLS.
+func tlsEnabled(cfg Config) bool {
+	return cfg.TLSCert != "" || cfg.AutocertHosts != ""
+}
+
+// setupTLS returns the TLS config for the main listener and the handler
+// for the plain HTTP listener: a redirect to HTTPS that, under autocert,
+// also answers ACME http-01 challenges.
+func setupTLS(cfg Config) (*tls.Config, http.Handler, error) {
+	redirect := http.HandlerFunc(redirectToHTTPS(cfg.httpsPort()))
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, redirect, nil
+	}
+	var hosts []string
+	for _, h := range strings.Split(cfg.AutocertHosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("autocert-hosts lists no hosts")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(cfg.AutocertCache),
+		Email:      cfg.AutocertEmail,
+	}
+	tc := m.TLSConfig()
+	tc.MinVersion = tls.VersionTLS12
+	return tc, m.HTTPHandler(redirect), nil
+}
+
+// redirectToHTTPS sends every request to the same host and path over
+// HTTPS on port.
+func redirectToHTTPS(port string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+}
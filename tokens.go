@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errBadToken     = errors.New("invalid token")
+	errExpiredToken = errors.New("token expired")
+)
+
+// signToken binds value to purpose until exp (zero for never) with an
+// HMAC under cookieSecret, yielding a URL-safe token.
+func signToken(purpose, value string, exp time.Time) string {
+	var expUnix int64
+	if !exp.IsZero() {
+		expUnix = exp.Unix()
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value)) + "." + strconv.FormatInt(expUnix, 10)
+	return payload + "." + tokenMAC(purpose, payload)
+}
+
+// verifyToken returns the value signed into token for purpose.
+func verifyToken(purpose, token string, now time.Time) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", errBadToken
+	}
+	payload, mac := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(tokenMAC(purpose, payload))) {
+		return "", errBadToken
+	}
+	enc, expStr, ok := strings.Cut(payload, ".")
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if !ok || err != nil {
+		return "", errBadToken
+	}
+	if exp != 0 && now.Unix() > exp {
+		return "", errExpiredToken
+	}
+	value, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return "", errBadToken
+	}
+	return string(value), nil
+}
+
+func tokenMAC(purpose, payload string) string {
+	m := hmac.New(sha256.New, cookieSecret)
+	m.Write([]byte(purpose + "|" + payload))
+	return hex.EncodeToString(m.Sum(nil)[:16])
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestIDHeader names the request ID, taken from the client or proxy
+// when it sends a sane one and echoed on the response.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestIDs assigns every request an ID, visible to handlers through
+// requestIDFrom and to clients in the X-Request-ID response header.
+func requestIDs(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID accepts up to 64 letters, digits, '-', '_' and '.', so
+// an inbound ID can't smuggle anything into logs or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// span is one timed operation of a trace.
+type span struct {
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte
+	name    string
+	kind    int // OTLP SpanKind: 1 internal, 2 server
+	start   time.Time
+	end     time.Time
+	attrs   map[string]any
+	err     string
+}
+
+type spanKey struct{}
+
+// tracer batches finished spans for the OTLP/HTTP collector at endpoint.
+// Tracing is off while it is nil.
+var tracer *otlpExporter
+
+// startSpan starts a child of the span in ctx, or a new trace when there
+// is none. It returns a nil span, whose methods do nothing, when tracing
+// is off.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: 1, start: time.Now(), attrs: map[string]any{}}
+	if p, ok := ctx.Value(spanKey{}).(*span); ok && p != nil {
+		s.traceID, s.parent = p.traceID, p.id
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.id[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+func (s *span) set(key string, v any) {
+	if s != nil {
+		s.attrs[key] = v
+	}
+}
+
+func (s *span) fail(err error) {
+	if s != nil && err != nil {
+		s.err = err.Error()
+	}
+}
+
+func (s *span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	tracer.add(s)
+}
+
+// ctxWriter gives code that only holds the ResponseWriter, like render,
+// the request's context.
+type ctxWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+}
+
+func (c *ctxWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+func (c *ctxWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// findWriter unwraps w until it finds a T.
+func findWriter[T http.ResponseWriter](w http.ResponseWriter) (T, bool) {
+	for {
+		if t, ok := w.(T); ok {
+			return t, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// writerContext returns the request context tracing attached to w.
+func writerContext(w http.ResponseWriter) context.Context {
+	if c, ok := findWriter[*ctxWriter](w); ok {
+		return c.ctx
+	}
+	return context.Background()
+}
+
+// traceRequests wraps every request in a server span, continuing the
+// caller's trace when it sends a W3C traceparent header. handle renames
+// the span after the matched route.
+func traceRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracer == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if tid, pid, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = context.WithValue(ctx, spanKey{}, &span{traceID: tid, id: pid})
+		}
+		ctx, s := startSpan(ctx, r.Method)
+		s.kind = 2
+		s.set("http.request.method", r.Method)
+		s.set("url.path", r.URL.Path)
+		s.set("request.id", requestIDFrom(ctx))
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			s.set("http.response.status_code", rec.Status())
+			if rec.Status() >= 500 {
+				s.err = http.StatusText(rec.Status())
+			}
+			s.End()
+		}()
+		h.ServeHTTP(&ctxWriter{rec, ctx}, r.WithContext(ctx))
+	})
+}
+
+// routeSpan names the request's span after pattern.
+func routeSpan(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := spanFrom(r.Context()); s != nil {
+			s.name = pattern
+			s.set("http.route", pattern)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// parseTraceparent reads a version-00 W3C traceparent header.
+func parseTraceparent(v string) (tid [16]byte, pid [8]byte, ok bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return tid, pid, false
+	}
+	if _, err := hex.Decode(tid[:], []byte(parts[1])); err != nil {
+		return tid, pid, false
+	}
+	if _, err := hex.Decode(pid[:], []byte(parts[2])); err != nil {
+		return tid, pid, false
+	}
+	return tid, pid, tid != [16]byte{} && pid != [8]byte{}
+}
+
+// otlpExporter sends spans to an OTLP/HTTP collector as JSON, in batches
+// of up to otlpBatch or every otlpInterval.
+type otlpExporter struct {
+	url     string
+	service string
+	client  *http.Client
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+const (
+	otlpBatch    = 256
+	otlpMaxQueue = 4096 // spans beyond this are dropped while the collector lags
+	otlpInterval = 5 * time.Second
+)
+
+// setTracing enables OTLP export to endpoint, e.g. http://localhost:4318.
+func setTracing(endpoint, service string) {
+	if endpoint == "" {
+		return
+	}
+	tracer = &otlpExporter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	go func() {
+		for range time.Tick(otlpInterval) {
+			tracer.flush()
+		}
+	}()
+}
+
+func (e *otlpExporter) add(s *span) {
+	e.mu.Lock()
+	if len(e.spans) < otlpMaxQueue {
+		e.spans = append(e.spans, s)
+	}
+	full := len(e.spans) >= otlpBatch
+	e.mu.Unlock()
+	if full {
+		go e.flush()
+	}
+}
+
+// flush exports every queued span.
+func (e *otlpExporter) flush() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	batch := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.request(batch))
+	if err != nil {
+		log.Printf("otlp: %v", err)
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("otlp: export %d spans: %v", len(batch), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("otlp: export %d spans: %s", len(batch), resp.Status)
+	}
+}
+
+// request builds an ExportTraceServiceRequest in OTLP's JSON encoding.
+func (e *otlpExporter) request(batch []*span) map[string]any {
+	spans := make([]map[string]any, len(batch))
+	for i, s := range batch {
+		js := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.id[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			js["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			js["status"] = map[string]any{"code": 2, "message": s.err}
+		}
+		spans[i] = js
+	}
+	return map[string]any{"resourceSpans": []any{map[string]any{
+		"resource": map[string]any{"attributes": otlpAttrs(map[string]any{"service.name": e.service})},
+		"scopeSpans": []any{map[string]any{
+			"scope": map[string]any{"name": "blendingwaves"},
+			"spans": spans,
+		}},
+	}}}
+}
+
+func otlpAttrs(attrs map[string]any) []any {
+	out := make([]any, 0, len(attrs))
+	for k, v := range attrs {
+		var val map[string]any
+		switch v := v.(type) {
+		case int:
+			val = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			val = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			val = map[string]any{"boolValue": v}
+		case float64:
+			val = map[string]any{"doubleValue": v}
+		default:
+			val = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": k, "value": val})
+	}
+	return out
+}
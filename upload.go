@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// uploadTypes maps each accepted sniffed Content-Type to the directory
+// and extension its files are stored under. WebVTT sniffs as plain text,
+// so storeUpload recognizes it by its header.
+var uploadTypes = map[string][2]string{
+	"text/vtt":   {"captions", ".vtt"},
+	"video/mp4":  {"video", ".mp4"},
+	"video/webm": {"video", ".webm"},
+	"image/jpeg": {"images", ".jpg"},
+	"image/png":  {"images", ".png"},
+	"image/gif":  {"images", ".gif"},
+	"image/webp": {"images", ".webp"},
+}
+
+// uploadMax caps the size of an upload request in bytes.
+var uploadMax int64
+
+// adminUploadHandler accepts a multipart "file" field, streams it to the
+// media store and answers with the stored path to put in an item's
+// VideoPath or Captions. The type is sniffed from the content, not
+// trusted from the client.
+func adminUploadHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, uploadMax)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "expected a multipart/form-data upload")
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			writeJSONError(w, http.StatusBadRequest, `missing "file" field`)
+			return
+		}
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		storeUpload(w, r, part.FileName(), part)
+		return
+	}
+}
+
+// storeUpload spools body to a temporary file, so its size is known and
+// the limit enforced before anything reaches the store, then puts it.
+func storeUpload(w http.ResponseWriter, r *http.Request, filename string, body io.Reader) {
+	br := bufio.NewReaderSize(body, 512)
+	head, _ := br.Peek(512)
+	ct := http.DetectContentType(head)
+	if isVTT(head) {
+		ct = "text/vtt"
+	}
+	kind, ok := uploadTypes[ct]
+	if !ok {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "unsupported file type "+ct)
+		return
+	}
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	n, err := io.Copy(tmp, br)
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		serverError(w, err)
+		return
+	}
+	ref, err := media.Put(kind[0]+"/"+uploadName(filename, kind[1]), ct, tmp, n)
+	if err != nil {
+		serverError(w, fmt.Errorf("upload %s: %w", filename, err))
+		return
+	}
+	out := map[string]any{"path": ref, "type": ct, "size": n}
+	audit(r, "media.upload", ref, nil, out)
+	writeJSON(w, http.StatusCreated, out)
+}
+
+// uploadName derives a safe, unique file name from the client's one.
+func uploadName(filename, ext string) string {
+	base := slugify(strings.TrimSuffix(path.Base(filename), path.Ext(filename)))
+	if base == "" {
+		base = "upload"
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return base + "-" + hex.EncodeToString(b) + ext
+}
+
+// uploadError maps a failed read of the request body to a response.
+func uploadError(w http.ResponseWriter, err error) {
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload exceeds %d bytes", tooBig.Limit))
+		return
+	}
+	writeJSONError(w, http.StatusBadRequest, err.Error())
+}
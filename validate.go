@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"strings"
+)
+
+// itemProblem is one thing wrong with one item. Line is where the item
+// starts in items.json, or 0 for stores without lines.
+type itemProblem struct {
+	Index int
+	ID    int
+	Title string
+	Line  int
+	Msg   string
+}
+
+func (p itemProblem) String() string {
+	where := fmt.Sprintf("item #%d", p.Index+1)
+	if p.ID != 0 {
+		where += fmt.Sprintf(" (id %d", p.ID)
+		if p.Title != "" {
+			where += fmt.Sprintf(", %q", p.Title)
+		}
+		where += ")"
+	}
+	if p.Line > 0 {
+		where = fmt.Sprintf("line %d: %s", p.Line, where)
+	}
+	return where + ": " + p.Msg
+}
+
+// invalidItemsError lists every problem found in a catalog, one per line.
+type invalidItemsError struct {
+	Problems []itemProblem
+}
+
+func (e *invalidItemsError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("%d problem(s) in items:\n  %s", len(e.Problems), strings.Join(lines, "\n  "))
+}
+
+// checkItems validates a whole catalog: each item on its own (see
+// checkItem) plus IDs, slugs and short codes that must be unique across
+// items. lines, when given, holds the line each item starts on.
+func checkItems(items []Item, lines []int) error {
+	var problems []itemProblem
+	add := func(i int, msg string) {
+		p := itemProblem{Index: i, ID: items[i].ID, Title: items[i].KeywordTitle, Msg: msg}
+		if i < len(lines) {
+			p.Line = lines[i]
+		}
+		problems = append(problems, p)
+	}
+	ids := map[int]int{}
+	slugs := map[string]int{}
+	codes := map[string]int{}
+	for i := range items {
+		it := &items[i]
+		for _, msg := range checkItem(it) {
+			add(i, msg)
+		}
+		if first, dup := ids[it.ID]; dup && it.ID != 0 {
+			add(i, fmt.Sprintf("id %d is already used by item #%d", it.ID, first+1))
+		} else {
+			ids[it.ID] = i
+		}
+		if it.Slug != "" {
+			if first, dup := slugs[it.Slug]; dup {
+				add(i, fmt.Sprintf("slug %q is already used by item #%d", it.Slug, first+1))
+			} else {
+				slugs[it.Slug] = i
+			}
+		}
+		if it.ShortCode != "" {
+			if first, dup := codes[it.ShortCode]; dup {
+				add(i, fmt.Sprintf("short_code %q is already used by item #%d", it.ShortCode, first+1))
+			} else {
+				codes[it.ShortCode] = i
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &invalidItemsError{problems}
+	}
+	return nil
+}
+
+// checkItem returns what is wrong with it alone: missing required fields,
+// videos without a credit each, malformed links, local videos that
+// aren't under static/ and captions that don't fit their videos.
+func checkItem(it *Item) []string {
+	var msgs []string
+	if it.ID <= 0 {
+		msgs = append(msgs, "id must be a positive number")
+	}
+	if strings.TrimSpace(it.KeywordTitle) == "" {
+		msgs = append(msgs, "keyword_title is required")
+	}
+	if len(it.Texts) == 0 {
+		msgs = append(msgs, "texts needs at least one paragraph")
+	}
+	if len(it.VideoPath) == 0 {
+		msgs = append(msgs, "video_path needs at least one video")
+	}
+	if len(it.VideoCredit) != len(it.VideoPath) {
+		msgs = append(msgs, fmt.Sprintf("video_credit has %d entries for %d videos; give one credit per video", len(it.VideoCredit), len(it.VideoPath)))
+	}
+	for i, vp := range it.VideoPath {
+		if msg := checkVideoPath(vp); msg != "" {
+			msgs = append(msgs, fmt.Sprintf("video_path[%d] %q %s", i, vp, msg))
+		}
+	}
+	for i, c := range it.VideoCredit {
+		if strings.HasPrefix(c, "http") && !wellFormedURL(c) {
+			msgs = append(msgs, fmt.Sprintf("video_credit[%d] %q is not a valid URL", i, c))
+		}
+	}
+	msgs = append(msgs, checkCaptions(it)...)
+	msgs = append(msgs, checkPosters(it)...)
+	msgs = append(msgs, checkHeaders(it)...)
+	if msg := checkShortCode(it.ShortCode); msg != "" {
+		msgs = append(msgs, msg)
+	}
+	if it.Order < 0 {
+		msgs = append(msgs, fmt.Sprintf("order %d must not be negative; use 0 for no set position", it.Order))
+	}
+	if it.ItemLink != "" && !wellFormedURL(it.ItemLink) && !strings.HasPrefix(it.ItemLink, "/") {
+		msgs = append(msgs, fmt.Sprintf("ItemLink %q must be an http(s) URL or a site path", it.ItemLink))
+	}
+	if err := validStatus(it.Status, it.PublishAt); err != nil {
+		msgs = append(msgs, err.Error())
+	}
+	for lang := range it.Translations {
+		if len(lang) < 2 || strings.ToLower(lang) != lang {
+			msgs = append(msgs, fmt.Sprintf("translations key %q is not a lowercase language code", lang))
+		}
+	}
+	return msgs
+}
+
+// checkVideoPath explains what is wrong with a video reference, or
+// returns "".
+func checkVideoPath(vp string) string {
+	if isRemoteMedia(vp) {
+		if strings.TrimPrefix(vp, remotePrefix) == "" {
+			return "names no object"
+		}
+		return ""
+	}
+	rel, ok := staticFile(vp)
+	if !ok {
+		return `must be a /static/ path or an "s3:" object`
+	}
+	if staticFS == nil {
+		return ""
+	}
+	if _, err := fs.Stat(staticFS, rel); err != nil {
+		return "does not exist under static/"
+	}
+	return ""
+}
+
+// wellFormedURL reports whether s is an absolute http or https URL.
+func wellFormedURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// decodeItems reads a JSON array of items, recording the line each one
+// starts on. Syntax and type errors name the line and column they occur
+// at rather than a byte offset.
+func decodeItems(name string, r io.Reader) ([]Item, []int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// off is where the failing value starts; type errors count from
+	// there, syntax errors from the start of the file.
+	fail := func(err error, off int64) error {
+		var se *json.SyntaxError
+		var te *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &se):
+			off = se.Offset
+		case errors.As(err, &te):
+			off += te.Offset
+			err = fmt.Errorf("%s should be %s, not %s", te.Field, te.Type, te.Value)
+		}
+		line, col := lineCol(data, off)
+		return fmt.Errorf("%s:%d:%d: %v", name, line, col, err)
+	}
+	if tok, err := dec.Token(); err != nil {
+		return nil, nil, fail(err, dec.InputOffset())
+	} else if tok != json.Delim('[') {
+		return nil, nil, fail(errors.New("items must be a JSON array"), 0)
+	}
+	var items []Item
+	var lines []int
+	for dec.More() {
+		// InputOffset is just past the previous value; skip to this one.
+		start := dec.InputOffset()
+		for start < int64(len(data)) && strings.IndexByte(", \t\r\n", data[start]) >= 0 {
+			start++
+		}
+		var it Item
+		if err := dec.Decode(&it); err != nil {
+			return nil, nil, fail(err, start)
+		}
+		line, _ := lineCol(data, start)
+		items = append(items, it)
+		lines = append(lines, line)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, fail(err, dec.InputOffset())
+	}
+	return items, lines, nil
+}
+
+// lineCol converts a byte offset in data to a 1-based line and column.
+func lineCol(data []byte, off int64) (line, col int) {
+	off = min(max(off, 0), int64(len(data)))
+	before := data[:off]
+	line = 1 + bytes.Count(before, []byte("\n"))
+	col = int(off) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// storeLines returns item line numbers when the store has them.
+func storeLines() []int {
+	if l, ok := store.(interface{ itemLines() []int }); ok {
+		return l.itemLines()
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// videoTypes covers the video extensions missing from Go's built-in MIME
+// table; mime.TypeByExtension is consulted for the rest.
+var videoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+}
+
+// videoRate caps each video response in bytes per second; 0 is unlimited.
+var videoRate int
+
+// VideoURL is the streaming URL of the item's i-th video.
+func (it Item) VideoURL(i int) string {
+	return "/video/" + strconv.Itoa(it.ID) + "/" + strconv.Itoa(i)
+}
+
+// videoHandler streams /video/{id}/{index} with Range support, so players
+// can seek without downloading the whole file. Videos in the object store
+// redirect to a freshly signed URL instead.
+func videoHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := startSpan(r.Context(), "media.video")
+	defer span.End()
+	span.set("range", r.Header.Get("Range"))
+	it, ok := current().lookup(r.PathValue("id"), time.Now())
+	i, err := strconv.Atoi(r.PathValue("index"))
+	if !ok || err != nil || i < 0 || i >= len(it.VideoPath) {
+		notFound(w, r)
+		return
+	}
+	if needMediaPass(w, r) {
+		return
+	}
+	if protectedMedia() {
+		w.Header().Set("Cache-Control", "private")
+	}
+	// Players issue several ranged requests per playback; count the
+	// one that starts at the beginning.
+	if rg := r.Header.Get("Range"); rg == "" || strings.HasPrefix(rg, "bytes=0-") {
+		countView(r, it.ID, true)
+	}
+	if vp := it.VideoPath[i]; isRemoteMedia(vp) {
+		u, err := media.URL(vp)
+		if err != nil {
+			serverError(w, fmt.Errorf("video %s: %w", vp, err))
+			return
+		}
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	name, ok := staticFile(it.VideoPath[i])
+	if !ok {
+		notFound(w, r)
+		return
+	}
+	f, err := staticFS.Open(name)
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	rs, seekable := f.(io.ReadSeeker)
+	if err != nil || !fi.Mode().IsRegular() || !seekable {
+		notFound(w, r)
+		return
+	}
+
+	if ct := videoType(name); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if tag, ok := fileETag(staticFS, name); ok {
+		w.Header().Set("ETag", tag)
+	}
+	if videoRate > 0 {
+		w = &throttledWriter{ResponseWriter: w, ctx: r.Context(), rate: videoRate, start: time.Now()}
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), rs)
+}
+
+// videoType returns the Content-Type of the video file name, or "" when
+// its extension is unknown.
+func videoType(name string) string {
+	ext := path.Ext(name)
+	if ct, ok := videoTypes[ext]; ok {
+		return ct
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// throttledWriter paces writes so the body averages at most rate bytes
+// per second.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx   context.Context
+	rate  int
+	start time.Time
+	sent  int64
+}
+
+func (t *throttledWriter) Write(b []byte) (int, error) {
+	chunk := max(t.rate/10, 1)
+	n := 0
+	for len(b) > 0 {
+		m, err := t.ResponseWriter.Write(b[:min(chunk, len(b))])
+		n += m
+		t.sent += int64(m)
+		if err != nil {
+			return n, err
+		}
+		b = b[m:]
+		due := t.start.Add(time.Duration(t.sent) * time.Second / time.Duration(t.rate))
+		if d := time.Until(due); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-t.ctx.Done():
+				return n, t.ctx.Err()
+			}
+		}
+	}
+	return n, nil
+}
+
+func (t *throttledWriter) Unwrap() http.ResponseWriter { return t.ResponseWriter }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useStaticDir serves static files from a temporary directory holding
+// files for the rest of the test.
+func useStaticDir(t *testing.T, files map[string][]byte) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prev := staticFS
+	staticFS = os.DirFS(dir)
+	t.Cleanup(func() { staticFS = prev })
+}
+
+func TestVideoHead(t *testing.T) {
+	useItems(t, testItem(1, "Alpha"))
+	video := bytes.Repeat([]byte("0123456789"), 1000)
+	useStaticDir(t, map[string][]byte{"video/chalk.mp4": video})
+
+	head := do(httptest.NewRequest("HEAD", "/video/1/0", nil))
+	if head.Code != 200 || head.Body.Len() != 0 {
+		t.Fatalf("HEAD: status %d with %d body bytes", head.Code, head.Body.Len())
+	}
+	ranged := get("/video/1/0", "Range", "bytes=0-99")
+	if ranged.Code != 206 || ranged.Body.Len() != 100 {
+		t.Fatalf("ranged GET: status %d with %d bytes", ranged.Code, ranged.Body.Len())
+	}
+
+	tests := []struct {
+		header string
+		head   string
+		get    string
+	}{
+		{"Content-Length", head.Header().Get("Content-Length"), fmt.Sprint(len(video))},
+		{"Content-Type", head.Header().Get("Content-Type"), ranged.Header().Get("Content-Type")},
+		{"Accept-Ranges", head.Header().Get("Accept-Ranges"), ranged.Header().Get("Accept-Ranges")},
+		{"ETag", head.Header().Get("ETag"), ranged.Header().Get("ETag")},
+		{"Last-Modified", head.Header().Get("Last-Modified"), ranged.Header().Get("Last-Modified")},
+	}
+	for _, tt := range tests {
+		if tt.head == "" || tt.head != tt.get {
+			t.Errorf("%s: HEAD %q, ranged GET %q", tt.header, tt.head, tt.get)
+		}
+	}
+	if got, want := ranged.Header().Get("Content-Range"), fmt.Sprintf("bytes 0-99/%d", len(video)); got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+	if ct := head.Header().Get("Content-Type"); ct != "video/mp4" {
+		t.Errorf("Content-Type = %q, want video/mp4", ct)
+	}
+	if ar := head.Header().Get("Accept-Ranges"); ar != "bytes" {
+		t.Errorf("Accept-Ranges = %q, want bytes", ar)
+	}
+}
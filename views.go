@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Views counts how often an item's page and videos were viewed, and its
+// videos downloaded.
+type Views struct {
+	Page     int64 `json:"page"`
+	Video    int64 `json:"video"`
+	Download int64 `json:"download"`
+}
+
+// plus returns the sum of both counts.
+func (v Views) plus(d Views) Views {
+	return Views{Page: v.Page + d.Page, Video: v.Video + d.Video, Download: v.Download + d.Download}
+}
+
+// viewStore is implemented by repositories that persist view counts.
+// AddViews adds the deltas to the stored totals.
+type viewStore interface {
+	LoadViews() (map[int]Views, error)
+	AddViews(deltas map[int]Views) error
+}
+
+// viewFlushInterval is how often buffered counts reach the store: the
+// default schedule of the analytics job.
+const viewFlushInterval = 10 * time.Second
+
+// showViews displays view counts on item pages and in the items API.
+var showViews bool
+
+// views buffers counts in memory so requests never wait on the store.
+// totals holds what has been flushed; pending what hasn't yet.
+var views = struct {
+	sync.Mutex
+	totals  map[int]Views
+	pending map[int]Views
+}{totals: map[int]Views{}, pending: map[int]Views{}}
+
+// startViews loads the stored totals; the analytics job flushes pending
+// counts.
+func startViews() {
+	vs, ok := store.(viewStore)
+	if !ok {
+		return
+	}
+	totals, err := vs.LoadViews()
+	if err != nil {
+		log.Printf("views: load: %v", err)
+	} else {
+		views.Lock()
+		views.totals = totals
+		views.Unlock()
+	}
+}
+
+// countView records a page or video view unless it came from a bot.
+func countView(r *http.Request, id int, video bool) {
+	if r.Method == http.MethodHead || isBot(r) || exporting {
+		return
+	}
+	views.Lock()
+	v := views.pending[id]
+	if video {
+		v.Video++
+	} else {
+		v.Page++
+	}
+	views.pending[id] = v
+	views.Unlock()
+}
+
+// flushViews writes pending counts to the store. On failure they are
+// kept and retried on the next flush.
+func flushViews() {
+	vs, ok := store.(viewStore)
+	if !ok {
+		return
+	}
+	views.Lock()
+	pending := views.pending
+	views.pending = map[int]Views{}
+	views.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	err := vs.AddViews(pending)
+	views.Lock()
+	defer views.Unlock()
+	for id, d := range pending {
+		if err != nil {
+			views.pending[id] = views.pending[id].plus(d)
+			continue
+		}
+		views.totals[id] = views.totals[id].plus(d)
+	}
+	if err != nil {
+		log.Printf("views: flush: %v", err)
+	}
+}
+
+// viewsOf returns an item's counts including those not yet flushed.
+func viewsOf(id int) Views {
+	views.Lock()
+	defer views.Unlock()
+	return views.totals[id].plus(views.pending[id])
+}
+
+// withViews returns copies of items carrying their view counts, or items
+// themselves when counts aren't shown.
+func withViews(items []Item) []Item {
+	if !showViews {
+		return items
+	}
+	out := slices.Clone(items)
+	for i := range out {
+		v := viewsOf(out[i].ID)
+		out[i].Views = &v
+	}
+	return out
+}
+
+// ItemViews is one row of /admin/api/views.
+type ItemViews struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Views
+}
+
+// adminViewsHandler lists every item's view counts, most viewed first.
+func adminViewsHandler(w http.ResponseWriter, r *http.Request) {
+	c := current()
+	out := make([]ItemViews, 0, len(c.items))
+	for _, it := range c.items {
+		out = append(out, ItemViews{ID: it.ID, Title: it.KeywordTitle, Views: viewsOf(it.ID)})
+	}
+	slices.SortStableFunc(out, func(a, b ItemViews) int {
+		return int((b.Page + b.Video) - (a.Page + a.Video))
+	})
+	writeJSON(w, http.StatusOK, out)
+}
+
+// viewsPath is where the JSON store keeps counts, beside items.json.
+func (s *jsonStore) viewsPath() string {
+	return strings.TrimSuffix(s.path, ".json") + ".views.json"
+}
+
+func (s *jsonStore) LoadViews() (map[int]Views, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readViews()
+}
+
+func (s *jsonStore) readViews() (map[int]Views, error) {
+	var out map[int]Views
+	if ok, err := readRecoverable(s.viewsPath(), &out); !ok || out == nil {
+		return map[int]Views{}, err
+	}
+	return out, nil
+}
+
+func (s *jsonStore) AddViews(deltas map[int]Views) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readViews()
+	if err != nil {
+		return err
+	}
+	for id, d := range deltas {
+		all[id] = all[id].plus(d)
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.viewsPath(), data)
+}
+
+func (s *sqliteStore) LoadViews() (map[int]Views, error) {
+	rows, err := s.db.Query(`SELECT item_id, page, video, download FROM item_views`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]Views{}
+	for rows.Next() {
+		var id int
+		var v Views
+		if err := rows.Scan(&id, &v.Page, &v.Video, &v.Download); err != nil {
+			return nil, err
+		}
+		out[id] = v
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) AddViews(deltas map[int]Views) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, d := range deltas {
+		if _, err := tx.Exec(`INSERT INTO item_views (item_id, page, video, download) VALUES (?, ?, ?, ?)
+			ON CONFLICT(item_id) DO UPDATE SET page = page + excluded.page, video = video + excluded.video,
+				download = download + excluded.download`,
+			id, d.Page, d.Video, d.Download); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// resetViews empties the view counters for the rest of the test.
+func resetViews(t *testing.T) {
+	t.Helper()
+	views.Lock()
+	totals, pending := views.totals, views.pending
+	views.totals, views.pending = map[int]Views{}, map[int]Views{}
+	views.Unlock()
+	t.Cleanup(func() {
+		views.Lock()
+		views.totals, views.pending = totals, pending
+		views.Unlock()
+	})
+}
+
+func TestCountViewConcurrent(t *testing.T) {
+	resetViews(t)
+	const workers, each = 20, 250
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range each {
+				r := httptest.NewRequest("GET", "/items/1", nil)
+				r.Header.Set("User-Agent", "Mozilla/5.0 Firefox/128.0")
+				if i%2 == 0 {
+					countView(r, 1, false)
+				} else {
+					countView(r, 1, true)
+				}
+				bot := httptest.NewRequest("GET", "/items/1", nil)
+				bot.Header.Set("User-Agent", "Googlebot/2.1")
+				countView(bot, 1, false)
+			}
+		}()
+	}
+	wg.Wait()
+	got := viewsOf(1)
+	want := Views{Page: workers / 2 * each, Video: workers / 2 * each}
+	if got != want {
+		t.Errorf("viewsOf(1) = %+v, want %+v", got, want)
+	}
+}
+
+func TestItemsAPIViews(t *testing.T) {
+	useItems(t, testItem(1, "Alpha"), testItem(2, "Beta"))
+	resetViews(t)
+	views.Lock()
+	views.totals[1] = Views{Page: 5, Video: 2}
+	views.pending[1] = Views{Page: 1}
+	views.Unlock()
+	t.Cleanup(func() { showViews = testConfig.ShowViews })
+
+	tests := []struct {
+		show bool
+		path string
+		want map[int]*Views
+	}{
+		{true, "/api/items", map[int]*Views{1: {Page: 6, Video: 2}, 2: {}}},
+		{true, "/api/items?page=1", map[int]*Views{1: {Page: 6, Video: 2}, 2: {}}},
+		{true, "/api/items/1", map[int]*Views{1: {Page: 6, Video: 2}}},
+		{false, "/api/items", map[int]*Views{1: nil, 2: nil}},
+		{false, "/api/items/1", map[int]*Views{1: nil}},
+	}
+	for _, tt := range tests {
+		showViews = tt.show
+		w := get(tt.path)
+		if w.Code != 200 {
+			t.Fatalf("%s: status %d", tt.path, w.Code)
+		}
+		var items []Item
+		if tt.path == "/api/items/1" {
+			var it Item
+			if err := json.Unmarshal(w.Body.Bytes(), &it); err != nil {
+				t.Fatal(err)
+			}
+			items = []Item{it}
+		} else if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != len(tt.want) {
+			t.Fatalf("show=%v %s: %d items, want %d", tt.show, tt.path, len(items), len(tt.want))
+		}
+		for _, it := range items {
+			want := tt.want[it.ID]
+			switch {
+			case want == nil && it.Views != nil:
+				t.Errorf("show=%v %s: item %d has views %+v, want none", tt.show, tt.path, it.ID, *it.Views)
+			case want != nil && (it.Views == nil || *it.Views != *want):
+				t.Errorf("show=%v %s: item %d views = %v, want %+v", tt.show, tt.path, it.ID, it.Views, *want)
+			}
+		}
+	}
+}
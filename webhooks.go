@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook events, one per kind of catalog change.
+const (
+	EventItemCreated   = "item.created"
+	EventItemUpdated   = "item.updated"
+	EventItemPublished = "item.published"
+	EventItemDeleted   = "item.deleted"
+)
+
+var webhookEvents = []string{EventItemCreated, EventItemUpdated, EventItemPublished, EventItemDeleted}
+
+// Delivery tuning: attempts per event and the first retry delay, which
+// doubles after each failure.
+const (
+	webhookAttempts = 6
+	webhookBackoff  = 2 * time.Second
+	webhookQueue    = 256
+)
+
+// WebhookEvent is the JSON payload posted to every endpoint.
+type WebhookEvent struct {
+	ID    string    `json:"id"`
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Item  Item      `json:"item"`
+}
+
+// webhook is one endpoint with its own queue, so a slow or failing
+// receiver doesn't hold up the others.
+type webhook struct {
+	url   string
+	queue chan []byte
+}
+
+// webhooks holds the configured endpoints; empty when none are set.
+var webhooks struct {
+	hooks  []*webhook
+	secret []byte
+	events map[string]bool
+	client *http.Client
+}
+
+// setWebhooks validates the endpoints and starts one delivery worker
+// per URL. events is a comma-separated subset of webhookEvents; empty
+// means all of them.
+func setWebhooks(urls []string, secret, events string) error {
+	webhooks.hooks = nil
+	if len(urls) == 0 {
+		return nil
+	}
+	if secret == "" {
+		return fmt.Errorf("webhook-secret is required with webhook-url")
+	}
+	want := map[string]bool{}
+	for _, ev := range strings.Split(events, ",") {
+		if ev = strings.TrimSpace(ev); ev == "" {
+			continue
+		}
+		known := false
+		for _, k := range webhookEvents {
+			known = known || k == ev
+		}
+		if !known {
+			return fmt.Errorf("unknown webhook event %q; want one of %s", ev, strings.Join(webhookEvents, ", "))
+		}
+		want[ev] = true
+	}
+	if len(want) == 0 {
+		for _, k := range webhookEvents {
+			want[k] = true
+		}
+	}
+	var hooks []*webhook
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook-url %q must be an http(s) URL", raw)
+		}
+		hooks = append(hooks, &webhook{url: raw, queue: make(chan []byte, webhookQueue)})
+	}
+	webhooks.hooks, webhooks.secret, webhooks.events = hooks, []byte(secret), want
+	webhooks.client = &http.Client{Timeout: 10 * time.Second}
+	for _, h := range hooks {
+		go h.run()
+	}
+	return nil
+}
+
+// catalogChanged fires webhooks for the differences between two
+// consecutive snapshots. Diffing at reload catches every writer: the
+// admin, the publish scheduler and the expiry sweeper alike.
+func catalogChanged(prev, next *catalog, now time.Time) {
+	if len(webhooks.hooks) == 0 || prev == nil {
+		return
+	}
+	for _, it := range next.items {
+		old, ok := prev.byID[it.ID]
+		switch {
+		case !ok:
+			fireWebhook(EventItemCreated, it, now)
+			if it.visible(now) {
+				fireWebhook(EventItemPublished, it, now)
+			}
+		case !old.published(prev.loadedAt) && it.published(now):
+			fireWebhook(EventItemPublished, it, now)
+		case !reflect.DeepEqual(*old, it):
+			fireWebhook(EventItemUpdated, it, now)
+		}
+	}
+	for _, it := range prev.items {
+		if _, ok := next.byID[it.ID]; !ok {
+			fireWebhook(EventItemDeleted, it, now)
+		}
+	}
+}
+
+// fireWebhook queues event for every endpoint subscribed to it. A full
+// queue drops the event rather than blocking the reload.
+func fireWebhook(event string, it Item, now time.Time) {
+	if !webhooks.events[event] {
+		return
+	}
+	id := make([]byte, 12)
+	rand.Read(id)
+	body, err := json.Marshal(WebhookEvent{ID: hex.EncodeToString(id), Event: event, Time: now.UTC(), Item: it})
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+		return
+	}
+	for _, h := range webhooks.hooks {
+		select {
+		case h.queue <- body:
+		default:
+			log.Printf("webhooks: %s queue full, dropping %s for item %d", h.url, event, it.ID)
+		}
+	}
+}
+
+// webhookSignature is the X-Webhook-Signature value for body sent at ts:
+// an HMAC-SHA256 over "ts.body", so receivers can reject replays.
+func webhookSignature(secret []byte, ts string, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(ts + "."))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// run delivers queued events in order, retrying each with exponential
+// backoff on network errors, 429s and 5xx responses.
+func (h *webhook) run() {
+	for body := range h.queue {
+		var ev WebhookEvent
+		json.Unmarshal(body, &ev)
+		delay := webhookBackoff
+		for attempt := 1; ; attempt++ {
+			retry, err := h.deliver(ev, body)
+			if err == nil {
+				break
+			}
+			if !retry || attempt == webhookAttempts {
+				log.Printf("webhooks: %s: giving up on %s %s after %d attempt(s): %v", h.url, ev.Event, ev.ID, attempt, err)
+				break
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// deliver posts body once, reporting whether a failure is worth retrying.
+func (h *webhook) deliver(ev WebhookEvent, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "BlendingWaves-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", ev.Event)
+	req.Header.Set("X-Webhook-ID", ev.ID)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", webhookSignature(webhooks.secret, ts, body))
+	resp, err := webhooks.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("%s", resp.Status)
+	}
+	return false, fmt.Errorf("%s", resp.Status)
+}